@@ -56,6 +56,17 @@ const (
 	DefaultWebDavMaxCacheItems = 1000
 	DefaultSearcher            = "bleve"
 
+	DefaultSMTPPort = 25
+
+	DefaultOIDCUsernameClaim = "preferred_username"
+
+	DefaultTwoFactorIssuer = "go-drive"
+
+	DefaultLDAPTimeout            = 10 * time.Second
+	DefaultLDAPUserFilter         = "(uid={username})"
+	DefaultLDAPUsernameAttribute  = "uid"
+	DefaultLDAPGroupNameAttribute = "cn"
+
 	DefaultCacheType                      = "mem"
 	DefaultCacheCleanPeriod time.Duration = 10 * time.Minute
 
@@ -66,6 +77,8 @@ const (
 	DefaultDriveRepositoryURL = "https://api.github.com/repos/devld/go-drive/contents/script-drives"
 )
 
+var DefaultOIDCScopes = []string{"openid", "profile", "email"}
+
 type Config struct {
 	Listen string `yaml:"listen"`
 
@@ -102,7 +115,8 @@ type Config struct {
 	Thumbnail ThumbnailConfig `yaml:"thumbnail"`
 	Auth      AuthConfig      `yaml:"auth"`
 
-	SignatureTTL time.Duration `yaml:"signature-ttl"`
+	SignatureTTL time.Duration   `yaml:"signature-ttl"`
+	Signature    SignatureConfig `yaml:"signature"`
 
 	WebDav WebDavConfig `yaml:"web-dav"`
 
@@ -110,6 +124,9 @@ type Config struct {
 
 	Cache CacheConfig `yaml:"cache"`
 
+	// SMTP is the mail server to send the notifications
+	SMTP SMTPConfig `yaml:"smtp"`
+
 	Version string
 	RevHash string
 	BuildAt string
@@ -144,6 +161,92 @@ type ThumbnailHandlerItem struct {
 type AuthConfig struct {
 	Validity    time.Duration `yaml:"validity"`
 	AutoRefresh bool          `yaml:"auto-refresh"`
+	// DisablePasswordLogin disables logging in with the username and password,
+	// including the basic authentication of WebDAV. The API tokens are still accepted.
+	DisablePasswordLogin bool            `yaml:"disable-password-login"`
+	OIDC                 OIDCConfig      `yaml:"oidc"`
+	LDAP                 LDAPConfig      `yaml:"ldap"`
+	TwoFactor            TwoFactorConfig `yaml:"two-factor"`
+}
+
+// TwoFactorConfig configures the TOTP two-factor authentication, which is enabled by the users themselves
+type TwoFactorConfig struct {
+	// Issuer is the name shown in the authenticator apps
+	Issuer string `yaml:"issuer"`
+	// RequireForAdmin revokes the admin privileges of the admin users who have not enabled the two-factor authentication
+	RequireForAdmin bool `yaml:"require-for-admin"`
+}
+
+// LDAPConfig configures the LDAP/Active Directory authentication.
+// The users are authenticated by binding with their DNs and passwords.
+type LDAPConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// URL is ldap://host[:port] or ldaps://host[:port]
+	URL string `yaml:"url"`
+	// StartTLS upgrades the ldap:// connection to TLS
+	StartTLS           bool          `yaml:"start-tls"`
+	InsecureSkipVerify bool          `yaml:"insecure-skip-verify"`
+	Timeout            time.Duration `yaml:"timeout"`
+	// BindDN and BindPassword are used to search the users and groups, the search is anonymous if BindDN is empty
+	BindDN       string `yaml:"bind-dn"`
+	BindPassword string `yaml:"bind-password"`
+	UserBaseDN   string `yaml:"user-base-dn"`
+	// UserFilter finds the user, {username} is replaced by the escaped username
+	UserFilter        string `yaml:"user-filter"`
+	UsernameAttribute string `yaml:"username-attribute"`
+	GroupBaseDN       string `yaml:"group-base-dn"`
+	// GroupFilter finds the groups of the user, {dn} and {username} are replaced by the escaped DN and username.
+	// The groups of the user are not synchronized if it's empty.
+	GroupFilter        string `yaml:"group-filter"`
+	GroupNameAttribute string `yaml:"group-name-attribute"`
+	// GroupMapping maps the LDAP group names to the group names, the unmapped groups are ignored.
+	// The groups of the user are not synchronized if it's empty.
+	GroupMapping map[string]string `yaml:"group-mapping"`
+	// AutoCreateUser creates the user when the user logs in for the first time
+	AutoCreateUser bool `yaml:"auto-create-user"`
+	// LinkExistingUsers allows the existing users that are not created by LDAP, e.g. the local users,
+	// to be authenticated and synchronized by LDAP
+	LinkExistingUsers bool `yaml:"link-existing-users"`
+	// SyncInterval is the interval to synchronize the groups of the users existing in LDAP, 0 disables it.
+	// It requires GroupFilter and GroupMapping.
+	SyncInterval time.Duration `yaml:"sync-interval"`
+}
+
+type OIDCConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Issuer is the issuer URL of the provider,
+	// the provider configuration is discovered from ISSUER/.well-known/openid-configuration
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"client-id"`
+	ClientSecret string `yaml:"client-secret"`
+	// Scopes are the requested scopes, 'openid' is always requested
+	Scopes []string `yaml:"scopes"`
+	// RedirectURI is the callback url registered in the provider, it's API_PATH/auth/oidc/callback
+	RedirectURI string `yaml:"redirect-uri"`
+	// UsernameClaim is the claim used as the username
+	UsernameClaim string `yaml:"username-claim"`
+	// GroupsClaim is the dot separated path of the claim that contains the groups, e.g. realm_access.roles.
+	// The groups of the user are not synchronized if it's empty.
+	GroupsClaim string `yaml:"groups-claim"`
+	// GroupMapping maps the groups in the claim to the group names, the unmapped groups are ignored.
+	// The groups of the user are not synchronized if it's empty.
+	GroupMapping map[string]string `yaml:"group-mapping"`
+	// AutoCreateUser creates the user when the user logs in for the first time
+	AutoCreateUser bool `yaml:"auto-create-user"`
+	// LinkExistingUsers allows the subject to be linked to the existing user of the username claim
+	// that is not created by single sign-on, e.g. the local users.
+	// The user is linked by the subject after the first login, the username claim is not used anymore.
+	LinkExistingUsers bool `yaml:"link-existing-users"`
+}
+
+type SignatureConfig struct {
+	// Secret is used to sign urls.
+	// If it's empty, a random secret will be generated and persisted in database
+	Secret string `yaml:"secret"`
+	// PreviousSecrets are only used to validate signatures signed before the secret rotation
+	PreviousSecrets []string `yaml:"previous-secrets"`
+	// BindIP binds the signatures to the client IP
+	BindIP bool `yaml:"bind-ip"`
 }
 
 type WebDavConfig struct {
@@ -164,6 +267,20 @@ type CacheConfig struct {
 	CleanPeriod time.Duration `yaml:"clean-period"`
 }
 
+type SMTPConfig struct {
+	// Host is the mail server host, sending emails is disabled if it's empty
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	// Username and Password are used for the PLAIN authentication, no authentication if Username is empty
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// From is the sender address
+	From string `yaml:"from"`
+	// TLS connects to the server with implicit TLS(usually port 465),
+	// otherwise STARTTLS is used if the server supports it
+	TLS bool `yaml:"tls"`
+}
+
 func InitConfig(ch *registry.ComponentsHolder) (Config, error) {
 	config := Config{
 		Listen:      DefaultListen,
@@ -187,6 +304,21 @@ func InitConfig(ch *registry.ComponentsHolder) (Config, error) {
 		Auth: AuthConfig{
 			Validity:    DefaultAuthValidity,
 			AutoRefresh: DefaultAuthAutoRefresh,
+			TwoFactor: TwoFactorConfig{
+				Issuer: DefaultTwoFactorIssuer,
+			},
+			LDAP: LDAPConfig{
+				Timeout:            DefaultLDAPTimeout,
+				UserFilter:         DefaultLDAPUserFilter,
+				UsernameAttribute:  DefaultLDAPUsernameAttribute,
+				GroupNameAttribute: DefaultLDAPGroupNameAttribute,
+				AutoCreateUser:     true,
+			},
+			OIDC: OIDCConfig{
+				Scopes:         DefaultOIDCScopes,
+				UsernameClaim:  DefaultOIDCUsernameClaim,
+				AutoCreateUser: true,
+			},
 		},
 		SignatureTTL: DefaultSignatureTTL,
 		WebDav: WebDavConfig{
@@ -201,6 +333,9 @@ func InitConfig(ch *registry.ComponentsHolder) (Config, error) {
 			Type:        DefaultCacheType,
 			CleanPeriod: DefaultCacheCleanPeriod,
 		},
+		SMTP: SMTPConfig{
+			Port: DefaultSMTPPort,
+		},
 
 		Version: Version,
 		RevHash: RevHash,
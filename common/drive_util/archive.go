@@ -0,0 +1,689 @@
+package drive_util
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ArchiveZip    = "zip"
+	ArchiveTar    = "tar"
+	ArchiveTarGz  = "tar.gz"
+	ArchiveTarBz2 = "tar.bz2"
+	ArchiveTarXz  = "tar.xz"
+)
+
+var archiveSuffixes = []struct{ suffix, format string }{
+	{".zip", ArchiveZip},
+	{".tar", ArchiveTar},
+	{".tar.gz", ArchiveTarGz},
+	{".tgz", ArchiveTarGz},
+	{".tar.bz2", ArchiveTarBz2},
+	{".tbz2", ArchiveTarBz2},
+	{".tar.xz", ArchiveTarXz},
+	{".txz", ArchiveTarXz},
+}
+
+// GetArchiveFormat returns the archive format by the file name, or empty string if it's not a supported archive
+func GetArchiveFormat(name string) string {
+	name = strings.ToLower(name)
+	for _, s := range archiveSuffixes {
+		if strings.HasSuffix(name, s.suffix) {
+			return s.format
+		}
+	}
+	return ""
+}
+
+// ratioCheckThreshold is the minimum uncompressed size to check the compression ratio,
+// small archives may have a high ratio legitimately
+const ratioCheckThreshold = 16 * 1024 * 1024
+
+// ExtractLimits guards the extraction against archive bombs, zero value means no limit
+type ExtractLimits struct {
+	// MaxFiles is the maximum number of files and directories in the archive
+	MaxFiles int
+	// MaxSize is the maximum total uncompressed size
+	MaxSize int64
+	// MaxRatio is the maximum ratio of the uncompressed size to the archive size
+	MaxRatio float64
+}
+
+func (l ExtractLimits) check(files int, size, compressed int64) error {
+	if e := l.checkSize(files, size); e != nil {
+		return e
+	}
+	return l.checkRatio(size, compressed)
+}
+
+func (l ExtractLimits) checkSize(files int, size int64) error {
+	if l.MaxFiles > 0 && files > l.MaxFiles {
+		return err.NewNotAllowedMessageError(i18n.T("api.extract.too_many_files", strconv.Itoa(l.MaxFiles)))
+	}
+	if l.MaxSize > 0 && size > l.MaxSize {
+		return err.NewNotAllowedMessageError(i18n.T("api.extract.size_exceed", utils.FormatBytes(uint64(l.MaxSize), 2)))
+	}
+	return nil
+}
+
+func (l ExtractLimits) checkRatio(size, compressed int64) error {
+	if l.MaxRatio > 0 && size > ratioCheckThreshold && float64(size) > l.MaxRatio*float64(compressed) {
+		return err.NewNotAllowedMessageError(i18n.T("api.extract.ratio_exceed", strconv.FormatFloat(l.MaxRatio, 'f', -1, 64)))
+	}
+	return nil
+}
+
+// ArchiveFile is a file or directory in the archive
+type ArchiveFile struct {
+	// Path is the cleaned relative path in the archive
+	Path string
+	Dir  bool
+	Size int64
+	// Open opens the content of the file, it's only valid in the walk callback
+	Open func() (io.ReadCloser, error)
+}
+
+// WalkArchive walks the files in the archive entry in the archive order.
+// Paths escaping the archive root are rejected, links and other special files are skipped.
+// Progress is reported on ctx.
+func WalkArchive(ctx types.TaskCtx, archive types.IEntry, format string,
+	limits ExtractLimits, fn func(ArchiveFile) error) error {
+	content, ok := archive.(types.IContent)
+	if !ok || archive.Type() != types.TypeFile {
+		return err.NewNotAllowedMessageError(i18n.T("api.extract.unsupported_format"))
+	}
+	switch format {
+	case ArchiveZip:
+		return walkZip(ctx, content, archive.Size(), limits, fn)
+	case ArchiveTar, ArchiveTarGz, ArchiveTarBz2, ArchiveTarXz:
+		return walkTar(ctx, content, archive.Size(), format, limits, fn)
+	}
+	return err.NewNotAllowedMessageError(i18n.T("api.extract.unsupported_format"))
+}
+
+func walkZip(ctx types.TaskCtx, content types.IContent, size int64,
+	limits ExtractLimits, fn func(ArchiveFile) error) error {
+	// the central directory is at the end of the zip file, read it by ranges
+	zr, e := zip.NewReader(NewContentReaderAt(ctx, content, size), size)
+	if e != nil {
+		return invalidArchiveError(e.Error())
+	}
+	total := int64(0)
+	for _, f := range zr.File {
+		total += int64(f.UncompressedSize64)
+	}
+	if e := limits.check(len(zr.File), total, size); e != nil {
+		return e
+	}
+	ctx.Total(total, true)
+	for _, f := range zr.File {
+		if e := ctx.Err(); e != nil {
+			return e
+		}
+		p, e := cleanArchivePath(f.Name)
+		if e != nil {
+			return e
+		}
+		mode := f.Mode()
+		if p == "" || !(mode.IsDir() || mode.IsRegular()) {
+			continue
+		}
+		file := f
+		// archive/zip fails reading if the content exceeds the declared size,
+		// so the preflight check above could not be cheated
+		e = fn(ArchiveFile{
+			Path: p, Dir: mode.IsDir(), Size: int64(f.UncompressedSize64),
+			Open: func() (io.ReadCloser, error) { return file.Open() },
+		})
+		if e != nil {
+			return e
+		}
+		ctx.Progress(int64(f.UncompressedSize64), false)
+	}
+	return nil
+}
+
+func walkTar(ctx types.TaskCtx, content types.IContent, size int64, format string,
+	limits ExtractLimits, fn func(ArchiveFile) error) error {
+	reader, e := GetIContentReader(ctx, content, -1, -1)
+	if e != nil {
+		return e
+	}
+	defer func() { _ = reader.Close() }()
+	counter := &countingReader{r: reader}
+
+	var r io.Reader = counter
+	// ratio is the decompressed stream, nil if the tar is not compressed
+	var ratio *ratioReader
+	switch format {
+	case ArchiveTarGz:
+		gr, e := gzip.NewReader(counter)
+		if e != nil {
+			return invalidArchiveError(e.Error())
+		}
+		defer func() { _ = gr.Close() }()
+		r = gr
+	case ArchiveTarBz2:
+		r = bzip2.NewReader(counter)
+	case ArchiveTarXz:
+		xr, e := newXzReader(ctx, counter)
+		if e != nil {
+			return e
+		}
+		defer func() { _ = xr.Close() }()
+		r = xr
+	}
+	if format != ArchiveTar {
+		ratio = &ratioReader{r: r, compressed: counter, limits: limits}
+		r = ratio
+	}
+	// limitError returns the error of the ratio limit if the reading is stopped by it
+	limitError := func(e error) error {
+		if ratio != nil && ratio.e != nil {
+			return ratio.e
+		}
+		return e
+	}
+
+	ctx.Total(size, true)
+	tr := tar.NewReader(r)
+	files, total := 0, int64(0)
+	for {
+		if e := ctx.Err(); e != nil {
+			return e
+		}
+		h, e := tr.Next()
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			if le := limitError(nil); le != nil {
+				return le
+			}
+			return invalidArchiveError(e.Error())
+		}
+		p, e := cleanArchivePath(h.Name)
+		if e != nil {
+			return e
+		}
+		if p == "" || (h.Typeflag != tar.TypeDir && h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeRegA) {
+			continue
+		}
+		files++
+		if h.Typeflag != tar.TypeDir {
+			total += h.Size
+		}
+		// tar has no index, limits are checked while reading,
+		// the ratio is checked by the decompressed bytes as the declared sizes are not compressed
+		if e := limits.checkSize(files, total); e != nil {
+			return e
+		}
+		e = fn(ArchiveFile{
+			Path: p, Dir: h.Typeflag == tar.TypeDir, Size: h.Size,
+			Open: func() (io.ReadCloser, error) { return io.NopCloser(tr), nil },
+		})
+		if e != nil {
+			return limitError(e)
+		}
+		ctx.Progress(counter.n, true)
+	}
+	return nil
+}
+
+// ExtractArchive extracts the archive entry into the directory `to` of driveTo
+func ExtractArchive(ctx types.TaskCtx, archive types.IEntry, format string, driveTo types.IDrive, to string,
+	override bool, limits ExtractLimits, tempDir string) error {
+	// drives may report the progress of each file, it's not the progress of the extraction
+	fileCtx := task.NewContextWrapper(ctx)
+	dirs := make(map[string]bool)
+	var ensureDir func(string, string) error
+	ensureDir = func(dir, src string) error {
+		if dir == "" || dirs[dir] {
+			return nil
+		}
+		d, e := driveTo.Get(fileCtx, dir)
+		if e != nil && !err.IsNotFoundError(e) {
+			return e
+		}
+		if e == nil && !d.Type().IsDir() {
+			return err.NewNotAllowedMessageError(i18n.T("drive.copy_type_mismatch1", src, dir))
+		}
+		if e != nil {
+			if e := ensureDir(utils.PathParent(dir), src); e != nil {
+				return e
+			}
+			if _, e := driveTo.MakeDir(fileCtx, dir); e != nil {
+				return e
+			}
+		}
+		dirs[dir] = true
+		return nil
+	}
+
+	// the directories are created on demand, nothing is left if the archive is rejected
+	e := WalkArchive(ctx, archive, format, limits, func(f ArchiveFile) error {
+		target := utils.CleanPath(path.Join(to, f.Path))
+		if f.Dir {
+			return ensureDir(target, f.Path)
+		}
+		if e := ensureDir(utils.PathParent(target), f.Path); e != nil {
+			return e
+		}
+		if d, e := driveTo.Get(fileCtx, target); e == nil && d.Type().IsDir() {
+			return err.NewNotAllowedMessageError(i18n.T("drive.copy_type_mismatch2", f.Path, target))
+		}
+		reader, e := f.Open()
+		if e != nil {
+			return e
+		}
+		defer func() { _ = reader.Close() }()
+		file, e := CopyReaderToTempFile(fileCtx, reader, tempDir)
+		if e != nil {
+			return e
+		}
+		defer func() {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}()
+		_, e = driveTo.Save(fileCtx, target, f.Size, override, utils.NewTempFile(file))
+		return e
+	})
+	if e != nil {
+		return e
+	}
+	return ensureDir(to, archive.Path())
+}
+
+// cleanArchivePath returns the relative path of the file in the archive.
+// Absolute paths or paths escaping the archive root(zip slip) are rejected.
+func cleanArchivePath(name string) (string, error) {
+	p := strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(p, "/") || (len(p) >= 2 && p[1] == ':') {
+		return "", err.NewNotAllowedMessageError(i18n.T("api.extract.unsafe_path", name))
+	}
+	depth := 0
+	for _, s := range strings.Split(p, "/") {
+		switch s {
+		case "", ".":
+		case "..":
+			depth--
+			if depth < 0 {
+				return "", err.NewNotAllowedMessageError(i18n.T("api.extract.unsafe_path", name))
+			}
+		default:
+			depth++
+		}
+	}
+	return utils.CleanPath(p), nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, e := c.r.Read(p)
+	c.n += int64(n)
+	return n, e
+}
+
+// ratioReader fails reading if the decompressed bytes exceed the ratio limit of the compressed bytes read
+type ratioReader struct {
+	r          io.Reader
+	compressed *countingReader
+	limits     ExtractLimits
+	n          int64
+	// e is the error of the ratio limit, the reading is stopped after it
+	e error
+}
+
+func (r *ratioReader) Read(p []byte) (int, error) {
+	if r.e != nil {
+		return 0, r.e
+	}
+	n, e := r.r.Read(p)
+	r.n += int64(n)
+	if le := r.limits.checkRatio(r.n, r.compressed.n); le != nil {
+		r.e = le
+		return n, le
+	}
+	return n, e
+}
+
+// xzReader decompresses the xz stream by the xz command, there is no xz package in the standard library
+type xzReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func newXzReader(ctx context.Context, r io.Reader) (*xzReader, error) {
+	if _, e := exec.LookPath("xz"); e != nil {
+		return nil, err.NewUnsupportedMessageError(i18n.T("api.extract.xz_unavailable"))
+	}
+	cmd := exec.CommandContext(ctx, "xz", "-dc")
+	cmd.Stdin = r
+	out, e := cmd.StdoutPipe()
+	if e != nil {
+		return nil, e
+	}
+	if e := cmd.Start(); e != nil {
+		return nil, e
+	}
+	return &xzReader{out, cmd}, nil
+}
+
+func (x *xzReader) Close() error {
+	_ = x.ReadCloser.Close()
+	return x.cmd.Wait()
+}
+
+const (
+	contentReaderAtBlockSize    = 1024 * 1024
+	contentReaderAtCachedBlocks = 4
+)
+
+// contentReaderAt reads the IContent by ranges, the recently read blocks are cached
+type contentReaderAt struct {
+	ctx       context.Context
+	content   types.IContentReader
+	size      int64
+	blockSize int64
+
+	blocks map[int64][]byte
+	// order is the block indexes from the least recently read
+	order []int64
+	mux   *sync.Mutex
+}
+
+// NewContentReaderAt wraps the IContentReader as io.ReaderAt
+func NewContentReaderAt(ctx context.Context, content types.IContentReader, size int64) io.ReaderAt {
+	return newContentReaderAt(ctx, content, size, contentReaderAtBlockSize)
+}
+
+func newContentReaderAt(ctx context.Context, content types.IContentReader, size, blockSize int64) *contentReaderAt {
+	return &contentReaderAt{
+		ctx: ctx, content: content, size: size, blockSize: blockSize,
+		blocks: make(map[int64][]byte), mux: &sync.Mutex{},
+	}
+}
+
+func (c *contentReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	n := 0
+	for n < len(p) && off < c.size {
+		block, e := c.getBlock(off / c.blockSize)
+		if e != nil {
+			return n, e
+		}
+		copied := copy(p[n:], block[off%c.blockSize:])
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (c *contentReaderAt) getBlock(i int64) ([]byte, error) {
+	if b, ok := c.blocks[i]; ok {
+		for j, v := range c.order {
+			if v == i {
+				c.order = append(append(c.order[:j:j], c.order[j+1:]...), i)
+				break
+			}
+		}
+		return b, nil
+	}
+	start := i * c.blockSize
+	size := c.size - start
+	if size > c.blockSize {
+		size = c.blockSize
+	}
+	reader, e := GetIContentReader(c.ctx, c.content, start, size)
+	if e != nil {
+		return nil, e
+	}
+	defer func() { _ = reader.Close() }()
+	b := make([]byte, size)
+	if _, e := io.ReadFull(reader, b); e != nil {
+		return nil, e
+	}
+	if len(c.order) >= contentReaderAtCachedBlocks {
+		delete(c.blocks, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.blocks[i] = b
+	c.order = append(c.order, i)
+	return b, nil
+}
+
+// ArchiveZipStore is the zip archive without compression
+const ArchiveZipStore = "zip-store"
+
+// ArchiveItem is the entry to be written to the archive
+type ArchiveItem struct {
+	// Name is the path in the archive
+	Name  string
+	Entry types.IEntry
+}
+
+// WriteArchive writes the items as the archive in format zip, zip-store, tar or tar.gz
+func WriteArchive(ctx types.TaskCtx, w io.Writer, format string, items []ArchiveItem) error {
+	aw, e := newArchiveWriter(w, format)
+	if e != nil {
+		return e
+	}
+	for _, item := range items {
+		if e := ctx.Err(); e != nil {
+			return e
+		}
+		fw, e := aw.add(item)
+		if e != nil {
+			return e
+		}
+		if item.Entry.Type().IsFile() {
+			if e := CopyIContent(ctx, item.Entry, fw); e != nil {
+				return e
+			}
+		}
+	}
+	return aw.close()
+}
+
+// GetArchiveSize returns the exact size of the archive written by WriteArchive,
+// or -1 if the size is unknown before writing as the format is compressed or the size of any file is unknown.
+// The size is computed from the headers and the file sizes, the file contents are not read.
+func GetArchiveSize(format string, items []ArchiveItem) (int64, error) {
+	if format != ArchiveZipStore && format != ArchiveTar {
+		return -1, nil
+	}
+	for _, item := range items {
+		if item.Entry.Type().IsFile() && item.Entry.Size() < 0 {
+			return -1, nil
+		}
+	}
+	if format == ArchiveTar {
+		return getTarSize(items)
+	}
+	return getZipStoreSize(items), nil
+}
+
+const (
+	zipDataDescriptorLen   = 16
+	zipDataDescriptor64Len = 24
+	zip64ExtraHeaderLen    = 4
+	// zipExtTimeExtraLen is the extended timestamp field added by zip.Writer for the modified time
+	zipExtTimeExtraLen = 9
+	zipUint32Max       = 1<<32 - 1
+	zipUint16Max       = 1<<16 - 1
+)
+
+// getZipStoreSize computes the size of the uncompressed zip written by zip.Writer.
+// Each file has a local header, the data and a data descriptor, the directories have only the local header.
+// The central directory records all the entries, followed by the zip64 end record if needed and the end record.
+func getZipStoreSize(items []ArchiveItem) int64 {
+	offsets := make([]int64, len(items))
+	n := int64(0)
+	for i, item := range items {
+		offsets[i] = n
+		n += zipFileHeaderLen + int64(len(archiveItemName(item))) + zipExtTimeExtraLen
+		if item.Entry.Type().IsFile() {
+			size := item.Entry.Size()
+			n += size
+			if size > zipUint32Max {
+				n += zipDataDescriptor64Len
+			} else {
+				n += zipDataDescriptorLen
+			}
+		}
+	}
+	directoryStart := n
+	usedZip64 := false
+	for i, item := range items {
+		n += zipDirHeaderLen + int64(len(archiveItemName(item))) + zipExtTimeExtraLen
+		size := int64(0)
+		if item.Entry.Type().IsFile() {
+			size = item.Entry.Size()
+		}
+		if size >= zipUint32Max || offsets[i] >= zipUint32Max {
+			usedZip64 = true
+			n += zip64ExtraHeaderLen
+			if size >= zipUint32Max {
+				// both the compressed size and the uncompressed size
+				n += 16
+			}
+			if offsets[i] >= zipUint32Max {
+				n += 8
+			}
+		}
+	}
+	if usedZip64 || len(items) >= zipUint16Max ||
+		n-directoryStart >= zipUint32Max || directoryStart >= zipUint32Max {
+		n += zipDir64EndLen + zipDir64LocLen
+	}
+	return n + zipDirEndLen
+}
+
+// getTarSize computes the size of the tar written by tar.Writer.
+// Each entry has the header blocks and the data padded to 512-byte blocks, the archive ends with two zero blocks.
+func getTarSize(items []ArchiveItem) (int64, error) {
+	n := int64(0)
+	counter := &countingWriter{}
+	for _, item := range items {
+		// the header may take more than one block if the PAX records are needed, e.g. for long names
+		counter.n = 0
+		if e := tar.NewWriter(counter).WriteHeader(tarHeader(item)); e != nil {
+			return -1, e
+		}
+		n += counter.n
+		if item.Entry.Type().IsFile() {
+			n += (item.Entry.Size() + tarBlockSize - 1) / tarBlockSize * tarBlockSize
+		}
+	}
+	return n + 2*tarBlockSize, nil
+}
+
+const tarBlockSize = 512
+
+type archiveWriter struct {
+	zip    *zip.Writer
+	method uint16
+	tar    *tar.Writer
+	gzip   *gzip.Writer
+}
+
+func newArchiveWriter(w io.Writer, format string) (*archiveWriter, error) {
+	switch format {
+	case ArchiveZip:
+		return &archiveWriter{zip: zip.NewWriter(w), method: zip.Deflate}, nil
+	case ArchiveZipStore:
+		return &archiveWriter{zip: zip.NewWriter(w), method: zip.Store}, nil
+	case ArchiveTar:
+		return &archiveWriter{tar: tar.NewWriter(w)}, nil
+	case ArchiveTarGz:
+		gw := gzip.NewWriter(w)
+		return &archiveWriter{tar: tar.NewWriter(gw), gzip: gw}, nil
+	}
+	return nil, err.NewBadRequestError(i18n.T("api.extract.unsupported_format"))
+}
+
+func (a *archiveWriter) add(item ArchiveItem) (io.Writer, error) {
+	if a.zip != nil {
+		return a.zip.CreateHeader(&zip.FileHeader{
+			Name: archiveItemName(item), Method: a.method, Modified: archiveItemModTime(item),
+		})
+	}
+	if e := a.tar.WriteHeader(tarHeader(item)); e != nil {
+		return nil, e
+	}
+	return a.tar, nil
+}
+
+func tarHeader(item ArchiveItem) *tar.Header {
+	h := &tar.Header{
+		Name: archiveItemName(item), ModTime: archiveItemModTime(item), Typeflag: tar.TypeReg, Mode: 0644,
+	}
+	if item.Entry.Type().IsDir() {
+		h.Typeflag = tar.TypeDir
+		h.Mode = 0755
+	} else {
+		h.Size = item.Entry.Size()
+	}
+	return h
+}
+
+// archiveItemName returns the name in the archive, the names of directories end with '/'
+func archiveItemName(item ArchiveItem) string {
+	if item.Entry.Type().IsDir() {
+		return item.Name + "/"
+	}
+	return item.Name
+}
+
+func archiveItemModTime(item ArchiveItem) time.Time {
+	if t := item.Entry.ModTime(); t > 0 {
+		return time.UnixMilli(t)
+	}
+	return time.Unix(0, 0)
+}
+
+func (a *archiveWriter) close() error {
+	if a.zip != nil {
+		return a.zip.Close()
+	}
+	if e := a.tar.Close(); e != nil {
+		return e
+	}
+	if a.gzip != nil {
+		return a.gzip.Close()
+	}
+	return nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,407 @@
+package drive_util
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"errors"
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"io"
+	"time"
+)
+
+const (
+	zipFileHeaderSignature = 0x04034b50
+	zipDirHeaderSignature  = 0x02014b50
+	zipDirEndSignature     = 0x06054b50
+	zipDir64LocSignature   = 0x07064b50
+	zipDir64EndSignature   = 0x06064b50
+
+	zipFileHeaderLen = 30
+	zipDirHeaderLen  = 46
+	zipDirEndLen     = 22
+	zipDir64LocLen   = 20
+	zipDir64EndLen   = 56
+	zipMaxCommentLen = 65535
+
+	zipZip64ExtraID   = 0x0001
+	zipExtTimeExtraID = 0x5455
+
+	// tarIndexBlockSize is smaller, because only the headers are read when indexing tar
+	tarIndexBlockSize = 64 * 1024
+)
+
+// ArchiveMember is a file or directory in the zip or tar archive,
+// it contains the information to read the content by ranges without reading the whole archive.
+type ArchiveMember struct {
+	// Path is the cleaned relative path in the archive
+	Path    string
+	Dir     bool
+	Size    int64
+	ModTime int64
+	// Method is the compression method of the zip member
+	Method uint16
+	// Flags is the general purpose flags of the zip member
+	Flags uint16
+	// Offset is the offset of the local file header of the zip member, or the offset of the tar member data
+	Offset int64
+	// CompressedSize is the size of the compressed data of the zip member
+	CompressedSize int64
+}
+
+// IsMountableFormat returns true if the members of the archive in this format can be read by ranges
+func IsMountableFormat(format string) bool {
+	return format == ArchiveZip || format == ArchiveTar
+}
+
+// IsMountableArchive returns true if the entry is an archive which could be browsed as a directory
+func IsMountableArchive(entry types.IEntry) bool {
+	if !entry.Type().IsFile() {
+		return false
+	}
+	name := entry.Path()
+	if c, ok := entry.(types.IContent); ok {
+		name = c.Name()
+	}
+	return IsMountableFormat(GetArchiveFormat(name))
+}
+
+// ReadArchiveIndex reads all members of the zip or tar archive.
+// The zip central directory or the tar headers are read by ranges.
+// The members with unsafe path are ignored.
+func ReadArchiveIndex(ctx context.Context, content types.IContentReader, size int64, format string) ([]ArchiveMember, error) {
+	switch format {
+	case ArchiveZip:
+		return readZipIndex(ctx, content, size)
+	case ArchiveTar:
+		return readTarIndex(ctx, content, size)
+	}
+	return nil, err.NewNotAllowedMessageError(i18n.T("api.extract.unsupported_format"))
+}
+
+// OpenArchiveMember reads the content of the member in the archive,
+// the last two arguments are offset and size, pass both -1 to read all.
+func OpenArchiveMember(ctx context.Context, content types.IContentReader, format string,
+	m ArchiveMember, start, size int64) (io.ReadCloser, error) {
+	if m.Dir {
+		return nil, err.NewNotAllowedError()
+	}
+	if start < 0 {
+		start = 0
+	}
+	if size < 0 || start+size > m.Size {
+		size = m.Size - start
+	}
+	if size <= 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	if format == ArchiveTar {
+		return newSectionReader(ctx, content, m.Offset+start, size), nil
+	}
+	if format != ArchiveZip {
+		return nil, err.NewNotAllowedMessageError(i18n.T("api.extract.unsupported_format"))
+	}
+
+	if m.Flags&0x1 != 0 {
+		return nil, err.NewUnsupportedMessageError(i18n.T("api.extract.encrypted_member"))
+	}
+	dataOffset, e := readZipDataOffset(ctx, content, m.Offset)
+	if e != nil {
+		return nil, e
+	}
+	switch m.Method {
+	case zip.Store:
+		return newSectionReader(ctx, content, dataOffset+start, size), nil
+	case zip.Deflate:
+		r, e := GetIContentReader(ctx, content, dataOffset, m.CompressedSize)
+		if e != nil {
+			return nil, e
+		}
+		fr := flate.NewReader(r)
+		if start > 0 {
+			if _, e := io.CopyN(io.Discard, fr, start); e != nil {
+				_ = fr.Close()
+				_ = r.Close()
+				return nil, e
+			}
+		}
+		return &archiveMemberReader{io.LimitReader(fr, size), []io.Closer{fr, r}}, nil
+	}
+	return nil, err.NewUnsupportedMessageError(i18n.T("api.extract.unsupported_method", m.Path))
+}
+
+type archiveMemberReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (a *archiveMemberReader) Close() error {
+	var e error
+	for _, c := range a.closers {
+		if ce := c.Close(); ce != nil && e == nil {
+			e = ce
+		}
+	}
+	return e
+}
+
+// sectionReader reads the section of the content by ranges, it's seekable so that the ranged downloading works.
+// The reader is opened on reading and reopened after seeking.
+type sectionReader struct {
+	ctx     context.Context
+	content types.IContentReader
+	offset  int64
+	size    int64
+	pos     int64
+	r       io.ReadCloser
+}
+
+func newSectionReader(ctx context.Context, content types.IContentReader, offset, size int64) *sectionReader {
+	return &sectionReader{ctx: ctx, content: content, offset: offset, size: size}
+}
+
+func (s *sectionReader) Read(p []byte) (int, error) {
+	if s.pos >= s.size {
+		return 0, io.EOF
+	}
+	if s.r == nil {
+		r, e := GetIContentReader(s.ctx, s.content, s.offset+s.pos, s.size-s.pos)
+		if e != nil {
+			return 0, e
+		}
+		s.r = r
+	}
+	n, e := s.r.Read(p)
+	s.pos += int64(n)
+	return n, e
+}
+
+func (s *sectionReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	if offset != s.pos {
+		_ = s.Close()
+		s.pos = offset
+	}
+	return offset, nil
+}
+
+func (s *sectionReader) Close() error {
+	if s.r == nil {
+		return nil
+	}
+	e := s.r.Close()
+	s.r = nil
+	return e
+}
+
+// readZipDataOffset reads the local file header to get the offset of the data,
+// the length of the extra field may be different from the central directory.
+func readZipDataOffset(ctx context.Context, content types.IContentReader, headerOffset int64) (int64, error) {
+	r, e := GetIContentReader(ctx, content, headerOffset, zipFileHeaderLen)
+	if e != nil {
+		return 0, e
+	}
+	defer func() { _ = r.Close() }()
+	h := make([]byte, zipFileHeaderLen)
+	if _, e := io.ReadFull(r, h); e != nil {
+		return 0, e
+	}
+	if binary.LittleEndian.Uint32(h) != zipFileHeaderSignature {
+		return 0, invalidArchiveError("invalid local file header")
+	}
+	nameLen := int64(binary.LittleEndian.Uint16(h[26:]))
+	extraLen := int64(binary.LittleEndian.Uint16(h[28:]))
+	return headerOffset + zipFileHeaderLen + nameLen + extraLen, nil
+}
+
+func readZipIndex(ctx context.Context, content types.IContentReader, size int64) ([]ArchiveMember, error) {
+	ra := newContentReaderAt(ctx, content, size, contentReaderAtBlockSize)
+
+	// the end of central directory record is at the end of the file, followed by the comment
+	tailSize := int64(zipDirEndLen + zipMaxCommentLen)
+	if tailSize > size {
+		tailSize = size
+	}
+	tail := make([]byte, tailSize)
+	if _, e := ra.ReadAt(tail, size-tailSize); e != nil && e != io.EOF {
+		return nil, e
+	}
+	endPos := -1
+	for i := len(tail) - zipDirEndLen; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) == zipDirEndSignature {
+			endPos = i
+			break
+		}
+	}
+	if endPos < 0 {
+		return nil, invalidArchiveError("end of central directory not found")
+	}
+	end := tail[endPos:]
+	records := uint64(binary.LittleEndian.Uint16(end[10:]))
+	dirSize := uint64(binary.LittleEndian.Uint32(end[12:]))
+	dirOffset := uint64(binary.LittleEndian.Uint32(end[16:]))
+
+	if records == 0xffff || dirSize == 0xffffffff || dirOffset == 0xffffffff {
+		locOffset := size - tailSize + int64(endPos) - zipDir64LocLen
+		if locOffset < 0 {
+			return nil, invalidArchiveError("zip64 end of central directory locator not found")
+		}
+		loc := make([]byte, zipDir64LocLen)
+		if _, e := ra.ReadAt(loc, locOffset); e != nil {
+			return nil, e
+		}
+		if binary.LittleEndian.Uint32(loc) != zipDir64LocSignature {
+			return nil, invalidArchiveError("zip64 end of central directory locator not found")
+		}
+		end64Offset := binary.LittleEndian.Uint64(loc[8:])
+		if end64Offset > uint64(size-zipDir64EndLen) {
+			return nil, invalidArchiveError("invalid zip64 end of central directory offset")
+		}
+		end64 := make([]byte, zipDir64EndLen)
+		if _, e := ra.ReadAt(end64, int64(end64Offset)); e != nil {
+			return nil, e
+		}
+		if binary.LittleEndian.Uint32(end64) != zipDir64EndSignature {
+			return nil, invalidArchiveError("zip64 end of central directory not found")
+		}
+		records = binary.LittleEndian.Uint64(end64[32:])
+		dirSize = binary.LittleEndian.Uint64(end64[40:])
+		dirOffset = binary.LittleEndian.Uint64(end64[48:])
+	}
+	if dirOffset+dirSize > uint64(size) || records > dirSize/zipDirHeaderLen {
+		return nil, invalidArchiveError("invalid central directory")
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(ra, int64(dirOffset), int64(dirSize)))
+	members := make([]ArchiveMember, 0, records)
+	h := make([]byte, zipDirHeaderLen)
+	for i := uint64(0); i < records; i++ {
+		if e := ctx.Err(); e != nil {
+			return nil, e
+		}
+		if _, e := io.ReadFull(r, h); e != nil {
+			return nil, invalidArchiveError(e.Error())
+		}
+		if binary.LittleEndian.Uint32(h) != zipDirHeaderSignature {
+			return nil, invalidArchiveError("invalid central directory header")
+		}
+		flags := binary.LittleEndian.Uint16(h[8:])
+		method := binary.LittleEndian.Uint16(h[10:])
+		modTime := zipDosTime(binary.LittleEndian.Uint16(h[14:]), binary.LittleEndian.Uint16(h[12:]))
+		compressedSize := uint64(binary.LittleEndian.Uint32(h[20:]))
+		uncompressedSize := uint64(binary.LittleEndian.Uint32(h[24:]))
+		nameLen := int(binary.LittleEndian.Uint16(h[28:]))
+		extraLen := int(binary.LittleEndian.Uint16(h[30:]))
+		commentLen := int(binary.LittleEndian.Uint16(h[32:]))
+		headerOffset := uint64(binary.LittleEndian.Uint32(h[42:]))
+
+		buf := make([]byte, nameLen+extraLen+commentLen)
+		if _, e := io.ReadFull(r, buf); e != nil {
+			return nil, invalidArchiveError(e.Error())
+		}
+		name := string(buf[:nameLen])
+		extra := buf[nameLen : nameLen+extraLen]
+		for len(extra) >= 4 {
+			id := binary.LittleEndian.Uint16(extra)
+			l := int(binary.LittleEndian.Uint16(extra[2:]))
+			if len(extra) < 4+l {
+				break
+			}
+			data := extra[4 : 4+l]
+			switch id {
+			case zipZip64ExtraID:
+				// the fields are present only if the corresponding fields in the header are 0xffffffff
+				for _, v := range []*uint64{&uncompressedSize, &compressedSize, &headerOffset} {
+					if *v == 0xffffffff && len(data) >= 8 {
+						*v = binary.LittleEndian.Uint64(data)
+						data = data[8:]
+					}
+				}
+			case zipExtTimeExtraID:
+				if len(data) >= 5 && data[0]&0x1 != 0 {
+					modTime = time.Unix(int64(binary.LittleEndian.Uint32(data[1:])), 0)
+				}
+			}
+			extra = extra[4+l:]
+		}
+
+		p, e := cleanArchivePath(name)
+		if e != nil || p == "" {
+			continue
+		}
+		isDir := name[len(name)-1] == '/' || name[len(name)-1] == '\\'
+		m := ArchiveMember{Path: p, Dir: isDir, ModTime: modTime.UnixMilli(), Flags: flags, Method: method}
+		if !isDir {
+			if headerOffset >= uint64(size) || compressedSize > uint64(size) {
+				return nil, invalidArchiveError("invalid file header offset")
+			}
+			m.Size = int64(uncompressedSize)
+			m.CompressedSize = int64(compressedSize)
+			m.Offset = int64(headerOffset)
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+func readTarIndex(ctx context.Context, content types.IContentReader, size int64) ([]ArchiveMember, error) {
+	// tar reader seeks over the data if the reader is a io.Seeker, only the headers are read
+	sr := io.NewSectionReader(newContentReaderAt(ctx, content, size, tarIndexBlockSize), 0, size)
+	tr := tar.NewReader(sr)
+	members := make([]ArchiveMember, 0)
+	for {
+		if e := ctx.Err(); e != nil {
+			return nil, e
+		}
+		h, e := tr.Next()
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			return nil, invalidArchiveError(e.Error())
+		}
+		if h.Typeflag != tar.TypeDir && h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeRegA {
+			continue
+		}
+		p, e := cleanArchivePath(h.Name)
+		if e != nil || p == "" {
+			continue
+		}
+		offset, e := sr.Seek(0, io.SeekCurrent)
+		if e != nil {
+			return nil, e
+		}
+		m := ArchiveMember{Path: p, Dir: h.Typeflag == tar.TypeDir, ModTime: h.ModTime.UnixMilli()}
+		if !m.Dir {
+			m.Size = h.Size
+			m.Offset = offset
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+func zipDosTime(date, t uint16) time.Time {
+	return time.Date(
+		int(date>>9+1980), time.Month(date>>5&0xf), int(date&0x1f),
+		int(t>>11), int(t>>5&0x3f), int(t&0x1f*2), 0, time.UTC,
+	)
+}
+
+func invalidArchiveError(msg string) error {
+	return err.NewNotAllowedMessageError(i18n.T("api.extract.invalid_archive", msg))
+}
@@ -0,0 +1,212 @@
+package drive_util
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	err "go-drive/common/errors"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"io"
+	"testing"
+)
+
+// memContent is the content in memory
+type memContent struct {
+	data []byte
+}
+
+func (m *memContent) GetReader(_ context.Context, start, size int64) (io.ReadCloser, error) {
+	if start < 0 {
+		return io.NopCloser(bytes.NewReader(m.data)), nil
+	}
+	return io.NopCloser(bytes.NewReader(m.data[start : start+size])), nil
+}
+
+func (m *memContent) GetURL(context.Context) (*types.ContentURL, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+func (m *memContent) Name() string   { return "archive" }
+func (m *memContent) Size() int64    { return int64(len(m.data)) }
+func (m *memContent) ModTime() int64 { return 0 }
+
+type testArchiveFile struct {
+	name string
+	data []byte
+}
+
+func makeTar(t *testing.T, gz bool, files ...testArchiveFile) []byte {
+	buf := &bytes.Buffer{}
+	var w io.Writer = buf
+	var gw *gzip.Writer
+	if gz {
+		gw = gzip.NewWriter(buf)
+		w = gw
+	}
+	tw := tar.NewWriter(w)
+	for _, f := range files {
+		if e := tw.WriteHeader(&tar.Header{Name: f.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(f.data))}); e != nil {
+			t.Fatal(e)
+		}
+		if _, e := tw.Write(f.data); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if e := tw.Close(); e != nil {
+		t.Fatal(e)
+	}
+	if gw != nil {
+		if e := gw.Close(); e != nil {
+			t.Fatal(e)
+		}
+	}
+	return buf.Bytes()
+}
+
+// walkTestTar walks the tar, the content of the files is read if read is true
+func walkTestTar(data []byte, format string, limits ExtractLimits, read bool) ([]string, error) {
+	paths := make([]string, 0)
+	e := walkTar(task.DummyContext(), &memContent{data}, int64(len(data)), format, limits, func(f ArchiveFile) error {
+		paths = append(paths, f.Path)
+		if !read {
+			return nil
+		}
+		r, e := f.Open()
+		if e != nil {
+			return e
+		}
+		defer func() { _ = r.Close() }()
+		_, e = io.Copy(io.Discard, r)
+		return e
+	})
+	return paths, e
+}
+
+func TestWalkTarLargeFile(t *testing.T) {
+	// the random content is not compressible, the ratio is about 1
+	data := make([]byte, ratioCheckThreshold+1024*1024)
+	if _, e := rand.Read(data); e != nil {
+		t.Fatal(e)
+	}
+	limits := ExtractLimits{MaxRatio: 2}
+	archive := makeTar(t, true, testArchiveFile{"a/large.bin", data})
+	paths, e := walkTestTar(archive, ArchiveTarGz, limits, true)
+	if e != nil {
+		t.Errorf("unexpected error %v", e)
+	}
+	if len(paths) != 1 || paths[0] != "a/large.bin" {
+		t.Errorf("unexpected paths %v", paths)
+	}
+}
+
+func TestWalkTarRatio(t *testing.T) {
+	// the zeros are highly compressible
+	zeros := make([]byte, 2*ratioCheckThreshold)
+	limits := ExtractLimits{MaxRatio: 200}
+
+	archive := makeTar(t, true, testArchiveFile{"zeros.bin", zeros})
+	for _, read := range []bool{true, false} {
+		if _, e := walkTestTar(archive, ArchiveTarGz, limits, read); !err.IsNotAllowedError(e) {
+			t.Errorf("read %v: expect the ratio to be exceeded, but is %v", read, e)
+		}
+	}
+
+	// the ratio is not checked for the uncompressed tar
+	archive = makeTar(t, false, testArchiveFile{"zeros.bin", zeros})
+	if _, e := walkTestTar(archive, ArchiveTar, ExtractLimits{MaxRatio: 0.5}, true); e != nil {
+		t.Errorf("unexpected error %v", e)
+	}
+}
+
+func TestWalkTarLimits(t *testing.T) {
+	archive := makeTar(t, true,
+		testArchiveFile{"a", []byte("a")},
+		testArchiveFile{"b", []byte("bb")},
+		testArchiveFile{"c", []byte("ccc")},
+	)
+	if _, e := walkTestTar(archive, ArchiveTarGz, ExtractLimits{MaxFiles: 3, MaxSize: 6}, true); e != nil {
+		t.Errorf("unexpected error %v", e)
+	}
+	paths, e := walkTestTar(archive, ArchiveTarGz, ExtractLimits{MaxFiles: 2}, true)
+	if !err.IsNotAllowedError(e) {
+		t.Errorf("expect too many files, but is %v", e)
+	}
+	if len(paths) != 2 {
+		t.Errorf("expect the walking to be stopped at the third file, but is %v", paths)
+	}
+	if _, e := walkTestTar(archive, ArchiveTarGz, ExtractLimits{MaxSize: 5}, true); !err.IsNotAllowedError(e) {
+		t.Errorf("expect the size to be exceeded, but is %v", e)
+	}
+
+	archive = makeTar(t, false, testArchiveFile{"../a", []byte("a")})
+	if _, e := walkTestTar(archive, ArchiveTar, ExtractLimits{}, true); !err.IsNotAllowedError(e) {
+		t.Errorf("expect the unsafe path to be rejected, but is %v", e)
+	}
+}
+
+func TestWalkZipLimits(t *testing.T) {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, name := range []string{"a", "b/c"} {
+		w, e := zw.Create(name)
+		if e != nil {
+			t.Fatal(e)
+		}
+		_, _ = w.Write([]byte("data"))
+	}
+	if e := zw.Close(); e != nil {
+		t.Fatal(e)
+	}
+	data := buf.Bytes()
+	walk := func(limits ExtractLimits) error {
+		return walkZip(task.DummyContext(), &memContent{data}, int64(len(data)), limits,
+			func(ArchiveFile) error { return nil })
+	}
+	if e := walk(ExtractLimits{MaxFiles: 2, MaxSize: 8}); e != nil {
+		t.Errorf("unexpected error %v", e)
+	}
+	if e := walk(ExtractLimits{MaxFiles: 1}); !err.IsNotAllowedError(e) {
+		t.Errorf("expect too many files, but is %v", e)
+	}
+	if e := walk(ExtractLimits{MaxSize: 7}); !err.IsNotAllowedError(e) {
+		t.Errorf("expect the size to be exceeded, but is %v", e)
+	}
+}
+
+func TestCleanArchivePath(t *testing.T) {
+	cases := []struct {
+		name   string
+		path   string
+		reject bool
+	}{
+		{"a/b.txt", "a/b.txt", false},
+		{"./a//b/", "a/b", false},
+		{"a/../b", "b", false},
+		{"a\\b", "a/b", false},
+		{"", "", false},
+		{"/etc/passwd", "", true},
+		{"\\etc\\passwd", "", true},
+		{"C:/windows", "", true},
+		{"c:windows", "", true},
+		{"..", "", true},
+		{"../a", "", true},
+		{"a/../../b", "", true},
+		{"a\\..\\..\\b", "", true},
+	}
+	for _, c := range cases {
+		p, e := cleanArchivePath(c.name)
+		if c.reject {
+			if !err.IsNotAllowedError(e) {
+				t.Errorf("'%s': expect to be rejected, but is '%s', %v", c.name, p, e)
+			}
+			continue
+		}
+		if e != nil || p != c.path {
+			t.Errorf("'%s': expect '%s', but is '%s', %v", c.name, c.path, p, e)
+		}
+	}
+}
@@ -113,6 +113,10 @@ func (w *DriveFS) Rename(ctx context.Context, oldName, newName string) error {
 		return mapError(e)
 	}
 	_, e = w.drive.Move(task.NewContextWrapper(ctx), from, utils.CleanPath(newName), false)
+	if err.IsUnsupportedError(e) {
+		// moving across drives is not supported
+		return os.ErrPermission
+	}
 	return mapError(e)
 }
 
@@ -359,7 +363,7 @@ func (w *driveFSFile) GetURL(ctx context.Context) (string, error) {
 		return "", nil
 	}
 	if e != nil {
-		return "", e
+		return "", mapError(e)
 	}
 	if u.Proxy {
 		return "", nil
@@ -0,0 +1,187 @@
+package drive_util
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"hash"
+	"io"
+	"log"
+	"strings"
+)
+
+// hashFactories are the hash algorithms can be calculated from the content
+var hashFactories = map[string]func() hash.Hash{
+	types.HashMD5:    md5.New,
+	types.HashSHA1:   sha1.New,
+	types.HashSHA256: sha256.New,
+}
+
+// IsComputableHash returns true if the hash of the algorithm can be calculated from the content
+func IsComputableHash(algorithm string) bool {
+	_, ok := hashFactories[algorithm]
+	return ok
+}
+
+// GetEntryHasher returns the IEntryHasher of the entry, or nil if the entry does not provide hashes
+func GetEntryHasher(entry types.IEntry) types.IEntryHasher {
+	e := GetIEntry(entry, func(e types.IEntry) bool {
+		_, ok := e.(types.IEntryHasher)
+		return ok
+	})
+	if e == nil {
+		return nil
+	}
+	return e.(types.IEntryHasher)
+}
+
+// ComputeHash calculates the hash of the content by reading it.
+// The progress is reported if ctx is a TaskCtx.
+func ComputeHash(ctx context.Context, content types.IContentReader, algorithm string) (string, error) {
+	newHash, ok := hashFactories[algorithm]
+	if !ok {
+		return "", err.NewUnsupportedMessageError(i18n.T("drive.hash.unsupported_algorithm", algorithm))
+	}
+	taskCtx, ok := ctx.(types.TaskCtx)
+	if !ok {
+		taskCtx = task.NewContextWrapper(ctx)
+	}
+	reader, e := GetIContentReader(ctx, content, -1, -1)
+	if e != nil {
+		return "", e
+	}
+	defer func() { _ = reader.Close() }()
+	h := newHash()
+	// the hash is wrong if the content is partially read, so the errors must not be ignored
+	if _, e := io.Copy(h, ProgressReader(reader, taskCtx)); e != nil {
+		return "", e
+	}
+	if e := ctx.Err(); e != nil {
+		return "", e
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// HashWriter calculates the hashes of the data written to it
+type HashWriter struct {
+	hashes map[string]hash.Hash
+}
+
+// NewHashWriter creates a HashWriter calculating the hashes of the algorithms,
+// all the computable hashes are calculated if no algorithm specified.
+// The algorithms which are not computable are ignored.
+func NewHashWriter(algorithms ...string) *HashWriter {
+	hashes := make(map[string]hash.Hash, len(hashFactories))
+	for algorithm, newHash := range hashFactories {
+		if len(algorithms) > 0 {
+			if _, ok := utils.ArrayFind(algorithms,
+				func(a string, _ int) bool { return a == algorithm }); !ok {
+				continue
+			}
+		}
+		hashes[algorithm] = newHash()
+	}
+	return &HashWriter{hashes: hashes}
+}
+
+func (w *HashWriter) Write(p []byte) (int, error) {
+	for _, h := range w.hashes {
+		_, _ = h.Write(p)
+	}
+	return len(p), nil
+}
+
+// Sum returns the hex encoded hash of the algorithm, returns false if the algorithm is not calculated
+func (w *HashWriter) Sum(algorithm string) (string, bool) {
+	h, ok := w.hashes[algorithm]
+	if !ok {
+		return "", false
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// VerifyHash verifies the content of the saved entry after a transfer,
+// if both the saved entry and the source support the same hash algorithm.
+// source is the entry that the content is read from, it's nil if the content is not from an entry,
+// then the saved entry is verified with the hashes in written.
+// written is fed with the transferred content, the computed hashes of the source are taken from it.
+func VerifyHash(ctx context.Context, source types.IEntry, written *HashWriter, saved types.IEntry) error {
+	savedHasher := GetEntryHasher(saved)
+	if savedHasher == nil {
+		return nil
+	}
+	// reading the content for the hashes is not the progress of the transfer
+	ctx = task.NewContextWrapper(ctx)
+	var sourceHasher types.IEntryHasher
+	sourceAlgorithms, sourceComputed := []string(nil), true
+	if source != nil {
+		if sourceHasher = GetEntryHasher(source); sourceHasher == nil {
+			return nil
+		}
+		sourceAlgorithms, sourceComputed = sourceHasher.HashAlgorithms()
+	}
+	algorithms, _ := savedHasher.HashAlgorithms()
+	for _, algorithm := range algorithms {
+		if sourceHasher != nil {
+			if _, ok := utils.ArrayFind(sourceAlgorithms,
+				func(a string, _ int) bool { return a == algorithm }); !ok {
+				continue
+			}
+		}
+		expected, ok := "", false
+		if sourceComputed && written != nil {
+			// the computed hash of the source is the same as the hash of the content read from it
+			expected, ok = written.Sum(algorithm)
+		}
+		if !ok && sourceHasher != nil {
+			v, e := sourceHasher.Hash(ctx, algorithm)
+			if err.IsUnsupportedError(e) {
+				continue
+			}
+			if e != nil {
+				return e
+			}
+			expected, ok = v, true
+		}
+		if !ok {
+			continue
+		}
+		actual, e := savedHasher.Hash(ctx, algorithm)
+		if err.IsUnsupportedError(e) {
+			continue
+		}
+		if e != nil {
+			return e
+		}
+		if !strings.EqualFold(expected, actual) {
+			return hashMismatchError{errors.New(i18n.T("drive.hash.mismatch", saved.Path(), algorithm))}
+		}
+		return nil
+	}
+	return nil
+}
+
+// VerifySavedHash verifies the saved entry like VerifyHash,
+// and deletes the saved entry at path of d if the content does not match, so the corrupted file is not left.
+func VerifySavedHash(ctx types.TaskCtx, d types.IDrive, path string,
+	source types.IEntry, written *HashWriter, saved types.IEntry) error {
+	e := VerifyHash(ctx, source, written, saved)
+	if _, ok := e.(hashMismatchError); ok {
+		if de := d.Delete(task.NewContextWrapper(ctx), path); de != nil && !err.IsNotFoundError(de) {
+			log.Printf("failed to delete the mismatched file %s: %v", utils.LogSanitize(path), de)
+		}
+	}
+	return e
+}
+
+type hashMismatchError struct {
+	error
+}
@@ -221,6 +221,49 @@ type EntryTreeNode struct {
 type DoCopy = func(from types.IEntry, driveTo types.IDrive, to string, ctx types.TaskCtx) error
 type CopyCallback = func(entry types.IEntry, allProcessed bool, ctx types.TaskCtx) error
 
+const (
+	CopyStarted = "started"
+	CopyDone    = "done"
+)
+
+// CopyCheckpoint records the files copied by CopyAll, so that an interrupted copy can be resumed
+type CopyCheckpoint interface {
+	// State returns the state of the file to be copied to `to`,
+	// and the actual destination path if the copy has been started
+	State(to string) (state string, dest string)
+	// Start records the file to be copied to `to` is being written to `dest`
+	Start(to, dest string) error
+	// Done records the file to be copied to `to` is completely written
+	Done(to string) error
+	// Resuming returns true if the checkpoint is recorded by a previous copy
+	Resuming() bool
+}
+
+type copyCheckpointKey struct{}
+
+type checkpointCtx struct {
+	types.TaskCtx
+	checkpoint CopyCheckpoint
+}
+
+func (c *checkpointCtx) Value(key interface{}) interface{} {
+	if key == (copyCheckpointKey{}) {
+		return c.checkpoint
+	}
+	return c.TaskCtx.Value(key)
+}
+
+// WithCopyCheckpoint returns a TaskCtx carrying the checkpoint, which will be used by CopyAll
+func WithCopyCheckpoint(ctx types.TaskCtx, checkpoint CopyCheckpoint) types.TaskCtx {
+	return &checkpointCtx{ctx, checkpoint}
+}
+
+// GetCopyCheckpoint returns the checkpoint carried by the context, or nil
+func GetCopyCheckpoint(ctx context.Context) CopyCheckpoint {
+	cp, _ := ctx.Value(copyCheckpointKey{}).(CopyCheckpoint)
+	return cp
+}
+
 var ErrSkipDir = errors.New("skip")
 
 func buildEntriesTree(ctx types.TaskCtx, entry types.IEntry, filter func(types.IEntry) (bool, error), bytesProgress bool) (*EntryTreeNode, error) {
@@ -410,8 +453,18 @@ func copyAll(ctx types.TaskCtx, entry EntryTreeNode, driveTo types.IDrive, to st
 			}
 		}
 
-		if e := doCopy(entry.Entry, driveTo, to, ctx); e != nil {
-			return false, e
+		cp := GetCopyCheckpoint(ctx)
+		if state, _ := stateOf(cp, to); state == CopyDone {
+			ctx.Progress(entry.Entry.Size(), false)
+		} else {
+			if e := doCopy(entry.Entry, driveTo, to, ctx); e != nil {
+				return false, e
+			}
+			if cp != nil {
+				if e := cp.Done(to); e != nil {
+					return false, e
+				}
+			}
 		}
 	}
 	if e := after(entry.Entry, allProcessed, ctx); e != nil {
@@ -420,6 +473,15 @@ func copyAll(ctx types.TaskCtx, entry EntryTreeNode, driveTo types.IDrive, to st
 	return allProcessed, nil
 }
 
+func stateOf(cp CopyCheckpoint, to string) (string, string) {
+	if cp == nil {
+		return "", ""
+	}
+	return cp.State(to)
+}
+
+// CopyAll copies the entry and its descendants to `to` of driveTo.
+// If the ctx carries a CopyCheckpoint, the files recorded as done will be skipped.
 func CopyAll(ctx types.TaskCtx, entry types.IEntry, driveTo types.IDrive, to string,
 	doCopy DoCopy, after CopyCallback) error {
 	tree, e := BuildEntriesTree(ctx, entry, true)
@@ -433,9 +495,27 @@ func CopyAll(ctx types.TaskCtx, entry types.IEntry, driveTo types.IDrive, to str
 	return e
 }
 
+// CopyEntry copies the file by reading it to a temp file and saving it to driveTo.
+// The saved file is verified if both sides support the same hash algorithm, and deleted if it does not match.
 func CopyEntry(ctx types.TaskCtx, from types.IEntry, driveTo types.IDrive, to string,
 	override bool, tempDir string) error {
-	file, e := CopyIContentToTempFile(task.DummyContext(), from, tempDir)
+	reader, e := GetIContentReader(ctx, from, -1, -1)
+	if e != nil {
+		return e
+	}
+	var written *HashWriter
+	if hasher := GetEntryHasher(from); hasher != nil {
+		// the computed hashes of the source are calculated while reading
+		if algorithms, computed := hasher.HashAlgorithms(); computed {
+			written = NewHashWriter(algorithms...)
+		}
+	}
+	var r io.Reader = reader
+	if written != nil {
+		r = io.TeeReader(reader, written)
+	}
+	file, e := CopyReaderToTempFile(task.DummyContext(), r, tempDir)
+	_ = reader.Close()
 	if e != nil {
 		return e
 	}
@@ -443,8 +523,11 @@ func CopyEntry(ctx types.TaskCtx, from types.IEntry, driveTo types.IDrive, to st
 		_ = file.Close()
 		_ = os.Remove(file.Name())
 	}()
-	_, e = driveTo.Save(ctx, to, from.Size(), override, file)
-	return e
+	saved, e := driveTo.Save(ctx, to, from.Size(), override, file)
+	if e != nil {
+		return e
+	}
+	return VerifySavedHash(ctx, driveTo, to, from, written, saved)
 }
 
 // endregion
@@ -85,6 +85,16 @@ func (d NotAllowedError) Name() string {
 	return "NOT_ALLOWED"
 }
 
+// NotAllowedDataError 403, with the data telling the client what to do
+type NotAllowedDataError struct {
+	NotAllowedError
+	data types.M
+}
+
+func (d NotAllowedDataError) Data() types.M {
+	return d.data
+}
+
 // PermissionDeniedError 403
 type PermissionDeniedError struct {
 	msg string
@@ -187,6 +197,10 @@ func NewNotAllowedMessageError(msg string) NotAllowedError {
 	return NotAllowedError{msg}
 }
 
+func NewNotAllowedDataError(msg string, data types.M) NotAllowedDataError {
+	return NotAllowedDataError{NotAllowedError{msg}, data}
+}
+
 func NewUnsupportedError() UnsupportedError {
 	return UnsupportedError{}
 }
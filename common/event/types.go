@@ -10,4 +10,10 @@ const (
 	// EntryDeleted fires when an entry is deleted.
 	// The args is (types.DriveListenerContext, path).
 	EntryDeleted = "drive:entry_deleted"
+	// EntryOperated fires after an entry was written, copied, moved, deleted or downloaded, whether it succeeded or not.
+	// The args is (types.DriveListenerContext, types.EntryOperation).
+	EntryOperated = "drive:entry_operated"
+	// TaskUpdated fires when the status or progress of a task changed.
+	// The args is (task.Task).
+	TaskUpdated = "task:updated"
 )
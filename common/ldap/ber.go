@@ -0,0 +1,210 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// the BER tag classes and the constructed bit
+const (
+	classUniversal   = 0x00
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+)
+
+// the universal tags used by LDAP
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x10 | constructed
+	tagSet         = 0x11 | constructed
+)
+
+// maxPacketSize limits the size of the packets received from the server
+const maxPacketSize = 16 * 1024 * 1024
+
+var errMalformedPacket = errors.New("ldap: malformed packet")
+
+// packet is a BER encoded element, only the low tag numbers(< 31) are supported, which is enough for LDAP
+type packet struct {
+	tag      byte
+	value    []byte
+	children []*packet
+}
+
+func newSequence(children ...*packet) *packet {
+	return &packet{tag: tagSequence, children: children}
+}
+
+func newConstructed(tag byte, children ...*packet) *packet {
+	return &packet{tag: tag | constructed, children: children}
+}
+
+func newPrimitive(tag byte, value []byte) *packet {
+	return &packet{tag: tag, value: value}
+}
+
+func newString(tag byte, s string) *packet {
+	return newPrimitive(tag, []byte(s))
+}
+
+func newInteger(tag byte, v int64) *packet {
+	b := make([]byte, 0, 8)
+	for {
+		b = append([]byte{byte(v)}, b...)
+		v >>= 8
+		if (v == 0 && b[0]&0x80 == 0) || (v == -1 && b[0]&0x80 != 0) {
+			break
+		}
+	}
+	return newPrimitive(tag, b)
+}
+
+func newBoolean(tag byte, v bool) *packet {
+	if v {
+		return newPrimitive(tag, []byte{0xff})
+	}
+	return newPrimitive(tag, []byte{0x00})
+}
+
+func (p *packet) isConstructed() bool {
+	return p.tag&constructed != 0
+}
+
+func (p *packet) add(children ...*packet) *packet {
+	p.children = append(p.children, children...)
+	return p
+}
+
+func (p *packet) str() string {
+	return string(p.value)
+}
+
+func (p *packet) int() int64 {
+	var v int64
+	for i, b := range p.value {
+		if i == 0 && b&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int64(b)
+	}
+	return v
+}
+
+func (p *packet) child(i int) *packet {
+	if i < len(p.children) {
+		return p.children[i]
+	}
+	return &packet{}
+}
+
+func (p *packet) encode() []byte {
+	content := p.value
+	if p.isConstructed() {
+		content = make([]byte, 0)
+		for _, c := range p.children {
+			content = append(content, c.encode()...)
+		}
+	}
+	b := []byte{p.tag}
+	b = append(b, encodeLength(len(content))...)
+	return append(b, content...)
+}
+
+func encodeLength(l int) []byte {
+	if l < 0x80 {
+		return []byte{byte(l)}
+	}
+	b := make([]byte, 0, 4)
+	for ; l > 0; l >>= 8 {
+		b = append([]byte{byte(l)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+// readPacket reads a packet from r
+func readPacket(r *bufio.Reader) (*packet, error) {
+	tag, e := r.ReadByte()
+	if e != nil {
+		return nil, e
+	}
+	l, e := readLength(r)
+	if e != nil {
+		return nil, e
+	}
+	content := make([]byte, l)
+	if _, e := io.ReadFull(r, content); e != nil {
+		return nil, e
+	}
+	return decodePacket(tag, content)
+}
+
+func readLength(r *bufio.Reader) (int, error) {
+	b, e := r.ReadByte()
+	if e != nil {
+		return 0, e
+	}
+	if b&0x80 == 0 {
+		return int(b), nil
+	}
+	n := int(b & 0x7f)
+	if n == 0 || n > 4 {
+		// the indefinite length is not allowed in LDAP
+		return 0, errMalformedPacket
+	}
+	l := 0
+	for i := 0; i < n; i++ {
+		b, e := r.ReadByte()
+		if e != nil {
+			return 0, e
+		}
+		l = l<<8 | int(b)
+	}
+	if l > maxPacketSize {
+		return 0, errMalformedPacket
+	}
+	return l, nil
+}
+
+func decodePacket(tag byte, content []byte) (*packet, error) {
+	if tag&0x1f == 0x1f {
+		return nil, errMalformedPacket
+	}
+	p := &packet{tag: tag}
+	if !p.isConstructed() {
+		p.value = content
+		return p, nil
+	}
+	for len(content) > 0 {
+		if len(content) < 2 {
+			return nil, errMalformedPacket
+		}
+		childTag := content[0]
+		l, n := int(content[1]), 2
+		if l&0x80 != 0 {
+			lenBytes := l & 0x7f
+			if lenBytes == 0 || lenBytes > 4 || len(content) < 2+lenBytes {
+				return nil, errMalformedPacket
+			}
+			l = 0
+			for _, b := range content[2 : 2+lenBytes] {
+				l = l<<8 | int(b)
+			}
+			n += lenBytes
+		}
+		if l < 0 || len(content) < n+l {
+			return nil, errMalformedPacket
+		}
+		child, e := decodePacket(childTag, content[n:n+l])
+		if e != nil {
+			return nil, e
+		}
+		p.children = append(p.children, child)
+		content = content[n+l:]
+	}
+	return p, nil
+}
@@ -0,0 +1,118 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestInteger(t *testing.T) {
+	cases := []struct {
+		v   int64
+		hex string
+	}{
+		{0, "020100"},
+		{127, "02017f"},
+		{128, "02020080"},
+		{256, "02020100"},
+		{-1, "0201ff"},
+		{-128, "020180"},
+		{-129, "0202ff7f"},
+		{1<<31 - 1, "02047fffffff"},
+	}
+	for _, c := range cases {
+		p := newInteger(tagInteger, c.v)
+		if r := hex.EncodeToString(p.encode()); r != c.hex {
+			t.Errorf("%d: expect '%s', but is '%s'", c.v, c.hex, r)
+		}
+		if v := p.int(); v != c.v {
+			t.Errorf("%d: decoded as %d", c.v, v)
+		}
+	}
+}
+
+func TestEncodeLength(t *testing.T) {
+	cases := []struct {
+		l   int
+		hex string
+	}{
+		{0, "00"},
+		{0x7f, "7f"},
+		{0x80, "8180"},
+		{0xff, "81ff"},
+		{0x100, "820100"},
+		{0x10000, "83010000"},
+	}
+	for _, c := range cases {
+		if r := hex.EncodeToString(encodeLength(c.l)); r != c.hex {
+			t.Errorf("%d: expect '%s', but is '%s'", c.l, c.hex, r)
+		}
+	}
+}
+
+func TestDecodePacket(t *testing.T) {
+	p := newSequence(
+		newString(tagOctetString, "cn=a"),
+		newBoolean(tagBoolean, true),
+		newConstructed(classContext|1, newString(tagOctetString, string(bytes.Repeat([]byte("b"), 200)))),
+		newSequence(),
+	)
+	encoded := p.encode()
+	decoded, e := readPacket(bufio.NewReader(bytes.NewReader(encoded)))
+	if e != nil {
+		t.Error(e)
+		return
+	}
+	if !bytes.Equal(decoded.encode(), encoded) {
+		t.Errorf("expect '%x', but is '%x'", encoded, decoded.encode())
+	}
+	if v := decoded.child(0).str(); v != "cn=a" {
+		t.Errorf("expect '%s', but is '%s'", "cn=a", v)
+	}
+	if v := decoded.child(1).value; !bytes.Equal(v, []byte{0xff}) {
+		t.Errorf("expect 'ff', but is '%x'", v)
+	}
+	if v := decoded.child(2); v.tag != classContext|constructed|1 || len(v.child(0).value) != 200 {
+		t.Errorf("unexpected packet %x", v.encode())
+	}
+	if v := decoded.child(3); !v.isConstructed() || len(v.children) != 0 {
+		t.Errorf("unexpected packet %x", v.encode())
+	}
+	// the missing child is an empty packet
+	if v := decoded.child(4); v.tag != 0 || v.value != nil {
+		t.Errorf("unexpected packet %x", v.encode())
+	}
+}
+
+func TestDecodeMalformedPacket(t *testing.T) {
+	cases := []string{
+		// the high tag number
+		"1f0100",
+		// the indefinite length
+		"3080",
+		// the length of more than 4 bytes
+		"308500000000010000",
+		// the length exceeds maxPacketSize
+		"3084ffffffff",
+		// the child exceeds the parent
+		"30030405616263",
+		// the truncated length of the child
+		"300204",
+		"3003048101",
+		// the high tag number of the child
+		"30021f00",
+	}
+	for _, c := range cases {
+		b, _ := hex.DecodeString(c)
+		if _, e := readPacket(bufio.NewReader(bytes.NewReader(b))); e == nil {
+			t.Errorf("'%s': expect error", c)
+		}
+	}
+
+	// the truncated content
+	b, _ := hex.DecodeString("300504036162")
+	if _, e := readPacket(bufio.NewReader(bytes.NewReader(b))); e == nil {
+		t.Error("expect error of the truncated content")
+	}
+}
@@ -0,0 +1,223 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// the context tags of the filter choices
+const (
+	filterAnd             = 0
+	filterOr              = 1
+	filterNot             = 2
+	filterEqualityMatch   = 3
+	filterSubstrings      = 4
+	filterGreaterOrEqual  = 5
+	filterLessOrEqual     = 6
+	filterPresent         = 7
+	filterApproxMatch     = 8
+	filterExtensibleMatch = 9
+)
+
+// EscapeFilter escapes the special characters of the value used in a filter
+func EscapeFilter(s string) string {
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '*' || c == '(' || c == ')' || c == '\\' || c == 0 || c >= 0x80:
+			b.WriteString(fmt.Sprintf("\\%02x", c))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// compileFilter compiles the string representation of the filter(RFC 4515)
+func compileFilter(filter string) (*packet, error) {
+	filter = strings.TrimSpace(filter)
+	if filter == "" {
+		return nil, errors.New("ldap: empty filter")
+	}
+	if filter[0] != '(' {
+		filter = "(" + filter + ")"
+	}
+	p, pos, e := parseFilter(filter, 0)
+	if e != nil {
+		return nil, e
+	}
+	if pos != len(filter) {
+		return nil, fmt.Errorf("ldap: unexpected '%s' in filter", filter[pos:])
+	}
+	return p, nil
+}
+
+// parseFilter parses the filter starts at pos, returns the packet and the position after the filter
+func parseFilter(f string, pos int) (*packet, int, error) {
+	if pos >= len(f) || f[pos] != '(' {
+		return nil, pos, errors.New("ldap: filter must start with '('")
+	}
+	pos++
+	if pos >= len(f) {
+		return nil, pos, errors.New("ldap: unexpected end of filter")
+	}
+	switch f[pos] {
+	case '&', '|':
+		tag := byte(filterAnd)
+		if f[pos] == '|' {
+			tag = filterOr
+		}
+		p := newConstructed(classContext | tag)
+		pos++
+		for pos < len(f) && f[pos] == '(' {
+			child, next, e := parseFilter(f, pos)
+			if e != nil {
+				return nil, next, e
+			}
+			p.add(child)
+			pos = next
+		}
+		end, e := closeFilter(f, pos)
+		return p, end, e
+	case '!':
+		child, next, e := parseFilter(f, pos+1)
+		if e != nil {
+			return nil, next, e
+		}
+		end, e := closeFilter(f, next)
+		return newConstructed(classContext|filterNot, child), end, e
+	}
+	end := strings.IndexByte(f[pos:], ')')
+	if end < 0 {
+		return nil, pos, errors.New("ldap: unexpected end of filter")
+	}
+	p, e := parseItem(f[pos : pos+end])
+	if e != nil {
+		return nil, pos, e
+	}
+	return p, pos + end + 1, nil
+}
+
+func closeFilter(f string, pos int) (int, error) {
+	if pos >= len(f) || f[pos] != ')' {
+		return pos, errors.New("ldap: filter must end with ')'")
+	}
+	return pos + 1, nil
+}
+
+func parseItem(item string) (*packet, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, fmt.Errorf("ldap: invalid filter item '%s'", item)
+	}
+	attr, rawValue := item[:eq], item[eq+1:]
+
+	var tag byte
+	switch attr[len(attr)-1] {
+	case '~':
+		tag = filterApproxMatch
+	case '>':
+		tag = filterGreaterOrEqual
+	case '<':
+		tag = filterLessOrEqual
+	case ':':
+		return parseExtensible(attr[:len(attr)-1], rawValue)
+	default:
+		tag = filterEqualityMatch
+	}
+	if tag != filterEqualityMatch {
+		attr = attr[:len(attr)-1]
+	}
+	if attr == "" {
+		return nil, fmt.Errorf("ldap: invalid filter item '%s'", item)
+	}
+
+	if tag == filterEqualityMatch && rawValue == "*" {
+		return newString(classContext|filterPresent, attr), nil
+	}
+	if tag == filterEqualityMatch && strings.Contains(rawValue, "*") {
+		parts := strings.Split(rawValue, "*")
+		subs := newSequence()
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+			v, e := unescapeFilter(part)
+			if e != nil {
+				return nil, e
+			}
+			subTag := byte(1)
+			if i == 0 {
+				subTag = 0
+			} else if i == len(parts)-1 {
+				subTag = 2
+			}
+			subs.add(newString(classContext|subTag, v))
+		}
+		return newConstructed(classContext|filterSubstrings, newString(tagOctetString, attr), subs), nil
+	}
+
+	v, e := unescapeFilter(rawValue)
+	if e != nil {
+		return nil, e
+	}
+	return newConstructed(classContext|tag, newString(tagOctetString, attr), newString(tagOctetString, v)), nil
+}
+
+// parseExtensible parses the extensible match, desc is the part before ':='
+func parseExtensible(desc, rawValue string) (*packet, error) {
+	parts := strings.Split(desc, ":")
+	attr, rule, dnAttributes := parts[0], "", false
+	for _, part := range parts[1:] {
+		if strings.EqualFold(part, "dn") {
+			dnAttributes = true
+		} else if part != "" {
+			rule = part
+		}
+	}
+	if attr == "" && rule == "" {
+		return nil, fmt.Errorf("ldap: invalid extensible match '%s'", desc)
+	}
+	v, e := unescapeFilter(rawValue)
+	if e != nil {
+		return nil, e
+	}
+	p := newConstructed(classContext | filterExtensibleMatch)
+	if rule != "" {
+		p.add(newString(classContext|1, rule))
+	}
+	if attr != "" {
+		p.add(newString(classContext|2, attr))
+	}
+	p.add(newString(classContext|3, v))
+	if dnAttributes {
+		p.add(newBoolean(classContext|4, true))
+	}
+	return p, nil
+}
+
+func unescapeFilter(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b = append(b, s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", fmt.Errorf("ldap: invalid escape in '%s'", s)
+		}
+		d, e := hex.DecodeString(s[i+1 : i+3])
+		if e != nil {
+			return "", fmt.Errorf("ldap: invalid escape in '%s'", s)
+		}
+		b = append(b, d[0])
+		i += 2
+	}
+	return string(b), nil
+}
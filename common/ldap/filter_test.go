@@ -0,0 +1,72 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestCompileFilter(t *testing.T) {
+	cases := [][2]string{
+		{"(uid=foo)", "a30a0403756964" + "0403666f6f"},
+		{"uid=foo", "a30a0403756964" + "0403666f6f"},
+		{"(objectClass=*)", "870b" + hex.EncodeToString([]byte("objectClass"))},
+		{"(&(uid=a)(!(cn=b)))", "a015" + "a30804037569640401" + "61" + "a209" + "a3070402636e040162"},
+		{"(cn=a*b*c)", "a40f0402636e3009" + "800161" + "810162" + "820163"},
+		{"(cn=*b)", "a4090402636e3003" + "820162"},
+		{"(cn=a\\2ab)", "a3090402636e0403612a62"},
+		{"(uid>=5)", "a5080403756964040135"},
+		{"(member:1.2:=x)", "a910" + "8103312e32" + "82066d656d626572" + "830178"},
+	}
+	for _, c := range cases {
+		p, e := compileFilter(c[0])
+		if e != nil {
+			t.Errorf("'%s': unexpected error %v", c[0], e)
+			continue
+		}
+		if r := hex.EncodeToString(p.encode()); r != c[1] {
+			t.Errorf("'%s': expect '%s', but is '%s'", c[0], c[1], r)
+		}
+	}
+
+	for _, f := range []string{"", "(uid=foo", "(uid=foo))", "(=foo)", "(&(uid=foo)", "(cn=\\4)"} {
+		if _, e := compileFilter(f); e == nil {
+			t.Errorf("'%s': expect error", f)
+		}
+	}
+}
+
+func TestEscapeFilter(t *testing.T) {
+	if r := EscapeFilter("a*(b)\\"); r != "a\\2a\\28b\\29\\5c" {
+		t.Errorf("expect '%s', but is '%s'", "a\\2a\\28b\\29\\5c", r)
+	}
+	p, e := compileFilter("(cn=" + EscapeFilter("*)(uid=*") + ")")
+	if e != nil {
+		t.Error(e)
+		return
+	}
+	if v := p.child(1).str(); v != "*)(uid=*" {
+		t.Errorf("expect '%s', but is '%s'", "*)(uid=*", v)
+	}
+}
+
+func TestPacket(t *testing.T) {
+	long := bytes.Repeat([]byte("a"), 300)
+	p := newSequence(newInteger(tagInteger, 300), newInteger(tagInteger, -1),
+		newConstructed(classApplication|opSearchResultEntry, newPrimitive(tagOctetString, long)))
+	decoded, e := readPacket(bufio.NewReader(bytes.NewReader(p.encode())))
+	if e != nil {
+		t.Error(e)
+		return
+	}
+	if v := decoded.child(0).int(); v != 300 {
+		t.Errorf("expect %d, but is %d", 300, v)
+	}
+	if v := decoded.child(1).int(); v != -1 {
+		t.Errorf("expect %d, but is %d", -1, v)
+	}
+	if v := decoded.child(2).child(0).value; !bytes.Equal(v, long) {
+		t.Errorf("expect %d bytes, but is %d", len(long), len(v))
+	}
+}
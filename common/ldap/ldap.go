@@ -0,0 +1,323 @@
+// Package ldap is a minimal LDAPv3 client, which supports the simple bind, search and StartTLS
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ResultSuccess            = 0
+	ResultSizeLimitExceeded  = 4
+	ResultInvalidCredentials = 49
+)
+
+const (
+	ScopeBaseObject   = 0
+	ScopeSingleLevel  = 1
+	ScopeWholeSubtree = 2
+)
+
+// the application tags of the protocol operations
+const (
+	opBindRequest           = 0
+	opBindResponse          = 1
+	opUnbindRequest         = 2
+	opSearchRequest         = 3
+	opSearchResultEntry     = 4
+	opSearchResultDone      = 5
+	opSearchResultReference = 19
+	opExtendedRequest       = 23
+	opExtendedResponse      = 24
+)
+
+const oidStartTLS = "1.3.6.1.4.1.1466.20037"
+
+// Error is the error result returned by the server
+type Error struct {
+	ResultCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ldap: result code %d", e.ResultCode)
+	}
+	return fmt.Sprintf("ldap: result code %d: %s", e.ResultCode, e.Message)
+}
+
+// IsResultCode returns true if e is an Error with the result code
+func IsResultCode(e error, code int) bool {
+	var le *Error
+	return errors.As(e, &le) && le.ResultCode == code
+}
+
+type SearchRequest struct {
+	BaseDN string
+	Scope  int
+	// Filter is the string representation of the filter, e.g. (&(objectClass=person)(uid=foo))
+	Filter     string
+	Attributes []string
+	SizeLimit  int
+}
+
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// GetAttributeValues returns the values of the attribute, the name is case-insensitive
+func (e *Entry) GetAttributeValues(name string) []string {
+	for k, v := range e.Attributes {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+// GetAttributeValue returns the first value of the attribute
+func (e *Entry) GetAttributeValue(name string) string {
+	v := e.GetAttributeValues(name)
+	if len(v) == 0 {
+		return ""
+	}
+	return v[0]
+}
+
+// Conn is a connection to the LDAP server, the operations are executed one by one
+type Conn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	host    string
+	timeout time.Duration
+	msgID   int64
+	mu      sync.Mutex
+}
+
+// Dial connects to the server, rawURL is ldap://host[:port] or ldaps://host[:port].
+// timeout applies to the connecting and each operation.
+func Dial(rawURL string, tlsConfig *tls.Config, timeout time.Duration) (*Conn, error) {
+	u, e := url.Parse(rawURL)
+	if e != nil {
+		return nil, e
+	}
+	host, port := u.Hostname(), u.Port()
+	switch u.Scheme {
+	case "ldap":
+		if port == "" {
+			port = "389"
+		}
+	case "ldaps":
+		if port == "" {
+			port = "636"
+		}
+	default:
+		return nil, fmt.Errorf("ldap: unsupported scheme '%s'", u.Scheme)
+	}
+	conn, e := net.DialTimeout("tcp", net.JoinHostPort(host, port), timeout)
+	if e != nil {
+		return nil, e
+	}
+	c := &Conn{host: host, timeout: timeout}
+	if u.Scheme == "ldaps" {
+		tlsConn := tls.Client(conn, c.tlsConfig(tlsConfig))
+		_ = tlsConn.SetDeadline(time.Now().Add(timeout))
+		if e := tlsConn.Handshake(); e != nil {
+			_ = conn.Close()
+			return nil, e
+		}
+		conn = tlsConn
+	}
+	c.setConn(conn)
+	return c, nil
+}
+
+func (c *Conn) setConn(conn net.Conn) {
+	c.conn = conn
+	c.r = bufio.NewReader(conn)
+}
+
+func (c *Conn) tlsConfig(config *tls.Config) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName = c.host
+	}
+	return config
+}
+
+// StartTLS upgrades the connection to TLS
+func (c *Conn) StartTLS(tlsConfig *tls.Config) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	op := newConstructed(classApplication|opExtendedRequest, newString(classContext|0, oidStartTLS))
+	resp, e := c.roundTrip(op, opExtendedResponse)
+	if e != nil {
+		return e
+	}
+	if e := checkResult(resp); e != nil {
+		return e
+	}
+	tlsConn := tls.Client(c.conn, c.tlsConfig(tlsConfig))
+	if e := tlsConn.Handshake(); e != nil {
+		return e
+	}
+	c.setConn(tlsConn)
+	return nil
+}
+
+// Bind authenticates the connection by the simple bind.
+// An empty password is rejected because it makes an unauthenticated bind, which always succeeds.
+func (c *Conn) Bind(dn, password string) error {
+	if password == "" {
+		return &Error{ResultCode: ResultInvalidCredentials, Message: "empty password"}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	op := newConstructed(classApplication|opBindRequest,
+		newInteger(tagInteger, 3),
+		newString(tagOctetString, dn),
+		newString(classContext|0, password),
+	)
+	resp, e := c.roundTrip(op, opBindResponse)
+	if e != nil {
+		return e
+	}
+	return checkResult(resp)
+}
+
+func (c *Conn) Search(req SearchRequest) ([]*Entry, error) {
+	filter, e := compileFilter(req.Filter)
+	if e != nil {
+		return nil, e
+	}
+	attributes := newSequence()
+	for _, a := range req.Attributes {
+		attributes.add(newString(tagOctetString, a))
+	}
+	op := newConstructed(classApplication|opSearchRequest,
+		newString(tagOctetString, req.BaseDN),
+		newInteger(tagEnumerated, int64(req.Scope)),
+		// never dereference aliases
+		newInteger(tagEnumerated, 0),
+		newInteger(tagInteger, int64(req.SizeLimit)),
+		newInteger(tagInteger, int64(c.timeout/time.Second)),
+		newBoolean(tagBoolean, false),
+		filter,
+		attributes,
+	)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, e := c.send(op)
+	if e != nil {
+		return nil, e
+	}
+	entries := make([]*Entry, 0)
+	for {
+		resp, e := c.receive(id)
+		if e != nil {
+			return nil, e
+		}
+		switch resp.tag &^ constructed {
+		case classApplication | opSearchResultEntry:
+			entries = append(entries, parseEntry(resp))
+		case classApplication | opSearchResultReference:
+			// the referrals are not followed
+		case classApplication | opSearchResultDone:
+			return entries, checkResult(resp)
+		default:
+			return nil, errMalformedPacket
+		}
+	}
+}
+
+// Close sends the unbind request and closes the connection
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, _ = c.send(newPrimitive(classApplication|opUnbindRequest, nil))
+	return c.conn.Close()
+}
+
+// roundTrip sends the request and receives the response, which should be the op
+func (c *Conn) roundTrip(req *packet, op byte) (*packet, error) {
+	id, e := c.send(req)
+	if e != nil {
+		return nil, e
+	}
+	resp, e := c.receive(id)
+	if e != nil {
+		return nil, e
+	}
+	if resp.tag&^constructed != classApplication|op {
+		return nil, errMalformedPacket
+	}
+	return resp, nil
+}
+
+func (c *Conn) send(op *packet) (int64, error) {
+	c.msgID++
+	msg := newSequence(newInteger(tagInteger, c.msgID), op)
+	_ = c.conn.SetDeadline(time.Now().Add(c.timeout))
+	_, e := c.conn.Write(msg.encode())
+	return c.msgID, e
+}
+
+// receive reads the response of the message id, and returns the protocol operation
+func (c *Conn) receive(id int64) (*packet, error) {
+	for {
+		_ = c.conn.SetDeadline(time.Now().Add(c.timeout))
+		msg, e := readPacket(c.r)
+		if e != nil {
+			return nil, e
+		}
+		if msg.tag != tagSequence || len(msg.children) < 2 {
+			return nil, errMalformedPacket
+		}
+		msgID := msg.children[0].int()
+		if msgID == 0 {
+			// the unsolicited notification, e.g. notice of disconnection
+			if e := checkResult(msg.children[1]); e != nil {
+				return nil, e
+			}
+			return nil, errors.New("ldap: unexpected unsolicited notification")
+		}
+		if msgID == id {
+			return msg.children[1], nil
+		}
+	}
+}
+
+func checkResult(resp *packet) error {
+	if len(resp.children) < 3 {
+		return errMalformedPacket
+	}
+	code := int(resp.children[0].int())
+	if code == ResultSuccess {
+		return nil
+	}
+	return &Error{ResultCode: code, Message: resp.children[2].str()}
+}
+
+func parseEntry(p *packet) *Entry {
+	entry := &Entry{DN: p.child(0).str(), Attributes: make(map[string][]string)}
+	for _, attr := range p.child(1).children {
+		values := make([]string, 0, len(attr.child(1).children))
+		for _, v := range attr.child(1).children {
+			values = append(values, v.str())
+		}
+		entry.Attributes[attr.child(0).str()] = values
+	}
+	return entry
+}
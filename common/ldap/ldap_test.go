@@ -0,0 +1,161 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeServer serves the connection by handle, which returns the responses of the protocol operation
+func fakeServer(t *testing.T, handle func(op *packet) []*packet) *Conn {
+	client, server := net.Pipe()
+	go func() {
+		defer func() { _ = server.Close() }()
+		r := bufio.NewReader(server)
+		for {
+			msg, e := readPacket(r)
+			if e != nil {
+				return
+			}
+			id := msg.child(0).int()
+			if id <= 0 {
+				t.Errorf("unexpected message id %d", id)
+			}
+			for _, resp := range handle(msg.child(1)) {
+				if _, e := server.Write(newSequence(newInteger(tagInteger, id), resp).encode()); e != nil {
+					return
+				}
+			}
+		}
+	}()
+	c := &Conn{timeout: 5 * time.Second}
+	c.setConn(client)
+	return c
+}
+
+func newResult(op byte, code int64, message string) *packet {
+	return newConstructed(classApplication|op,
+		newInteger(tagEnumerated, code),
+		newString(tagOctetString, ""),
+		newString(tagOctetString, message),
+	)
+}
+
+func TestBind(t *testing.T) {
+	c := fakeServer(t, func(op *packet) []*packet {
+		if op.tag != classApplication|constructed|opBindRequest {
+			return nil
+		}
+		if v := op.child(0).int(); v != 3 {
+			t.Errorf("expect version 3, but is %d", v)
+		}
+		if op.child(2).tag != classContext|0 {
+			t.Errorf("expect the simple authentication, but is tag %x", op.child(2).tag)
+		}
+		if op.child(1).str() == "cn=admin,dc=example,dc=com" && op.child(2).str() == "secret" {
+			return []*packet{newResult(opBindResponse, ResultSuccess, "")}
+		}
+		return []*packet{newResult(opBindResponse, ResultInvalidCredentials, "invalid credentials")}
+	})
+	defer func() { _ = c.Close() }()
+
+	if e := c.Bind("cn=admin,dc=example,dc=com", "secret"); e != nil {
+		t.Errorf("unexpected error %v", e)
+	}
+	e := c.Bind("cn=admin,dc=example,dc=com", "wrong")
+	if !IsResultCode(e, ResultInvalidCredentials) {
+		t.Errorf("expect invalid credentials, but is %v", e)
+	}
+	if e.Error() != "ldap: result code 49: invalid credentials" {
+		t.Errorf("unexpected message '%s'", e.Error())
+	}
+	// the unauthenticated bind is rejected without sending
+	if e := c.Bind("cn=admin,dc=example,dc=com", ""); !IsResultCode(e, ResultInvalidCredentials) {
+		t.Errorf("expect invalid credentials, but is %v", e)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	c := fakeServer(t, func(op *packet) []*packet {
+		if op.tag != classApplication|constructed|opSearchRequest {
+			return nil
+		}
+		if v := op.child(0).str(); v != "ou=people,dc=example,dc=com" {
+			t.Errorf("expect base DN '%s', but is '%s'", "ou=people,dc=example,dc=com", v)
+		}
+		if v := op.child(1).int(); v != ScopeWholeSubtree {
+			t.Errorf("expect scope %d, but is %d", ScopeWholeSubtree, v)
+		}
+		if v := op.child(3).int(); v != 2 {
+			t.Errorf("expect size limit %d, but is %d", 2, v)
+		}
+		filter, _ := compileFilter("(uid=foo)")
+		if v := op.child(6).encode(); !bytes.Equal(v, filter.encode()) {
+			t.Errorf("expect filter '%x', but is '%x'", filter.encode(), v)
+		}
+		if v := op.child(7); len(v.children) != 2 || v.child(0).str() != "uid" || v.child(1).str() != "cn" {
+			t.Errorf("unexpected attributes '%x'", v.encode())
+		}
+		return []*packet{
+			newConstructed(classApplication|opSearchResultEntry,
+				newString(tagOctetString, "uid=foo,ou=people,dc=example,dc=com"),
+				newSequence(
+					newSequence(newString(tagOctetString, "uid"),
+						newConstructed(tagSet, newString(tagOctetString, "foo"))),
+					newSequence(newString(tagOctetString, "cn"),
+						newConstructed(tagSet, newString(tagOctetString, "Foo"), newString(tagOctetString, "F"))),
+				),
+			),
+			newConstructed(classApplication|opSearchResultReference,
+				newString(tagOctetString, "ldap://other/dc=example,dc=com")),
+			newResult(opSearchResultDone, ResultSizeLimitExceeded, ""),
+		}
+	})
+	defer func() { _ = c.Close() }()
+
+	entries, e := c.Search(SearchRequest{
+		BaseDN:     "ou=people,dc=example,dc=com",
+		Scope:      ScopeWholeSubtree,
+		Filter:     "(uid=foo)",
+		Attributes: []string{"uid", "cn"},
+		SizeLimit:  2,
+	})
+	if !IsResultCode(e, ResultSizeLimitExceeded) {
+		t.Errorf("expect size limit exceeded, but is %v", e)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expect 1 entry, but is %d", len(entries))
+		return
+	}
+	if entries[0].DN != "uid=foo,ou=people,dc=example,dc=com" {
+		t.Errorf("unexpected DN '%s'", entries[0].DN)
+	}
+	if v := entries[0].GetAttributeValue("UID"); v != "foo" {
+		t.Errorf("expect '%s', but is '%s'", "foo", v)
+	}
+	if v := entries[0].GetAttributeValues("cn"); !reflect.DeepEqual(v, []string{"Foo", "F"}) {
+		t.Errorf("expect %v, but is %v", []string{"Foo", "F"}, v)
+	}
+	if v := entries[0].GetAttributeValue("mail"); v != "" {
+		t.Errorf("expect empty, but is '%s'", v)
+	}
+
+	if _, e := c.Search(SearchRequest{Filter: "(uid=foo"}); e == nil {
+		t.Error("expect error of the invalid filter")
+	}
+}
+
+func TestUnexpectedResponse(t *testing.T) {
+	c := fakeServer(t, func(op *packet) []*packet {
+		// the search result instead of the bind response
+		return []*packet{newResult(opSearchResultDone, ResultSuccess, "")}
+	})
+	defer func() { _ = c.Close() }()
+
+	if e := c.Bind("cn=admin", "secret"); e != errMalformedPacket {
+		t.Errorf("expect %v, but is %v", errMalformedPacket, e)
+	}
+}
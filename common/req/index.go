@@ -236,6 +236,10 @@ func NewJsonBody(v interface{}) RequestBody {
 	return &byteBody{b: b, t: "application/json"}
 }
 
+func NewBytesBody(b []byte, contentType string) RequestBody {
+	return &byteBody{b: b, t: contentType}
+}
+
 type byteBody struct {
 	b []byte
 	t string
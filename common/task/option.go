@@ -39,3 +39,10 @@ func WithNameGroup(name, group string) Option {
 		t.Group = group
 	}
 }
+
+// WithUser sets the username of the user who started the task.
+func WithUser(username string) Option {
+	return func(t *Task) {
+		t.User = username
+	}
+}
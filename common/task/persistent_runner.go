@@ -0,0 +1,227 @@
+package task
+
+import (
+	"go-drive/common"
+	"go-drive/common/event"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store persists the tasks
+type Store interface {
+	// SaveTask creates or updates the task
+	SaveTask(task Task) error
+	// GetTask gets the task by id, returns ErrorNotFound if not exists
+	GetTask(id string) (Task, error)
+	// GetTasks gets the tasks in the group and started by the user, empty group or user matches all
+	GetTasks(group, user string) ([]Task, error)
+	DeleteTask(id string) error
+	// DeleteTasksBefore deletes the finished tasks last updated before the time
+	DeleteTasksBefore(before time.Time) error
+	// MarkInterrupted marks the unfinished tasks as Interrupted
+	MarkInterrupted() error
+}
+
+var (
+	historyThreshold = 7 * 24 * time.Hour
+	progressInterval = time.Second
+	// saveInterval is how many progressInterval to save the progress of running tasks
+	saveInterval = 5
+)
+
+// PersistentRunner executes tasks in TunnyRunner and saves them to Store,
+// so the tasks can be still queried after they are cleaned from memory or the server restarted.
+type PersistentRunner struct {
+	*TunnyRunner
+
+	store      Store
+	bus        event.Bus
+	mux        *sync.Mutex
+	ticks      int
+	tickerStop func()
+
+	// updates are published in a separate goroutine, because tasks may be created in event handlers,
+	// and the bus can not be published to while publishing.
+	// pending holds the latest unpublished update of each task, so publishing never blocks.
+	pending    map[string]Task
+	pendingMux *sync.Mutex
+	notify     chan struct{}
+	done       chan struct{}
+	disposed   *sync.Once
+}
+
+func NewPersistentRunner(config common.Config, store Store, bus event.Bus,
+	ch *registry.ComponentsHolder) (*PersistentRunner, error) {
+	if e := store.MarkInterrupted(); e != nil {
+		return nil, e
+	}
+	pr := &PersistentRunner{
+		store:      store,
+		bus:        bus,
+		mux:        &sync.Mutex{},
+		pending:    make(map[string]Task),
+		pendingMux: &sync.Mutex{},
+		notify:     make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		disposed:   &sync.Once{},
+	}
+	pr.TunnyRunner = newTunnyRunner(config, pr.save)
+	go pr.publishUpdates()
+	pr.tickerStop = utils.TimeTick(pr.tick, progressInterval)
+	ch.Add("taskRunner", pr)
+	return pr, nil
+}
+
+func (p *PersistentRunner) GetTask(id string) (Task, error) {
+	if t, e := p.TunnyRunner.GetTask(id); e == nil {
+		return t, nil
+	}
+	return p.store.GetTask(id)
+}
+
+func (p *PersistentRunner) GetTasks(group, user string) ([]Task, error) {
+	tasks, e := p.store.GetTasks(group, user)
+	if e != nil {
+		return nil, e
+	}
+	running, _ := p.TunnyRunner.GetTasks(group, user)
+	// tasks in memory have the latest progress
+	merged := make(map[string]Task, len(tasks)+len(running))
+	for _, t := range tasks {
+		merged[t.Id] = t
+	}
+	for _, t := range running {
+		merged[t.Id] = t
+	}
+	result := make([]Task, 0, len(merged))
+	for _, t := range merged {
+		result = append(result, t)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.After(result[j].CreatedAt) })
+	return result, nil
+}
+
+func (p *PersistentRunner) StopTask(id string) (Task, error) {
+	t, e := p.TunnyRunner.StopTask(id)
+	if e == ErrorNotFound {
+		// not in memory, it must be finished or interrupted
+		return p.store.GetTask(id)
+	}
+	return t, e
+}
+
+func (p *PersistentRunner) RemoveTask(id string) error {
+	if p.TunnyRunner.RemoveTask(id) == ErrorNotFound {
+		if _, e := p.store.GetTask(id); e != nil {
+			return e
+		}
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.store.DeleteTask(id)
+}
+
+func (p *PersistentRunner) Dispose() error {
+	var e error
+	p.disposed.Do(func() {
+		p.tickerStop()
+		defer close(p.done)
+		// unfinished tasks will be marked as interrupted on next startup if they can not be saved here
+		e = p.TunnyRunner.Dispose()
+	})
+	return e
+}
+
+func (p *PersistentRunner) save(t Task) {
+	if p.saveTask(t) {
+		p.publish(t)
+	}
+}
+
+// saveTask saves the task, returns false if the task has been removed
+func (p *PersistentRunner) saveTask(t Task) bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if !p.TunnyRunner.store.Has(t.Id) {
+		return false
+	}
+	if e := p.store.SaveTask(t); e != nil {
+		log.Printf("[PersistentRunner] failed to save task %s: %v", t.Id, e)
+	}
+	return true
+}
+
+// tick publishes the progress of running tasks,
+// saves the progress and cleans the expired tasks every saveInterval ticks
+func (p *PersistentRunner) tick() {
+	p.ticks++
+	persist := p.ticks%saveInterval == 0
+	running := make([]Task, 0)
+	p.TunnyRunner.store.IterCb(func(_ string, w *tunnyTaskCtx) {
+		if w.snapshot().Status != Running {
+			return
+		}
+		p.mux.Lock()
+		defer p.mux.Unlock()
+		// the status may be changed while waiting for the lock
+		t := w.snapshot()
+		if t.Status != Running {
+			return
+		}
+		if persist {
+			if e := p.store.SaveTask(t); e != nil {
+				log.Printf("[PersistentRunner] failed to save task %s: %v", t.Id, e)
+			}
+		}
+		running = append(running, t)
+	})
+	for _, t := range running {
+		p.publish(t)
+	}
+	if persist {
+		if e := p.store.DeleteTasksBefore(time.Now().Add(-historyThreshold)); e != nil {
+			log.Printf("[PersistentRunner] failed to clean tasks: %v", e)
+		}
+	}
+}
+
+// publish queues the update of the task, the unpublished update of the same task is replaced
+func (p *PersistentRunner) publish(t Task) {
+	p.pendingMux.Lock()
+	p.pending[t.Id] = t
+	p.pendingMux.Unlock()
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (p *PersistentRunner) publishUpdates() {
+	for {
+		select {
+		case <-p.notify:
+			p.pendingMux.Lock()
+			updates := make([]Task, 0, len(p.pending))
+			for _, t := range p.pending {
+				updates = append(updates, t)
+			}
+			p.pending = make(map[string]Task)
+			p.pendingMux.Unlock()
+			sort.Slice(updates, func(i, j int) bool { return updates[i].UpdatedAt.Before(updates[j].UpdatedAt) })
+			for _, t := range updates {
+				p.bus.Publish(event.TaskUpdated, t)
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *PersistentRunner) Status() (string, types.SM, error) {
+	return p.TunnyRunner.Status()
+}
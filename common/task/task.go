@@ -3,6 +3,7 @@ package task
 import (
 	"errors"
 	"go-drive/common/types"
+	"strings"
 	"time"
 )
 
@@ -12,6 +13,8 @@ const (
 	Done     = "done"
 	Error    = "error"
 	Canceled = "canceled"
+	// Interrupted means the task was not finished when the server stopped
+	Interrupted = "interrupted"
 )
 
 var (
@@ -37,10 +40,17 @@ type Task struct {
 	// meta data
 	Name  string `json:"name"`
 	Group string `json:"group"`
+	// User is the username of the user who started the task
+	User string `json:"user"`
 }
 
 func (t Task) Finished() bool {
-	return t.Status == Done || t.Status == Error || t.Status == Canceled
+	return t.Status == Done || t.Status == Error || t.Status == Canceled || t.Status == Interrupted
+}
+
+// InGroup returns true if the task is in the group or its subgroups
+func (t Task) InGroup(group string) bool {
+	return group == "" || t.Group == group || strings.HasPrefix(t.Group, group+"/")
 }
 
 type Runnable = func(ctx types.TaskCtx) (interface{}, error)
@@ -49,7 +59,8 @@ type Runner interface {
 	Execute(runnable Runnable, options ...Option) (Task, error)
 	ExecuteAndWait(runnable Runnable, timeout time.Duration, options ...Option) (Task, error)
 	GetTask(id string) (Task, error)
-	GetTasks(group string) ([]Task, error)
+	// GetTasks gets the tasks in the group and started by the user, empty group or user matches all
+	GetTasks(group, user string) ([]Task, error)
 	StopTask(id string) (Task, error)
 	RemoveTask(id string) error
 	Dispose() error
@@ -10,7 +10,6 @@ import (
 	"go-drive/common/types"
 	"go-drive/common/utils"
 	"log"
-	"strings"
 	"sync"
 	"time"
 
@@ -23,17 +22,26 @@ type TunnyRunner struct {
 	pool       *tunny.Pool
 	store      cmap.ConcurrentMap[string, *tunnyTaskCtx]
 	tickerStop func()
+
+	// onUpdate is called when the status of a task changed
+	onUpdate func(Task)
 }
 
 var cleanThreshold = 10 * time.Minute
 
 func NewTunnyRunner(config common.Config, ch *registry.ComponentsHolder) *TunnyRunner {
+	tr := newTunnyRunner(config, nil)
+	ch.Add("taskRunner", tr)
+	return tr
+}
+
+func newTunnyRunner(config common.Config, onUpdate func(Task)) *TunnyRunner {
 	tr := &TunnyRunner{
-		pool:  tunny.NewFunc(config.MaxConcurrentTask, executor),
-		store: cmap.New[*tunnyTaskCtx](),
+		pool:     tunny.NewFunc(config.MaxConcurrentTask, executor),
+		store:    cmap.New[*tunnyTaskCtx](),
+		onUpdate: onUpdate,
 	}
 	tr.tickerStop = utils.TimeTick(tr.clean, 30*time.Second)
-	ch.Add("taskRunner", tr)
 	return tr
 }
 
@@ -43,6 +51,7 @@ func (t *TunnyRunner) createTask(runnable Runnable, options ...Option) *tunnyTas
 		Status:    Pending,
 		Progress:  Progress{Loaded: 0, Total: 0},
 		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
 	}
 
 	for _, o := range options {
@@ -56,9 +65,11 @@ func (t *TunnyRunner) createTask(runnable Runnable, options ...Option) *tunnyTas
 		runnable: runnable,
 		task:     task,
 		mux:      &sync.RWMutex{},
+		onUpdate: t.onUpdate,
 	}
 
 	t.store.Set(task.Id, w)
+	w.notify()
 	return w
 }
 
@@ -87,10 +98,10 @@ func (t *TunnyRunner) ExecuteAndWait(runnable Runnable, timeout time.Duration, o
 	return *w.task, nil
 }
 
-func (t *TunnyRunner) GetTasks(group string) ([]Task, error) {
+func (t *TunnyRunner) GetTasks(group, user string) ([]Task, error) {
 	tasks := make([]Task, 0)
 	for _, w := range t.store.Items() {
-		if group == "" || w.task.Group == group || strings.HasPrefix(w.task.Group, group+"/") {
+		if w.task.InGroup(group) && (user == "" || w.task.User == user) {
 			tasks = append(tasks, *w.task)
 		}
 	}
@@ -189,6 +200,7 @@ type tunnyTaskCtx struct {
 	runnable Runnable
 	task     *Task
 	mux      *sync.RWMutex
+	onUpdate func(Task)
 }
 
 func (w *tunnyTaskCtx) Progress(loaded int64, abs bool) {
@@ -221,12 +233,26 @@ func (w *tunnyTaskCtx) Total(total int64, abs bool) {
 
 func (w *tunnyTaskCtx) cancel() {
 	w.mux.Lock()
-	defer w.mux.Unlock()
 	if w.Err() != nil {
+		w.mux.Unlock()
 		return
 	}
 	w.cancelFn()
 	w.task.Status = Canceled
+	w.mux.Unlock()
+	w.notify()
+}
+
+func (w *tunnyTaskCtx) snapshot() Task {
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+	return *w.task
+}
+
+func (w *tunnyTaskCtx) notify() {
+	if w.onUpdate != nil {
+		w.onUpdate(w.snapshot())
+	}
 }
 
 func executor(arg interface{}) interface{} {
@@ -236,6 +262,7 @@ func executor(arg interface{}) interface{} {
 	}
 	w.task.Status = Running
 	w.task.UpdatedAt = time.Now()
+	w.notify()
 	r, e := w.runnable(w)
 	if e != nil {
 		if errors.Is(e, context.Canceled) {
@@ -250,5 +277,6 @@ func executor(arg interface{}) interface{} {
 		w.task.Result = r
 	}
 	w.task.UpdatedAt = time.Now()
+	w.notify()
 	return nil
 }
@@ -1,6 +1,9 @@
 package types
 
-import "strings"
+import (
+	"strings"
+	"time"
+)
 
 type Option struct {
 	ID    uint   `gorm:"column:id;primaryKey;autoIncrement"`
@@ -13,6 +16,20 @@ type User struct {
 	Password string  `gorm:"column:password;not null;type:string;size:64" json:"password,omitempty"`
 	RootPath string  `gorm:"column:root_path;type:string;size:4096" json:"rootPath,omitempty"`
 	Groups   []Group `gorm:"many2many:user_groups;joinForeignKey:username;foreignKey:username" json:"groups"`
+	// Source is where the user is provisioned from, it's empty for the local users
+	Source string `gorm:"column:source;not null;type:string;size:16;default:''" json:"source,omitempty"`
+}
+
+const (
+	UserSourceOIDC = "oidc"
+	UserSourceLDAP = "ldap"
+)
+
+// UserIdentity links the subject of the OpenID Connect provider to the user
+type UserIdentity struct {
+	Issuer   string `gorm:"column:issuer;primaryKey;not null;type:string;size:255"`
+	Subject  string `gorm:"column:subject;primaryKey;not null;type:string;size:255"`
+	Username string `gorm:"column:username;not null;type:string;size:32;index"`
 }
 
 type Group struct {
@@ -72,19 +89,39 @@ func (DriveCache) TableName() string {
 
 type Permission uint8
 
+// Has returns true if p has all the bits of require
+func (p Permission) Has(require Permission) bool {
+	return p&require == require
+}
+
+// Readable returns true if the entry can be listed
 func (p Permission) Readable() bool {
-	return p&PermissionRead == PermissionRead
+	return p.Has(PermissionList)
 }
 
+// Writable returns true if any of the write operations is allowed
 func (p Permission) Writable() bool {
-	return p&PermissionWrite == PermissionWrite
+	return p&PermissionWrite != 0
 }
 
 const (
-	PermissionEmpty     Permission = 0
-	PermissionRead      Permission = 1 << 0
-	PermissionWrite     Permission = 1 << 1
-	PermissionReadWrite            = PermissionRead | PermissionWrite
+	PermissionEmpty Permission = 0
+	// PermissionList allows to see the entry and list the directory
+	PermissionList Permission = 1 << 0
+	// PermissionDownload allows to read the content of the file
+	PermissionDownload Permission = 1 << 1
+	// PermissionCreate allows to create files and directories
+	PermissionCreate Permission = 1 << 2
+	// PermissionOverwrite allows to overwrite the existing files
+	PermissionOverwrite Permission = 1 << 3
+	PermissionDelete    Permission = 1 << 4
+	// PermissionMove allows to rename or move the entry
+	PermissionMove  Permission = 1 << 5
+	PermissionShare Permission = 1 << 6
+
+	PermissionRead  = PermissionList | PermissionDownload
+	PermissionWrite = PermissionCreate | PermissionOverwrite | PermissionDelete | PermissionMove
+	PermissionAll   = PermissionRead | PermissionWrite | PermissionShare
 )
 
 const (
@@ -96,7 +133,8 @@ type PathPermission struct {
 	ID      uint    `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
 	Path    *string `gorm:"column:path;not null;type:string;size:4096" json:"path"`
 	Subject string  `gorm:"column:subject;not null;type:string;size:34" json:"subject"`
-	// Permission bits for the path which subject accessed: 1: read, 2: write
+	// Permission bits for the path which subject accessed:
+	// 1: list, 2: download, 4: create, 8: overwrite, 16: delete, 32: rename/move, 64: share
 	Permission Permission `gorm:"column:permission;not null" json:"permission"`
 	// Policy to apply to the permission when subject access this path: 0: REJECT, 1: ACCEPT
 	Policy uint8 `gorm:"column:policy;not null" json:"policy"`
@@ -108,6 +146,27 @@ type Job struct {
 	Job         string `gorm:"column:job;not null;type:string;size:64" json:"job"`
 	Params      string `gorm:"column:params;not null;type:text" json:"params"`
 	Schedule    string `gorm:"column:schedule;not null;type:string;size:64" json:"schedule"`
+	// Events is the comma separated drive event types that trigger the job, empty if the job is only scheduled by cron
+	Events string `gorm:"column:events;not null;type:string;size:255;default:''" json:"events"`
+	// PathPattern is the glob pattern of the paths of the events, empty matches all paths
+	PathPattern string `gorm:"column:path_pattern;not null;type:string;size:4096;default:''" json:"pathPattern"`
+	// Debounce is the duration to wait for more events after the last one,
+	// the events within it are coalesced into one execution
+	Debounce string `gorm:"column:debounce;not null;type:string;size:32;default:''" json:"debounce"`
+	// MaxConcurrency is the max number of the running executions triggered by events, 0 means 1
+	MaxConcurrency int `gorm:"column:max_concurrency;not null;default:0" json:"maxConcurrency"`
+	// MaxRetries is the max number of retries after the execution failed
+	MaxRetries int `gorm:"column:max_retries;not null;default:0" json:"maxRetries"`
+	// RetryBackoff is the delay before the first retry, it's doubled for each retry
+	RetryBackoff string `gorm:"column:retry_backoff;not null;type:string;size:32;default:''" json:"retryBackoff"`
+	// Timeout is the max duration of each execution attempt, empty means no timeout
+	Timeout string `gorm:"column:timeout;not null;type:string;size:32;default:''" json:"timeout"`
+	// NotifyOn is when to send the notifications, see JobNotifyOnFailure, JobNotifyOnSuccess and JobNotifyAlways
+	NotifyOn string `gorm:"column:notify_on;not null;type:string;size:16;default:''" json:"notifyOn"`
+	// NotifyWebhook is the url to post the execution result to
+	NotifyWebhook string `gorm:"column:notify_webhook;not null;type:string;size:4096;default:''" json:"notifyWebhook"`
+	// NotifyEmail is the comma separated email addresses to send the execution result to
+	NotifyEmail string `gorm:"column:notify_email;not null;type:string;size:1024;default:''" json:"notifyEmail"`
 	Enabled     bool   `gorm:"column:enabled;not null;type:bool" json:"enabled"`
 }
 
@@ -117,14 +176,229 @@ const (
 	JobExecutionFailed  = "failed"
 )
 
+const (
+	JobNotifyOnFailure = "failure"
+	JobNotifyOnSuccess = "success"
+	JobNotifyAlways    = "always"
+)
+
 type JobExecution struct {
 	ID          uint   `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
 	JobId       uint   `gorm:"column:job_id;not null;type:uint" json:"jobId"`
 	StartedAt   uint64 `gorm:"column:started_at;type:uint" json:"startedAt"`
 	CompletedAt uint64 `gorm:"column:completed_at;type:uint" json:"completedAt"`
 	Status      string `gorm:"column:status;not null;type:string" json:"status"`
-	Logs        string `gorm:"column:logs;type:string" json:"logs"`
-	ErrorMsg    string `gorm:"column:error_msg;type:text" json:"errorMsg"`
+	// Attempt is the attempt number of the execution, starts from 1
+	Attempt  int    `gorm:"column:attempt;not null;default:1" json:"attempt"`
+	Logs     string `gorm:"column:logs;type:string" json:"logs"`
+	ErrorMsg string `gorm:"column:error_msg;type:text" json:"errorMsg"`
+}
+
+type Share struct {
+	ID       string `gorm:"column:id;primaryKey;not null;type:string;size:32" json:"id"`
+	Path     string `gorm:"column:path;not null;type:string;size:4096" json:"path"`
+	Username string `gorm:"column:username;not null;type:string;size:32;index" json:"username"`
+	// Password is the bcrypt hash of the share password, empty if no password is required
+	Password string `gorm:"column:password;not null;type:string;size:64" json:"-"`
+	// AllowBrowse indicates whether the descendants of a shared folder can be listed
+	AllowBrowse bool `gorm:"column:allow_browse;not null;type:bool" json:"allowBrowse"`
+	// MaxDownloads is the maximum download count, 0 means unlimited
+	MaxDownloads uint `gorm:"column:max_downloads;not null" json:"maxDownloads"`
+	Downloads    uint `gorm:"column:downloads;not null" json:"downloads"`
+	// ExpiresAt is unix timestamp in milliseconds, 0 means never expires
+	ExpiresAt uint64 `gorm:"column:expires_at;not null" json:"expiresAt"`
+	CreatedAt uint64 `gorm:"column:created_at;not null" json:"createdAt"`
+}
+
+func (s Share) HasPassword() bool {
+	return s.Password != ""
+}
+
+func (s Share) IsExpired() bool {
+	return s.ExpiresAt > 0 && s.ExpiresAt < uint64(time.Now().UnixMilli())
+}
+
+func (s Share) IsDownloadLimitReached() bool {
+	return s.MaxDownloads > 0 && s.Downloads >= s.MaxDownloads
+}
+
+type TrashItem struct {
+	ID string `gorm:"column:id;primaryKey;not null;type:string;size:32" json:"id"`
+	// Path is the original path of the deleted entry
+	Path string `gorm:"column:path;not null;type:string;size:4096" json:"path"`
+	// TrashPath is the path of the deleted entry in the trash folder
+	TrashPath string    `gorm:"column:trash_path;not null;type:string;size:4096" json:"-"`
+	Type      EntryType `gorm:"column:type;not null;type:string;size:16" json:"type"`
+	Size      int64     `gorm:"column:size;not null" json:"size"`
+	DeletedBy string    `gorm:"column:deleted_by;not null;type:string;size:32;index" json:"deletedBy"`
+	// DeletedAt is unix timestamp in milliseconds
+	DeletedAt uint64 `gorm:"column:deleted_at;not null;index" json:"deletedAt"`
+}
+
+type FileVersion struct {
+	ID string `gorm:"column:id;primaryKey;not null;type:string;size:32" json:"id"`
+	// Path is the path of the versioned file
+	Path string `gorm:"column:path;not null;type:string;size:4096;index" json:"path"`
+	// VersionPath is the path where the content of this version is stored
+	VersionPath string `gorm:"column:version_path;not null;type:string;size:4096" json:"-"`
+	Size        int64  `gorm:"column:size;not null" json:"size"`
+	// ModTime is the modified time of this version, unix timestamp in milliseconds
+	ModTime   int64  `gorm:"column:mod_time;not null" json:"modTime"`
+	CreatedBy string `gorm:"column:created_by;not null;type:string;size:32" json:"createdBy"`
+	// CreatedAt is the time when this version was replaced, unix timestamp in milliseconds
+	CreatedAt uint64 `gorm:"column:created_at;not null;index" json:"createdAt"`
+}
+
+// TaskRecord is the persisted task.Task
+type TaskRecord struct {
+	ID     string `gorm:"column:id;primaryKey;not null;type:string;size:36"`
+	Name   string `gorm:"column:name;not null;type:string;size:4096"`
+	Group  string `gorm:"column:task_group;not null;type:string;size:255;index"`
+	User   string `gorm:"column:username;not null;type:string;size:32;index"`
+	Status string `gorm:"column:status;not null;type:string;size:16"`
+	Loaded int64  `gorm:"column:loaded;not null"`
+	Total  int64  `gorm:"column:total;not null"`
+	// Result and Error are JSON encoded
+	Result string `gorm:"column:result;type:text"`
+	Error  string `gorm:"column:error;type:text"`
+	// CreatedAt and UpdatedAt are unix timestamps in milliseconds
+	CreatedAt int64 `gorm:"column:created_at;not null;index"`
+	UpdatedAt int64 `gorm:"column:updated_at;not null;autoUpdateTime:milli"`
+}
+
+func (TaskRecord) TableName() string {
+	return "tasks"
+}
+
+// CopyCheckpoint records the parameters of a copy or move task, so that the task can be resumed
+type CopyCheckpoint struct {
+	ID string `gorm:"column:id;primaryKey;not null;type:string;size:32"`
+	// TaskID is the id of the latest task running with this checkpoint
+	TaskID   string `gorm:"column:task_id;not null;type:string;size:36;index"`
+	Username string `gorm:"column:username;not null;type:string;size:32"`
+	From     string `gorm:"column:from_path;not null;type:string;size:4096"`
+	To       string `gorm:"column:to_path;not null;type:string;size:4096"`
+	Override bool   `gorm:"column:override;not null;type:bool"`
+	Move     bool   `gorm:"column:move;not null;type:bool"`
+	// CreatedAt is unix timestamp in milliseconds
+	CreatedAt int64 `gorm:"column:created_at;not null;index"`
+}
+
+// CopyCheckpointEntry records the state of a file copied by the task of the checkpoint
+type CopyCheckpointEntry struct {
+	ID           uint   `gorm:"column:id;primaryKey;autoIncrement"`
+	CheckpointID string `gorm:"column:checkpoint_id;not null;type:string;size:32;index"`
+	Path         string `gorm:"column:path;not null;type:string;size:4096"`
+	State        string `gorm:"column:state;not null;type:string;size:16"`
+	// Dest is the actual path the file is written to
+	Dest string `gorm:"column:dest;not null;type:string;size:4096"`
+}
+
+type Webhook struct {
+	ID   uint   `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	Name string `gorm:"column:name;not null;type:string;size:255" json:"name" binding:"required"`
+	URL  string `gorm:"column:url;not null;type:string;size:4096" json:"url" binding:"required"`
+	// Secret is used to sign the payload with HMAC-SHA256
+	Secret string `gorm:"column:secret;not null;type:string;size:255" json:"secret"`
+	// Events is the comma separated event types
+	Events string `gorm:"column:events;not null;type:string;size:255" json:"events" binding:"required"`
+	// PathPattern is the glob pattern of the paths, empty matches all paths
+	PathPattern string `gorm:"column:path_pattern;not null;type:string;size:4096" json:"pathPattern"`
+	Enabled     bool   `gorm:"column:enabled;not null;type:bool" json:"enabled"`
+}
+
+const (
+	WebhookDeliveryPending = "pending"
+	WebhookDeliverySuccess = "success"
+	WebhookDeliveryFailed  = "failed"
+)
+
+type WebhookDelivery struct {
+	ID        uint   `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	WebhookID uint   `gorm:"column:webhook_id;not null;index" json:"webhookId"`
+	Event     string `gorm:"column:event;not null;type:string;size:64" json:"event"`
+	Payload   string `gorm:"column:payload;not null;type:text" json:"payload"`
+	Status    string `gorm:"column:status;not null;type:string;size:16" json:"status"`
+	// StatusCode is the response status code of the last attempt
+	StatusCode int    `gorm:"column:status_code;not null" json:"statusCode"`
+	Attempts   int    `gorm:"column:attempts;not null" json:"attempts"`
+	ErrorMsg   string `gorm:"column:error_msg;type:text" json:"errorMsg"`
+	// CreatedAt and CompletedAt are unix timestamps in milliseconds
+	CreatedAt   uint64 `gorm:"column:created_at;not null" json:"createdAt"`
+	CompletedAt uint64 `gorm:"column:completed_at;not null" json:"completedAt"`
+}
+
+const (
+	AuditResultSuccess = "success"
+	AuditResultFailed  = "failed"
+)
+
+type AuditLog struct {
+	ID uint `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	// Username is the actor, empty for anonymous users
+	Username string `gorm:"column:username;not null;type:string;size:32;index" json:"username"`
+	IP       string `gorm:"column:ip;not null;type:string;size:64" json:"ip"`
+	// Action is the entry operation like 'write', 'move', or the admin request like 'PUT /admin/drive/:name'
+	Action string `gorm:"column:action;not null;type:string;size:255;index" json:"action"`
+	Path   string `gorm:"column:path;not null;type:string;size:4096" json:"path"`
+	// To is the destination path of copying or moving
+	To      string `gorm:"column:to_path;not null;type:string;size:4096" json:"to"`
+	Result  string `gorm:"column:result;not null;type:string;size:16" json:"result"`
+	Message string `gorm:"column:message;type:text" json:"message"`
+	// CreatedAt is unix timestamp in milliseconds
+	CreatedAt int64 `gorm:"column:created_at;not null;index" json:"createdAt"`
+}
+
+// APIToken is the long-lived personal access token of the user
+type APIToken struct {
+	ID       uint   `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	Username string `gorm:"column:username;not null;type:string;size:32;index" json:"username"`
+	Name     string `gorm:"column:name;not null;type:string;size:64" json:"name"`
+	// Token is the SHA-256 hash of the token, the token itself is only shown once when it's created
+	Token string `gorm:"column:token;not null;type:string;size:64;uniqueIndex" json:"-"`
+	// Path restricts the token to the path and its descendants, empty means no restriction
+	Path string `gorm:"column:path;not null;type:string;size:4096" json:"path"`
+	// ReadOnly restricts the token to read the files only
+	ReadOnly bool `gorm:"column:read_only;not null;type:bool" json:"readOnly"`
+	// ExpiresAt is unix timestamp in milliseconds, 0 means never expires
+	ExpiresAt uint64 `gorm:"column:expires_at;not null" json:"expiresAt"`
+	CreatedAt uint64 `gorm:"column:created_at;not null" json:"createdAt"`
+	// LastUsedAt is unix timestamp in milliseconds, 0 means never used
+	LastUsedAt uint64 `gorm:"column:last_used_at;not null" json:"lastUsedAt"`
+	LastUsedIP string `gorm:"column:last_used_ip;not null;type:string;size:64" json:"lastUsedIP"`
+}
+
+func (t APIToken) IsExpired() bool {
+	return t.ExpiresAt > 0 && t.ExpiresAt < uint64(time.Now().UnixMilli())
+}
+
+// TwoFactor is the TOTP two-factor authentication of the user
+type TwoFactor struct {
+	Username string `gorm:"column:username;primaryKey;not null;type:string;size:32" json:"username"`
+	// Secret is the base32 encoded TOTP secret
+	Secret string `gorm:"column:secret;not null;type:string;size:64" json:"-"`
+	// Enabled is false until the user confirms the enrollment with a valid code
+	Enabled bool `gorm:"column:enabled;not null;type:bool" json:"enabled"`
+	// RecoveryCodes are the comma separated SHA-256 hashes of the unused recovery codes
+	RecoveryCodes string `gorm:"column:recovery_codes;not null;type:string;size:1024" json:"-"`
+	// LastStep is the time step of the last accepted code, a code can not be used twice
+	LastStep  int64  `gorm:"column:last_step;not null" json:"-"`
+	CreatedAt uint64 `gorm:"column:created_at;not null" json:"createdAt"`
+}
+
+// Quota limits the bytes can be written by the subject
+type Quota struct {
+	ID uint `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	// Subject is the user or the group, see UserSubject and GroupSubject
+	Subject string `gorm:"column:subject;not null;type:string;size:34;index" json:"subject" binding:"required"`
+	// Path limits the bytes under the path, empty limits the bytes across all the writable paths of the subject
+	Path string `gorm:"column:path;not null;type:string;size:4096" json:"path"`
+	// Limit is the max bytes, 0 means unlimited
+	Limit int64 `gorm:"column:limit_bytes;not null" json:"limit"`
+	// Used is the bytes written by the subject, it's tracked incrementally and can be recomputed
+	Used int64 `gorm:"column:used_bytes;not null" json:"used"`
+	// UpdatedAt is the unix timestamp in milliseconds when the usage was recomputed
+	UpdatedAt int64 `gorm:"column:updated_at;not null;autoUpdateTime:milli" json:"updatedAt"`
 }
 
 func UserSubject(username string) string {
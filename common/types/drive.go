@@ -90,6 +90,28 @@ type IDispatcherEntry interface {
 	GetRealPath() string
 }
 
+const (
+	HashMD5    = "md5"
+	HashSHA1   = "sha1"
+	HashSHA256 = "sha256"
+	// HashQuickXor is the quickXorHash of OneDrive, it's base64 encoded
+	HashQuickXor = "quickXorHash"
+)
+
+// IEntryHasher is the extension of IEntry.
+// Entries implement this interface to provide the hashes of the content.
+// The hashes are hex encoded in lower case, except HashQuickXor.
+// The wrapper IEntry must NOT implement this interface.
+type IEntryHasher interface {
+	// HashAlgorithms returns the hash algorithms that may be supported by this entry.
+	// computed is true if the hashes are calculated by reading the content,
+	// rather than provided by the backend.
+	HashAlgorithms() (algorithms []string, computed bool)
+	// Hash returns the hash of the content.
+	// Returns err.UnsupportedError if the hash of the algorithm is not available.
+	Hash(ctx context.Context, algorithm string) (string, error)
+}
+
 // DriveMeta is the metadata of drive
 type DriveMeta struct {
 	// Writable indicates is this drive writable
@@ -139,6 +161,14 @@ type IDispatcherDrive interface {
 	FindNonExistsEntryName(ctx context.Context, drive IDrive, path string) (string, error)
 }
 
+// IHardLinkDrive is the extension of IDrive.
+// Drives implement this interface to support hard links.
+type IHardLinkDrive interface {
+	// HardLink creates a hard link at 'to' to the file 'from', 'to' must not exist.
+	// Returns err.NewUnsupportedError if 'from' is not in this drive or the backend does not support hard links.
+	HardLink(ctx context.Context, from IEntry, to string) (IEntry, error)
+}
+
 const (
 	// LocalProvider is for smaller files. It's upload file directly
 	LocalProvider = "local"
@@ -4,3 +4,22 @@ type DriveListenerContext struct {
 	Session *Session
 	Drive   IDrive
 }
+
+const (
+	EntryOpWrite    = "write"
+	EntryOpMakeDir  = "mkdir"
+	EntryOpCopy     = "copy"
+	EntryOpMove     = "move"
+	EntryOpDelete   = "delete"
+	EntryOpDownload = "download"
+)
+
+// EntryOperation describes an operation on the entry, it's published no matter whether the operation succeeded
+type EntryOperation struct {
+	Action string
+	Path   string
+	// To is the destination path of copying or moving
+	To string
+	// Error is not nil if the operation failed
+	Error error
+}
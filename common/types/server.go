@@ -6,13 +6,43 @@ const (
 
 type Session struct {
 	User User
+	// ClientIP is the IP address of the current request, it's set on each request
+	ClientIP string
+	// Scope is the restriction of the API token, nil if the session is not authenticated by an API token
+	Scope *TokenScope
+	// TwoFactorPending is true if the user is required to enable the two-factor authentication,
+	// the session has no privilege of the admin group until then
+	TwoFactorPending bool
+}
+
+// TokenScope restricts what the session authenticated by an API token can do
+type TokenScope struct {
+	// TokenID is the id of the APIToken
+	TokenID uint
+	// Path restricts the access to the path and its descendants, empty means no restriction
+	Path     string
+	ReadOnly bool
+}
+
+// IsRestricted returns true if the session can not do everything the user can do
+func (s *Session) IsRestricted() bool {
+	return s.Scope != nil && (s.Scope.Path != "" || s.Scope.ReadOnly)
 }
 
 func (s *Session) IsAnonymous() bool {
 	return s.User.Username == ""
 }
 
+// IsEffectiveAdmin returns true if the session has the privileges of the admin group,
+// the sessions pending the two-factor enrollment or restricted by the API token scope have not
+func (s *Session) IsEffectiveAdmin() bool {
+	return !s.TwoFactorPending && !s.IsRestricted() && s.HasUserGroup(AdminUserGroup)
+}
+
 func (s *Session) HasUserGroup(group string) bool {
+	if group == AdminUserGroup && s.TwoFactorPending {
+		return false
+	}
 	for _, r := range s.User.Groups {
 		if r.Name == group {
 			return true
@@ -15,7 +15,7 @@ func init() {
 		ID:         0,
 		Path:       &rootPath,
 		Subject:    types.AnySubject,
-		Permission: types.PermissionReadWrite,
+		Permission: types.PermissionAll,
 		Policy:     types.PolicyAccept,
 	}})
 }
@@ -106,6 +106,10 @@ func makeSubjects(session types.Session) []string {
 		subjects = append(subjects, types.UserSubject(session.User.Username))
 		if session.User.Groups != nil {
 			for _, g := range session.User.Groups {
+				// the admin group is not effective until the two-factor authentication is enabled
+				if g.Name == types.AdminUserGroup && session.TwoFactorPending {
+					continue
+				}
 				subjects = append(subjects, types.GroupSubject(g.Name))
 			}
 		}
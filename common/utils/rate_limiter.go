@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the transfer rate in bytes per second.
+// It can be shared by multiple readers, then the total rate of them is limited.
+type RateLimiter struct {
+	rate int64
+	// next is the time when the next byte can be transferred
+	next time.Time
+	mu   sync.Mutex
+}
+
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	return &RateLimiter{rate: bytesPerSecond}
+}
+
+// Wait blocks until n bytes are allowed to be transferred, or ctx is done
+func (l *RateLimiter) Wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Reader returns a reader that reads from r with the rate limited
+func (l *RateLimiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	// read in small chunks, so that the rate is smooth
+	chunk := int(l.rate / 10)
+	if chunk < 1 {
+		chunk = 1
+	}
+	return &rateLimitedReader{r: r, l: l, ctx: ctx, chunk: chunk}
+}
+
+type rateLimitedReader struct {
+	r     io.Reader
+	l     *RateLimiter
+	ctx   context.Context
+	chunk int
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > r.chunk {
+		p = p[:r.chunk]
+	}
+	if e := r.l.Wait(r.ctx, len(p)); e != nil {
+		return 0, e
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(10 * 1024)
+	data := make([]byte, 15*1024)
+
+	start := time.Now()
+	read, e := io.ReadAll(l.Reader(context.Background(), bytes.NewReader(data)))
+	elapsed := time.Since(start)
+	if e != nil {
+		t.Error(e)
+		return
+	}
+	if len(read) != len(data) {
+		t.Errorf("expected %d bytes read, but %d", len(data), len(read))
+		return
+	}
+	if elapsed < time.Second || elapsed > 3*time.Second {
+		t.Errorf("expected about 1.5s elapsed, but %v", elapsed)
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	l := NewRateLimiter(1024)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	_, e := io.ReadAll(l.Reader(ctx, bytes.NewReader(make([]byte, 10*1024))))
+	if e != context.DeadlineExceeded {
+		t.Errorf("expected %v, but %v", context.DeadlineExceeded, e)
+	}
+}
@@ -4,11 +4,22 @@ import (
 	"crypto"
 	"encoding/binary"
 	"math/rand"
+	"sync"
 	"time"
 )
 
 type Signer struct {
 	secret []byte
+	// previous are the rotated secrets, they are only used to validate signatures
+	previous [][]byte
+	mux      *sync.RWMutex
+
+	// reload reloads the secrets when a signature is invalid,
+	// the secrets may have been rotated by another instance
+	reload         func() ([]byte, [][]byte, error)
+	reloadInterval time.Duration
+	reloadedAt     time.Time
+	reloadMux      sync.Mutex
 }
 
 func sha256(v []byte) []byte {
@@ -18,16 +29,71 @@ func sha256(v []byte) []byte {
 }
 
 func NewSigner() *Signer {
-	return &Signer{[]byte(RandString(16))}
+	return NewSignerWithSecrets([]byte(RandString(16)))
+}
+
+// NewSignerWithSecrets creates Signer with the secret to sign,
+// the previous secrets are still valid for validation
+func NewSignerWithSecrets(secret []byte, previous ...[]byte) *Signer {
+	s := &Signer{mux: &sync.RWMutex{}}
+	s.SetSecrets(secret, previous...)
+	return s
+}
+
+// SetSecrets replaces the secrets of this Signer
+func (s *Signer) SetSecrets(secret []byte, previous ...[]byte) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.secret = secret
+	s.previous = previous
+}
+
+// SetReloader sets the function to reload the secrets when a signature is invalid.
+// The secrets are reloaded at most once in interval.
+func (s *Signer) SetReloader(reload func() ([]byte, [][]byte, error), interval time.Duration) {
+	s.reloadMux.Lock()
+	defer s.reloadMux.Unlock()
+	s.reload = reload
+	s.reloadInterval = interval
+}
+
+// Reload reloads the secrets by the reloader
+func (s *Signer) Reload() error {
+	s.reloadMux.Lock()
+	defer s.reloadMux.Unlock()
+	return s.doReload()
+}
+
+func (s *Signer) doReload() error {
+	if s.reload == nil {
+		return nil
+	}
+	s.reloadedAt = time.Now()
+	secret, previous, e := s.reload()
+	if e != nil {
+		return e
+	}
+	s.SetSecrets(secret, previous...)
+	return nil
+}
+
+// tryReload reloads the secrets if they were not reloaded in the interval, returns true if reloaded
+func (s *Signer) tryReload() bool {
+	s.reloadMux.Lock()
+	defer s.reloadMux.Unlock()
+	if s.reload == nil || time.Since(s.reloadedAt) < s.reloadInterval {
+		return false
+	}
+	return s.doReload() == nil
 }
 
-func (s *Signer) sign(v string, notAfter int64, r uint32) string {
+func (s *Signer) sign(secret []byte, v string, notAfter int64, r uint32) string {
 	vByte := []byte(v)
-	buf := make([]byte, 4+8+len(vByte)+len(s.secret))
+	buf := make([]byte, 4+8+len(vByte)+len(secret))
 	binary.LittleEndian.PutUint32(buf, r)
 	binary.LittleEndian.PutUint64(buf[4:], uint64(notAfter))
 	copy(buf[4+8:], vByte)
-	copy(buf[4+8+len(vByte):], s.secret)
+	copy(buf[4+8+len(vByte):], secret)
 	signature := sha256(buf)
 
 	result := make([]byte, 4+8+32)
@@ -39,7 +105,9 @@ func (s *Signer) sign(v string, notAfter int64, r uint32) string {
 
 func (s *Signer) Sign(v string, notAfter time.Time) string {
 	r := rand.Uint32()
-	return s.sign(v, notAfter.Unix(), r)
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.sign(s.secret, v, notAfter.Unix(), r)
 }
 
 func (s *Signer) Validate(v string, signature string) bool {
@@ -49,11 +117,25 @@ func (s *Signer) Validate(v string, signature string) bool {
 	}
 	r := binary.LittleEndian.Uint32(buf)
 	notAfter := int64(binary.LittleEndian.Uint64(buf[4:]))
-
-	actualSignature := s.sign(v, notAfter, r)
-	if actualSignature != signature {
+	if notAfter <= time.Now().Unix() {
 		return false
 	}
+	if s.validate(v, signature, notAfter, r) {
+		return true
+	}
+	return s.tryReload() && s.validate(v, signature, notAfter, r)
+}
 
-	return notAfter > time.Now().Unix()
+func (s *Signer) validate(v string, signature string, notAfter int64, r uint32) bool {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if s.sign(s.secret, v, notAfter, r) == signature {
+		return true
+	}
+	for _, secret := range s.previous {
+		if s.sign(secret, v, notAfter, r) == signature {
+			return true
+		}
+	}
+	return false
 }
@@ -28,3 +28,49 @@ func TestSigner(t *testing.T) {
 		t.Errorf("test failed")
 	}
 }
+
+func TestSignerRotation(t *testing.T) {
+	s := NewSignerWithSecrets([]byte("secret1"))
+	signature := s.Sign("hello world", time.Now().Add(time.Minute))
+
+	s.SetSecrets([]byte("secret2"), []byte("secret1"))
+	if !s.Validate("hello world", signature) {
+		t.Errorf("expect signature signed by previous secret to be valid")
+	}
+	if !s.Validate("hello world", s.Sign("hello world", time.Now().Add(time.Minute))) {
+		t.Errorf("expect signature signed by current secret to be valid")
+	}
+
+	s.SetSecrets([]byte("secret3"), []byte("secret2"))
+	if s.Validate("hello world", signature) {
+		t.Errorf("expect signature signed by removed secret to be invalid")
+	}
+
+	s2 := NewSignerWithSecrets([]byte("secret3"))
+	if !s2.Validate("hello world", s.Sign("hello world", time.Now().Add(time.Minute))) {
+		t.Errorf("expect signers with the same secret to validate each other's signatures")
+	}
+}
+
+func TestSignerReload(t *testing.T) {
+	s1 := NewSignerWithSecrets([]byte("secret1"))
+	s2 := NewSignerWithSecrets([]byte("secret2"), []byte("secret1"))
+	signature := s2.Sign("hello world", time.Now().Add(time.Minute))
+	if s1.Validate("hello world", signature) {
+		t.Errorf("expect signature signed by unknown secret to be invalid")
+	}
+
+	reloaded := 0
+	s1.SetReloader(func() ([]byte, [][]byte, error) {
+		reloaded++
+		return []byte("secret2"), [][]byte{[]byte("secret1")}, nil
+	}, time.Minute)
+	if !s1.Validate("hello world", signature) {
+		t.Errorf("expect signature to be valid after the secrets reloaded")
+	}
+	s1.Validate("hello world", "invalid")
+	s1.Validate("hello world", s2.Sign("hello", time.Now().Add(time.Minute)))
+	if reloaded != 1 {
+		t.Errorf("expect the secrets reloaded once in the interval, but reloaded %d times", reloaded)
+	}
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpPeriod = 30
+	totpDigits = 6
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret generates a base32 encoded secret for TOTP
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, e := rand.Read(b); e != nil {
+		return "", e
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPStep returns the time step of t
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// TOTPCode generates the code of the time step(RFC 6238, HMAC-SHA1, 6 digits)
+func TOTPCode(secret string, step int64) (string, error) {
+	key, e := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if e != nil {
+		return "", e
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	h := hmac.New(sha1.New, key)
+	h.Write(msg)
+	sum := h.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", code%1000000), nil
+}
+
+// ValidateTOTP validates the code at time t, the adjacent skew steps are accepted.
+// It returns the matched time step.
+func ValidateTOTP(secret, code string, t time.Time, skew int64) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	step := TOTPStep(t)
+	for i := -skew; i <= skew; i++ {
+		expected, e := TOTPCode(secret, step+i)
+		if e != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step + i, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPURI builds the otpauth URI, which is usually shown as QR code to the authenticator apps
+func TOTPURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprintf("%d", totpDigits))
+	q.Set("period", fmt.Sprintf("%d", totpPeriod))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// test vectors of RFC 6238, the last 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for ts, expected := range cases {
+		code, e := TOTPCode(secret, TOTPStep(time.Unix(ts, 0)))
+		if e != nil {
+			t.Error(e)
+			return
+		}
+		if code != expected {
+			t.Errorf("%d: expect '%s', but is '%s'", ts, expected, code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, e := NewTOTPSecret()
+	if e != nil {
+		t.Error(e)
+		return
+	}
+	now := time.Now()
+	prev, _ := TOTPCode(secret, TOTPStep(now)-1)
+	if step, ok := ValidateTOTP(secret, prev, now, 1); !ok || step != TOTPStep(now)-1 {
+		t.Errorf("expect the code of the previous step is accepted")
+	}
+	old, _ := TOTPCode(secret, TOTPStep(now)-2)
+	if _, ok := ValidateTOTP(secret, old, now, 1); ok {
+		t.Errorf("expect the code of 2 steps ago is rejected")
+	}
+	if _, ok := ValidateTOTP(secret, "12345", now, 1); ok {
+		t.Errorf("expect the code of 5 digits is rejected")
+	}
+}
@@ -4,6 +4,19 @@ declare const drive: DriveInstance;
 
 declare function log(s: string): void;
 
+interface JobEvent {
+  /** 'entry.updated' or 'entry.deleted' */
+  type: string;
+  path: string;
+  /** username of the user who triggered the event */
+  user: string;
+  /** unix timestamp in milliseconds */
+  timestamp: number;
+}
+
+/** the events that triggered this execution, empty if it's triggered by the schedule */
+declare const events: JobEvent[];
+
 /** copy files */
 declare function cp(from: string, to: string, override: boolean): DriveEntry;
 /** move files */
@@ -15,6 +15,9 @@ const (
 
 type Access struct {
 	rootDrive *RootDrive
+	trash     *Trash
+	versions  *Versions
+	quotas    *Quotas
 
 	perms         utils.PermMap
 	permMux       *sync.RWMutex
@@ -28,11 +31,17 @@ type Access struct {
 
 func NewAccess(ch *registry.ComponentsHolder,
 	rootDrive *RootDrive,
+	trash *Trash,
+	versions *Versions,
+	quotas *Quotas,
 	permissionDAO *storage.PathPermissionDAO,
 	options *storage.OptionsDAO, bus event.Bus) (*Access, error) {
 
 	da := &Access{
 		rootDrive:     rootDrive,
+		trash:         trash,
+		versions:      versions,
+		quotas:        quotas,
 		permMux:       &sync.RWMutex{},
 		permissionDAO: permissionDAO,
 		options:       options,
@@ -48,7 +57,7 @@ func NewAccess(ch *registry.ComponentsHolder,
 }
 
 func (da *Access) GetChroot(s types.Session) (*Chroot, error) {
-	if s.HasUserGroup(types.AdminUserGroup) {
+	if s.IsEffectiveAdmin() {
 		return nil, nil
 	}
 	rootPath := ""
@@ -78,7 +87,7 @@ func (da *Access) GetDrive(session types.Session) (types.IDrive, error) {
 	da.permMux.RUnlock()
 
 	var drive types.IDrive = NewListenerWrapper(
-		NewPermissionWrapperDrive(da.rootDrive.Get(), perms.Filter(session)),
+		NewPermissionWrapperDrive(da.wrapRootDrive(session), perms.Filter(session)),
 		types.DriveListenerContext{
 			Session: &session,
 			Drive:   da.rootDrive.Get(),
@@ -88,16 +97,77 @@ func (da *Access) GetDrive(session types.Session) (types.IDrive, error) {
 	if chroot != nil {
 		drive = NewChrootWrapper(drive, chroot)
 	}
+	if session.IsRestricted() {
+		drive = NewPermissionWrapperDrive(drive, ScopePerms(session.Scope))
+	}
 
 	return drive, nil
 }
 
+// ScopePerms makes the permissions that only allow to access the path of the API token scope,
+// the path is in the drive of the user, i.e. inside the chroot
+func ScopePerms(scope *types.TokenScope) utils.PermMap {
+	path := scope.Path
+	permission := types.PermissionAll
+	if scope.ReadOnly {
+		permission = types.PermissionRead
+	}
+	return utils.NewPermMap([]types.PathPermission{{
+		Path:       &path,
+		Subject:    types.AnySubject,
+		Permission: permission,
+		Policy:     types.PolicyAccept,
+	}})
+}
+
+// GetShareDrive returns a read-only drive jailed in the shared path.
+// The permissions of the share owner are applied to the drive.
+func (da *Access) GetShareDrive(owner types.Session, path string, isDir bool) types.IDrive {
+	da.permMux.RLock()
+	perms := da.perms
+	da.permMux.RUnlock()
+
+	var chroot *Chroot
+	if isDir {
+		chroot = NewChroot(path, nil)
+	} else {
+		chroot = NewChroot(utils.PathParent(path), []string{utils.PathBase(path)})
+	}
+
+	return NewChrootWrapper(
+		NewReadOnlyWrapperDrive(
+			NewListenerWrapper(
+				NewPermissionWrapperDrive(da.rootDrive.Get(), perms.Filter(owner)),
+				types.DriveListenerContext{
+					Session: &types.Session{},
+					Drive:   da.rootDrive.Get(),
+				},
+				da.bus,
+			),
+		),
+		chroot,
+	)
+}
+
 func (da *Access) GetRootDrive() types.IDrive {
+	return NewListenerWrapper(da.wrapRootDrive(types.Session{}), types.DriveListenerContext{
+		Drive: da.rootDrive.Get(),
+	}, da.bus)
+}
+
+// GetUnwrappedRootDrive returns the root drive without the versioning, trash and quota layers,
+// it's used by the jobs managing their own files, the deleted and overwritten files are not kept.
+func (da *Access) GetUnwrappedRootDrive() types.IDrive {
 	return NewListenerWrapper(da.rootDrive.Get(), types.DriveListenerContext{
 		Drive: da.rootDrive.Get(),
 	}, da.bus)
 }
 
+// wrapRootDrive wraps the root drive with the versioning, trash and quota layers
+func (da *Access) wrapRootDrive(session types.Session) types.IDrive {
+	return da.quotas.Wrap(da.trash.Wrap(da.versions.Wrap(da.rootDrive.Get(), session), session), session)
+}
+
 func (da *Access) GetPerms() utils.PermMap {
 	da.permMux.RLock()
 	defer da.permMux.RUnlock()
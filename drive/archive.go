@@ -0,0 +1,286 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+	"go-drive/common/drive_util"
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"io"
+	path2 "path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// archivesCacheNs is the cache namespace of the archive indexes, ':' is not allowed in drive names
+	archivesCacheNs = ":archives"
+	archiveIndexTTL = 24 * time.Hour
+)
+
+// archiveMounts browses the zip and tar files as read-only directories.
+// The index of the archive is cached by the archive path, modification time and size.
+type archiveMounts struct {
+	cache drive_util.DriveCache
+	lock  *utils.KeyLock
+}
+
+func newArchiveMounts(cache drive_util.DriveCache) *archiveMounts {
+	return &archiveMounts{cache: cache, lock: utils.NewKeyLock(0)}
+}
+
+// resolveArchive finds the archive file in the path of the drive,
+// returns the archive and the path in it, or nil if the path is not in an archive.
+// If includeSelf is true, the path itself may be the archive.
+func resolveArchive(ctx context.Context, drive types.IDrive, path string, includeSelf bool) (types.IEntry, string, error) {
+	if path == "" {
+		return nil, "", nil
+	}
+	segments := strings.Split(path, "/")
+	n := len(segments)
+	if !includeSelf {
+		n--
+	}
+	for i := 0; i < n; i++ {
+		if !drive_util.IsMountableFormat(drive_util.GetArchiveFormat(segments[i])) {
+			continue
+		}
+		entry, e := drive.Get(ctx, strings.Join(segments[:i+1], "/"))
+		if e != nil {
+			if err.IsNotFoundError(e) {
+				return nil, "", nil
+			}
+			return nil, "", e
+		}
+		if entry.Type().IsFile() {
+			return entry, strings.Join(segments[i+1:], "/"), nil
+		}
+	}
+	return nil, "", nil
+}
+
+// requireNotInArchive returns error if the path is in an archive
+func requireNotInArchive(ctx context.Context, drive types.IDrive, path string) error {
+	archive, _, e := resolveArchive(ctx, drive, path, false)
+	if e != nil {
+		return e
+	}
+	if archive != nil {
+		return err.NewNotAllowedMessageError(i18n.T("drive.dispatcher.archive_readonly"))
+	}
+	return nil
+}
+
+// isArchiveEntry returns true if the entry is in an archive
+func isArchiveEntry(entry types.IEntry) bool {
+	return drive_util.GetIEntry(entry, func(e types.IEntry) bool {
+		_, ok := e.(*archiveEntry)
+		return ok
+	}) != nil
+}
+
+// getIndex returns the cache path of the archive index, the index is built if it's not cached
+func (a *archiveMounts) getIndex(ctx context.Context, driveName string, archive types.IEntry) (string, error) {
+	base := path2.Join(driveName, archive.Path())
+	root := path2.Join(base, fmt.Sprintf("@%d_%d", archive.ModTime(), archive.Size()))
+	if cached, e := a.cache.GetEntryRaw(root); e != nil || cached != nil {
+		return root, e
+	}
+
+	a.lock.Lock(base)
+	defer a.lock.UnLock(base)
+	if cached, e := a.cache.GetEntryRaw(root); e != nil || cached != nil {
+		return root, e
+	}
+
+	content, ok := archive.(types.IContent)
+	if !ok {
+		return "", err.NewNotAllowedMessageError(i18n.T("api.extract.unsupported_format"))
+	}
+	format := drive_util.GetArchiveFormat(content.Name())
+	members, e := drive_util.ReadArchiveIndex(ctx, content, archive.Size(), format)
+	if e != nil {
+		return "", e
+	}
+
+	// the directories may be omitted in the archive
+	children := map[string][]types.IEntry{"": {}}
+	var addMember func(m drive_util.ArchiveMember)
+	addMember = func(m drive_util.ArchiveMember) {
+		if m.Dir {
+			if _, ok := children[m.Path]; ok {
+				return
+			}
+			children[m.Path] = []types.IEntry{}
+		}
+		parent := utils.PathParent(m.Path)
+		if _, ok := children[parent]; !ok {
+			addMember(drive_util.ArchiveMember{Path: parent, Dir: true, ModTime: m.ModTime})
+		}
+		children[parent] = append(children[parent],
+			&archiveEntry{path: path2.Join(root, m.Path), format: format, member: m})
+	}
+	for _, m := range members {
+		addMember(m)
+	}
+
+	// the index of the old version
+	_ = a.cache.Evict(base, true)
+	// the parents must be put before the children, putting children replaces the existing child nodes
+	dirs := make([]string, 0, len(children))
+	for dir := range children {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool { return utils.PathDepth(dirs[i]) < utils.PathDepth(dirs[j]) })
+	for _, dir := range dirs {
+		if e := a.cache.PutChildren(utils.CleanPath(path2.Join(root, dir)), children[dir], archiveIndexTTL); e != nil {
+			return "", e
+		}
+	}
+	// the root is put at last to indicate the index is complete
+	rootEntry := &archiveEntry{path: root, format: format,
+		member: drive_util.ArchiveMember{Dir: true, ModTime: archive.ModTime()}}
+	if e := a.cache.PutEntry(rootEntry, archiveIndexTTL); e != nil {
+		return "", e
+	}
+	return root, nil
+}
+
+// Get returns the entry in the archive, path is the path in the dispatcher
+func (a *archiveMounts) Get(ctx context.Context, d *DispatcherDrive, path, driveName string,
+	archive types.IEntry, inner string) (types.IEntry, error) {
+	root, e := a.getIndex(ctx, driveName, archive)
+	if e != nil {
+		return nil, e
+	}
+	item, e := a.cache.GetEntryRaw(path2.Join(root, inner))
+	if e != nil {
+		return nil, e
+	}
+	if item == nil {
+		return nil, err.NewNotFoundError()
+	}
+	return newArchiveEntry(d, path, driveName, archive, *item), nil
+}
+
+// List returns the children of the directory in the archive, path is the path in the dispatcher
+func (a *archiveMounts) List(ctx context.Context, d *DispatcherDrive, path, driveName string,
+	archive types.IEntry, inner string) ([]types.IEntry, error) {
+	root, e := a.getIndex(ctx, driveName, archive)
+	if e != nil {
+		return nil, e
+	}
+	dir := utils.CleanPath(path2.Join(root, inner))
+	if inner != "" {
+		item, e := a.cache.GetEntryRaw(dir)
+		if e != nil {
+			return nil, e
+		}
+		if item == nil || !item.Type.IsDir() {
+			return nil, err.NewNotFoundError()
+		}
+	}
+	items, e := a.cache.GetChildrenRaw(dir)
+	if e != nil {
+		return nil, e
+	}
+	entries := make([]types.IEntry, 0, len(items))
+	for _, item := range items {
+		entries = append(entries,
+			newArchiveEntry(d, path2.Join(path, utils.PathBase(item.Path)), driveName, archive, item))
+	}
+	return entries, nil
+}
+
+// archiveEntry is the file or directory in the archive
+type archiveEntry struct {
+	d         *DispatcherDrive
+	path      string
+	driveName string
+	archive   types.IEntry
+	format    string
+	member    drive_util.ArchiveMember
+}
+
+func newArchiveEntry(d *DispatcherDrive, path, driveName string, archive types.IEntry,
+	item drive_util.EntryCacheItem) *archiveEntry {
+	return &archiveEntry{
+		d: d, path: path, driveName: driveName, archive: archive,
+		format: item.Data["format"],
+		member: drive_util.ArchiveMember{
+			Path:           item.Data["path"],
+			Dir:            item.Type.IsDir(),
+			Size:           item.Size,
+			ModTime:        item.ModTime,
+			Method:         uint16(types.SV(item.Data["method"]).Uint(0)),
+			Flags:          uint16(types.SV(item.Data["flags"]).Uint(0)),
+			Offset:         types.SV(item.Data["offset"]).Int64(0),
+			CompressedSize: types.SV(item.Data["compressedSize"]).Int64(0),
+		},
+	}
+}
+
+func (a *archiveEntry) Path() string {
+	return a.path
+}
+
+func (a *archiveEntry) Type() types.EntryType {
+	if a.member.Dir {
+		return types.TypeDir
+	}
+	return types.TypeFile
+}
+
+func (a *archiveEntry) Size() int64 {
+	if a.member.Dir {
+		return -1
+	}
+	return a.member.Size
+}
+
+func (a *archiveEntry) Meta() types.EntryMeta {
+	return types.EntryMeta{Readable: true, Writable: false}
+}
+
+func (a *archiveEntry) ModTime() int64 {
+	return a.member.ModTime
+}
+
+func (a *archiveEntry) Name() string {
+	return utils.PathBase(a.path)
+}
+
+func (a *archiveEntry) GetReader(ctx context.Context, start, size int64) (io.ReadCloser, error) {
+	return drive_util.OpenArchiveMember(ctx, a.archive.(types.IContentReader), a.format, a.member, start, size)
+}
+
+func (a *archiveEntry) GetURL(context.Context) (*types.ContentURL, error) {
+	return nil, err.NewUnsupportedError()
+}
+
+func (a *archiveEntry) Drive() types.IDrive {
+	return a.d
+}
+
+func (a *archiveEntry) GetDispatchedDrive() (string, types.IDrive) {
+	return a.driveName, a.archive.Drive()
+}
+
+func (a *archiveEntry) GetRealPath() string {
+	return path2.Join(a.driveName, a.archive.Path(), a.member.Path)
+}
+
+func (a *archiveEntry) EntryData() types.SM {
+	return types.SM{
+		"path":           a.member.Path,
+		"format":         a.format,
+		"method":         strconv.Itoa(int(a.member.Method)),
+		"flags":          strconv.Itoa(int(a.member.Flags)),
+		"offset":         strconv.FormatInt(a.member.Offset, 10),
+		"compressedSize": strconv.FormatInt(a.member.CompressedSize, 10),
+	}
+}
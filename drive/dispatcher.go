@@ -36,6 +36,7 @@ type DispatcherDrive struct {
 	tempDir string
 
 	mountStorage *storage.PathMountDAO
+	archives     *archiveMounts
 
 	drivesLock *utils.KeyLock
 }
@@ -196,6 +197,13 @@ func (d *DispatcherDrive) Get(ctx context.Context, path string) (types.IEntry, e
 	if e != nil {
 		return nil, e
 	}
+	archive, inner, e := resolveArchive(ctx, drive, realPath, false)
+	if e != nil {
+		return nil, e
+	}
+	if archive != nil {
+		return d.archives.Get(ctx, d, path, driveName, archive, inner)
+	}
 	entry, e := drive.Get(ctx, realPath)
 	if e != nil {
 		return nil, e
@@ -242,6 +250,9 @@ func (d *DispatcherDrive) Save(ctx types.TaskCtx, path string, size int64,
 	if e != nil {
 		return nil, e
 	}
+	if e := requireNotInArchive(ctx, drive, realPath); e != nil {
+		return nil, e
+	}
 	if e := d.ensureDir(ctx, driveName, drive, utils.PathParent(realPath)); e != nil {
 		return nil, e
 	}
@@ -291,6 +302,9 @@ func (d *DispatcherDrive) MakeDir(ctx context.Context, path string) (types.IEntr
 	if e != nil {
 		return nil, e
 	}
+	if e := requireNotInArchive(ctx, drive, realPath); e != nil {
+		return nil, e
+	}
 	if e := d.ensureDir(ctx, driveName, drive, utils.PathParent(realPath)); e != nil {
 		return nil, e
 	}
@@ -307,10 +321,15 @@ func (d *DispatcherDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string,
 	if e != nil {
 		return nil, e
 	}
+	if e := requireNotInArchive(ctx, driveTo, pathTo); e != nil {
+		return nil, e
+	}
+	checkpoint := drive_util.GetCopyCheckpoint(ctx)
+	resuming := checkpoint != nil && checkpoint.Resuming()
 	mounts, _ := d.resolveMountedChildren(from.Path())
 	if len(mounts) == 0 {
 		// if `from` has no mounted children, then copy
-		if !override {
+		if !override && !resuming {
 			pathTo, e = d.FindNonExistsEntryName(ctx, driveTo, pathTo)
 			if e != nil {
 				return nil, e
@@ -327,6 +346,17 @@ func (d *DispatcherDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string,
 	// if `from` has mounted children, we need to copy them
 	e = drive_util.CopyAll(ctx, from, d, to,
 		func(from types.IEntry, _ types.IDrive, to string, ctx types.TaskCtx) error {
+			key, override := to, override
+			if checkpoint != nil {
+				if state, dest := checkpoint.State(key); state == drive_util.CopyStarted {
+					// the previous copy was interrupted, skip the file if it was completely written
+					if copied, e := d.Get(ctx, dest); e == nil && copied.Size() == from.Size() {
+						ctx.Progress(from.Size(), false)
+						return nil
+					}
+					to, override = dest, true
+				}
+			}
 			_, driveTo, pathTo, e := d.resolve(to)
 			ctxWrapper := task.NewCtxWrapper(ctx, true, false)
 			if e != nil {
@@ -339,6 +369,12 @@ func (d *DispatcherDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string,
 					return e
 				}
 			}
+			if checkpoint != nil {
+				dest := path2.Join(utils.PathParent(to), utils.PathBase(pathTo))
+				if e := checkpoint.Start(key, dest); e != nil {
+					return e
+				}
+			}
 			_, e = driveTo.Copy(ctxWrapper, from, pathTo, true)
 			if e == nil {
 				return nil
@@ -361,6 +397,9 @@ func (d *DispatcherDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string,
 }
 
 func (d *DispatcherDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	if isArchiveEntry(from) {
+		return nil, err.NewNotAllowedMessageError(i18n.T("drive.dispatcher.archive_readonly"))
+	}
 	driveName, driveTo, pathTo, e := d.resolve(to)
 	// if path depth is 1, move mounts
 	if e != nil && utils.PathDepth(to) != 1 {
@@ -402,7 +441,9 @@ func (d *DispatcherDrive) Move(ctx types.TaskCtx, from types.IEntry, to string,
 		}
 	}
 	if driveTo != nil {
-
+		if e := requireNotInArchive(ctx, driveTo, pathTo); e != nil {
+			return nil, e
+		}
 		if !override {
 			pathTo, e = d.FindNonExistsEntryName(ctx, driveTo, pathTo)
 			if e != nil {
@@ -413,7 +454,7 @@ func (d *DispatcherDrive) Move(ctx types.TaskCtx, from types.IEntry, to string,
 		move, e := driveTo.Move(ctx, from, pathTo, override)
 		if e != nil {
 			if err.IsUnsupportedError(e) {
-				return nil, err.NewNotAllowedMessageError(i18n.T("drive.dispatcher.move_across_not_supported"))
+				return nil, err.NewUnsupportedMessageError(i18n.T("drive.dispatcher.move_across_not_supported"))
 			}
 			return nil, e
 		}
@@ -441,11 +482,25 @@ func (d *DispatcherDrive) List(ctx context.Context, path string) ([]types.IEntry
 		if e != nil {
 			return nil, e
 		}
-		list, e := drive.List(ctx, realPath)
+		archive, inner, e := resolveArchive(ctx, drive, realPath, true)
 		if e != nil {
 			return nil, e
 		}
-		entries = d.mapDriveEntries(path, driveName, list)
+		if archive != nil {
+			entries, e = d.archives.List(ctx, d, path, driveName, archive, inner)
+			if e != nil {
+				return nil, e
+			}
+		} else {
+			list, e := drive.List(ctx, realPath)
+			if e != nil {
+				return nil, e
+			}
+			if utils.IsRootPath(realPath) {
+				list = filterInternalDirs(list)
+			}
+			entries = d.mapDriveEntries(path, driveName, list)
+		}
 	}
 
 	ms := d.mounts()[path]
@@ -501,6 +556,9 @@ func (d *DispatcherDrive) Delete(ctx types.TaskCtx, path string) error {
 	if utils.IsRootPath(path) {
 		return err.NewNotAllowedError()
 	}
+	if e := requireNotInArchive(ctx, drive, path); e != nil {
+		return e
+	}
 	return drive.Delete(ctx, path)
 }
 
@@ -510,6 +568,9 @@ func (d *DispatcherDrive) Upload(ctx context.Context, path string, size int64,
 	if e != nil {
 		return nil, e
 	}
+	if e := requireNotInArchive(ctx, drive, realPath); e != nil {
+		return nil, e
+	}
 	if e := d.ensureDir(ctx, driveName, drive, utils.PathParent(realPath)); e != nil {
 		return nil, e
 	}
@@ -539,6 +600,18 @@ func (d *DispatcherDrive) Upload(ctx context.Context, path string, size int64,
 	return r, nil
 }
 
+// filterInternalDirs hides the trash and versions folders in the root of drive
+func filterInternalDirs(entries []types.IEntry) []types.IEntry {
+	filtered := make([]types.IEntry, 0, len(entries))
+	for _, e := range entries {
+		name := utils.PathBase(e.Path())
+		if name != TrashDirName && name != VersionsDirName {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
 func (d *DispatcherDrive) mapDriveEntry(path string, driveName string, entry types.IEntry) types.IEntry {
 	return &entryWrapper{d: d, path: path, IEntry: entry, driveName: driveName}
 }
@@ -202,6 +202,25 @@ func (f *Drive) Move(_ types.TaskCtx, from types.IEntry, to string, override boo
 	return f.newFsFile(toPath, stat)
 }
 
+func (f *Drive) HardLink(_ context.Context, from types.IEntry, to string) (types.IEntry, error) {
+	from = drive_util.GetSelfEntry(f, from)
+	if from == nil || from.Type().IsDir() {
+		return nil, err.NewUnsupportedError()
+	}
+	toPath := f.getPath(to)
+	if e := requireFile(toPath, false); e != nil {
+		return nil, e
+	}
+	if e := os.Link(f.getPath(from.(*fsFile).path), toPath); e != nil {
+		return nil, e
+	}
+	stat, e := os.Stat(toPath)
+	if e != nil {
+		return nil, e
+	}
+	return f.newFsFile(toPath, stat)
+}
+
 func (f *Drive) List(_ context.Context, path string) ([]types.IEntry, error) {
 	path = f.getPath(path)
 	isDir, e := utils.IsDir(path)
@@ -335,3 +354,15 @@ func (f *fsFile) GetReader(ctx context.Context, start, size int64) (io.ReadClose
 func (f *fsFile) GetURL(context.Context) (*types.ContentURL, error) {
 	return nil, err.NewUnsupportedError()
 }
+
+// HashAlgorithms returns the computed sha256 only, the hash is calculated by reading the content
+func (f *fsFile) HashAlgorithms() ([]string, bool) {
+	return []string{types.HashSHA256}, true
+}
+
+func (f *fsFile) Hash(ctx context.Context, algorithm string) (string, error) {
+	if f.isDir || algorithm != types.HashSHA256 {
+		return "", err.NewUnsupportedError()
+	}
+	return drive_util.ComputeHash(ctx, f, algorithm)
+}
@@ -304,6 +304,18 @@ func (f *ftpEntry) GetURL(context.Context) (*types.ContentURL, error) {
 	return nil, err.NewUnsupportedError()
 }
 
+// HashAlgorithms returns the computed sha256 only, the hash is calculated by reading the content
+func (f *ftpEntry) HashAlgorithms() ([]string, bool) {
+	return []string{types.HashSHA256}, true
+}
+
+func (f *ftpEntry) Hash(ctx context.Context, algorithm string) (string, error) {
+	if f.isDir || algorithm != types.HashSHA256 {
+		return "", err.NewUnsupportedError()
+	}
+	return drive_util.ComputeHash(ctx, f, algorithm)
+}
+
 func mapError(e error) error {
 	fe, ok := e.(goftp.Error)
 	if !ok {
@@ -287,7 +287,7 @@ func (g *GDrive) List(ctx context.Context, path string) ([]types.IEntry, error)
 		}
 		resp, e := req.
 			Q(fmt.Sprintf("'%s' in parents and trashed = false", id)).
-			Fields("files(id,name,mimeType,parents,hasThumbnail,thumbnailLink,modifiedTime,driveId,size," +
+			Fields("files(id,name,mimeType,parents,hasThumbnail,thumbnailLink,modifiedTime,driveId,size,md5Checksum," +
 				"shortcutDetails,capabilities(canDownload,canEdit,canDelete,canCopy)),nextPageToken").
 			PageToken(nextPageToken).
 			PageSize(1000).
@@ -385,6 +385,7 @@ func (g *GDrive) newEntry(parentPath string, file *drive.File) *gdriveEntry {
 		isDir: file.MimeType == typeFolder || targetMime == typeFolder,
 		size:  size, modTime: utils.Millisecond(modTime),
 		targetId: targetId, targetMime: targetMime, thumbnail: thumbnail,
+		md5: file.Md5Checksum,
 	}
 }
 
@@ -396,6 +397,8 @@ type gdriveEntry struct {
 	// targetMime is the target mimeType, if it's a shortcut
 	targetMime string
 	thumbnail  string
+	// md5 is the md5Checksum of the file, it's empty if not fetched
+	md5 string
 
 	path    string
 	isDir   bool
@@ -513,6 +516,31 @@ func (g *gdriveEntry) EntryData() types.SM {
 	return types.SM{
 		"i": g.id, "m": g.mime,
 		"ti": g.targetId, "tm": g.targetMime,
-		"th": g.thumbnail,
+		"th": g.thumbnail, "h": g.md5,
 	}
 }
+
+func (g *gdriveEntry) HashAlgorithms() ([]string, bool) {
+	return []string{types.HashMD5}, false
+}
+
+func (g *gdriveEntry) Hash(ctx context.Context, algorithm string) (string, error) {
+	if g.isDir || algorithm != types.HashMD5 {
+		return "", err.NewUnsupportedError()
+	}
+	if g.md5 != "" {
+		return g.md5, nil
+	}
+	// md5Checksum is not included in the responses of creating or updating files
+	file, e := g.d.s.Files.Get(g.fileId()).SupportsAllDrives(true).
+		Fields("md5Checksum").Context(ctx).Do()
+	if e != nil {
+		return "", e
+	}
+	// the Google Docs files have no md5Checksum
+	if file.Md5Checksum == "" {
+		return "", err.NewUnsupportedError()
+	}
+	g.md5 = file.Md5Checksum
+	return g.md5, nil
+}
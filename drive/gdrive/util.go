@@ -143,6 +143,6 @@ func (g *GDrive) deserializeEntry(ci drive_util.EntryCacheItem) (types.IEntry, e
 		id: id, mime: ci.Data["m"], path: ci.Path, isDir: ci.Type.IsDir(),
 		size: ci.Size, modTime: ci.ModTime, d: g,
 		targetId: ci.Data["ti"], targetMime: ci.Data["tm"],
-		thumbnail: ci.Data["th"],
+		thumbnail: ci.Data["th"], md5: ci.Data["h"],
 	}, nil
 }
@@ -35,6 +35,7 @@ func (d *ListenerWrapper) Save(ctx types.TaskCtx, path string, size int64, overr
 	if e == nil {
 		d.bus.Publish(event.EntryUpdated, d.ctx, path, false)
 	}
+	d.operated(types.EntryOpWrite, path, "", e)
 	return entry, e
 }
 
@@ -43,6 +44,7 @@ func (d *ListenerWrapper) MakeDir(ctx context.Context, path string) (types.IEntr
 	if e == nil {
 		d.bus.Publish(event.EntryUpdated, d.ctx, path, false)
 	}
+	d.operated(types.EntryOpMakeDir, path, "", e)
 	return entry, e
 }
 
@@ -51,7 +53,9 @@ func (d *ListenerWrapper) Copy(ctx types.TaskCtx, from types.IEntry, to string,
 	if e == nil {
 		d.bus.Publish(event.EntryAccessed, d.ctx, from.Path())
 		d.bus.Publish(event.EntryUpdated, d.ctx, entry.Path(), true)
+		to = entry.Path()
 	}
+	d.operated(types.EntryOpCopy, from.Path(), to, e)
 	return entry, e
 }
 
@@ -60,7 +64,9 @@ func (d *ListenerWrapper) Move(ctx types.TaskCtx, from types.IEntry, to string,
 	if e == nil {
 		d.bus.Publish(event.EntryDeleted, d.ctx, from.Path())
 		d.bus.Publish(event.EntryUpdated, d.ctx, entry.Path(), true)
+		to = entry.Path()
 	}
+	d.operated(types.EntryOpMove, from.Path(), to, e)
 	return entry, e
 }
 
@@ -77,5 +83,10 @@ func (d *ListenerWrapper) Delete(ctx types.TaskCtx, path string) error {
 	if e == nil {
 		d.bus.Publish(event.EntryDeleted, d.ctx, path)
 	}
+	d.operated(types.EntryOpDelete, path, "", e)
 	return e
 }
+
+func (d *ListenerWrapper) operated(action, path, to string, e error) {
+	d.bus.Publish(event.EntryOperated, d.ctx, types.EntryOperation{Action: action, Path: path, To: to, Error: e})
+}
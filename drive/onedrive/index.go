@@ -13,6 +13,7 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -336,6 +337,10 @@ func (o *OneDrive) newEntry(item driveItem) *oneDriveEntry {
 		item.Thumbnails[0].Large != nil {
 		thumbnailUrl = item.Thumbnails[0].Large.URL
 	}
+	quickXorHash, sha1Hash := "", ""
+	if item.File != nil {
+		quickXorHash, sha1Hash = item.File.Hashes.QuickXorHash, strings.ToLower(item.File.Hashes.Sha1Hash)
+	}
 	return &oneDriveEntry{
 		id:                   item.Id,
 		path:                 item.Path(),
@@ -346,6 +351,8 @@ func (o *OneDrive) newEntry(item driveItem) *oneDriveEntry {
 		thumbnail:            thumbnailUrl,
 		downloadUrl:          item.DownloadURL,
 		downloadUrlExpiresAt: time.Now().Add(downloadUrlTTL).Unix(),
+		quickXorHash:         quickXorHash,
+		sha1Hash:             sha1Hash,
 	}
 }
 
@@ -361,6 +368,10 @@ type oneDriveEntry struct {
 
 	downloadUrl          string
 	downloadUrlExpiresAt int64
+
+	// sha1Hash is not available in OneDrive for Business
+	quickXorHash string
+	sha1Hash     string
 }
 
 func (o *oneDriveEntry) Path() string {
@@ -456,5 +467,40 @@ func (o *oneDriveEntry) EntryData() types.SM {
 		"du": o.downloadUrl,
 		"de": strconv.FormatInt(o.downloadUrlExpiresAt, 10),
 		"th": o.thumbnail,
+		"qx": o.quickXorHash,
+		"s1": o.sha1Hash,
+	}
+}
+
+func (o *oneDriveEntry) HashAlgorithms() ([]string, bool) {
+	return []string{types.HashSHA1, types.HashQuickXor}, false
+}
+
+func (o *oneDriveEntry) Hash(ctx context.Context, algorithm string) (string, error) {
+	if o.isDir {
+		return "", err.NewUnsupportedError()
+	}
+	if o.quickXorHash == "" && o.sha1Hash == "" {
+		// the hashes may be absent in the response of uploading
+		resp, e := o.d.c.Get(ctx, utils.BuildURL("/root:/{}", o.path), nil)
+		if e != nil {
+			return "", e
+		}
+		entry, e := o.d.toEntry(resp)
+		if e != nil {
+			return "", e
+		}
+		o.quickXorHash, o.sha1Hash = entry.quickXorHash, entry.sha1Hash
+	}
+	value := ""
+	switch algorithm {
+	case types.HashSHA1:
+		value = o.sha1Hash
+	case types.HashQuickXor:
+		value = o.quickXorHash
+	}
+	if value == "" {
+		return "", err.NewUnsupportedError()
 	}
+	return value, nil
 }
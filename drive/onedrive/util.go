@@ -403,6 +403,8 @@ func (o *OneDrive) deserializeEntry(ec drive_util.EntryCacheItem) (types.IEntry,
 		downloadUrl:          ed["du"],
 		downloadUrlExpiresAt: ed.GetInt64("de", -1),
 		thumbnail:            ed["th"],
+		quickXorHash:         ed["qx"],
+		sha1Hash:             ed["s1"],
 	}, nil
 }
 
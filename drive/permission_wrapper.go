@@ -2,6 +2,7 @@ package drive
 
 import (
 	"context"
+	"go-drive/common/drive_util"
 	err "go-drive/common/errors"
 	"go-drive/common/i18n"
 	"go-drive/common/types"
@@ -15,6 +16,9 @@ import (
 // but have priority over the permissions of the parent path.
 // Permissions for users take precedence over permissions for user groups.
 // REJECT takes precedence over ACCEPT
+//
+// Each operation requires its own permission bit, e.g. Delete requires PermissionDelete,
+// and Save requires PermissionOverwrite if the file exists, otherwise PermissionCreate.
 type PermissionWrapperDrive struct {
 	drive types.IDrive
 	pm    utils.PermMap
@@ -32,7 +36,7 @@ func (p *PermissionWrapperDrive) Meta(ctx context.Context) (types.DriveMeta, err
 }
 
 func (p *PermissionWrapperDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
-	permission, e := p.requirePermission(path, types.PermissionRead)
+	permission, e := p.requirePermission(path, types.PermissionList)
 	if e != nil {
 		return nil, e
 	}
@@ -49,7 +53,7 @@ func (p *PermissionWrapperDrive) Get(ctx context.Context, path string) (types.IE
 
 func (p *PermissionWrapperDrive) Save(ctx types.TaskCtx, path string, size int64,
 	override bool, reader io.Reader) (types.IEntry, error) {
-	permission, e := p.requirePermission(path, types.PermissionReadWrite)
+	permission, e := p.requireSavePermission(ctx, path)
 	if e != nil {
 		return nil, e
 	}
@@ -61,7 +65,7 @@ func (p *PermissionWrapperDrive) Save(ctx types.TaskCtx, path string, size int64
 }
 
 func (p *PermissionWrapperDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
-	permission, e := p.requirePermission(path, types.PermissionReadWrite)
+	permission, e := p.requirePermission(path, types.PermissionCreate)
 	if e != nil {
 		return nil, e
 	}
@@ -73,16 +77,13 @@ func (p *PermissionWrapperDrive) MakeDir(ctx context.Context, path string) (type
 }
 
 func (p *PermissionWrapperDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
-	toPermission, e := p.requirePathAndParentWritable(to)
+	toPermission, e := p.requireTargetPermission(to, override)
 	if e != nil {
 		return nil, e
 	}
 	if e := p.requireDescendantPermission(from.Path(), types.PermissionRead); e != nil {
 		return nil, e
 	}
-	if e := p.requireDescendantPermission(to, types.PermissionReadWrite); e != nil {
-		return nil, e
-	}
 	entry, e := p.drive.Copy(ctx, from, to, override)
 	if e != nil {
 		return nil, e
@@ -91,17 +92,14 @@ func (p *PermissionWrapperDrive) Copy(ctx types.TaskCtx, from types.IEntry, to s
 }
 
 func (p *PermissionWrapperDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
-	toPermission, e := p.requirePathAndParentWritable(to)
+	toPermission, e := p.requireTargetPermission(to, override)
 	if e != nil {
 		return nil, e
 	}
-	if _, e := p.requirePathAndParentWritable(from.Path()); e != nil {
-		return nil, e
-	}
-	if e := p.requireDescendantPermission(from.Path(), types.PermissionReadWrite); e != nil {
+	if _, e := p.requirePathAndParentPermission(from.Path(), types.PermissionMove); e != nil {
 		return nil, e
 	}
-	if e := p.requireDescendantPermission(to, types.PermissionReadWrite); e != nil {
+	if e := p.requireDescendantPermission(from.Path(), types.PermissionMove); e != nil {
 		return nil, e
 	}
 	entry, e := p.drive.Move(ctx, from, to, override)
@@ -139,7 +137,10 @@ func (p *PermissionWrapperDrive) List(ctx context.Context, path string) ([]types
 }
 
 func (p *PermissionWrapperDrive) Delete(ctx types.TaskCtx, path string) error {
-	if _, e := p.requirePathAndParentWritable(path); e != nil {
+	if _, e := p.requirePathAndParentPermission(path, types.PermissionDelete); e != nil {
+		return e
+	}
+	if e := p.requireDescendantPermission(path, types.PermissionDelete); e != nil {
 		return e
 	}
 	return p.drive.Delete(ctx, path)
@@ -147,26 +148,60 @@ func (p *PermissionWrapperDrive) Delete(ctx types.TaskCtx, path string) error {
 
 func (p *PermissionWrapperDrive) Upload(ctx context.Context, path string, size int64,
 	override bool, config types.SM) (*types.DriveUploadConfig, error) {
-	_, e := p.requirePermission(path, types.PermissionReadWrite)
-	if e != nil {
+	if _, e := p.requireSavePermission(ctx, path); e != nil {
 		return nil, e
 	}
 	return p.drive.Upload(ctx, path, size, override, config)
 }
 
-func (p *PermissionWrapperDrive) requirePathAndParentWritable(path string) (types.Permission, error) {
+// requireSavePermission requires PermissionOverwrite if the file exists, otherwise PermissionCreate
+func (p *PermissionWrapperDrive) requireSavePermission(ctx context.Context, path string) (types.Permission, error) {
+	require := types.PermissionCreate | types.PermissionOverwrite
+	if !p.pm.ResolvePath(path).Has(require) {
+		_, e := p.drive.Get(ctx, path)
+		if e != nil && !err.IsNotFoundError(e) {
+			return types.PermissionEmpty, e
+		}
+		require = types.PermissionCreate
+		if e == nil {
+			require = types.PermissionOverwrite
+		}
+	}
+	return p.requirePermission(path, require)
+}
+
+// requireTargetPermission requires the permissions to create the target of Copy and Move,
+// the existing files in the target will be overwritten if override is true
+func (p *PermissionWrapperDrive) requireTargetPermission(to string, override bool) (types.Permission, error) {
+	require := types.PermissionCreate
+	if override {
+		require |= types.PermissionOverwrite
+	}
+	if !utils.IsRootPath(to) {
+		if _, e := p.requirePermission(utils.PathParent(to), types.PermissionCreate); e != nil {
+			return types.PermissionEmpty, e
+		}
+	}
+	permission, e := p.requirePermission(to, require)
+	if e != nil {
+		return permission, e
+	}
+	return permission, p.requireDescendantPermission(to, require)
+}
+
+func (p *PermissionWrapperDrive) requirePathAndParentPermission(path string, require types.Permission) (types.Permission, error) {
 	if !utils.IsRootPath(path) {
-		perm, e := p.requirePermission(utils.PathParent(path), types.PermissionReadWrite)
+		perm, e := p.requirePermission(utils.PathParent(path), require)
 		if e != nil {
 			return perm, e
 		}
 	}
-	return p.requirePermission(path, types.PermissionReadWrite)
+	return p.requirePermission(path, require)
 }
 
 func (p *PermissionWrapperDrive) requirePermission(path string, require types.Permission) (types.Permission, error) {
 	resolved := p.pm.ResolvePath(path)
-	if resolved&require != require {
+	if !resolved.Has(require) {
 		return resolved, err.NewNotFoundMessageError(i18n.T("error.permission_denied"))
 	}
 	return resolved, nil
@@ -175,7 +210,7 @@ func (p *PermissionWrapperDrive) requirePermission(path string, require types.Pe
 func (p *PermissionWrapperDrive) requireDescendantPermission(path string, require types.Permission) error {
 	pp := p.pm.ResolvePath(path)
 	dp, defined := p.pm.ResolveDescendant(path)
-	ok := pp&require == require && (!defined || dp&require == require)
+	ok := pp.Has(require) && (!defined || dp.Has(require))
 	if !ok {
 		return err.NewNotAllowedMessageError(i18n.T("api.permission_wrapper.no_subfolder_permission"))
 	}
@@ -192,9 +227,40 @@ func (p *permissionWrapperEntry) Meta() types.EntryMeta {
 	meta := p.IEntry.Meta()
 	meta.Readable = meta.Readable && p.permission.Readable()
 	meta.Writable = meta.Writable && p.permission.Writable()
+	if !p.permission.Has(types.PermissionDownload) {
+		// the thumbnail shows the content
+		meta.Thumbnail = ""
+	}
 	return meta
 }
 
+func (p *permissionWrapperEntry) GetReader(ctx context.Context, start, size int64) (io.ReadCloser, error) {
+	if !p.permission.Has(types.PermissionDownload) {
+		return nil, err.NewNotAllowedMessageError(i18n.T("error.permission_denied"))
+	}
+	return p.IEntry.GetReader(ctx, start, size)
+}
+
+func (p *permissionWrapperEntry) GetURL(ctx context.Context) (*types.ContentURL, error) {
+	if !p.permission.Has(types.PermissionDownload) {
+		return nil, err.NewNotAllowedMessageError(i18n.T("error.permission_denied"))
+	}
+	return p.IEntry.GetURL(ctx)
+}
+
+// RequirePermission returns an error if the permission is not allowed by any permission wrapper of the entry.
+// It's used where the wrapped entry is accessed directly, e.g. the thumbnails and hashes provided by the entry.
+func RequirePermission(entry types.IEntry, require types.Permission) error {
+	denied := drive_util.GetIEntry(entry, func(e types.IEntry) bool {
+		p, ok := e.(*permissionWrapperEntry)
+		return ok && !p.permission.Has(require)
+	})
+	if denied != nil {
+		return err.NewNotAllowedMessageError(i18n.T("error.permission_denied"))
+	}
+	return nil
+}
+
 func (p *permissionWrapperEntry) Drive() types.IDrive {
 	return p.p
 }
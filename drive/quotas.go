@@ -0,0 +1,529 @@
+package drive
+
+import (
+	"context"
+	err "go-drive/common/errors"
+	"go-drive/common/event"
+	"go-drive/common/i18n"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/storage"
+	"io"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Quotas limits the bytes can be written by users and groups.
+// The usage is checked by QuotaWrapperDrive before writing,
+// and tracked incrementally from the EntryUpdated and EntryDeleted events.
+// The sizes of the updated entries are computed by a worker, walking the remote drives never blocks the event bus.
+type Quotas struct {
+	rootDrive     *RootDrive
+	quotaDAO      *storage.QuotaDAO
+	permissionDAO *storage.PathPermissionDAO
+
+	quotas []types.Quota
+	// pending are the sizes of the entries before they are overwritten or deleted,
+	// they are recorded by QuotaWrapperDrive and consumed by the event handlers.
+	pending map[string]int64
+	// updates are the updated entries waiting for the worker to compute their sizes
+	updates  []sizeUpdate
+	mux      *sync.Mutex
+	notify   chan struct{}
+	done     chan struct{}
+	disposed *sync.Once
+}
+
+type sizeUpdate struct {
+	quotas             []types.Quota
+	path               string
+	includeDescendants bool
+	// old is the size of the entry before it's overwritten
+	old int64
+}
+
+func NewQuotas(ch *registry.ComponentsHolder, rootDrive *RootDrive, quotaDAO *storage.QuotaDAO,
+	permissionDAO *storage.PathPermissionDAO, bus event.Bus) (*Quotas, error) {
+	q := &Quotas{
+		rootDrive:     rootDrive,
+		quotaDAO:      quotaDAO,
+		permissionDAO: permissionDAO,
+		pending:       make(map[string]int64),
+		mux:           &sync.Mutex{},
+		notify:        make(chan struct{}, 1),
+		done:          make(chan struct{}),
+		disposed:      &sync.Once{},
+	}
+	if e := q.Reload(); e != nil {
+		return nil, e
+	}
+	go q.computeUpdates()
+	bus.Subscribe(event.EntryUpdated, q.onUpdated)
+	bus.Subscribe(event.EntryDeleted, q.onDeleted)
+	ch.Add("quotas", q)
+	return q, nil
+}
+
+// Reload reloads the quotas from database
+func (q *Quotas) Reload() error {
+	quotas, e := q.quotaDAO.GetQuotas()
+	if e != nil {
+		return e
+	}
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.quotas = quotas
+	return nil
+}
+
+// GetQuotas returns the quotas with the latest usage
+func (q *Quotas) GetQuotas() []types.Quota {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return append(make([]types.Quota, 0, len(q.quotas)), q.quotas...)
+}
+
+// Recompute walks the paths of the quota to compute the usage.
+// The quota with a path counts all the files under the path,
+// otherwise all the files under the writable paths of the subject are counted.
+func (q *Quotas) Recompute(ctx types.TaskCtx, id uint) (types.Quota, error) {
+	quota, e := q.quotaDAO.GetQuota(id)
+	if e != nil {
+		return quota, e
+	}
+	paths := []string{quota.Path}
+	if quota.Path == "" {
+		paths, e = q.writablePaths(quota.Subject)
+		if e != nil {
+			return quota, e
+		}
+	}
+	used := int64(0)
+	for _, p := range paths {
+		size, e := q.sizeAt(ctx, p)
+		if e != nil {
+			return quota, e
+		}
+		used += size
+	}
+	if e := q.quotaDAO.SetUsage(id, used); e != nil {
+		return quota, e
+	}
+	if e := q.Reload(); e != nil {
+		return quota, e
+	}
+	return q.quotaDAO.GetQuota(id)
+}
+
+// Wrap wraps the drive to check the quotas of the session before writing.
+// The drive must be the root drive or the wrapper of it.
+func (q *Quotas) Wrap(d types.IDrive, session types.Session) *QuotaWrapperDrive {
+	return &QuotaWrapperDrive{IDrive: d, quotas: q, session: session}
+}
+
+// writablePaths returns the paths the subject has the write permission, the nested paths are removed
+func (q *Quotas) writablePaths(subject string) ([]string, error) {
+	perms, e := q.permissionDAO.GetAll()
+	if e != nil {
+		return nil, e
+	}
+	paths := make([]string, 0)
+	for _, p := range perms {
+		if p.Subject == subject && p.IsAccept() && p.Permission.Writable() {
+			paths = append(paths, *p.Path)
+		}
+	}
+	result := make([]string, 0, len(paths))
+	for _, p := range paths {
+		nested := false
+		for _, other := range paths {
+			if other != p && pathCovers(other, p) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+// applicable returns the quotas of the session's user and groups which cover the path
+func (q *Quotas) applicable(session types.Session, path string) []types.Quota {
+	if session.IsAnonymous() {
+		return nil
+	}
+	subjects := map[string]bool{types.UserSubject(session.User.Username): true}
+	for _, g := range session.User.Groups {
+		subjects[types.GroupSubject(g.Name)] = true
+	}
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	result := make([]types.Quota, 0)
+	for _, quota := range q.quotas {
+		if subjects[quota.Subject] && pathCovers(quota.Path, path) {
+			result = append(result, quota)
+		}
+	}
+	return result
+}
+
+// check returns error if writing size bytes exceeds the quotas, released is the bytes that will be overwritten.
+// The unknown size is treated as 0, the caller should limit the bytes written by remaining.
+func (q *Quotas) check(quotas []types.Quota, size, released int64) error {
+	if size < 0 {
+		size = 0
+	}
+	for _, quota := range quotas {
+		if quota.Limit > 0 && quota.Used-released+size > quota.Limit {
+			return quotaExceededError(quota)
+		}
+	}
+	return nil
+}
+
+// remaining returns the quota with the least bytes can be written and the bytes, nil if all quotas are unlimited
+func (q *Quotas) remaining(quotas []types.Quota, released int64) (*types.Quota, int64) {
+	var min *types.Quota
+	remaining := int64(0)
+	for i, quota := range quotas {
+		if quota.Limit <= 0 {
+			continue
+		}
+		r := quota.Limit - quota.Used + released
+		if min == nil || r < remaining {
+			min, remaining = &quotas[i], r
+		}
+	}
+	return min, remaining
+}
+
+func quotaExceededError(quota types.Quota) error {
+	return err.NewNotAllowedMessageError(i18n.T("api.quota_wrapper.quota_exceeded",
+		utils.FormatBytes(uint64(quota.Limit), 2)))
+}
+
+func (q *Quotas) setPending(session types.Session, path string, size int64) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.pending[pendingKey(session, path)] = size
+}
+
+func (q *Quotas) takePending(session types.Session, path string) int64 {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	key := pendingKey(session, path)
+	size := q.pending[key]
+	delete(q.pending, key)
+	return size
+}
+
+func (q *Quotas) addUsage(quotas []types.Quota, delta int64) {
+	if delta == 0 {
+		return
+	}
+	for _, quota := range quotas {
+		if e := q.quotaDAO.AddUsage(quota.ID, delta); e != nil {
+			log.Printf("[Quotas] failed to update usage of quota %d: %v", quota.ID, e)
+		}
+	}
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	for i := range q.quotas {
+		for _, quota := range quotas {
+			if q.quotas[i].ID == quota.ID {
+				q.quotas[i].Used += delta
+			}
+		}
+	}
+}
+
+func (q *Quotas) onUpdated(dc types.DriveListenerContext, path string, includeDescendants bool) {
+	if dc.Session == nil {
+		return
+	}
+	quotas := q.applicable(*dc.Session, path)
+	old := q.takePending(*dc.Session, path)
+	if len(quotas) == 0 {
+		return
+	}
+	q.mux.Lock()
+	q.updates = append(q.updates, sizeUpdate{
+		quotas: quotas, path: path, includeDescendants: includeDescendants, old: old,
+	})
+	q.mux.Unlock()
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// computeUpdates computes the sizes of the updated entries in order until the Quotas is disposed
+func (q *Quotas) computeUpdates() {
+	for {
+		select {
+		case <-q.notify:
+			q.mux.Lock()
+			updates := q.updates
+			q.updates = nil
+			q.mux.Unlock()
+			for _, u := range updates {
+				q.computeUpdate(u)
+			}
+		case <-q.done:
+			return
+		}
+	}
+}
+
+func (q *Quotas) computeUpdate(u sizeUpdate) {
+	entry, e := q.rootDrive.Get().Get(context.Background(), u.path)
+	if e != nil {
+		log.Printf("[Quotas] failed to get entry %s: %v", utils.LogSanitize(u.path), e)
+		return
+	}
+	size := int64(0)
+	if entry.Type().IsFile() || u.includeDescendants {
+		size, e = usedSize(context.Background(), entry)
+		if e != nil {
+			log.Printf("[Quotas] failed to compute size of %s: %v", utils.LogSanitize(u.path), e)
+			return
+		}
+	}
+	q.addUsage(u.quotas, size-u.old)
+}
+
+func (q *Quotas) Dispose() error {
+	q.disposed.Do(func() { close(q.done) })
+	return nil
+}
+
+func (q *Quotas) onDeleted(dc types.DriveListenerContext, path string) {
+	if dc.Session == nil {
+		return
+	}
+	quotas := q.applicable(*dc.Session, path)
+	old := q.takePending(*dc.Session, path)
+	q.addUsage(quotas, -old)
+}
+
+// sizeAt returns the size of the entry at the path, 0 if not exists
+func (q *Quotas) sizeAt(ctx context.Context, path string) (int64, error) {
+	entry, e := q.rootDrive.Get().Get(ctx, path)
+	if err.IsNotFoundError(e) {
+		return 0, nil
+	}
+	if e != nil {
+		return 0, e
+	}
+	return usedSize(ctx, entry)
+}
+
+// usedSize returns the total size of the files under the entry, the trash and versions folders are excluded
+func usedSize(ctx context.Context, entry types.IEntry) (int64, error) {
+	if e := ctx.Err(); e != nil {
+		return 0, e
+	}
+	if entry.Type().IsFile() {
+		return entry.Size(), nil
+	}
+	if IsTrashPath(entry.Path()) || IsVersionsPath(entry.Path()) {
+		return 0, nil
+	}
+	children, e := entry.Drive().List(ctx, entry.Path())
+	if e != nil {
+		return 0, e
+	}
+	total := int64(0)
+	for _, child := range children {
+		size, e := usedSize(ctx, child)
+		if e != nil {
+			return 0, e
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// pathCovers returns true if the path is the root or the ancestor of the sub, or the same path
+func pathCovers(root, sub string) bool {
+	return root == "" || root == sub || strings.HasPrefix(sub, root+"/")
+}
+
+func pendingKey(session types.Session, path string) string {
+	return session.User.Username + ":" + path
+}
+
+// QuotaWrapperDrive rejects the writes that exceed the quotas of the session,
+// and records the sizes of the entries to be overwritten or deleted for tracking the usage.
+type QuotaWrapperDrive struct {
+	types.IDrive
+
+	quotas  *Quotas
+	session types.Session
+}
+
+func (d *QuotaWrapperDrive) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	quotas := d.quotas.applicable(d.session, path)
+	if len(quotas) == 0 {
+		return d.IDrive.Save(ctx, path, size, override, reader)
+	}
+	old, e := d.overwrittenSize(ctx, path, override)
+	if e != nil {
+		return nil, e
+	}
+	if e := d.quotas.check(quotas, size, old); e != nil {
+		return nil, e
+	}
+	var limited *quotaLimitedReader
+	existed := true
+	if size < 0 {
+		// the size is unknown, stop writing when the remaining bytes are used up
+		if quota, remaining := d.quotas.remaining(quotas, old); quota != nil {
+			limited = &quotaLimitedReader{r: reader, quota: *quota, remaining: remaining}
+			reader = limited
+			if _, e := d.IDrive.Get(ctx, path); err.IsNotFoundError(e) {
+				existed = false
+			}
+		}
+	}
+	d.quotas.setPending(d.session, path, old)
+	entry, e := d.IDrive.Save(ctx, path, size, override, reader)
+	if e != nil {
+		d.quotas.takePending(d.session, path)
+	}
+	if limited != nil && limited.exceeded {
+		if !existed {
+			// remove the partially written file
+			if de := d.quotas.rootDrive.Get().Delete(ctx, path); de != nil && !err.IsNotFoundError(de) {
+				log.Printf("[Quotas] failed to delete %s: %v", utils.LogSanitize(path), de)
+			}
+		}
+		return nil, quotaExceededError(limited.quota)
+	}
+	return entry, e
+}
+
+func (d *QuotaWrapperDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string,
+	override bool) (types.IEntry, error) {
+	quotas := d.quotas.applicable(d.session, to)
+	if len(quotas) == 0 {
+		return d.IDrive.Copy(ctx, from, to, override)
+	}
+	size, e := usedSize(ctx, from)
+	if e != nil {
+		return nil, e
+	}
+	old, e := d.overwrittenSize(ctx, to, override)
+	if e != nil {
+		return nil, e
+	}
+	if e := d.quotas.check(quotas, size, old); e != nil {
+		return nil, e
+	}
+	d.quotas.setPending(d.session, to, old)
+	entry, e := d.IDrive.Copy(ctx, from, to, override)
+	if e != nil {
+		d.quotas.takePending(d.session, to)
+	}
+	return entry, e
+}
+
+func (d *QuotaWrapperDrive) Move(ctx types.TaskCtx, from types.IEntry, to string,
+	override bool) (types.IEntry, error) {
+	fromQuotas := d.quotas.applicable(d.session, from.Path())
+	toQuotas := d.quotas.applicable(d.session, to)
+	if len(fromQuotas) == 0 && len(toQuotas) == 0 {
+		return d.IDrive.Move(ctx, from, to, override)
+	}
+	size, e := usedSize(ctx, from)
+	if e != nil {
+		return nil, e
+	}
+	old, e := d.overwrittenSize(ctx, to, override)
+	if e != nil {
+		return nil, e
+	}
+	// the usage of the quotas covering both paths will not increase
+	added := make([]types.Quota, 0, len(toQuotas))
+	for _, quota := range toQuotas {
+		if !pathCovers(quota.Path, from.Path()) {
+			added = append(added, quota)
+		}
+	}
+	if e := d.quotas.check(added, size, old); e != nil {
+		return nil, e
+	}
+	d.quotas.setPending(d.session, from.Path(), size)
+	d.quotas.setPending(d.session, to, old)
+	entry, e := d.IDrive.Move(ctx, from, to, override)
+	if e != nil {
+		d.quotas.takePending(d.session, from.Path())
+		d.quotas.takePending(d.session, to)
+	}
+	return entry, e
+}
+
+func (d *QuotaWrapperDrive) Delete(ctx types.TaskCtx, path string) error {
+	if len(d.quotas.applicable(d.session, path)) == 0 {
+		return d.IDrive.Delete(ctx, path)
+	}
+	size, e := d.quotas.sizeAt(ctx, path)
+	if e != nil {
+		return e
+	}
+	d.quotas.setPending(d.session, path, size)
+	e = d.IDrive.Delete(ctx, path)
+	if e != nil {
+		d.quotas.takePending(d.session, path)
+	}
+	return e
+}
+
+func (d *QuotaWrapperDrive) Upload(ctx context.Context, path string, size int64,
+	override bool, config types.SM) (*types.DriveUploadConfig, error) {
+	quotas := d.quotas.applicable(d.session, path)
+	if len(quotas) > 0 {
+		old, e := d.overwrittenSize(ctx, path, override)
+		if e != nil {
+			return nil, e
+		}
+		if e := d.quotas.check(quotas, size, old); e != nil {
+			return nil, e
+		}
+	}
+	return d.IDrive.Upload(ctx, path, size, override, config)
+}
+
+// quotaLimitedReader fails the reading when more than remaining bytes are read, remaining must not be negative
+type quotaLimitedReader struct {
+	r         io.Reader
+	quota     types.Quota
+	remaining int64
+	exceeded  bool
+}
+
+func (l *quotaLimitedReader) Read(p []byte) (int, error) {
+	if l.remaining < int64(len(p)) {
+		// read one more byte to know whether the remaining bytes are exceeded
+		p = p[:l.remaining+1]
+	}
+	n, e := l.r.Read(p)
+	if int64(n) > l.remaining {
+		l.exceeded = true
+		return 0, quotaExceededError(l.quota)
+	}
+	l.remaining -= int64(n)
+	return n, e
+}
+
+func (d *QuotaWrapperDrive) overwrittenSize(ctx context.Context, path string, override bool) (int64, error) {
+	if !override {
+		return 0, nil
+	}
+	return d.quotas.sizeAt(ctx, path)
+}
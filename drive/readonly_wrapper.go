@@ -0,0 +1,94 @@
+package drive
+
+import (
+	"context"
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"io"
+)
+
+// ReadOnlyWrapperDrive rejects all write operations to the wrapped drive
+type ReadOnlyWrapperDrive struct {
+	drive types.IDrive
+}
+
+func NewReadOnlyWrapperDrive(drive types.IDrive) *ReadOnlyWrapperDrive {
+	return &ReadOnlyWrapperDrive{drive: drive}
+}
+
+func (r *ReadOnlyWrapperDrive) Meta(ctx context.Context) (types.DriveMeta, error) {
+	meta, e := r.drive.Meta(ctx)
+	if e != nil {
+		return meta, e
+	}
+	meta.Writable = false
+	return meta, nil
+}
+
+func (r *ReadOnlyWrapperDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
+	entry, e := r.drive.Get(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	return &readOnlyWrapperEntry{IEntry: entry, d: r}, nil
+}
+
+func (r *ReadOnlyWrapperDrive) Save(types.TaskCtx, string, int64, bool, io.Reader) (types.IEntry, error) {
+	return nil, r.notAllowed()
+}
+
+func (r *ReadOnlyWrapperDrive) MakeDir(context.Context, string) (types.IEntry, error) {
+	return nil, r.notAllowed()
+}
+
+func (r *ReadOnlyWrapperDrive) Copy(types.TaskCtx, types.IEntry, string, bool) (types.IEntry, error) {
+	return nil, r.notAllowed()
+}
+
+func (r *ReadOnlyWrapperDrive) Move(types.TaskCtx, types.IEntry, string, bool) (types.IEntry, error) {
+	return nil, r.notAllowed()
+}
+
+func (r *ReadOnlyWrapperDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	entries, e := r.drive.List(ctx, path)
+	if e != nil {
+		return nil, e
+	}
+	result := make([]types.IEntry, len(entries))
+	for i, entry := range entries {
+		result[i] = &readOnlyWrapperEntry{IEntry: entry, d: r}
+	}
+	return result, nil
+}
+
+func (r *ReadOnlyWrapperDrive) Delete(types.TaskCtx, string) error {
+	return r.notAllowed()
+}
+
+func (r *ReadOnlyWrapperDrive) Upload(context.Context, string, int64, bool, types.SM) (*types.DriveUploadConfig, error) {
+	return nil, r.notAllowed()
+}
+
+func (r *ReadOnlyWrapperDrive) notAllowed() error {
+	return err.NewNotAllowedMessageError(i18n.T("api.readonly_wrapper.readonly"))
+}
+
+type readOnlyWrapperEntry struct {
+	types.IEntry
+	d *ReadOnlyWrapperDrive
+}
+
+func (r *readOnlyWrapperEntry) Meta() types.EntryMeta {
+	meta := r.IEntry.Meta()
+	meta.Writable = false
+	return meta
+}
+
+func (r *readOnlyWrapperEntry) Drive() types.IDrive {
+	return r.d
+}
+
+func (r *readOnlyWrapperEntry) GetIEntry() types.IEntry {
+	return r.IEntry
+}
@@ -52,6 +52,7 @@ func NewRootDrive(
 	default:
 		r.driveCacheMgr = drive_util.NewMemDriveCacheManager(config.Cache.CleanPeriod)
 	}
+	root.archives = newArchiveMounts(r.driveCacheMgr.GetCacheStore(archivesCacheNs, nil))
 
 	if e := r.ReloadMounts(); e != nil {
 		return nil, e
@@ -2,6 +2,8 @@ package s3
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"go-drive/common/drive_util"
 	err "go-drive/common/errors"
@@ -12,6 +14,8 @@ import (
 	"io"
 	"math"
 	"net/url"
+	path2 "path"
+	"regexp"
 	"strings"
 	"time"
 
@@ -23,7 +27,11 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
-var s3T = i18n.TPrefix("drive.s3.")
+var (
+	s3T = i18n.TPrefix("drive.s3.")
+
+	etagMD5Pattern = regexp.MustCompile("^[0-9a-fA-F]{32}$")
+)
 
 func init() {
 	drive_util.RegisterDrive(drive_util.DriveFactoryConfig{
@@ -45,6 +53,14 @@ func init() {
 	})
 }
 
+const (
+	// maxCopyObjectSize is the max size of the object that can be copied by CopyObject
+	maxCopyObjectSize = 5 * 1024 * 1024 * 1024
+	// copyPartSize is the min part size when copying the large object by UploadPartCopy
+	copyPartSize = 512 * 1024 * 1024
+	maxParts     = 10000
+)
+
 type Drive struct {
 	s             *session.Session
 	c             *s3.S3
@@ -54,6 +70,11 @@ type Drive struct {
 	cache         drive_util.DriveCache
 	cacheTTL      time.Duration
 
+	// the objects in the other drive with the same endpoint, region and access key can be copied by the server
+	endpoint  string
+	region    string
+	accessKey string
+
 	tempDir string
 }
 
@@ -82,6 +103,9 @@ func NewDrive(ctx context.Context, config types.SM,
 		s:             sess,
 		c:             client,
 		bucket:        aws.String(bucket),
+		endpoint:      endpoint,
+		region:        region,
+		accessKey:     id,
 		uploadProxy:   config.GetBool("proxy_upload"),
 		downloadProxy: config.GetBool("proxy_download"),
 		cacheTTL:      cacheTtl,
@@ -199,14 +223,60 @@ func (s *Drive) MakeDir(ctx context.Context, path string) (types.IEntry, error)
 }
 
 func (s *Drive) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
-	from = drive_util.GetSelfEntry(s, from)
-	if from == nil || from.Type().IsDir() {
+	src := s.getCopySource(from)
+	if src == nil {
 		return nil, err.NewUnsupportedError()
 	}
-	entry, _, e := s.copy(from.(*s3Entry), to, override, ctx)
+	if e := s.checkCopyTarget(src, to); e != nil {
+		return nil, e
+	}
+	if src.isDir {
+		if _, e := s.copyDir(ctx, src, to, override); e != nil {
+			return nil, e
+		}
+		return s.newS3DirEntry(to, nil), nil
+	}
+	ctx.Total(src.size, false)
+	entry, skip, e := s.copy(src, to, override, ctx)
+	if skip {
+		ctx.Progress(src.size, false)
+	}
 	return entry, e
 }
 
+// getCopySource returns the s3Entry of from if it can be copied by the server,
+// returns nil if it's not in the same endpoint and region, or not accessible by the access key of this drive.
+func (s *Drive) getCopySource(from types.IEntry) *s3Entry {
+	e := drive_util.GetIEntry(from, func(e types.IEntry) bool {
+		_, ok := e.(*s3Entry)
+		return ok
+	})
+	if e == nil {
+		return nil
+	}
+	src := e.(*s3Entry)
+	if src.c != s && (src.c.endpoint != s.endpoint || src.c.region != s.region || src.c.accessKey != s.accessKey) {
+		return nil
+	}
+	return src
+}
+
+// checkCopyTarget rejects copying or moving the object to itself, or the directory to its child path in the same bucket
+func (s *Drive) checkCopyTarget(src *s3Entry, to string) error {
+	if *src.c.bucket != *s.bucket {
+		return nil
+	}
+	to = utils.CleanPath(to)
+	if to == src.key {
+		return err.NewNotAllowedMessageError(i18n.T("api.drive.copy_to_same_path_not_allowed"))
+	}
+	if src.isDir && utils.IsPathParent(to, src.key) {
+		return err.NewNotAllowedMessageError(i18n.T("api.drive.copy_to_child_path_not_allowed"))
+	}
+	return nil
+}
+
+// copy copies the object, the progress is reported but the total is not
 func (s *Drive) copy(from *s3Entry, to string, override bool, ctx types.TaskCtx) (*s3Entry, bool, error) {
 	if !override {
 		_, e := s.Get(ctx, to)
@@ -219,35 +289,186 @@ func (s *Drive) copy(from *s3Entry, to string, override bool, ctx types.TaskCtx)
 			return nil, false, e
 		}
 	}
-	ctx.Total(from.size, false)
-	obj, e := s.c.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
-		Bucket:     s.bucket,
-		Key:        aws.String(to),
-		CopySource: aws.String(url.QueryEscape(*s.bucket + "/" + from.key)),
+	var lastModified *time.Time
+	if from.size > maxCopyObjectSize {
+		if e := s.copyMultipart(from, to, ctx); e != nil {
+			return nil, false, e
+		}
+		now := time.Now()
+		lastModified = &now
+	} else {
+		obj, e := s.c.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+			Bucket:     s.bucket,
+			Key:        aws.String(to),
+			CopySource: from.copySource(),
+		})
+		if e != nil {
+			return nil, false, e
+		}
+		lastModified = obj.CopyObjectResult.LastModified
+		ctx.Progress(from.size, false)
+	}
+	_ = s.cache.Evict(to, true)
+	_ = s.cache.Evict(utils.PathParent(to), false)
+	return s.newS3ObjectEntry(to, &from.size, lastModified), false, nil
+}
+
+// copyMultipart copies the object larger than maxCopyObjectSize by UploadPartCopy
+func (s *Drive) copyMultipart(from *s3Entry, to string, ctx types.TaskCtx) error {
+	upload, e := s.c.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: s.bucket,
+		Key:    aws.String(to),
 	})
 	if e != nil {
-		return nil, false, e
+		return e
+	}
+	partSize := int64(math.Max(copyPartSize, math.Ceil(float64(from.size)/maxParts)))
+	parts := make([]*s3.CompletedPart, 0, (from.size+partSize-1)/partSize)
+	for start := int64(0); start < from.size; start += partSize {
+		if e = ctx.Err(); e != nil {
+			break
+		}
+		end := int64(math.Min(float64(start+partSize), float64(from.size))) - 1
+		partNumber := aws.Int64(int64(len(parts) + 1))
+		var r *s3.UploadPartCopyOutput
+		r, e = s.c.UploadPartCopyWithContext(ctx, &s3.UploadPartCopyInput{
+			Bucket:          s.bucket,
+			Key:             aws.String(to),
+			UploadId:        upload.UploadId,
+			PartNumber:      partNumber,
+			CopySource:      from.copySource(),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		})
+		if e != nil {
+			break
+		}
+		parts = append(parts, &s3.CompletedPart{PartNumber: partNumber, ETag: r.CopyPartResult.ETag})
+		ctx.Progress(end-start+1, false)
+	}
+	if e == nil {
+		_, e = s.c.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          s.bucket,
+			Key:             aws.String(to),
+			UploadId:        upload.UploadId,
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+	}
+	if e != nil {
+		// the context may be canceled
+		_, _ = s.c.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   s.bucket,
+			Key:      aws.String(to),
+			UploadId: upload.UploadId,
+		})
+	}
+	return e
+}
+
+// copyDir copies all the objects under the directory.
+// Returns the keys of the source objects that are copied, the skipped objects and their parent directories are excluded.
+func (s *Drive) copyDir(ctx types.TaskCtx, from *s3Entry, to string, override bool) ([]string, error) {
+	prefix := from.key
+	if !utils.IsRootPath(prefix) {
+		prefix += "/"
+	}
+	objects, e := from.c.listAll(ctx, prefix)
+	if e != nil {
+		return nil, e
+	}
+	total := int64(0)
+	for _, o := range objects {
+		total += aws.Int64Value(o.Size)
+	}
+	ctx.Total(total, false)
+
+	if _, e := s.MakeDir(ctx, to); e != nil {
+		return nil, e
+	}
+	copied := make([]string, 0, len(objects)+1)
+	dirs := make([]string, 0)
+	skipped := make([]string, 0)
+	for _, o := range objects {
+		if e := ctx.Err(); e != nil {
+			return nil, e
+		}
+		key := aws.StringValue(o.Key)
+		dest := utils.CleanPath(path2.Join(to, strings.TrimPrefix(key, prefix)))
+		if strings.HasSuffix(key, "/") {
+			// the directory marker, the marker of the directory itself is added at last
+			if key != prefix {
+				if _, e := s.MakeDir(ctx, dest); e != nil {
+					return nil, e
+				}
+				dirs = append(dirs, key)
+			}
+			continue
+		}
+		_, skip, e := s.copy(from.c.newS3ObjectEntry(key, o.Size, o.LastModified), dest, override, ctx)
+		if e != nil {
+			return nil, e
+		}
+		if skip {
+			ctx.Progress(aws.Int64Value(o.Size), false)
+			skipped = append(skipped, key)
+			continue
+		}
+		copied = append(copied, key)
+	}
+	if prefix != "" {
+		dirs = append(dirs, prefix)
+	}
+	for _, dir := range dirs {
+		if _, ok := utils.ArrayFind(skipped, func(k string, _ int) bool { return strings.HasPrefix(k, dir) }); !ok {
+			copied = append(copied, dir)
+		}
 	}
 	_ = s.cache.Evict(to, true)
 	_ = s.cache.Evict(utils.PathParent(to), false)
-	ctx.Progress(from.Size(), false)
-	return s.newS3ObjectEntry(to, &from.size, obj.CopyObjectResult.LastModified), false, nil
+	return copied, nil
+}
+
+// listAll lists all the objects with the prefix recursively
+func (s *Drive) listAll(ctx context.Context, prefix string) ([]*s3.Object, error) {
+	objects := make([]*s3.Object, 0)
+	e := s.c.ListObjectsPagesWithContext(ctx, &s3.ListObjectsInput{
+		Bucket: s.bucket,
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsOutput, _ bool) bool {
+		objects = append(objects, page.Contents...)
+		return true
+	})
+	return objects, e
 }
 
 func (s *Drive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
-	from = drive_util.GetSelfEntry(s, from)
-	if from == nil || from.Type().IsDir() {
+	src := s.getCopySource(from)
+	if src == nil || utils.IsRootPath(src.key) {
 		return nil, err.NewUnsupportedError()
 	}
-	fromEntry := from.(*s3Entry)
-	entry, skip, e := s.copy(fromEntry, to, override, ctx)
+	if e := s.checkCopyTarget(src, to); e != nil {
+		return nil, e
+	}
+	if src.isDir {
+		copied, e := s.copyDir(ctx, src, to, override)
+		if e != nil {
+			return nil, e
+		}
+		e = src.c.deleteObjects(task.DummyContext(), copied)
+		_ = src.c.cache.Evict(src.key, true)
+		_ = src.c.cache.Evict(utils.PathParent(src.key), false)
+		return s.newS3DirEntry(to, nil), e
+	}
+	ctx.Total(src.size, false)
+	entry, skip, e := s.copy(src, to, override, ctx)
 	if e != nil {
 		return nil, e
 	}
-	if !skip {
-		e = s.delete(fromEntry.key, task.DummyContext())
-		_ = s.cache.Evict(fromEntry.key, true)
-		_ = s.cache.Evict(utils.PathParent(fromEntry.key), false)
+	if skip {
+		ctx.Progress(src.size, false)
+	} else {
+		e = src.c.deleteObjects(task.DummyContext(), []string{src.key})
+		_ = src.c.cache.Evict(src.key, true)
+		_ = src.c.cache.Evict(utils.PathParent(src.key), false)
 	}
 	return entry, e
 }
@@ -299,15 +520,23 @@ func (s *Drive) delete(path string, ctx types.TaskCtx) error {
 		return e
 	}
 	entries := drive_util.FlattenEntriesTree(tree, false)
-	n := int(math.Ceil(float64(len(entries)) / 1000))
+	keys := make([]string, len(entries))
+	for i, o := range entries {
+		keys[i] = o.Entry.Path()
+		if o.Entry.Type().IsDir() {
+			keys[i] += "/"
+		}
+	}
+	return s.deleteObjects(ctx, keys)
+}
+
+// deleteObjects deletes the objects in batches of 1000
+func (s *Drive) deleteObjects(ctx types.TaskCtx, keys []string) error {
+	n := int(math.Ceil(float64(len(keys)) / 1000))
 	for i := 0; i < n; i += 1 {
-		batches := entries[i*1000 : int(math.Min(float64((i+1)*1000), float64(len(entries))))]
+		batches := keys[i*1000 : int(math.Min(float64((i+1)*1000), float64(len(keys))))]
 		deletes := make([]*s3.ObjectIdentifier, len(batches))
-		for i, o := range batches {
-			key := o.Entry.Path()
-			if o.Entry.Type().IsDir() {
-				key += "/"
-			}
+		for i, key := range batches {
 			deletes[i] = &s3.ObjectIdentifier{
 				Key: aws.String(key),
 			}
@@ -522,6 +751,15 @@ func (s *s3Entry) GetReader(ctx context.Context, start, size int64) (io.ReadClos
 	return obj.Body, nil
 }
 
+// copySource returns the URL-encoded source of CopyObject and UploadPartCopy
+func (s *s3Entry) copySource() *string {
+	segments := strings.Split(*s.c.bucket+"/"+s.key, "/")
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(url.QueryEscape(segment), "+", "%20")
+	}
+	return aws.String(strings.Join(segments, "/"))
+}
+
 func (s *s3Entry) GetURL(context.Context) (*types.ContentURL, error) {
 	req, _ := s.c.c.GetObjectRequest(&s3.GetObjectInput{
 		Bucket: s.c.bucket,
@@ -534,6 +772,51 @@ func (s *s3Entry) GetURL(context.Context) (*types.ContentURL, error) {
 	return &types.ContentURL{URL: downloadUrl, Proxy: s.c.downloadProxy}, nil
 }
 
+// HashAlgorithms returns the algorithms may be provided by S3,
+// the sha256 and sha1 checksums are available only if they are specified when uploading
+func (s *s3Entry) HashAlgorithms() ([]string, bool) {
+	return []string{types.HashSHA256, types.HashSHA1, types.HashMD5}, false
+}
+
+func (s *s3Entry) Hash(ctx context.Context, algorithm string) (string, error) {
+	if s.isDir {
+		return "", err.NewUnsupportedError()
+	}
+	obj, e := s.c.c.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket:       s.c.bucket,
+		Key:          aws.String(s.key),
+		ChecksumMode: aws.String(s3.ChecksumModeEnabled),
+	})
+	if e != nil {
+		return "", e
+	}
+	var checksum *string
+	switch algorithm {
+	case types.HashSHA256:
+		checksum = obj.ChecksumSHA256
+	case types.HashSHA1:
+		checksum = obj.ChecksumSHA1
+	case types.HashMD5:
+		// the ETag is not the md5 of the content if the object is encrypted by SSE-KMS or SSE-C,
+		// or uploaded in multiple parts
+		etag := strings.Trim(aws.StringValue(obj.ETag), "\"")
+		if aws.StringValue(obj.ServerSideEncryption) == s3.ServerSideEncryptionAwsKms ||
+			obj.SSECustomerAlgorithm != nil || !etagMD5Pattern.MatchString(etag) {
+			return "", err.NewUnsupportedError()
+		}
+		return strings.ToLower(etag), nil
+	}
+	// the checksum of the object uploaded in multiple parts is the checksum of the checksums, like 'xxx-3'
+	if checksum == nil || strings.Contains(*checksum, "-") {
+		return "", err.NewUnsupportedError()
+	}
+	value, e := base64.StdEncoding.DecodeString(*checksum)
+	if e != nil {
+		return "", err.NewUnsupportedError()
+	}
+	return hex.EncodeToString(value), nil
+}
+
 func errCodeMatches(e error, code string) bool {
 	if ae, ok := e.(awserr.Error); ok {
 		return ae.Code() == code
@@ -0,0 +1,498 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"go-drive/common/drive_util"
+	err "go-drive/common/errors"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testObject is the object in the S3 stand-in, the data of the large object is not stored
+type testObject struct {
+	data []byte
+	size int64
+}
+
+type testPartCopy struct {
+	source string
+	start  int64
+	end    int64
+}
+
+type testUpload struct {
+	bucket string
+	key    string
+	parts  map[int]int64
+}
+
+// testS3 is an in-memory S3 stand-in serving the path-style requests used by the drive
+type testS3 struct {
+	mux     sync.Mutex
+	buckets map[string]map[string]*testObject
+	uploads map[string]*testUpload
+	// partCopies records the ranges copied by UploadPartCopy
+	partCopies []testPartCopy
+	// objectCopies records the sources copied by CopyObject
+	objectCopies []string
+	aborted      int
+}
+
+func newTestS3(t *testing.T, buckets ...string) (*testS3, *httptest.Server) {
+	s := &testS3{buckets: make(map[string]map[string]*testObject), uploads: make(map[string]*testUpload)}
+	for _, b := range buckets {
+		s.buckets[b] = make(map[string]*testObject)
+	}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+func (s *testS3) put(bucket string, keys ...string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, k := range keys {
+		s.buckets[bucket][k] = &testObject{data: []byte(k), size: int64(len(k))}
+	}
+}
+
+func (s *testS3) keys(bucket string) []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	keys := make([]string, 0)
+	for k := range s.buckets[bucket] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *testS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	segments := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	objects, ok := s.buckets[segments[0]]
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := ""
+	if len(segments) > 1 {
+		key = segments[1]
+	}
+	q := r.URL.Query()
+	switch {
+	case r.Method == http.MethodHead && key == "":
+	case r.Method == http.MethodHead:
+		o, ok := objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(o.size, 10))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+	case r.Method == http.MethodGet && key == "":
+		s.list(w, objects, q.Get("prefix"), q.Get("delimiter"))
+	case r.Method == http.MethodGet:
+		o, ok := objects[key]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		_, _ = w.Write(o.data)
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		s.uploadPartCopy(w, r, q)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		src, status := s.copySource(r)
+		if src == nil {
+			writeS3Error(w, status, "NoSuchKey")
+			return
+		}
+		if src.size > maxCopyObjectSize {
+			writeS3Error(w, http.StatusBadRequest, "InvalidRequest")
+			return
+		}
+		objects[key] = &testObject{data: src.data, size: src.size}
+		s.objectCopies = append(s.objectCopies, r.Header.Get("X-Amz-Copy-Source"))
+		writeS3XML(w, struct {
+			XMLName      xml.Name `xml:"CopyObjectResult"`
+			LastModified string
+			ETag         string
+		}{LastModified: time.Now().UTC().Format(time.RFC3339), ETag: `"etag"`})
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		objects[key] = &testObject{data: data, size: int64(len(data))}
+	case r.Method == http.MethodPost && q.Has("delete"):
+		var body struct {
+			Object []struct{ Key string }
+		}
+		if e := xml.NewDecoder(r.Body).Decode(&body); e != nil {
+			writeS3Error(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		for _, o := range body.Object {
+			delete(objects, o.Key)
+		}
+		writeS3XML(w, struct {
+			XMLName xml.Name `xml:"DeleteResult"`
+		}{})
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		id := strconv.Itoa(len(s.uploads) + 1)
+		s.uploads[id] = &testUpload{bucket: segments[0], key: key, parts: make(map[int]int64)}
+		writeS3XML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: segments[0], Key: key, UploadId: id})
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		s.completeUpload(w, r, objects, q.Get("uploadId"))
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		delete(s.uploads, q.Get("uploadId"))
+		s.aborted++
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (s *testS3) list(w http.ResponseWriter, objects map[string]*testObject, prefix, delimiter string) {
+	type content struct {
+		Key          string
+		LastModified string
+		Size         int64
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	result := struct {
+		XMLName        xml.Name `xml:"ListBucketResult"`
+		Prefix         string
+		IsTruncated    bool
+		Contents       []content
+		CommonPrefixes []commonPrefix
+	}{Prefix: prefix}
+	keys := make([]string, 0, len(objects))
+	for k := range objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	prefixes := make(map[string]bool)
+	for _, k := range keys {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		if i := strings.Index(k[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			p := k[:len(prefix)+i+len(delimiter)]
+			if !prefixes[p] {
+				prefixes[p] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{p})
+			}
+			continue
+		}
+		result.Contents = append(result.Contents, content{
+			Key: k, LastModified: time.Now().UTC().Format(time.RFC3339), Size: objects[k].size,
+		})
+	}
+	writeS3XML(w, result)
+}
+
+// copySource returns the source object of x-amz-copy-source
+func (s *testS3) copySource(r *http.Request) (*testObject, int) {
+	source, e := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+	if e != nil {
+		return nil, http.StatusBadRequest
+	}
+	segments := strings.SplitN(source, "/", 2)
+	if len(segments) != 2 {
+		return nil, http.StatusBadRequest
+	}
+	o, ok := s.buckets[segments[0]][segments[1]]
+	if !ok {
+		return nil, http.StatusNotFound
+	}
+	return o, http.StatusOK
+}
+
+func (s *testS3) uploadPartCopy(w http.ResponseWriter, r *http.Request, q url.Values) {
+	upload, ok := s.uploads[q.Get("uploadId")]
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	src, status := s.copySource(r)
+	if src == nil {
+		writeS3Error(w, status, "NoSuchKey")
+		return
+	}
+	var start, end int64
+	if _, e := fmt.Sscanf(r.Header.Get("X-Amz-Copy-Source-Range"), "bytes=%d-%d", &start, &end); e != nil ||
+		start > end || end >= src.size {
+		writeS3Error(w, http.StatusBadRequest, "InvalidRange")
+		return
+	}
+	partNumber, _ := strconv.Atoi(q.Get("partNumber"))
+	upload.parts[partNumber] = end - start + 1
+	s.partCopies = append(s.partCopies, testPartCopy{r.Header.Get("X-Amz-Copy-Source"), start, end})
+	writeS3XML(w, struct {
+		XMLName      xml.Name `xml:"CopyPartResult"`
+		LastModified string
+		ETag         string
+	}{LastModified: time.Now().UTC().Format(time.RFC3339), ETag: fmt.Sprintf(`"part-%d"`, partNumber)})
+}
+
+func (s *testS3) completeUpload(w http.ResponseWriter, r *http.Request, objects map[string]*testObject, id string) {
+	upload, ok := s.uploads[id]
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	var body struct {
+		Part []struct {
+			PartNumber int
+			ETag       string
+		}
+	}
+	if e := xml.NewDecoder(r.Body).Decode(&body); e != nil {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	size := int64(0)
+	for i, p := range body.Part {
+		partSize, ok := upload.parts[p.PartNumber]
+		if !ok || p.PartNumber != i+1 || p.ETag != fmt.Sprintf(`"part-%d"`, p.PartNumber) {
+			writeS3Error(w, http.StatusBadRequest, "InvalidPart")
+			return
+		}
+		size += partSize
+	}
+	delete(s.uploads, id)
+	objects[upload.key] = &testObject{size: size}
+	writeS3XML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string
+		Key     string
+		ETag    string
+	}{Bucket: upload.bucket, Key: upload.key, ETag: `"etag-` + strconv.Itoa(len(body.Part)) + `"`})
+}
+
+func writeS3XML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: code, Message: code})
+}
+
+func newTestDrive(t *testing.T, endpoint, bucket, accessKey string) *Drive {
+	d, e := NewDrive(context.Background(), types.SM{
+		"id": accessKey, "secret": "secret", "bucket": bucket,
+		"path_style": "1", "region": "us-east-1", "endpoint": endpoint,
+	}, drive_util.DriveUtils{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	return d.(*Drive)
+}
+
+func getTestEntry(t *testing.T, d *Drive, path string) types.IEntry {
+	entry, e := d.Get(context.Background(), path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return entry
+}
+
+func assertKeys(t *testing.T, name string, actual []string, expected ...string) {
+	if strings.Join(actual, ",") != strings.Join(expected, ",") {
+		t.Errorf("%s: expect %v, but is %v", name, expected, actual)
+	}
+}
+
+// testDirKeys are the objects of the directory 'a', 'a/sub/deep' has no directory marker
+var testDirKeys = []string{"a/", "a/x.txt", "a/sub/", "a/sub/y.txt", "a/sub/deep/z.txt", "ab.txt"}
+
+func TestCopyDir(t *testing.T) {
+	store, srv := newTestS3(t, "b1")
+	store.put("b1", testDirKeys...)
+	d := newTestDrive(t, srv.URL, "b1", "ak")
+
+	if _, e := d.Copy(task.DummyContext(), getTestEntry(t, d, "a"), "b", false); e != nil {
+		t.Fatal(e)
+	}
+	assertKeys(t, "copy", store.keys("b1"),
+		"a/", "a/sub/", "a/sub/deep/z.txt", "a/sub/y.txt", "a/x.txt", "ab.txt",
+		"b/", "b/sub/", "b/sub/deep/z.txt", "b/sub/y.txt", "b/x.txt")
+	if data := string(store.buckets["b1"]["b/sub/deep/z.txt"].data); data != "a/sub/deep/z.txt" {
+		t.Errorf("unexpected content '%s'", data)
+	}
+}
+
+func TestMoveDir(t *testing.T) {
+	store, srv := newTestS3(t, "b1")
+	store.put("b1", testDirKeys...)
+	store.put("b1", "ab/x.txt")
+	d := newTestDrive(t, srv.URL, "b1", "ak")
+
+	// 'ab' has the prefix 'a' but is not the child of 'a'
+	if _, e := d.Move(task.DummyContext(), getTestEntry(t, d, "a"), "ab", true); e != nil {
+		t.Fatal(e)
+	}
+	assertKeys(t, "move", store.keys("b1"),
+		"ab.txt", "ab/", "ab/sub/", "ab/sub/deep/z.txt", "ab/sub/y.txt", "ab/x.txt")
+	if data := string(store.buckets["b1"]["ab/x.txt"].data); data != "a/x.txt" {
+		t.Errorf("expect the object to be overridden, but is '%s'", data)
+	}
+
+	// the skipped objects and their parent directories are kept
+	store.put("b1", "c/", "c/x.txt", "c/sub/y.txt", "d/sub/y.txt")
+	if _, e := d.Move(task.DummyContext(), getTestEntry(t, d, "c"), "d", false); e != nil {
+		t.Fatal(e)
+	}
+	assertKeys(t, "move without override", store.keys("b1"),
+		"ab.txt", "ab/", "ab/sub/", "ab/sub/deep/z.txt", "ab/sub/y.txt", "ab/x.txt",
+		"c/", "c/sub/y.txt", "d/", "d/sub/y.txt", "d/x.txt")
+}
+
+func TestMoveToChild(t *testing.T) {
+	store, srv := newTestS3(t, "b1", "b2")
+	store.put("b1", testDirKeys...)
+	d := newTestDrive(t, srv.URL, "b1", "ak")
+	dir := getTestEntry(t, d, "a")
+	file := getTestEntry(t, d, "a/x.txt")
+
+	cases := []struct {
+		name  string
+		entry types.IEntry
+		to    string
+	}{
+		{"dir to itself", dir, "a"},
+		{"dir to child", dir, "a/sub/a"},
+		{"dir to child with trailing slash", dir, "a/b/"},
+		{"file to itself", file, "a/x.txt"},
+	}
+	for _, c := range cases {
+		if _, e := d.Move(task.DummyContext(), c.entry, c.to, true); !err.IsNotAllowedError(e) {
+			t.Errorf("move %s: expect not allowed, but is %v", c.name, e)
+		}
+		if _, e := d.Copy(task.DummyContext(), c.entry, c.to, true); !err.IsNotAllowedError(e) {
+			t.Errorf("copy %s: expect not allowed, but is %v", c.name, e)
+		}
+	}
+	assertKeys(t, "rejected", store.keys("b1"), "a/", "a/sub/", "a/sub/deep/z.txt", "a/sub/y.txt", "a/x.txt", "ab.txt")
+
+	// the same key in another bucket is not the child
+	d2 := newTestDrive(t, srv.URL, "b2", "ak")
+	if _, e := d2.Move(task.DummyContext(), dir, "a/sub", false); e != nil {
+		t.Fatal(e)
+	}
+	assertKeys(t, "source", store.keys("b1"), "ab.txt")
+	assertKeys(t, "target", store.keys("b2"), "a/sub/", "a/sub/sub/", "a/sub/sub/deep/z.txt", "a/sub/sub/y.txt", "a/sub/x.txt")
+}
+
+func TestCopyMultipart(t *testing.T) {
+	store, srv := newTestS3(t, "b1")
+	const size = 6<<30 + 1
+	store.buckets["b1"]["dir/big file"] = &testObject{size: size}
+	store.buckets["b1"]["max"] = &testObject{size: maxCopyObjectSize}
+	d := newTestDrive(t, srv.URL, "b1", "ak")
+
+	if _, e := d.Copy(task.DummyContext(), getTestEntry(t, d, "max"), "max2", false); e != nil {
+		t.Fatal(e)
+	}
+	if len(store.partCopies) != 0 || len(store.objectCopies) != 1 {
+		t.Errorf("expect the object of %d bytes to be copied by CopyObject", maxCopyObjectSize)
+	}
+
+	copied, e := d.Copy(task.DummyContext(), getTestEntry(t, d, "dir/big file"), "big", false)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if copied.Size() != size || store.buckets["b1"]["big"].size != size {
+		t.Errorf("expect the size to be %d, but is %d", int64(size), store.buckets["b1"]["big"].size)
+	}
+	if len(store.objectCopies) != 1 || len(store.partCopies) != 13 {
+		t.Fatalf("expect 13 parts to be copied by UploadPartCopy, but is %d", len(store.partCopies))
+	}
+	next := int64(0)
+	for i, p := range store.partCopies {
+		if p.source != "b1/dir/big%20file" {
+			t.Errorf("unexpected copy source '%s'", p.source)
+		}
+		if p.start != next || (i < len(store.partCopies)-1 && p.end-p.start+1 != copyPartSize) {
+			t.Errorf("unexpected range of part %d: %d-%d", i+1, p.start, p.end)
+		}
+		next = p.end + 1
+	}
+	if next != size {
+		t.Errorf("expect the parts to cover %d bytes, but is %d", int64(size), next)
+	}
+	if len(store.uploads) != 0 || store.aborted != 0 {
+		t.Errorf("expect the upload to be completed")
+	}
+
+	// the upload is aborted if the part can not be copied
+	bigSize := int64(size)
+	store.buckets["b1"]["dir/big file"].size = 1 << 30
+	e = d.copyMultipart(d.newS3ObjectEntry("dir/big file", &bigSize, &time.Time{}), "big3", task.DummyContext())
+	if e == nil || store.aborted != 1 || len(store.uploads) != 0 {
+		t.Errorf("expect the upload to be aborted, but is %v", e)
+	}
+	if _, ok := store.buckets["b1"]["big3"]; ok {
+		t.Errorf("expect the object not to be created")
+	}
+}
+
+func TestGetCopySource(t *testing.T) {
+	store, srv := newTestS3(t, "b1", "b2")
+	_, srv2 := newTestS3(t, "b2")
+	store.put("b1", "x.txt")
+	d := newTestDrive(t, srv.URL, "b1", "ak")
+	file := getTestEntry(t, d, "x.txt")
+
+	// the same endpoint, region and access key
+	if _, e := newTestDrive(t, srv.URL, "b2", "ak").Copy(task.DummyContext(), file, "y.txt", false); e != nil {
+		t.Fatal(e)
+	}
+	assertKeys(t, "copy to another bucket", store.keys("b2"), "y.txt")
+	if len(store.objectCopies) != 1 || store.objectCopies[0] != "b1/x.txt" {
+		t.Errorf("expect the object to be copied by the server, but is %v", store.objectCopies)
+	}
+
+	unsupported := map[string]*Drive{
+		"another endpoint":   newTestDrive(t, srv2.URL, "b2", "ak"),
+		"another access key": newTestDrive(t, srv.URL, "b2", "ak2"),
+	}
+	region := newTestDrive(t, srv.URL, "b2", "ak")
+	region.region = "us-west-1"
+	unsupported["another region"] = region
+	for name, target := range unsupported {
+		if _, e := target.Copy(task.DummyContext(), file, "z.txt", false); !err.IsUnsupportedError(e) {
+			t.Errorf("copy from %s: expect unsupported, but is %v", name, e)
+		}
+		if _, e := target.Move(task.DummyContext(), file, "z.txt", false); !err.IsUnsupportedError(e) {
+			t.Errorf("move from %s: expect unsupported, but is %v", name, e)
+		}
+	}
+	assertKeys(t, "source", store.keys("b1"), "x.txt")
+	assertKeys(t, "target", store.keys("b2"), "y.txt")
+}
@@ -255,6 +255,29 @@ func (f *Drive) Move(ctx types.TaskCtx, from types.IEntry, to string, override b
 	return f.Get(ctx, to)
 }
 
+func (f *Drive) HardLink(ctx context.Context, from types.IEntry, to string) (types.IEntry, error) {
+	from = drive_util.GetSelfEntry(f, from)
+	if from == nil || from.Type().IsDir() {
+		return nil, err.NewUnsupportedError()
+	}
+	c, e := f.getClient()
+	if e != nil {
+		return nil, e
+	}
+	if _, ok := c.HasExtension("hardlink@openssh.com"); !ok {
+		return nil, err.NewUnsupportedError()
+	}
+	if _, e := drive_util.RequireFileNotExists(ctx, f, to); e != nil {
+		return nil, e
+	}
+	e = c.Link(f.toRemotePath(from.(*sftpEntry).path), f.toRemotePath(to))
+	if e != nil {
+		return nil, f.handleError(e)
+	}
+	_ = f.cache.Evict(utils.PathParent(to), false)
+	return f.Get(ctx, to)
+}
+
 func (f *Drive) List(_ context.Context, path string) ([]types.IEntry, error) {
 	if cached, _ := f.cache.GetChildren(path); cached != nil {
 		return cached, nil
@@ -440,3 +463,15 @@ func (f *sftpEntry) GetReader(ctx context.Context, start, size int64) (io.ReadCl
 func (f *sftpEntry) GetURL(context.Context) (*types.ContentURL, error) {
 	return nil, err.NewUnsupportedError()
 }
+
+// HashAlgorithms returns the computed sha256 only, the hash is calculated by reading the content
+func (f *sftpEntry) HashAlgorithms() ([]string, bool) {
+	return []string{types.HashSHA256}, true
+}
+
+func (f *sftpEntry) Hash(ctx context.Context, algorithm string) (string, error) {
+	if f.isDir || algorithm != types.HashSHA256 {
+		return "", err.NewUnsupportedError()
+	}
+	return drive_util.ComputeHash(ctx, f, algorithm)
+}
@@ -0,0 +1,236 @@
+package drive
+
+import (
+	"context"
+	err "go-drive/common/errors"
+	"go-drive/common/event"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/storage"
+	"io"
+	path2 "path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// TrashDirName is the name of the trash folder in the root of each drive
+	TrashDirName = ".go-drive-trash"
+
+	trashEnabledKey = "trash.enabled"
+)
+
+// Trash moves the deleted entries to the trash folder of the drive where they are located,
+// and records where they come from, so they can be restored later.
+type Trash struct {
+	rootDrive *RootDrive
+	trashDAO  *storage.TrashDAO
+	options   *storage.OptionsDAO
+	bus       event.Bus
+}
+
+func NewTrash(ch *registry.ComponentsHolder, rootDrive *RootDrive,
+	trashDAO *storage.TrashDAO, options *storage.OptionsDAO, bus event.Bus) *Trash {
+	t := &Trash{
+		rootDrive: rootDrive,
+		trashDAO:  trashDAO,
+		options:   options,
+		bus:       bus,
+	}
+	ch.Add("trash", t)
+	return t
+}
+
+// Enabled returns whether the deleted entries are moved to trash, it's enabled by default
+func (t *Trash) Enabled() bool {
+	v, _ := t.options.GetOrDefault(trashEnabledKey, "1")
+	return types.SV(v).Bool()
+}
+
+// Wrap wraps the drive, the entries deleted from the wrapped drive will be moved to trash.
+// The drive must be the root drive or the wrapper of it.
+func (t *Trash) Wrap(d types.IDrive, session types.Session) *TrashWrapperDrive {
+	return &TrashWrapperDrive{IDrive: d, trash: t, session: session}
+}
+
+func (t *Trash) moveToTrash(ctx types.TaskCtx, entry types.IEntry, realPath string, session types.Session) error {
+	root := t.rootDrive.Get()
+	driveName := strings.SplitN(realPath, "/", 2)[0]
+	id := strings.ReplaceAll(uuid.New().String(), "-", "")
+	trashDir := path2.Join(driveName, TrashDirName, id)
+
+	if _, e := root.MakeDir(ctx, trashDir); e != nil {
+		return e
+	}
+	moved, e := root.Move(ctx, entry, path2.Join(trashDir, utils.PathBase(realPath)), false)
+	if e != nil {
+		_ = root.Delete(ctx, trashDir)
+		return e
+	}
+	return t.trashDAO.AddItem(types.TrashItem{
+		ID:        id,
+		Path:      entry.Path(),
+		TrashPath: moved.Path(),
+		Type:      entry.Type(),
+		Size:      entry.Size(),
+		DeletedBy: session.User.Username,
+		DeletedAt: uint64(time.Now().UnixMilli()),
+	})
+}
+
+// Restore moves the entry back to where it was deleted from.
+// If the path is occupied, the entry will be renamed.
+func (t *Trash) Restore(ctx types.TaskCtx, item types.TrashItem, session types.Session) (types.IEntry, error) {
+	root := t.rootDrive.Get()
+	entry, e := root.Get(ctx, item.TrashPath)
+	if e != nil {
+		return nil, e
+	}
+	if e := makeDirs(ctx, root, utils.PathParent(item.Path)); e != nil {
+		return nil, e
+	}
+	to, e := root.FindNonExistsEntryName(ctx, root, item.Path)
+	if e != nil {
+		return nil, e
+	}
+	d := NewListenerWrapper(root, types.DriveListenerContext{Session: &session, Drive: root}, t.bus)
+	restored, e := d.Move(ctx, entry, to, false)
+	if e != nil {
+		return nil, e
+	}
+	_ = root.Delete(ctx, utils.PathParent(item.TrashPath))
+	return restored, t.trashDAO.DeleteItem(item.ID)
+}
+
+// makeDirs makes the directory and all its missing ancestors
+func makeDirs(ctx context.Context, d types.IDrive, path string) error {
+	if utils.IsRootPath(path) {
+		return nil
+	}
+	if _, e := d.Get(ctx, path); e == nil || !err.IsNotFoundError(e) {
+		return e
+	}
+	if e := makeDirs(ctx, d, utils.PathParent(path)); e != nil {
+		return e
+	}
+	_, e := d.MakeDir(ctx, path)
+	return e
+}
+
+// Purge deletes the entry in trash permanently
+func (t *Trash) Purge(ctx types.TaskCtx, item types.TrashItem) error {
+	e := t.rootDrive.Get().Delete(ctx, utils.PathParent(item.TrashPath))
+	if e != nil && !err.IsNotFoundError(e) {
+		return e
+	}
+	return t.trashDAO.DeleteItem(item.ID)
+}
+
+// Clean purges the entries deleted before the specified time, returns the count of purged entries
+func (t *Trash) Clean(ctx types.TaskCtx, before time.Time) (int, error) {
+	items, e := t.trashDAO.ListItemsBefore(uint64(before.UnixMilli()))
+	if e != nil {
+		return 0, e
+	}
+	for i, item := range items {
+		if e := ctx.Err(); e != nil {
+			return i, e
+		}
+		if e := t.Purge(ctx, item); e != nil {
+			return i, e
+		}
+	}
+	return len(items), nil
+}
+
+// IsTrashPath returns true if the path is in the trash folder of a drive
+func IsTrashPath(path string) bool {
+	segments := strings.SplitN(path, "/", 3)
+	return len(segments) > 1 && segments[1] == TrashDirName
+}
+
+// TrashWrapperDrive moves the deleted entries to trash instead of deleting them,
+// and prevents the trash folders from being accessed.
+type TrashWrapperDrive struct {
+	types.IDrive
+
+	trash   *Trash
+	session types.Session
+}
+
+func (t *TrashWrapperDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if IsTrashPath(path) {
+		return nil, err.NewNotFoundError()
+	}
+	return t.IDrive.Get(ctx, path)
+}
+
+func (t *TrashWrapperDrive) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if IsTrashPath(path) {
+		return nil, err.NewNotFoundError()
+	}
+	return t.IDrive.Save(ctx, path, size, override, reader)
+}
+
+func (t *TrashWrapperDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if IsTrashPath(path) {
+		return nil, err.NewNotFoundError()
+	}
+	return t.IDrive.MakeDir(ctx, path)
+}
+
+func (t *TrashWrapperDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	if IsTrashPath(from.Path()) || IsTrashPath(to) {
+		return nil, err.NewNotFoundError()
+	}
+	return t.IDrive.Copy(ctx, from, to, override)
+}
+
+func (t *TrashWrapperDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	if IsTrashPath(from.Path()) || IsTrashPath(to) {
+		return nil, err.NewNotFoundError()
+	}
+	return t.IDrive.Move(ctx, from, to, override)
+}
+
+func (t *TrashWrapperDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if IsTrashPath(path) {
+		return nil, err.NewNotFoundError()
+	}
+	return t.IDrive.List(ctx, path)
+}
+
+func (t *TrashWrapperDrive) Delete(ctx types.TaskCtx, path string) error {
+	if IsTrashPath(path) {
+		return err.NewNotFoundError()
+	}
+	// drives can not be deleted
+	if !t.trash.Enabled() || utils.PathDepth(path) <= 1 {
+		return t.IDrive.Delete(ctx, path)
+	}
+	// deleting the mount point only removes the mount
+	if _, isSelf := t.trash.rootDrive.root.resolveMountedChildren(path); isSelf {
+		return t.IDrive.Delete(ctx, path)
+	}
+	entry, e := t.IDrive.Get(ctx, path)
+	if e != nil {
+		return e
+	}
+	de, ok := entry.(types.IDispatcherEntry)
+	if !ok {
+		return t.IDrive.Delete(ctx, path)
+	}
+	return t.trash.moveToTrash(ctx, entry, de.GetRealPath(), t.session)
+}
+
+func (t *TrashWrapperDrive) Upload(ctx context.Context, path string, size int64,
+	override bool, config types.SM) (*types.DriveUploadConfig, error) {
+	if IsTrashPath(path) {
+		return nil, err.NewNotFoundError()
+	}
+	return t.IDrive.Upload(ctx, path, size, override, config)
+}
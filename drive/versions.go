@@ -0,0 +1,293 @@
+package drive
+
+import (
+	"context"
+	err "go-drive/common/errors"
+	"go-drive/common/registry"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/storage"
+	"io"
+	"log"
+	path2 "path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// VersionsDirName is the name of the folder in the root of each drive where the old versions are stored
+	VersionsDirName = ".go-drive-versions"
+
+	versioningEnabledKey     = "versioning.enabled"
+	versioningMaxVersionsKey = "versioning.maxVersions"
+	versioningMaxAgeKey      = "versioning.maxAge"
+
+	defaultMaxVersions = 10
+)
+
+// Versions keeps the old versions of files when they are overwritten.
+// The old content is stored in the versions folder of the drive where the file is located.
+type Versions struct {
+	rootDrive  *RootDrive
+	versionDAO *storage.FileVersionDAO
+	options    *storage.OptionsDAO
+}
+
+func NewVersions(ch *registry.ComponentsHolder, rootDrive *RootDrive,
+	versionDAO *storage.FileVersionDAO, options *storage.OptionsDAO) *Versions {
+	v := &Versions{
+		rootDrive:  rootDrive,
+		versionDAO: versionDAO,
+		options:    options,
+	}
+	ch.Add("versions", v)
+	return v
+}
+
+// Enabled returns whether the versioning is enabled, it's disabled by default
+func (v *Versions) Enabled() bool {
+	return v.options.GetValue(versioningEnabledKey).Bool()
+}
+
+// Wrap wraps the drive to keep old versions of the overwritten files.
+// The drive must be the root drive or the wrapper of it.
+func (v *Versions) Wrap(d types.IDrive, session types.Session) *VersionWrapperDrive {
+	return &VersionWrapperDrive{IDrive: d, versions: v, session: session}
+}
+
+// ListVersions lists the versions of the file at the path, the latest version comes first
+func (v *Versions) ListVersions(path string) ([]types.FileVersion, error) {
+	return v.versionDAO.ListVersions(path)
+}
+
+func (v *Versions) GetVersion(id string) (types.FileVersion, error) {
+	return v.versionDAO.GetVersion(id)
+}
+
+// GetVersionEntry returns the entry that holds the content of the version
+func (v *Versions) GetVersionEntry(ctx context.Context, version types.FileVersion) (types.IEntry, error) {
+	return v.rootDrive.Get().Get(ctx, version.VersionPath)
+}
+
+// snapshot keeps the content of the entry as a new version.
+// If move is true, the entry will be moved to the versions folder, otherwise it will be copied.
+func (v *Versions) snapshot(ctx types.TaskCtx, entry types.IEntry, move bool,
+	session types.Session) (types.FileVersion, error) {
+	de, ok := entry.(types.IDispatcherEntry)
+	if !ok {
+		return types.FileVersion{}, err.NewNotAllowedError()
+	}
+	realPath := de.GetRealPath()
+	root := v.rootDrive.Get()
+	driveName := strings.SplitN(realPath, "/", 2)[0]
+	id := strings.ReplaceAll(uuid.New().String(), "-", "")
+	versionDir := path2.Join(driveName, VersionsDirName, id)
+
+	if _, e := root.MakeDir(ctx, versionDir); e != nil {
+		return types.FileVersion{}, e
+	}
+	var saved types.IEntry
+	var e error
+	to := path2.Join(versionDir, utils.PathBase(realPath))
+	if move {
+		saved, e = root.Move(ctx, entry, to, false)
+	} else {
+		saved, e = root.Copy(ctx, entry, to, false)
+	}
+	if e != nil {
+		_ = root.Delete(ctx, versionDir)
+		return types.FileVersion{}, e
+	}
+	version := types.FileVersion{
+		ID:          id,
+		Path:        entry.Path(),
+		VersionPath: saved.Path(),
+		Size:        entry.Size(),
+		ModTime:     entry.ModTime(),
+		CreatedBy:   session.User.Username,
+		CreatedAt:   uint64(time.Now().UnixMilli()),
+	}
+	if e := v.versionDAO.AddVersion(version); e != nil {
+		_ = root.Delete(ctx, versionDir)
+		return types.FileVersion{}, e
+	}
+	return version, nil
+}
+
+// rollback moves the content of the version back to the file and removes the version
+func (v *Versions) rollback(ctx types.TaskCtx, version types.FileVersion) error {
+	root := v.rootDrive.Get()
+	entry, e := root.Get(ctx, version.VersionPath)
+	if e != nil {
+		return e
+	}
+	if _, e := root.Move(ctx, entry, version.Path, true); e != nil {
+		return e
+	}
+	return v.deleteVersion(ctx, version)
+}
+
+// prune removes the versions exceed the max count or the max age
+func (v *Versions) prune(ctx types.TaskCtx, path string) {
+	maxVersions := v.options.GetValue(versioningMaxVersionsKey).Int(defaultMaxVersions)
+	maxAge := v.options.GetValue(versioningMaxAgeKey).Duration(0)
+
+	versions, e := v.versionDAO.ListVersions(path)
+	if e != nil {
+		log.Printf("[Versions] failed to list versions of %s: %v", utils.LogSanitize(path), e)
+		return
+	}
+	expiresBefore := uint64(0)
+	if maxAge > 0 {
+		expiresBefore = uint64(time.Now().Add(-maxAge).UnixMilli())
+	}
+	for i, version := range versions {
+		if (maxVersions > 0 && i >= maxVersions) || version.CreatedAt < expiresBefore {
+			if e := v.deleteVersion(ctx, version); e != nil {
+				log.Printf("[Versions] failed to delete version %s of %s: %v",
+					version.ID, utils.LogSanitize(path), e)
+			}
+		}
+	}
+}
+
+func (v *Versions) deleteVersion(ctx types.TaskCtx, version types.FileVersion) error {
+	e := v.rootDrive.Get().Delete(ctx, utils.PathParent(version.VersionPath))
+	if e != nil && !err.IsNotFoundError(e) {
+		return e
+	}
+	return v.versionDAO.DeleteVersion(version.ID)
+}
+
+// IsVersionsPath returns true if the path is in the versions folder of a drive
+func IsVersionsPath(path string) bool {
+	segments := strings.SplitN(path, "/", 3)
+	return len(segments) > 1 && segments[1] == VersionsDirName
+}
+
+// VersionWrapperDrive keeps the old versions of files when they are overwritten,
+// and prevents the versions folders from being accessed.
+type VersionWrapperDrive struct {
+	types.IDrive
+
+	versions *Versions
+	session  types.Session
+}
+
+func (v *VersionWrapperDrive) Get(ctx context.Context, path string) (types.IEntry, error) {
+	if IsVersionsPath(path) {
+		return nil, err.NewNotFoundError()
+	}
+	return v.IDrive.Get(ctx, path)
+}
+
+func (v *VersionWrapperDrive) Save(ctx types.TaskCtx, path string, size int64,
+	override bool, reader io.Reader) (types.IEntry, error) {
+	if IsVersionsPath(path) {
+		return nil, err.NewNotFoundError()
+	}
+	old, e := v.getOverwritten(ctx, path, override)
+	if e != nil {
+		return nil, e
+	}
+	if old == nil {
+		return v.IDrive.Save(ctx, path, size, override, reader)
+	}
+	version, e := v.versions.snapshot(ctx, old, true, v.session)
+	if e != nil {
+		return nil, e
+	}
+	saved, e := v.IDrive.Save(ctx, path, size, override, reader)
+	if e != nil {
+		if re := v.versions.rollback(ctx, version); re != nil {
+			log.Printf("[Versions] failed to rollback %s: %v", utils.LogSanitize(path), re)
+		}
+		return nil, e
+	}
+	v.versions.prune(ctx, path)
+	return saved, nil
+}
+
+func (v *VersionWrapperDrive) MakeDir(ctx context.Context, path string) (types.IEntry, error) {
+	if IsVersionsPath(path) {
+		return nil, err.NewNotFoundError()
+	}
+	return v.IDrive.MakeDir(ctx, path)
+}
+
+func (v *VersionWrapperDrive) Copy(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	if IsVersionsPath(from.Path()) || IsVersionsPath(to) {
+		return nil, err.NewNotFoundError()
+	}
+	return v.IDrive.Copy(ctx, from, to, override)
+}
+
+func (v *VersionWrapperDrive) Move(ctx types.TaskCtx, from types.IEntry, to string, override bool) (types.IEntry, error) {
+	if IsVersionsPath(from.Path()) || IsVersionsPath(to) {
+		return nil, err.NewNotFoundError()
+	}
+	return v.IDrive.Move(ctx, from, to, override)
+}
+
+func (v *VersionWrapperDrive) List(ctx context.Context, path string) ([]types.IEntry, error) {
+	if IsVersionsPath(path) {
+		return nil, err.NewNotFoundError()
+	}
+	return v.IDrive.List(ctx, path)
+}
+
+func (v *VersionWrapperDrive) Delete(ctx types.TaskCtx, path string) error {
+	if IsVersionsPath(path) {
+		return err.NewNotFoundError()
+	}
+	return v.IDrive.Delete(ctx, path)
+}
+
+func (v *VersionWrapperDrive) Upload(ctx context.Context, path string, size int64,
+	override bool, config types.SM) (*types.DriveUploadConfig, error) {
+	if IsVersionsPath(path) {
+		return nil, err.NewNotFoundError()
+	}
+	r, e := v.IDrive.Upload(ctx, path, size, override, config)
+	if e != nil || r == nil {
+		return r, e
+	}
+	// local uploads will be saved by Save
+	if r.Provider == types.LocalProvider || r.Provider == types.LocalChunkProvider {
+		return r, nil
+	}
+	// the content will be uploaded to the drive directly, so keep a copy of the current content
+	tc := task.NewContextWrapper(ctx)
+	old, e := v.getOverwritten(tc, path, override)
+	if e != nil {
+		return nil, e
+	}
+	if old != nil {
+		if _, e := v.versions.snapshot(tc, old, false, v.session); e != nil {
+			return nil, e
+		}
+		v.versions.prune(tc, path)
+	}
+	return r, nil
+}
+
+// getOverwritten returns the file entry that will be overwritten, or nil if no versions should be kept
+func (v *VersionWrapperDrive) getOverwritten(ctx context.Context, path string, override bool) (types.IEntry, error) {
+	if !override || !v.versions.Enabled() {
+		return nil, nil
+	}
+	entry, e := v.IDrive.Get(ctx, path)
+	if e != nil {
+		if err.IsNotFoundError(e) {
+			return nil, nil
+		}
+		return nil, e
+	}
+	if entry.Type() != types.TypeFile {
+		return nil, nil
+	}
+	return entry, nil
+}
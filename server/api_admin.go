@@ -13,6 +13,7 @@ import (
 	"go-drive/common/utils"
 	"go-drive/drive"
 	"go-drive/drive/script"
+	"go-drive/server/audit"
 	"go-drive/server/search"
 	"go-drive/storage"
 	"os"
@@ -20,6 +21,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -35,15 +37,18 @@ func InitAdminRoutes(
 	rootDrive *drive.RootDrive,
 	search *search.Service,
 	tokenStore types.TokenStore,
+	signer *utils.Signer,
 	optionsDAO *storage.OptionsDAO,
 	userDAO *storage.UserDAO,
 	groupDAO *storage.GroupDAO,
 	driveDAO *storage.DriveDAO,
 	driveDataDAO *storage.DriveDataDAO,
 	permissionDAO *storage.PathPermissionDAO,
-	pathMountDAO *storage.PathMountDAO) error {
+	pathMountDAO *storage.PathMountDAO,
+	twoFactorDAO *storage.TwoFactorDAO,
+	auditLogger *audit.Logger) error {
 
-	r = r.Group("/admin", TokenAuth(tokenStore), AdminGroupRequired())
+	r = r.Group("/admin", TokenAuth(tokenStore), AdminGroupRequired(), AuditAdmin(auditLogger))
 
 	// region user
 
@@ -112,6 +117,13 @@ func InitAdminRoutes(
 		}
 	})
 
+	// reset the two-factor authentication of the user, e.g. the user lost the device and the recovery codes
+	r.DELETE("/user/:username/2fa", func(c *gin.Context) {
+		if e := twoFactorDAO.DeleteTwoFactor(c.Param("username")); e != nil {
+			_ = c.Error(e)
+		}
+	})
+
 	// endregion
 
 	// region group
@@ -319,6 +331,12 @@ func InitAdminRoutes(
 			_ = c.Error(e)
 			return
 		}
+		for _, p := range permissions {
+			if p.Permission&^types.PermissionAll != 0 {
+				_ = c.Error(err.NewBadRequestError(i18n.T("api.admin.invalid_permission", strconv.Itoa(int(p.Permission)), p.Subject)))
+				return
+			}
+		}
 		if e := permissionDAO.SavePathPermissions(path, permissions); e != nil {
 			_ = c.Error(e)
 			return
@@ -487,6 +505,10 @@ func InitAdminRoutes(
 			_ = c.Error(e)
 			return
 		}
+		if _, ok := options[signerSecretsKey]; ok {
+			_ = c.Error(err.NewNotAllowedError())
+			return
+		}
 		e := optionsDAO.Sets(options)
 		if e != nil {
 			_ = c.Error(e)
@@ -502,9 +524,17 @@ func InitAdminRoutes(
 			_ = c.Error(e)
 			return
 		}
+		delete(value, signerSecretsKey)
 		SetResult(c, value)
 	})
 
+	// rotate the secret of signed urls
+	r.POST("/signer/rotate", func(c *gin.Context) {
+		if e := RotateSignerSecret(config, signer, optionsDAO); e != nil {
+			_ = c.Error(e)
+		}
+	})
+
 	// endregion
 
 	// region script drives
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/server/audit"
+	"go-drive/storage"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultAuditLogsLimit = 50
+	maxAuditLogsLimit     = 1000
+)
+
+func InitAuditRoutes(
+	r gin.IRouter,
+	tokenStore types.TokenStore,
+	auditLogDAO *storage.AuditLogDAO) error {
+
+	r = r.Group("/admin/audit-logs", TokenAuth(tokenStore), AdminGroupRequired())
+
+	// query audit logs
+	r.GET("", func(c *gin.Context) {
+		q := storage.AuditLogQuery{
+			Username: c.Query("username"),
+			Action:   c.Query("action"),
+			Result:   c.Query("result"),
+			Since:    utils.ToInt64(c.Query("since"), 0),
+			Before:   utils.ToInt64(c.Query("before"), 0),
+			Offset:   utils.ToInt(c.Query("offset"), 0),
+			Limit:    utils.ToInt(c.Query("limit"), defaultAuditLogsLimit),
+		}
+		if path := c.Query("path"); path != "" {
+			q.Path = utils.CleanPath(path)
+		}
+		if q.Offset < 0 {
+			q.Offset = 0
+		}
+		if q.Limit <= 0 || q.Limit > maxAuditLogsLimit {
+			q.Limit = defaultAuditLogsLimit
+		}
+		logs, total, e := auditLogDAO.GetLogs(q)
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		SetResult(c, auditLogsResult{Items: logs, Total: total})
+	})
+
+	return nil
+}
+
+type auditLogsResult struct {
+	Items []types.AuditLog `json:"items"`
+	Total int64            `json:"total"`
+}
+
+// AuditAdmin records the admin requests except GET and HEAD.
+// The request body is not recorded since it may contain passwords or secrets.
+func AuditAdmin(auditLogger *audit.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			return
+		}
+		params := make([]string, 0, len(c.Params))
+		for _, p := range c.Params {
+			params = append(params, strings.TrimPrefix(p.Value, "/"))
+		}
+		item := types.AuditLog{
+			Username: GetSession(c).User.Username,
+			IP:       c.ClientIP(),
+			Action:   c.Request.Method + " " + c.FullPath(),
+			Path:     strings.Join(params, ", "),
+		}
+		if e := c.Errors.Last(); e != nil {
+			item.Result = types.AuditResultFailed
+			item.Message = e.Error()
+		}
+		auditLogger.Log(item)
+	}
+}
@@ -1,18 +1,38 @@
 package server
 
 import (
+	"go-drive/common"
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
 	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/storage"
+	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func InitAuthRoutes(r gin.IRouter, ua *UserAuth,
-	tokenStore types.TokenStore, failBan *FailBanGroup) error {
+func InitAuthRoutes(r gin.IRouter, config common.Config, ua *UserAuth, oidc *OIDCAuth, twoFactor *TwoFactorAuth,
+	tokenStore types.TokenStore, apiTokenDAO *storage.APITokenDAO, failBan *FailBanGroup) error {
 
-	ar := authRoute{userAuth: ua, tokenStore: tokenStore}
+	ar := authRoute{
+		userAuth:    ua,
+		oidc:        oidc,
+		twoFactor:   twoFactor,
+		tokenStore:  tokenStore,
+		apiTokenDAO: apiTokenDAO,
+		webPath:     config.WebPath,
+		apiPath:     config.APIPath,
+	}
 
 	r.POST("/auth/init", ar.init)
+	// the provider redirects the browser to here after the user logged in
+	r.GET("/auth/oidc/callback", ar.oidcCallback)
+	// exchange the login code of the callback for a new session token
+	r.POST("/auth/oidc/token", failBan.LimiterByIP("/oidc/token", 5*time.Minute, 5), ar.oidcToken)
 
 	auth := r.Group("/auth", TokenAuth(tokenStore))
 	{
@@ -22,16 +42,37 @@ func InitAuthRoutes(r gin.IRouter, ua *UserAuth,
 			ar.login,
 		)
 
+		auth.POST("/oidc/login", ar.oidcLogin)
+
 		auth.POST("/logout", ar.logout)
 		auth.GET("/user", ar.getUser)
+
+		// personal API tokens of current user
+		tokens := auth.Group("/tokens", UnrestrictedRequired())
+		tokens.GET("", ar.listTokens)
+		tokens.POST("", ar.createToken)
+		tokens.DELETE("/:id", ar.deleteToken)
+
+		// two-factor authentication of current user
+		tf := auth.Group("/2fa", UnrestrictedRequired())
+		tf.GET("", ar.getTwoFactor)
+		tf.POST("/enroll", ar.enrollTwoFactor)
+		tf.POST("/enable", failBan.LimiterByIP("/2fa/enable", 5*time.Minute, 5), ar.enableTwoFactor)
+		tf.POST("/disable", failBan.LimiterByIP("/2fa/disable", 5*time.Minute, 5), ar.disableTwoFactor)
+		tf.POST("/recovery-codes", failBan.LimiterByIP("/2fa/recovery-codes", 5*time.Minute, 5), ar.regenerateRecoveryCodes)
 	}
 
 	return nil
 }
 
 type authRoute struct {
-	userAuth   *UserAuth
-	tokenStore types.TokenStore
+	userAuth    *UserAuth
+	oidc        *OIDCAuth
+	twoFactor   *TwoFactorAuth
+	tokenStore  types.TokenStore
+	apiTokenDAO *storage.APITokenDAO
+	webPath     string
+	apiPath     string
 }
 
 func (a *authRoute) init(c *gin.Context) {
@@ -44,24 +85,139 @@ func (a *authRoute) init(c *gin.Context) {
 }
 
 func (a *authRoute) login(c *gin.Context) {
-	user := types.User{}
-	if e := c.Bind(&user); e != nil {
+	req := loginRequest{}
+	if e := c.Bind(&req); e != nil {
+		_ = c.Error(e)
+		return
+	}
+	user, e := a.userAuth.AuthByUsernamePassword(req.Username, req.Password)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	pending, e := a.verifyTwoFactor(user, req.OTP)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	session := GetSession(c)
+	session.User = user
+	session.TwoFactorPending = pending
+	if _, e := a.tokenStore.Update(GetToken(c), session); e != nil {
+		_ = c.Error(e)
+	}
+}
+
+// verifyTwoFactor verifies the code if the user has enabled the two-factor authentication,
+// it returns true if the user is required to enable it
+func (a *authRoute) verifyTwoFactor(user types.User, otp string) (bool, error) {
+	enabled, e := a.twoFactor.IsEnabled(user.Username)
+	if e != nil {
+		return false, e
+	}
+	if !enabled {
+		return a.twoFactor.IsRequired(user), nil
+	}
+	if otp == "" {
+		return false, err.NewNotAllowedDataError(i18n.T("api.auth.two_factor_required"),
+			types.M{"twoFactorRequired": true})
+	}
+	return false, a.twoFactor.Verify(user.Username, otp)
+}
+
+// oidcLogin starts the OpenID Connect login of current session, the browser should go to the returned url
+func (a *authRoute) oidcLogin(c *gin.Context) {
+	token := GetToken(c)
+	if IsAPIToken(token) {
+		_ = c.Error(err.NewNotAllowedMessageError(i18n.T("api.auth.api_token_not_allowed")))
+		return
+	}
+	req := struct {
+		Redirect string `json:"redirect"`
+	}{}
+	if e := c.Bind(&req); e != nil {
+		_ = c.Error(e)
+		return
+	}
+	// only the paths of this site are allowed
+	redirect := req.Redirect
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") ||
+		strings.HasPrefix(redirect, "/\\") {
+		redirect = a.webPath + "/"
+	}
+	authURL, binding, e := a.oidc.AuthURL(c.Request.Context(), redirect)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	// binds the login to this browser, so the callback can not be completed in another browser
+	a.setOIDCBinding(c, binding, int(oidcStateValidity.Seconds()))
+	SetResult(c, types.M{"url": authURL})
+}
+
+func (a *authRoute) oidcCallback(c *gin.Context) {
+	if errCode := c.Query("error"); errCode != "" {
+		_ = c.Error(err.NewUnauthorizedError(
+			i18n.T("api.auth.oidc_login_failed", errCode+": "+c.Query("error_description"))))
+		return
+	}
+	binding, _ := c.Cookie(oidcBindingCookie)
+	loginCode, redirect, e := a.oidc.Callback(c.Request.Context(), c.Query("state"), c.Query("code"), binding)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	u, e := url.Parse(redirect)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	q := u.Query()
+	q.Set(oidcLoginCodeParam, loginCode)
+	u.RawQuery = q.Encode()
+	c.Redirect(http.StatusFound, u.String())
+}
+
+// oidcToken creates a new session of the user logged in by the login code,
+// the users are verified by the two-factor authentication as the password login
+func (a *authRoute) oidcToken(c *gin.Context) {
+	req := struct {
+		Code string `json:"code" binding:"required"`
+		OTP  string `json:"otp"`
+	}{}
+	if e := c.Bind(&req); e != nil {
 		_ = c.Error(e)
 		return
 	}
-	user, e := a.userAuth.AuthByUsernamePassword(user.Username, user.Password)
+	binding, _ := c.Cookie(oidcBindingCookie)
+	pending := false
+	user, e := a.oidc.Login(req.Code, binding, func(user types.User) (e error) {
+		pending, e = a.verifyTwoFactor(user, req.OTP)
+		return
+	})
 	if e != nil {
 		_ = c.Error(e)
 		return
 	}
-	e = UpdateSessionUser(c, a.tokenStore, user)
+	a.setOIDCBinding(c, "", -1)
+	token, e := a.tokenStore.Create(types.Session{User: user, TwoFactorPending: pending})
 	if e != nil {
 		_ = c.Error(e)
+		return
 	}
+	SetResult(c, token)
+}
+
+func (a *authRoute) setOIDCBinding(c *gin.Context, binding string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcBindingCookie, binding, maxAge, a.apiPath+"/auth/oidc", "", c.Request.TLS != nil, true)
 }
 
 func (a *authRoute) logout(c *gin.Context) {
-	_ = UpdateSessionUser(c, a.tokenStore, types.User{})
+	session := GetSession(c)
+	session.User = types.User{}
+	session.TwoFactorPending = false
+	_, _ = a.tokenStore.Update(GetToken(c), session)
 }
 
 func (a *authRoute) getUser(c *gin.Context) {
@@ -73,3 +229,192 @@ func (a *authRoute) getUser(c *gin.Context) {
 		SetResult(c, u)
 	}
 }
+
+func (a *authRoute) listTokens(c *gin.Context) {
+	s := GetSession(c)
+	if s.IsAnonymous() {
+		_ = c.Error(err.NewUnauthorizedError(i18n.T("api.auth.login_required")))
+		return
+	}
+	tokens, e := a.apiTokenDAO.ListTokens(s.User.Username)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	SetResult(c, tokens)
+}
+
+func (a *authRoute) createToken(c *gin.Context) {
+	s := GetSession(c)
+	if s.IsAnonymous() {
+		_ = c.Error(err.NewUnauthorizedError(i18n.T("api.auth.login_required")))
+		return
+	}
+	req := apiTokenRequest{}
+	if e := c.Bind(&req); e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if req.Name == "" || len(req.Name) > 64 {
+		_ = c.Error(err.NewBadRequestError(i18n.T("api.auth.invalid_token_name")))
+		return
+	}
+	if req.ExpiresAt > 0 && req.ExpiresAt < uint64(time.Now().UnixMilli()) {
+		_ = c.Error(err.NewBadRequestError(i18n.T("api.auth.invalid_expires_at")))
+		return
+	}
+	token, hash, e := newAPIToken()
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	t, e := a.apiTokenDAO.AddToken(types.APIToken{
+		Username:  s.User.Username,
+		Name:      req.Name,
+		Token:     hash,
+		Path:      utils.CleanPath(req.Path),
+		ReadOnly:  req.ReadOnly,
+		ExpiresAt: req.ExpiresAt,
+	})
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	SetResult(c, apiTokenCreated{APIToken: t, Token: token})
+}
+
+func (a *authRoute) deleteToken(c *gin.Context) {
+	s := GetSession(c)
+	if s.IsAnonymous() {
+		_ = c.Error(err.NewUnauthorizedError(i18n.T("api.auth.login_required")))
+		return
+	}
+	id := utils.ToUInt(c.Param("id"), 0)
+	if id == 0 {
+		_ = c.Error(err.NewBadRequestError(""))
+		return
+	}
+	if e := a.apiTokenDAO.DeleteToken(id, s.User.Username); e != nil {
+		_ = c.Error(e)
+	}
+}
+
+func (a *authRoute) getTwoFactor(c *gin.Context) {
+	s := GetSession(c)
+	if s.IsAnonymous() {
+		_ = c.Error(err.NewUnauthorizedError(i18n.T("api.auth.login_required")))
+		return
+	}
+	status, e := a.twoFactor.Status(s.User)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	SetResult(c, status)
+}
+
+func (a *authRoute) enrollTwoFactor(c *gin.Context) {
+	s := GetSession(c)
+	if s.IsAnonymous() {
+		_ = c.Error(err.NewUnauthorizedError(i18n.T("api.auth.login_required")))
+		return
+	}
+	secret, uri, e := a.twoFactor.Enroll(s.User.Username)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	SetResult(c, types.M{"secret": secret, "uri": uri})
+}
+
+func (a *authRoute) enableTwoFactor(c *gin.Context) {
+	s := GetSession(c)
+	if s.IsAnonymous() {
+		_ = c.Error(err.NewUnauthorizedError(i18n.T("api.auth.login_required")))
+		return
+	}
+	req := twoFactorRequest{}
+	if e := c.Bind(&req); e != nil {
+		_ = c.Error(e)
+		return
+	}
+	codes, e := a.twoFactor.Enable(s.User.Username, req.Code)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	// the current session has passed the two-factor authentication by enabling it
+	if s.TwoFactorPending {
+		s.TwoFactorPending = false
+		if _, e := a.tokenStore.Update(GetToken(c), s); e != nil {
+			_ = c.Error(e)
+			return
+		}
+	}
+	SetResult(c, types.M{"recoveryCodes": codes})
+}
+
+func (a *authRoute) disableTwoFactor(c *gin.Context) {
+	s := GetSession(c)
+	if s.IsAnonymous() {
+		_ = c.Error(err.NewUnauthorizedError(i18n.T("api.auth.login_required")))
+		return
+	}
+	req := twoFactorRequest{}
+	if e := c.Bind(&req); e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if a.twoFactor.IsRequired(s.User) {
+		_ = c.Error(err.NewNotAllowedMessageError(i18n.T("api.auth.two_factor_required_for_admin")))
+		return
+	}
+	if e := a.twoFactor.Disable(s.User.Username, req.Code); e != nil {
+		_ = c.Error(e)
+	}
+}
+
+func (a *authRoute) regenerateRecoveryCodes(c *gin.Context) {
+	s := GetSession(c)
+	if s.IsAnonymous() {
+		_ = c.Error(err.NewUnauthorizedError(i18n.T("api.auth.login_required")))
+		return
+	}
+	req := twoFactorRequest{}
+	if e := c.Bind(&req); e != nil {
+		_ = c.Error(e)
+		return
+	}
+	codes, e := a.twoFactor.RegenerateRecoveryCodes(s.User.Username, req.Code)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	SetResult(c, types.M{"recoveryCodes": codes})
+}
+
+type loginRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password"`
+	// OTP is the TOTP code or a recovery code, it's required if the user has enabled the two-factor authentication
+	OTP string `json:"otp"`
+}
+
+type twoFactorRequest struct {
+	// Code is the TOTP code or a recovery code
+	Code string `json:"code" binding:"required"`
+}
+
+type apiTokenRequest struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	ReadOnly bool   `json:"readOnly"`
+	// ExpiresAt is unix timestamp in milliseconds, 0 means never expires
+	ExpiresAt uint64 `json:"expiresAt"`
+}
+
+type apiTokenCreated struct {
+	types.APIToken
+	// Token is the plain text token, it's only returned when the token is created
+	Token string `json:"token"`
+}
@@ -62,10 +62,7 @@ func InitCommonRoutes(
 
 	// get task
 	authR.GET("/task/:id", func(c *gin.Context) {
-		t, e := runner.GetTask(c.Param("id"))
-		if e != nil && e == task.ErrorNotFound {
-			e = err.NewNotFoundMessageError(e.Error())
-		}
+		t, e := getSessionTask(c, runner)
 		if e != nil {
 			_ = c.Error(e)
 			return
@@ -75,7 +72,10 @@ func InitCommonRoutes(
 
 	// cancel and delete task
 	authR.DELETE("/task/:id", func(c *gin.Context) {
-		_, e := runner.StopTask(c.Param("id"))
+		t, e := getSessionTask(c, runner)
+		if e == nil {
+			_, e = runner.StopTask(t.Id)
+		}
 		if e != nil && e == task.ErrorNotFound {
 			e = err.NewNotFoundMessageError(e.Error())
 		}
@@ -84,12 +84,19 @@ func InitCommonRoutes(
 		}
 	})
 
-	authAdmin := authR.Group("/", AdminGroupRequired())
-
-	// get tasks
-	authAdmin.GET("/tasks", func(c *gin.Context) {
+	// get tasks, only admins can get the tasks of other users
+	authR.GET("/tasks", func(c *gin.Context) {
+		session := GetSession(c)
 		group := c.Query("group")
-		tasks, e := runner.GetTasks(group)
+		user := c.Query("user")
+		if !session.IsEffectiveAdmin() {
+			if session.IsAnonymous() {
+				SetResult(c, []task.Task{})
+				return
+			}
+			user = session.User.Username
+		}
+		tasks, e := runner.GetTasks(group, user)
 		if e != nil {
 			_ = c.Error(e)
 			return
@@ -99,3 +106,16 @@ func InitCommonRoutes(
 
 	return nil
 }
+
+// getSessionTask gets the task of the id param, the tasks of other users are not found unless the session is admin
+func getSessionTask(c *gin.Context, runner task.Runner) (task.Task, error) {
+	session := GetSession(c)
+	t, e := runner.GetTask(c.Param("id"))
+	if e == nil && t.User != session.User.Username && !session.IsEffectiveAdmin() {
+		e = task.ErrorNotFound
+	}
+	if e != nil && e == task.ErrorNotFound {
+		e = err.NewNotFoundMessageError(e.Error())
+	}
+	return t, e
+}
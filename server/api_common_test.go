@@ -0,0 +1,166 @@
+package server
+
+import (
+	err "go-drive/common/errors"
+	"go-drive/common/registry"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeRunner holds the tasks without running them
+type fakeRunner struct {
+	tasks []task.Task
+}
+
+func (f *fakeRunner) Execute(task.Runnable, ...task.Option) (task.Task, error) {
+	panic("not implemented")
+}
+
+func (f *fakeRunner) ExecuteAndWait(task.Runnable, time.Duration, ...task.Option) (task.Task, error) {
+	panic("not implemented")
+}
+
+func (f *fakeRunner) GetTask(id string) (task.Task, error) {
+	for _, t := range f.tasks {
+		if t.Id == id {
+			return t, nil
+		}
+	}
+	return task.Task{}, task.ErrorNotFound
+}
+
+func (f *fakeRunner) GetTasks(group, user string) ([]task.Task, error) {
+	result := make([]task.Task, 0)
+	for _, t := range f.tasks {
+		if (group == "" || t.Group == group) && (user == "" || t.User == user) {
+			result = append(result, t)
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeRunner) StopTask(id string) (task.Task, error) {
+	t, e := f.GetTask(id)
+	if e != nil {
+		return t, e
+	}
+	return t, f.RemoveTask(id)
+}
+
+func (f *fakeRunner) RemoveTask(id string) error {
+	for i, t := range f.tasks {
+		if t.Id == id {
+			f.tasks = append(f.tasks[:i], f.tasks[i+1:]...)
+			return nil
+		}
+	}
+	return task.ErrorNotFound
+}
+
+func (f *fakeRunner) Dispose() error {
+	return nil
+}
+
+// newCommonRouter creates the router of the common routes requested by the session,
+// the result of the request is passed to the result callback
+func newCommonRouter(t *testing.T, runner task.Runner, session types.Session,
+	result func(interface{}, []*gin.Error)) *gin.Engine {
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		SetSession(c, session)
+		c.Next()
+		v, _ := GetResult(c)
+		result(v, c.Errors)
+	})
+	if e := InitCommonRoutes(registry.NewComponentHolder(), r, nil, nil, runner); e != nil {
+		t.Fatal(e)
+	}
+	return r
+}
+
+func TestGetTasks(t *testing.T) {
+	runner := &fakeRunner{tasks: []task.Task{
+		{Id: "1", User: "admin"},
+		{Id: "2", User: "user"},
+	}}
+	admin := types.User{Username: "admin", Groups: []types.Group{{Name: types.AdminUserGroup}}}
+
+	cases := []struct {
+		name    string
+		session types.Session
+		tasks   int
+	}{
+		{"admin", types.Session{User: admin}, 2},
+		{"pending admin", types.Session{User: admin, TwoFactorPending: true}, 1},
+		{"restricted admin", types.Session{User: admin, Scope: &types.TokenScope{Path: "a"}}, 1},
+		{"user", types.Session{User: types.User{Username: "user"}}, 1},
+		{"anonymous", types.Session{}, 0},
+	}
+	for _, tc := range cases {
+		var tasks []task.Task
+		r := newCommonRouter(t, runner, tc.session, func(v interface{}, _ []*gin.Error) {
+			tasks, _ = v.([]task.Task)
+		})
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/tasks", nil))
+		if len(tasks) != tc.tasks {
+			t.Errorf("%s: expect %d tasks, but is %d", tc.name, tc.tasks, len(tasks))
+		}
+		for _, task := range tasks {
+			if tc.tasks < 2 && task.User != tc.session.User.Username {
+				t.Errorf("%s: unexpected task of user '%s'", tc.name, task.User)
+			}
+		}
+	}
+}
+
+func TestTaskOwner(t *testing.T) {
+	admin := types.User{Username: "admin", Groups: []types.Group{{Name: types.AdminUserGroup}}}
+	cases := []struct {
+		name    string
+		session types.Session
+		found   bool
+	}{
+		{"owner", types.Session{User: types.User{Username: "user"}}, true},
+		{"admin", types.Session{User: admin}, true},
+		{"other user", types.Session{User: types.User{Username: "other"}}, false},
+		{"pending admin", types.Session{User: admin, TwoFactorPending: true}, false},
+		{"anonymous", types.Session{}, false},
+	}
+	for _, tc := range cases {
+		for _, method := range []string{http.MethodGet, http.MethodDelete} {
+			runner := &fakeRunner{tasks: []task.Task{{Id: "1", User: "user"}}}
+			var result interface{}
+			var errors []*gin.Error
+			r := newCommonRouter(t, runner, tc.session, func(v interface{}, e []*gin.Error) {
+				result, errors = v, e
+			})
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/task/1", nil))
+
+			if !tc.found {
+				if len(errors) != 1 || !err.IsNotFoundError(errors[0].Err) {
+					t.Errorf("%s %s: expect not found, but is %v", tc.name, method, errors)
+				}
+				if len(runner.tasks) != 1 {
+					t.Errorf("%s %s: expect the task not to be deleted", tc.name, method)
+				}
+				continue
+			}
+			if len(errors) != 0 {
+				t.Errorf("%s %s: unexpected errors %v", tc.name, method, errors)
+			}
+			if method == http.MethodGet {
+				if task, ok := result.(task.Task); !ok || task.Id != "1" {
+					t.Errorf("%s: unexpected result %v", tc.name, result)
+				}
+			} else if len(runner.tasks) != 0 {
+				t.Errorf("%s: expect the task to be deleted", tc.name)
+			}
+		}
+	}
+}
@@ -1,13 +1,14 @@
 package server
 
 import (
-	"archive/zip"
 	"context"
 	"fmt"
 	"go-drive/common"
 	"go-drive/common/drive_util"
 	err "go-drive/common/errors"
+	"go-drive/common/event"
 	"go-drive/common/i18n"
+	"go-drive/common/registry"
 	"go-drive/common/task"
 	"go-drive/common/types"
 	"go-drive/common/utils"
@@ -15,6 +16,7 @@ import (
 	"go-drive/server/search"
 	"go-drive/server/thumbnail"
 	"go-drive/storage"
+	"log"
 	"net/http"
 	"net/url"
 	"os"
@@ -23,36 +25,60 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 const (
 	maxProxySizeKey = "proxy.maxSize"
 	maxZipSizeKey   = "zip.maxSize"
+
+	extractMaxSizeKey  = "extract.maxSize"
+	extractMaxFilesKey = "extract.maxFiles"
+	extractMaxRatioKey = "extract.maxRatio"
+
+	defaultExtractMaxSize  = 10 * 1024 * 1024 * 1024
+	defaultExtractMaxFiles = 100000
+	defaultExtractMaxRatio = 200
 )
 
 func InitDriveRoutes(
 	router gin.IRouter,
+	ch *registry.ComponentsHolder,
 	access *drive.Access,
+	versions *drive.Versions,
 	searcher *search.Service,
 	config common.Config,
 	thumbnail *thumbnail.Maker,
 	signer *utils.Signer,
 	chunkUploader *ChunkUploader,
+	hasher *Hasher,
 	runner task.Runner,
 	tokenStore types.TokenStore,
 	userDAO *storage.UserDAO,
-	optionsDAO *storage.OptionsDAO) error {
+	optionsDAO *storage.OptionsDAO,
+	checkpointDAO *storage.CopyCheckpointDAO,
+	bus event.Bus) error {
 
 	dr := driveRoute{
 		config:        config,
 		access:        access,
+		versions:      versions,
 		searcher:      searcher,
 		chunkUploader: chunkUploader,
+		hasher:        hasher,
 		thumbnail:     thumbnail,
 		runner:        runner,
 		signer:        signer,
 		options:       optionsDAO,
+		checkpointDAO: checkpointDAO,
+		bus:           bus,
+	}
+
+	// the checkpoints of the tasks which are too old to be resumed
+	if e := purgeCopyCheckpoints(checkpointDAO); e != nil {
+		return e
 	}
+	ch.Add("copyCheckpointPurger", newCopyCheckpointPurger(checkpointDAO))
 
 	scriptsDir, _ := config.GetDir(config.DriveUploadersDir, false)
 	router.Group("/", func(c *gin.Context) {
@@ -60,7 +86,7 @@ func InitDriveRoutes(
 		c.Next()
 	}).Static("/drive-uploader", scriptsDir)
 
-	signatureAuthRoute := router.Group("/", SignatureAuth(signer, userDAO, false))
+	signatureAuthRoute := router.Group("/", SignatureAuth(signer, userDAO, tokenStore, false))
 
 	// get file content
 	signatureAuthRoute.HEAD("/content/*path", dr.getContent)
@@ -70,10 +96,12 @@ func InitDriveRoutes(
 	tokenAuth := TokenAuth(tokenStore)
 	r := router.Group("/", tokenAuth)
 
-	router.POST("/zip", TokenAuthWithPostParams(tokenStore), dr.zipDownload)
+	// download entries as an archive, /zip is kept for compatibility
+	router.POST("/archive", TokenAuthWithPostParams(tokenStore), dr.archiveDownload)
+	router.POST("/zip", TokenAuthWithPostParams(tokenStore), dr.archiveDownload)
 
 	// list entries/drives
-	router.GET("/entries/*path", SignatureAuth(signer, userDAO, true), tokenAuth, dr.list)
+	router.GET("/entries/*path", SignatureAuth(signer, userDAO, tokenStore, true), tokenAuth, dr.list)
 	// get entry info
 	r.GET("/entry/*path", dr.get)
 	// mkdir
@@ -82,6 +110,8 @@ func InitDriveRoutes(
 	r.POST("/copy", dr.copyEntry)
 	// move file
 	r.POST("/move", dr.move)
+	// extract archive
+	r.POST("/extract", dr.extract)
 	// deleteEntry entry
 	r.DELETE("/entry/*path", dr.deleteEntry)
 	// get upload config
@@ -96,8 +126,16 @@ func InitDriveRoutes(
 	r.POST("/chunk-content/*path", dr.chunkUploadComplete)
 	// delete chunk upload
 	r.DELETE("/chunk/:id", dr.deleteChunkUpload)
+	// get the hash of file content
+	r.GET("/hash/*path", dr.hash)
 	// search
 	r.GET("/search/*path", dr.search)
+	// resume copy or move task
+	r.POST("/task/:id/resume", dr.resumeTask)
+	// list versions of file
+	r.GET("/versions/*path", dr.listVersions)
+	// restore file to the version
+	r.POST("/version-restore/*path", dr.restoreVersion)
 
 	return nil
 }
@@ -106,14 +144,19 @@ type driveRoute struct {
 	config common.Config
 
 	access   *drive.Access
+	versions *drive.Versions
 	searcher *search.Service
 
+	checkpointDAO *storage.CopyCheckpointDAO
+
 	chunkUploader *ChunkUploader
+	hasher        *Hasher
 	thumbnail     *thumbnail.Maker
 	runner        task.Runner
 	signer        *utils.Signer
 
 	options *storage.OptionsDAO
+	bus     event.Bus
 }
 
 func (dr *driveRoute) getDrive(c *gin.Context) (types.IDrive, error) {
@@ -140,10 +183,11 @@ func (dr *driveRoute) list(c *gin.Context) {
 		return
 	}
 	session := GetSession(c)
+	ip := SignatureIP(c, dr.config)
 	res := make([]entryJson, 0, len(entries)+1)
-	res = append(res, *dr.newEntryJson(entry, session))
+	res = append(res, *dr.newEntryJson(entry, session, ip))
 	for _, v := range entries {
-		res = append(res, *dr.newEntryJson(v, session))
+		res = append(res, *dr.newEntryJson(v, session, ip))
 	}
 	SetResult(c, res)
 }
@@ -160,7 +204,7 @@ func (dr *driveRoute) get(c *gin.Context) {
 		_ = c.Error(e)
 		return
 	}
-	SetResult(c, dr.newEntryJson(entry, GetSession(c)))
+	SetResult(c, dr.newEntryJson(entry, GetSession(c), SignatureIP(c, dr.config)))
 }
 
 func (dr *driveRoute) makeDir(c *gin.Context) {
@@ -175,10 +219,18 @@ func (dr *driveRoute) makeDir(c *gin.Context) {
 		_ = c.Error(e)
 		return
 	}
-	SetResult(c, dr.newEntryJson(entry, GetSession(c)))
+	SetResult(c, dr.newEntryJson(entry, GetSession(c), SignatureIP(c, dr.config)))
 }
 
 func (dr *driveRoute) copyEntry(c *gin.Context) {
+	dr.copyOrMove(c, false)
+}
+
+func (dr *driveRoute) move(c *gin.Context) {
+	dr.copyOrMove(c, true)
+}
+
+func (dr *driveRoute) copyOrMove(c *gin.Context, move bool) {
 	drive_, e := dr.getDrive(c)
 	if e != nil {
 		_ = c.Error(e)
@@ -195,50 +247,154 @@ func (dr *driveRoute) copyEntry(c *gin.Context) {
 		_ = c.Error(e)
 		return
 	}
+	cp := types.CopyCheckpoint{
+		ID:        strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Username:  GetSession(c).User.Username,
+		From:      from,
+		To:        to,
+		Override:  utils.ToBool(c.Query("override")),
+		Move:      move,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+	if e := dr.checkpointDAO.AddCheckpoint(cp); e != nil {
+		_ = c.Error(e)
+		return
+	}
+	t, e := dr.executeCopyOrMove(c, drive_, fromEntry, cp, false)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	SetResult(c, t)
+}
+
+// resumeTask resumes the failed, canceled or interrupted copy or move task
+func (dr *driveRoute) resumeTask(c *gin.Context) {
+	id := c.Param("id")
+	t, e := dr.runner.GetTask(id)
+	if e == task.ErrorNotFound {
+		e = err.NewNotFoundMessageError(e.Error())
+	}
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if !t.Finished() || t.Status == task.Done {
+		_ = c.Error(err.NewNotAllowedMessageError(i18n.T("api.drive.task_not_resumable")))
+		return
+	}
+	cp, e := dr.checkpointDAO.GetCheckpointByTask(id)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if cp.Username != GetSession(c).User.Username {
+		_ = c.Error(err.NewNotFoundMessageError(i18n.T("storage.copy_checkpoints.checkpoint_not_exists", id)))
+		return
+	}
+	drive_, e := dr.getDrive(c)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	fromEntry, e := drive_.Get(c.Request.Context(), cp.From)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	t, e = dr.executeCopyOrMove(c, drive_, fromEntry, cp, true)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	SetResult(c, t)
+}
+
+// executeCopyOrMove runs the task with the checkpoint, the checkpoint is deleted after the task succeeded
+func (dr *driveRoute) executeCopyOrMove(c *gin.Context, d types.IDrive, from types.IEntry,
+	cp types.CopyCheckpoint, resuming bool) (task.Task, error) {
 	session := GetSession(c)
-	override := utils.ToBool(c.Query("override"))
+	ip := SignatureIP(c, dr.config)
+	group := "drive/copy"
+	if cp.Move {
+		group = "drive/move"
+	}
 	t, e := dr.runner.ExecuteAndWait(func(ctx types.TaskCtx) (interface{}, error) {
-		r, e := drive_.Copy(ctx, fromEntry, to, override)
+		var r types.IEntry
+		var e error
+		if cp.Move {
+			r, e = d.Move(ctx, from, cp.To, cp.Override)
+			if err.IsUnsupportedError(e) {
+				// moving across drives, copy with the checkpoint and then delete the source
+				r, e = dr.checkpointedCopy(ctx, d, from, cp, resuming)
+				if e == nil {
+					e = d.Delete(ctx, from.Path())
+				}
+			}
+		} else {
+			r, e = dr.checkpointedCopy(ctx, d, from, cp, resuming)
+		}
 		if e != nil {
 			return nil, e
 		}
-		return dr.newEntryJson(r, session), nil
-	}, 2*time.Second, task.WithNameGroup(from+" -> "+to, "drive/copy"))
+		if e := dr.checkpointDAO.DeleteCheckpoint(cp.ID); e != nil {
+			log.Printf("failed to delete checkpoint %s: %v", cp.ID, e)
+		}
+		return dr.newEntryJson(r, session, ip), nil
+	}, 2*time.Second, task.WithNameGroup(cp.From+" -> "+cp.To, group), TaskUser(c))
+	if e != nil {
+		return t, e
+	}
+	return t, dr.checkpointDAO.SetCheckpointTask(cp.ID, t.Id)
+}
 
+func (dr *driveRoute) checkpointedCopy(ctx types.TaskCtx, d types.IDrive, from types.IEntry,
+	cp types.CopyCheckpoint, resuming bool) (types.IEntry, error) {
+	checkpoint, e := newCopyCheckpoint(dr.checkpointDAO, cp.ID, resuming)
 	if e != nil {
-		_ = c.Error(e)
-		return
+		return nil, e
 	}
-	SetResult(c, t)
+	return d.Copy(drive_util.WithCopyCheckpoint(ctx, checkpoint), from, cp.To, cp.Override)
 }
 
-func (dr *driveRoute) move(c *gin.Context) {
+// extract extracts the archive file into the directory
+func (dr *driveRoute) extract(c *gin.Context) {
 	drive_, e := dr.getDrive(c)
 	if e != nil {
 		_ = c.Error(e)
 		return
 	}
 	from := utils.CleanPath(c.Query("from"))
+	to := utils.CleanPath(c.Query("to"))
+	override := utils.ToBool(c.Query("override"))
 	fromEntry, e := drive_.Get(c.Request.Context(), from)
 	if e != nil {
 		_ = c.Error(e)
 		return
 	}
-	to := utils.CleanPath(c.Query("to"))
-	if e := checkCopyOrMove(from, to); e != nil {
+	format := drive_util.GetArchiveFormat(fromEntry.Name())
+	if !fromEntry.Type().IsFile() || format == "" {
+		_ = c.Error(err.NewNotAllowedMessageError(i18n.T("api.extract.unsupported_format")))
+		return
+	}
+	limits, e := dr.getExtractLimits()
+	if e != nil {
 		_ = c.Error(e)
 		return
 	}
 	session := GetSession(c)
-	override := utils.ToBool(c.Query("override"))
+	ip := SignatureIP(c, dr.config)
 	t, e := dr.runner.ExecuteAndWait(func(ctx types.TaskCtx) (interface{}, error) {
-		r, e := drive_.Move(ctx, fromEntry, to, override)
+		if e := drive_util.ExtractArchive(ctx, fromEntry, format, drive_, to,
+			override, limits, dr.config.TempDir); e != nil {
+			return nil, e
+		}
+		r, e := drive_.Get(ctx, to)
 		if e != nil {
 			return nil, e
 		}
-		return dr.newEntryJson(r, session), nil
-	}, 2*time.Second, task.WithNameGroup(from+" -> "+to, "drive/move"))
-
+		return dr.newEntryJson(r, session, ip), nil
+	}, 2*time.Second, task.WithNameGroup(from+" -> "+to, "drive/extract"), TaskUser(c))
 	if e != nil {
 		_ = c.Error(e)
 		return
@@ -246,6 +402,19 @@ func (dr *driveRoute) move(c *gin.Context) {
 	SetResult(c, t)
 }
 
+func (dr *driveRoute) getExtractLimits() (drive_util.ExtractLimits, error) {
+	values, e := dr.options.Gets(extractMaxSizeKey, extractMaxFilesKey, extractMaxRatioKey)
+	if e != nil {
+		return drive_util.ExtractLimits{}, e
+	}
+	limits := drive_util.ExtractLimits{
+		MaxSize:  types.SV(values[extractMaxSizeKey]).DataSize(defaultExtractMaxSize),
+		MaxFiles: types.SV(values[extractMaxFilesKey]).Int(defaultExtractMaxFiles),
+		MaxRatio: types.SV(values[extractMaxRatioKey]).Float64(defaultExtractMaxRatio),
+	}
+	return limits, nil
+}
+
 func checkCopyOrMove(from, to string) error {
 	if from == to {
 		return err.NewNotAllowedMessageError(i18n.T("api.drive.copy_to_same_path_not_allowed"))
@@ -265,7 +434,7 @@ func (dr *driveRoute) deleteEntry(c *gin.Context) {
 	}
 	t, e := dr.runner.ExecuteAndWait(func(ctx types.TaskCtx) (interface{}, error) {
 		return nil, d.Delete(ctx, path)
-	}, 2*time.Second, task.WithNameGroup(path, "drive/delete"))
+	}, 2*time.Second, task.WithNameGroup(path, "drive/delete"), TaskUser(c))
 	if e != nil {
 		_ = c.Error(e)
 		return
@@ -309,25 +478,75 @@ func (dr *driveRoute) getContent(c *gin.Context) {
 		_ = c.Error(e)
 		return
 	}
+	if versionId := c.Query("version"); versionId != "" {
+		file, e = dr.getVersionEntry(c, file, path, versionId)
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+	}
 	useProxy := utils.ToBool(c.Query("proxy"))
 	proxyMaxSize := dr.options.GetValue(maxProxySizeKey).DataSize(-1)
 
 	if proxyMaxSize > 0 && file.Size() > proxyMaxSize {
 		useProxy = false
 	}
-	if e := drive_util.DownloadIContent(c.Request.Context(), file, c.Writer, c.Request, useProxy); e != nil {
+	e = drive_util.DownloadIContent(c.Request.Context(), file, c.Writer, c.Request, useProxy)
+	dr.downloaded(c, path, e)
+	if e != nil {
 		_ = c.Error(e)
 		return
 	}
 }
 
-func (dr *driveRoute) zipDownload(c *gin.Context) {
+// downloaded publishes the download operation of the path in the drive of current user
+func (dr *driveRoute) downloaded(c *gin.Context, path string, e error) {
+	if !isDownloadRequest(c.Request) {
+		return
+	}
+	rootPath, pe := dr.getRootPath(c, path)
+	if pe != nil {
+		return
+	}
+	publishDownload(dr.bus, GetSession(c), rootPath, e)
+}
+
+// isDownloadRequest reports whether the request reads the file from the beginning,
+// the partial requests not starting from the beginning are ignored, they are usually from the media players.
+func isDownloadRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	rg := r.Header.Get("Range")
+	return rg == "" || strings.HasPrefix(rg, "bytes=0-")
+}
+
+// publishDownload publishes the download operation of the path in the root drive
+func publishDownload(bus event.Bus, session types.Session, rootPath string, e error) {
+	bus.Publish(event.EntryOperated, types.DriveListenerContext{Session: &session},
+		types.EntryOperation{Action: types.EntryOpDownload, Path: rootPath, Error: e})
+}
+
+var archiveDownloadTypes = map[string][2]string{
+	drive_util.ArchiveZip:      {"application/zip", ".zip"},
+	drive_util.ArchiveZipStore: {"application/zip", ".zip"},
+	drive_util.ArchiveTar:      {"application/x-tar", ".tar"},
+	drive_util.ArchiveTarGz:    {"application/gzip", ".tar.gz"},
+}
+
+func (dr *driveRoute) archiveDownload(c *gin.Context) {
 	files := utils.SplitLines(c.PostForm("files"))
 	if len(files) == 0 {
 		_ = c.Error(err.NewBadRequestError(""))
 		return
 	}
 	prefix := c.PostForm("prefix")
+	format := c.DefaultPostForm("format", drive_util.ArchiveZip)
+	archiveType, ok := archiveDownloadTypes[format]
+	if !ok {
+		_ = c.Error(err.NewBadRequestError(i18n.T("api.extract.unsupported_format")))
+		return
+	}
 
 	drive, e := dr.getDrive(c)
 	if e != nil {
@@ -351,13 +570,20 @@ func (dr *driveRoute) zipDownload(c *gin.Context) {
 
 	ctx := task.NewTaskContext(c.Request.Context())
 
-	entriesTrees := make([]drive_util.EntryTreeNode, 0, len(entries))
+	items := make([]drive_util.ArchiveItem, 0)
 	for _, entry := range entries {
 		rootNode, e := drive_util.BuildEntriesTree(ctx, entry, true)
 		if e != nil {
 			return
 		}
-		entriesTrees = append(entriesTrees, rootNode)
+		_ = drive_util.VisitEntriesTree(rootNode, func(entry types.IEntry) error {
+			name := entry.Path()
+			if prefix != "" && strings.HasPrefix(name, prefix+"/") {
+				name = strings.TrimPrefix(name, prefix+"/")
+			}
+			items = append(items, drive_util.ArchiveItem{Name: name, Entry: entry})
+			return nil
+		})
 	}
 
 	totalSize := ctx.GetTotal()
@@ -368,42 +594,25 @@ func (dr *driveRoute) zipDownload(c *gin.Context) {
 		return
 	}
 
-	c.Writer.Header().Set("Content-Type", "application/zip")
+	// the exact size of the uncompressed archive lets the browser show the progress
+	size, e := drive_util.GetArchiveSize(format, items)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if size >= 0 {
+		c.Writer.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	c.Writer.Header().Set("Content-Type", archiveType[0])
 	c.Writer.Header().Set("Content-Disposition",
 		"attachment; filename=\""+
-			url.QueryEscape(fmt.Sprintf("packaged_%d.zip", len(files)))+"\"")
-
-	zipFile := zip.NewWriter(c.Writer)
-	defer func() {
-		_ = zipFile.Close()
-	}()
-
-	for _, node := range entriesTrees {
-		if e := drive_util.VisitEntriesTree(node, func(entry types.IEntry) error {
-			if e := ctx.Err(); e != nil {
-				return e
-			}
-			name := entry.Path()
-			if entry.Type().IsDir() {
-				name += "/"
-			}
-
-			if prefix != "" && strings.HasPrefix(name, prefix+"/") {
-				name = strings.TrimPrefix(name, prefix+"/")
-			}
+			url.QueryEscape(fmt.Sprintf("packaged_%d%s", len(files), archiveType[1]))+"\"")
 
-			file, e := zipFile.Create(name)
-			if e != nil {
-				return e
-			}
-			if entry.Type().IsFile() {
-				if e := drive_util.CopyIContent(task.NewContextWrapper(c.Request.Context()), entry, file); e != nil {
-					return e
-				}
-			}
-			return nil
-		}); e != nil {
-			return
+	e = drive_util.WriteArchive(task.NewContextWrapper(c.Request.Context()), c.Writer, format, items)
+	session := GetSession(c)
+	for _, entry := range entries {
+		if rootPath, pe := dr.getRootPath(c, entry.Path()); pe == nil {
+			publishDownload(dr.bus, session, rootPath, e)
 		}
 	}
 }
@@ -420,6 +629,11 @@ func (dr *driveRoute) getThumbnail(c *gin.Context) {
 		_ = c.Error(e)
 		return
 	}
+	// the thumbnail may be cached or provided by the entry without reading the content
+	if e := drive.RequirePermission(entry, types.PermissionDownload); e != nil {
+		_ = c.Error(e)
+		return
+	}
 	if entry.Meta().Props != nil && entry.Meta().Thumbnail != "" {
 		c.Redirect(http.StatusFound, entry.Meta().Thumbnail)
 		return
@@ -447,6 +661,7 @@ func (dr *driveRoute) writeContent(c *gin.Context) {
 		return
 	}
 	session := GetSession(c)
+	ip := SignatureIP(c, dr.config)
 	override := utils.ToBool(c.Query("override"))
 	size := utils.ToInt64(c.GetHeader("Content-Length"), -1)
 	defer func() { _ = c.Request.Body.Close() }()
@@ -478,8 +693,88 @@ func (dr *driveRoute) writeContent(c *gin.Context) {
 		if e != nil {
 			return nil, e
 		}
-		return dr.newEntryJson(r, session), nil
-	}, 2*time.Second, task.WithNameGroup(path, "drive/write"))
+		return dr.newEntryJson(r, session, ip), nil
+	}, 2*time.Second, task.WithNameGroup(path, "drive/write"), TaskUser(c))
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	SetResult(c, t)
+}
+
+func (dr *driveRoute) listVersions(c *gin.Context) {
+	path := utils.CleanPath(c.Param("path"))
+	d, e := dr.getDrive(c)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	// check if the content of the file is accessible
+	file, e := d.Get(c.Request.Context(), path)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if e := drive.RequirePermission(file, types.PermissionDownload); e != nil {
+		_ = c.Error(e)
+		return
+	}
+	rootPath, e := dr.getRootPath(c, path)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	versions, e := dr.versions.ListVersions(rootPath)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	session := GetSession(c)
+	ip := SignatureIP(c, dr.config)
+	result := make([]versionJson, len(versions))
+	for i, v := range versions {
+		v.Path = path
+		result[i] = versionJson{
+			FileVersion: v,
+			AccessKey: MakeSignature(dr.signer, http.MethodGet, versionSignaturePath(path, v.ID),
+				session.User.Username, ip, dr.config.SignatureTTL),
+		}
+	}
+	SetResult(c, result)
+}
+
+func (dr *driveRoute) restoreVersion(c *gin.Context) {
+	path := utils.CleanPath(c.Param("path"))
+	d, e := dr.getDrive(c)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	file, e := d.Get(c.Request.Context(), path)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	version, e := dr.getVersionEntry(c, file, path, c.Query("version"))
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	session := GetSession(c)
+	ip := SignatureIP(c, dr.config)
+	t, e := dr.runner.ExecuteAndWait(func(ctx types.TaskCtx) (interface{}, error) {
+		reader, e := version.GetReader(ctx, -1, -1)
+		if e != nil {
+			return nil, e
+		}
+		defer func() { _ = reader.Close() }()
+		// the current content will be kept as a new version
+		r, e := d.Save(ctx, path, version.Size(), true, reader)
+		if e != nil {
+			return nil, e
+		}
+		return dr.newEntryJson(r, session, ip), nil
+	}, 2*time.Second, task.WithNameGroup(path, "drive/version-restore"), TaskUser(c))
 	if e != nil {
 		_ = c.Error(e)
 		return
@@ -487,6 +782,36 @@ func (dr *driveRoute) writeContent(c *gin.Context) {
 	SetResult(c, t)
 }
 
+// getVersionEntry gets the entry of the version which belongs to the file at path,
+// the content of the versions is readable only if the current file is downloadable
+func (dr *driveRoute) getVersionEntry(c *gin.Context, file types.IEntry,
+	path, versionId string) (types.IEntry, error) {
+	if e := drive.RequirePermission(file, types.PermissionDownload); e != nil {
+		return nil, e
+	}
+	rootPath, e := dr.getRootPath(c, path)
+	if e != nil {
+		return nil, e
+	}
+	version, e := dr.versions.GetVersion(versionId)
+	if e != nil {
+		return nil, e
+	}
+	if version.Path != rootPath {
+		return nil, err.NewNotFoundMessageError(i18n.T("storage.file_versions.version_not_exists", versionId))
+	}
+	return dr.versions.GetVersionEntry(c.Request.Context(), version)
+}
+
+// getRootPath converts the path of the current user to the path in root drive
+func (dr *driveRoute) getRootPath(c *gin.Context, path string) (string, error) {
+	chroot, e := dr.access.GetChroot(GetSession(c))
+	if e != nil || chroot == nil {
+		return path, e
+	}
+	return chroot.WrapPath(path)
+}
+
 func (dr *driveRoute) chunkUploadRequest(c *gin.Context) {
 	size := utils.ToInt64(c.Query("size"), -1)
 	chunkSize := utils.ToInt64(c.Query("chunkSize"), -1)
@@ -521,11 +846,12 @@ func (dr *driveRoute) chunkUploadComplete(c *gin.Context) {
 		return
 	}
 	session := GetSession(c)
+	ip := SignatureIP(c, dr.config)
 	override := utils.ToBool(c.Query("override"))
 	path := utils.CleanPath(c.Param("path"))
 	id := c.Query("id")
 	t, e := dr.runner.ExecuteAndWait(func(ctx types.TaskCtx) (interface{}, error) {
-		file, e := dr.chunkUploader.CompleteUpload(id, ctx)
+		file, hashes, e := dr.chunkUploader.CompleteUpload(id, ctx)
 		if e != nil {
 			return nil, e
 		}
@@ -543,8 +869,11 @@ func (dr *driveRoute) chunkUploadComplete(c *gin.Context) {
 		}
 		_ = tempFile.Close()
 		_ = dr.chunkUploader.DeleteUpload(id)
-		return dr.newEntryJson(entry, session), nil
-	}, 2*time.Second, task.WithNameGroup(path, "drive/chunk-merge"))
+		if e := drive_util.VerifySavedHash(ctx, d, path, nil, hashes, entry); e != nil {
+			return nil, e
+		}
+		return dr.newEntryJson(entry, session, ip), nil
+	}, 2*time.Second, task.WithNameGroup(path, "drive/chunk-merge"), TaskUser(c))
 	if e != nil {
 		_ = c.Error(e)
 		return
@@ -559,6 +888,40 @@ func (dr *driveRoute) deleteChunkUpload(c *gin.Context) {
 	}
 }
 
+// hash gets the hash of the file, the algorithm is specified by query parameter 'algorithm'
+func (dr *driveRoute) hash(c *gin.Context) {
+	path := utils.CleanPath(c.Param("path"))
+	algorithm := c.Query("algorithm")
+	d, e := dr.getDrive(c)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	entry, e := d.Get(c.Request.Context(), path)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if !entry.Type().IsFile() {
+		_ = c.Error(err.NewNotAllowedMessageError(i18n.T("api.drive.hash_dir_not_allowed")))
+		return
+	}
+	// the hash may be cached or provided by the entry without reading the content
+	if e := drive.RequirePermission(entry, types.PermissionDownload); e != nil {
+		_ = c.Error(e)
+		return
+	}
+	t, e := dr.runner.ExecuteAndWait(func(ctx types.TaskCtx) (interface{}, error) {
+		ctx.Total(entry.Size(), true)
+		return dr.hasher.Hash(ctx, entry, algorithm)
+	}, 2*time.Second, task.WithNameGroup(path, "drive/hash"), TaskUser(c))
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	SetResult(c, t)
+}
+
 func (dr *driveRoute) search(c *gin.Context) {
 	root := utils.CleanPath(c.Param("path"))
 	query := c.Query("q")
@@ -601,23 +964,23 @@ func (dr *driveRoute) search(c *gin.Context) {
 	SetResult(c, r)
 }
 
-func (dr *driveRoute) newEntryJson(e types.IEntry, s types.Session) *entryJson {
+func (dr *driveRoute) newEntryJson(e types.IEntry, s types.Session, ip string) *entryJson {
 	entryMeta := e.Meta()
 	meta := utils.MapCopy(entryMeta.Props, nil)
 	meta["writable"] = entryMeta.Writable
 	if entryMeta.Thumbnail != "" {
 		meta["thumbnail"] = entryMeta.Thumbnail
 	}
-	if entryMeta.Thumbnail == "" {
+	if entryMeta.Thumbnail == "" && drive.RequirePermission(e, types.PermissionDownload) == nil {
 		// thumbnail is true
 		// so the thumbnail is generated by the entry self
 		if te := thumbnail.GetWrappedThumbnailEntry(e); te != nil {
 			meta["thumbnail"] = true
 		}
 	}
-	meta["accessKey"] = MakeSignature(dr.signer, e.Path(), s.User.Username, dr.config.SignatureTTL)
+	meta["accessKey"] = MakeSignature(dr.signer, http.MethodGet, e.Path(), s.User.Username, ip, dr.config.SignatureTTL)
 
-	if !s.HasUserGroup(types.AdminUserGroup) {
+	if !s.IsEffectiveAdmin() {
 		delete(meta, "mountAt")
 	}
 	return &entryJson{
@@ -643,6 +1006,12 @@ type entryJson struct {
 	ModTime int64           `json:"modTime"`
 }
 
+// versionJson is the version with the access key to read its content
+type versionJson struct {
+	types.FileVersion
+	AccessKey string `json:"accessKey"`
+}
+
 type uploadConfig struct {
 	Provider string      `json:"provider"`
 	Path     string      `json:"path,omitempty"`
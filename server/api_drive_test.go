@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"go-drive/common/drive_util"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/drive"
+	"go-drive/drive/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestVersionRequiresDownload(t *testing.T) {
+	config, _, _ := newTestDB(t)
+	config.FreeFs = true
+	root := t.TempDir()
+	if e := os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0644); e != nil {
+		t.Fatal(e)
+	}
+	fsDrive, e := fs.NewDrive(context.Background(), types.SM{"path": root}, drive_util.DriveUtils{Config: config})
+	if e != nil {
+		t.Fatal(e)
+	}
+	rootPath := ""
+	newEntry := func(p types.Permission) types.IEntry {
+		pm := utils.NewPermMap([]types.PathPermission{{
+			Path: &rootPath, Subject: types.UserSubject("user"), Permission: p, Policy: types.PolicyAccept,
+		}})
+		entry, e := drive.NewPermissionWrapperDrive(fsDrive, pm).Get(context.Background(), "a.txt")
+		if e != nil {
+			t.Fatal(e)
+		}
+		return entry
+	}
+
+	dr := &driveRoute{}
+	c, _ := newTestContext(http.MethodGet, "/content/a.txt?version=v1", "10.0.0.1")
+	// the user who can list the file but cannot download it is rejected before looking up the version
+	if _, e := dr.getVersionEntry(c, newEntry(types.PermissionList), "a.txt", "v1"); e == nil {
+		t.Error("expect the version of the file without download permission to be rejected")
+	}
+	if e := drive.RequirePermission(newEntry(types.PermissionRead), types.PermissionDownload); e != nil {
+		t.Errorf("expect the file with read permission to be downloadable, but is %v", e)
+	}
+}
+
+func TestVersionSignature(t *testing.T) {
+	signer := utils.NewSigner()
+	auth := SignatureAuth(signer, nil, nil, false)
+	// request reports whether the signature is accepted
+	request := func(target string) bool {
+		c, _ := newTestContext(http.MethodGet, target, "10.0.0.1")
+		c.Params = gin.Params{{Key: "path", Value: "/a.txt"}}
+		auth(c)
+		return !c.IsAborted()
+	}
+
+	current := MakeSignature(signer, http.MethodGet, "a.txt", "", "", time.Minute)
+	v1 := MakeSignature(signer, http.MethodGet, versionSignaturePath("a.txt", "v1"), "", "", time.Minute)
+
+	if !request("/content/a.txt?_k=" + current) {
+		t.Error("expect the current content to be readable")
+	}
+	if !request("/content/a.txt?version=v1&_k=" + v1) {
+		t.Error("expect the version to be readable")
+	}
+	if request("/content/a.txt?version=v1&_k=" + current) {
+		t.Error("expect the signature of the current content to be rejected for the version")
+	}
+	if request("/content/a.txt?version=v2&_k=" + v1) {
+		t.Error("expect the signature of the version to be rejected for another version")
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"go-drive/common"
+	"go-drive/common/event"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/drive"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	eventEntryUpdated = "updated"
+	eventEntryDeleted = "deleted"
+
+	eventClientBufferSize = 64
+	eventPingInterval     = 30 * time.Second
+)
+
+// InitEventRoutes initializes the server-sent events stream,
+// which pushes the changes of the subscribed paths and the progress of the current user's tasks.
+func InitEventRoutes(
+	router gin.IRouter,
+	access *drive.Access,
+	tokenStore types.TokenStore,
+	bus event.Bus) error {
+
+	hub := &eventHub{access: access, clients: make(map[*eventClient]struct{}), mux: &sync.RWMutex{}}
+	bus.Subscribe(event.EntryUpdated, hub.onEntryUpdated)
+	bus.Subscribe(event.EntryDeleted, hub.onEntryDeleted)
+	bus.Subscribe(event.TaskUpdated, hub.onTaskUpdated)
+
+	// EventSource can not set headers, so the token can be passed by query
+	r := router.Group("/", tokenAuth(tokenStore, func(c *gin.Context) string {
+		if t := c.Query(common.ParamAuth); t != "" {
+			return t
+		}
+		return c.GetHeader(common.HeaderAuth)
+	}))
+
+	// subscribe to the changes of paths
+	r.GET("/events", hub.serve)
+
+	return nil
+}
+
+type entryEvent struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+}
+
+type eventMessage struct {
+	name string
+	data interface{}
+}
+
+type eventClient struct {
+	session types.Session
+	chroot  *drive.Chroot
+	// scope is the permissions of the API token scope, nil if the session is not restricted
+	scope utils.PermMap
+	// paths are the subscribed paths in root drive
+	paths    []string
+	messages chan eventMessage
+}
+
+type eventHub struct {
+	access  *drive.Access
+	clients map[*eventClient]struct{}
+	mux     *sync.RWMutex
+}
+
+func (h *eventHub) serve(c *gin.Context) {
+	client, e := h.newClient(GetSession(c), c.QueryArray("path"))
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+
+	h.mux.Lock()
+	h.clients[client] = struct{}{}
+	h.mux.Unlock()
+	defer func() {
+		h.mux.Lock()
+		delete(h.clients, client)
+		h.mux.Unlock()
+	}()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	ping := time.NewTicker(eventPingInterval)
+	defer ping.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-ping.C:
+			c.SSEvent("ping", "")
+		case m := <-client.messages:
+			c.SSEvent(m.name, m.data)
+		}
+		return true
+	})
+}
+
+// newClient creates the client of the session subscribing to the paths in the drive of the session
+func (h *eventHub) newClient(session types.Session, paths []string) (*eventClient, error) {
+	chroot, e := h.access.GetChroot(session)
+	if e != nil {
+		return nil, e
+	}
+	client := &eventClient{
+		session:  session,
+		chroot:   chroot,
+		messages: make(chan eventMessage, eventClientBufferSize),
+	}
+	if session.IsRestricted() {
+		client.scope = drive.ScopePerms(session.Scope)
+	}
+	for _, p := range paths {
+		p = utils.CleanPath(p)
+		if chroot != nil {
+			p, e = chroot.WrapPath(p)
+			if e != nil {
+				return nil, e
+			}
+		}
+		client.paths = append(client.paths, p)
+	}
+	return client, nil
+}
+
+func (h *eventHub) onEntryUpdated(_ types.DriveListenerContext, path string, _ bool) {
+	h.publishEntryEvent(eventEntryUpdated, path)
+}
+
+func (h *eventHub) onEntryDeleted(_ types.DriveListenerContext, path string) {
+	h.publishEntryEvent(eventEntryDeleted, path)
+}
+
+func (h *eventHub) publishEntryEvent(eventType, path string) {
+	perms := h.access.GetPerms()
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+	for client := range h.clients {
+		if !client.subscribed(path) {
+			continue
+		}
+		if !perms.Filter(client.session).ResolvePath(path).Readable() {
+			continue
+		}
+		p := path
+		if client.chroot != nil {
+			if p != client.chroot.Root && !strings.HasPrefix(p, client.chroot.Root+"/") {
+				continue
+			}
+			p = client.chroot.UnwrapPath(p)
+		}
+		// the API token can only access the path of its scope
+		if client.scope != nil && !client.scope.ResolvePath(p).Readable() {
+			continue
+		}
+		client.send(eventMessage{name: "entry", data: entryEvent{Type: eventType, Path: p}})
+	}
+}
+
+func (h *eventHub) onTaskUpdated(t task.Task) {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+	for client := range h.clients {
+		if client.session.IsAnonymous() || t.User != client.session.User.Username {
+			continue
+		}
+		client.send(eventMessage{name: "task", data: t})
+	}
+}
+
+// subscribed returns true if the path is one of the subscribed paths,
+// the child of a subscribed path, or the ancestor of a subscribed path
+func (c *eventClient) subscribed(path string) bool {
+	for _, p := range c.paths {
+		if path == p || utils.PathParent(path) == p || strings.HasPrefix(p, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// send sends the message without blocking, the message is dropped if the client is too slow
+func (c *eventClient) send(m eventMessage) {
+	select {
+	case c.messages <- m:
+	default:
+	}
+}
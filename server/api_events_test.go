@@ -0,0 +1,59 @@
+package server
+
+import (
+	"go-drive/common/types"
+	"go-drive/drive"
+	"go-drive/storage"
+	"sync"
+	"testing"
+)
+
+func newTestEventHub(t *testing.T) *eventHub {
+	_, db, ch := newTestDB(t)
+	access, e := drive.NewAccess(ch, nil, nil, nil, nil,
+		storage.NewPathPermissionDAO(db, ch), storage.NewOptionsDAO(db, ch), nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return &eventHub{access: access, clients: make(map[*eventClient]struct{}), mux: &sync.RWMutex{}}
+}
+
+// receivedPaths returns the paths of the entry events received by the client
+func receivedPaths(c *eventClient) []string {
+	paths := make([]string, 0)
+	for {
+		select {
+		case m := <-c.messages:
+			paths = append(paths, m.data.(entryEvent).Path)
+		default:
+			return paths
+		}
+	}
+}
+
+func TestEntryEventScope(t *testing.T) {
+	h := newTestEventHub(t)
+	admin := types.User{Username: "admin", Groups: []types.Group{{Name: types.AdminUserGroup}}}
+
+	subscribe := func(session types.Session) *eventClient {
+		client, e := h.newClient(session, []string{"a", "b"})
+		if e != nil {
+			t.Fatal(e)
+		}
+		h.clients[client] = struct{}{}
+		return client
+	}
+	unscoped := subscribe(types.Session{User: admin})
+	scoped := subscribe(types.Session{User: admin, Scope: &types.TokenScope{Path: "a", ReadOnly: true}})
+
+	for _, p := range []string{"a/x", "b/y", "a", "c/z"} {
+		h.publishEntryEvent(eventEntryUpdated, p)
+	}
+
+	if r := receivedPaths(unscoped); len(r) != 3 || r[0] != "a/x" || r[1] != "b/y" || r[2] != "a" {
+		t.Errorf("expect the unscoped client to receive [a/x b/y a], but is %v", r)
+	}
+	if r := receivedPaths(scoped); len(r) != 2 || r[0] != "a/x" || r[1] != "a" {
+		t.Errorf("expect the scoped client to receive [a/x a], but is %v", r)
+	}
+}
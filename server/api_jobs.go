@@ -5,6 +5,7 @@ import (
 	"go-drive/common/registry"
 	"go-drive/common/types"
 	"go-drive/common/utils"
+	"go-drive/server/audit"
 	"go-drive/server/scheduled"
 	"go-drive/storage"
 	"io"
@@ -18,9 +19,10 @@ func InitJobsRoutes(
 	ch *registry.ComponentsHolder,
 	tokenStore types.TokenStore,
 	jobExecutor *scheduled.JobExecutor,
-	scheduledDAO *storage.ScheduledDAO) error {
+	scheduledDAO *storage.ScheduledDAO,
+	auditLogger *audit.Logger) error {
 
-	r = r.Group("/admin/jobs", TokenAuth(tokenStore), AdminGroupRequired())
+	r = r.Group("/admin/jobs", TokenAuth(tokenStore), AdminGroupRequired(), AuditAdmin(auditLogger))
 
 	// get all job definitions
 	r.GET("/definitions", func(c *gin.Context) {
@@ -56,7 +58,7 @@ func InitJobsRoutes(
 			_ = c.Error(e)
 			return
 		}
-		if e := jobExecutor.ValidateSchedule(job.Schedule); e != nil {
+		if e := jobExecutor.ValidateJob(job); e != nil {
 			_ = c.Error(e)
 			return
 		}
@@ -85,7 +87,7 @@ func InitJobsRoutes(
 			_ = c.Error(err.NewBadRequestError(""))
 			return
 		}
-		if e := jobExecutor.ValidateSchedule(job.Schedule); e != nil {
+		if e := jobExecutor.ValidateJob(job); e != nil {
 			_ = c.Error(e)
 			return
 		}
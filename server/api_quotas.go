@@ -0,0 +1,149 @@
+package server
+
+import (
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/drive"
+	"go-drive/server/audit"
+	"go-drive/storage"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func InitQuotaRoutes(
+	r gin.IRouter,
+	tokenStore types.TokenStore,
+	quotas *drive.Quotas,
+	runner task.Runner,
+	quotaDAO *storage.QuotaDAO,
+	auditLogger *audit.Logger) error {
+
+	r = r.Group("/admin/quotas", TokenAuth(tokenStore), AdminGroupRequired(), AuditAdmin(auditLogger))
+
+	// get all quotas with usage
+	r.GET("", func(c *gin.Context) {
+		SetResult(c, quotas.GetQuotas())
+	})
+
+	// create quota, the usage is computed in background
+	r.POST("", func(c *gin.Context) {
+		quota := types.Quota{}
+		if e := c.Bind(&quota); e != nil {
+			_ = c.Error(e)
+			return
+		}
+		quota.Path = utils.CleanPath(quota.Path)
+		if e := validateQuota(quota); e != nil {
+			_ = c.Error(e)
+			return
+		}
+		addQuota, e := quotaDAO.AddQuota(quota)
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		if e := quotas.Reload(); e != nil {
+			_ = c.Error(e)
+			return
+		}
+		_, e = runner.Execute(func(ctx types.TaskCtx) (interface{}, error) {
+			return quotas.Recompute(ctx, addQuota.ID)
+		}, task.WithNameGroup(addQuota.Subject, "quota/recompute"), TaskUser(c))
+		if e != nil {
+			log.Printf("[Quotas] failed to compute usage of quota %d: %v", addQuota.ID, e)
+		}
+		SetResult(c, addQuota)
+	})
+
+	// update quota
+	r.PUT("/:id", func(c *gin.Context) {
+		quota := types.Quota{}
+		if e := c.Bind(&quota); e != nil {
+			_ = c.Error(e)
+			return
+		}
+		id := utils.ToUInt(c.Param("id"), 0)
+		if id == 0 {
+			_ = c.Error(err.NewBadRequestError(""))
+			return
+		}
+		quota.Path = utils.CleanPath(quota.Path)
+		if e := validateQuota(quota); e != nil {
+			_ = c.Error(e)
+			return
+		}
+		if _, e := quotaDAO.GetQuota(id); e != nil {
+			_ = c.Error(e)
+			return
+		}
+		if e := quotaDAO.UpdateQuota(id, quota); e != nil {
+			_ = c.Error(e)
+			return
+		}
+		if e := quotas.Reload(); e != nil {
+			_ = c.Error(e)
+			return
+		}
+	})
+
+	// delete quota
+	r.DELETE("/:id", func(c *gin.Context) {
+		id := utils.ToUInt(c.Param("id"), 0)
+		if id == 0 {
+			_ = c.Error(err.NewBadRequestError(""))
+			return
+		}
+		if e := quotaDAO.DeleteQuota(id); e != nil {
+			_ = c.Error(e)
+			return
+		}
+		if e := quotas.Reload(); e != nil {
+			_ = c.Error(e)
+			return
+		}
+	})
+
+	// recompute the usage of quota
+	r.POST("/:id/recompute", func(c *gin.Context) {
+		id := utils.ToUInt(c.Param("id"), 0)
+		if id == 0 {
+			_ = c.Error(err.NewBadRequestError(""))
+			return
+		}
+		quota, e := quotaDAO.GetQuota(id)
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		t, e := runner.ExecuteAndWait(func(ctx types.TaskCtx) (interface{}, error) {
+			return quotas.Recompute(ctx, id)
+		}, 2*time.Second, task.WithNameGroup(quota.Subject, "quota/recompute"), TaskUser(c))
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		SetResult(c, t)
+	})
+
+	return nil
+}
+
+func validateQuota(quota types.Quota) error {
+	name := ""
+	if strings.HasPrefix(quota.Subject, "u:") || strings.HasPrefix(quota.Subject, "g:") {
+		name = quota.Subject[2:]
+	}
+	if name == "" {
+		return err.NewNotAllowedMessageError(i18n.T("api.quotas.invalid_subject", quota.Subject))
+	}
+	if quota.Limit < 0 {
+		return err.NewNotAllowedMessageError(i18n.T("api.quotas.invalid_limit"))
+	}
+	return nil
+}
@@ -0,0 +1,425 @@
+package server
+
+import (
+	"go-drive/common"
+	"go-drive/common/drive_util"
+	err "go-drive/common/errors"
+	"go-drive/common/event"
+	"go-drive/common/i18n"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/drive"
+	"go-drive/storage"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	shareSignaturePrefix = "share:"
+	// shareDownloadSessionTTL is the duration in which the requests of a file from the same IP count as one download
+	shareDownloadSessionTTL = time.Hour
+)
+
+func InitShareRoutes(
+	router gin.IRouter,
+	ch *registry.ComponentsHolder,
+	config common.Config,
+	access *drive.Access,
+	signer *utils.Signer,
+	tokenStore types.TokenStore,
+	shareDAO *storage.ShareDAO,
+	userDAO *storage.UserDAO,
+	failBan *FailBanGroup,
+	bus event.Bus) error {
+
+	sr := shareRoute{
+		config:   config,
+		access:   access,
+		signer:   signer,
+		shareDAO: shareDAO,
+		userDAO:  userDAO,
+		counted:  utils.NewKVCache[bool](shareDownloadSessionTTL),
+		bus:      bus,
+	}
+	ch.Add("shareDownloads", sr.counted)
+
+	r := router.Group("/", TokenAuth(tokenStore))
+	// list shares of current user
+	r.GET("/shares", sr.listShares)
+	// create share
+	r.POST("/share/*path", sr.createShare)
+	// revoke share
+	r.DELETE("/share/:id", sr.deleteShare)
+
+	admin := router.Group("/admin", TokenAuth(tokenStore), AdminGroupRequired())
+	// list all shares
+	admin.GET("/shares", sr.listAllShares)
+	// revoke any share
+	admin.DELETE("/share/:id", sr.deleteShare)
+
+	// anonymous access
+	// open share, the password is verified here
+	router.POST("/s/:id", failBan.LimiterByIP("/share", 5*time.Minute, 5), sr.openShare)
+	// list entries of shared folder
+	router.GET("/s/:id/entries/*path", sr.list)
+	// get shared file content
+	router.HEAD("/s/:id/content/*path", sr.getContent)
+	router.GET("/s/:id/content/*path", sr.getContent)
+
+	return nil
+}
+
+type shareRoute struct {
+	config   common.Config
+	access   *drive.Access
+	signer   *utils.Signer
+	shareDAO *storage.ShareDAO
+	userDAO  *storage.UserDAO
+	// counted holds the download sessions that have been counted
+	counted *utils.KVCache[bool]
+	bus     event.Bus
+}
+
+func (sr *shareRoute) listShares(c *gin.Context) {
+	session := GetSession(c)
+	if session.IsAnonymous() {
+		_ = c.Error(err.NewUnauthorizedError(i18n.T("api.share.login_required")))
+		return
+	}
+	shares, e := sr.shareDAO.ListShares(session.User.Username)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	chroot, e := sr.access.GetChroot(session)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if chroot != nil {
+		for i := range shares {
+			shares[i].Path = chroot.UnwrapPath(shares[i].Path)
+		}
+	}
+	SetResult(c, shares)
+}
+
+func (sr *shareRoute) listAllShares(c *gin.Context) {
+	shares, e := sr.shareDAO.ListShares("")
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	SetResult(c, shares)
+}
+
+func (sr *shareRoute) createShare(c *gin.Context) {
+	session := GetSession(c)
+	if session.IsAnonymous() {
+		_ = c.Error(err.NewUnauthorizedError(i18n.T("api.share.login_required")))
+		return
+	}
+	req := shareRequest{}
+	if e := c.Bind(&req); e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if req.ExpiresAt > 0 && req.ExpiresAt < uint64(time.Now().UnixMilli()) {
+		_ = c.Error(err.NewBadRequestError(i18n.T("api.share.invalid_expires_at")))
+		return
+	}
+
+	path := utils.CleanPath(c.Param("path"))
+	if utils.IsRootPath(path) {
+		_ = c.Error(err.NewNotAllowedMessageError(i18n.T("api.share.cannot_share_root")))
+		return
+	}
+	d, e := sr.access.GetDrive(session)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	// make sure the user can read this entry
+	if _, e := d.Get(c.Request.Context(), path); e != nil {
+		_ = c.Error(e)
+		return
+	}
+
+	chroot, e := sr.access.GetChroot(session)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	realPath := path
+	if chroot != nil {
+		realPath, e = chroot.WrapPath(path)
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+	}
+	if !sr.access.GetPerms().Filter(session).ResolvePath(realPath).Has(types.PermissionShare) {
+		_ = c.Error(err.NewNotAllowedMessageError(i18n.T("error.permission_denied")))
+		return
+	}
+
+	share, e := sr.shareDAO.AddShare(types.Share{
+		Path:         realPath,
+		Username:     session.User.Username,
+		Password:     req.Password,
+		AllowBrowse:  req.AllowBrowse,
+		MaxDownloads: req.MaxDownloads,
+		ExpiresAt:    req.ExpiresAt,
+	})
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	share.Path = path
+	SetResult(c, share)
+}
+
+func (sr *shareRoute) deleteShare(c *gin.Context) {
+	session := GetSession(c)
+	share, e := sr.shareDAO.GetShare(c.Param("id"))
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if share.Username != session.User.Username && !session.IsEffectiveAdmin() {
+		_ = c.Error(err.NewNotFoundMessageError(i18n.T("storage.shares.share_not_exists", share.ID)))
+		return
+	}
+	if e := sr.shareDAO.DeleteShare(share.ID); e != nil {
+		_ = c.Error(e)
+	}
+}
+
+func (sr *shareRoute) openShare(c *gin.Context) {
+	req := struct {
+		Password string `json:"password" form:"password"`
+	}{}
+	if e := c.Bind(&req); e != nil {
+		_ = c.Error(e)
+		return
+	}
+	share, e := sr.getShare(c.Param("id"))
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if share.IsDownloadLimitReached() {
+		_ = c.Error(err.NewNotAllowedMessageError(i18n.T("api.share.download_limit_reached")))
+		return
+	}
+	if !sr.shareDAO.CheckPassword(share, req.Password) {
+		_ = c.Error(err.NewUnauthorizedError(i18n.T("api.share.invalid_password")))
+		return
+	}
+	_, entry, e := sr.getShareDrive(c, share)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	result := shareInfo{
+		ID:          share.ID,
+		Name:        utils.PathBase(share.Path),
+		Type:        entry.Type(),
+		Size:        entry.Size(),
+		ModTime:     entry.ModTime(),
+		AllowBrowse: share.AllowBrowse,
+		ExpiresAt:   share.ExpiresAt,
+	}
+	if share.HasPassword() {
+		result.Key = sr.signer.Sign(shareSignatureValue(share, SignatureIP(c, sr.config)),
+			time.Now().Add(sr.config.SignatureTTL))
+	}
+	SetResult(c, result)
+}
+
+func (sr *shareRoute) list(c *gin.Context) {
+	share, e := sr.getAuthorizedShare(c)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	d, entry, e := sr.getShareDrive(c, share)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	path := utils.CleanPath(c.Param("path"))
+	if entry.Type() != types.TypeDir ||
+		(!utils.IsRootPath(path) && !share.AllowBrowse) {
+		_ = c.Error(err.NewNotAllowedMessageError(i18n.T("api.share.browse_not_allowed")))
+		return
+	}
+	entries, e := d.List(c.Request.Context(), path)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	res := make([]entryJson, 0, len(entries))
+	for _, v := range entries {
+		res = append(res, entryJson{
+			Path:    v.Path(),
+			Name:    utils.PathBase(v.Path()),
+			Type:    v.Type(),
+			Size:    v.Size(),
+			Meta:    types.M{"writable": false},
+			ModTime: v.ModTime(),
+		})
+	}
+	SetResult(c, res)
+}
+
+func (sr *shareRoute) getContent(c *gin.Context) {
+	share, e := sr.getAuthorizedShare(c)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	d, entry, e := sr.getShareDrive(c, share)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	path := utils.CleanPath(c.Param("path"))
+	rootPath := share.Path
+	if entry.Type() == types.TypeDir {
+		rootPath = utils.CleanPath(share.Path + "/" + path)
+		if strings.Contains(path, "/") && !share.AllowBrowse {
+			_ = c.Error(err.NewNotAllowedMessageError(i18n.T("api.share.browse_not_allowed")))
+			return
+		}
+		entry, e = d.Get(c.Request.Context(), path)
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+	}
+	if entry.Type() != types.TypeFile {
+		_ = c.Error(err.NewNotFoundError())
+		return
+	}
+
+	if c.Request.Method == http.MethodGet {
+		ok, e := sr.countDownload(c, share, path)
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		if !ok {
+			_ = c.Error(err.NewNotAllowedMessageError(i18n.T("api.share.download_limit_reached")))
+			return
+		}
+	}
+	e = drive_util.DownloadIContent(c.Request.Context(), entry, c.Writer, c.Request, false)
+	if isDownloadRequest(c.Request) {
+		publishDownload(sr.bus, types.Session{ClientIP: c.ClientIP()}, rootPath, e)
+	}
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+}
+
+// getShare gets the share and checks if it is still available
+func (sr *shareRoute) getShare(id string) (types.Share, error) {
+	share, e := sr.shareDAO.GetShare(id)
+	if e != nil {
+		return share, e
+	}
+	if share.IsExpired() {
+		return share, err.NewNotFoundMessageError(i18n.T("api.share.expired"))
+	}
+	return share, nil
+}
+
+// getAuthorizedShare gets the share and validates the key for password protected shares
+func (sr *shareRoute) getAuthorizedShare(c *gin.Context) (types.Share, error) {
+	share, e := sr.getShare(c.Param("id"))
+	if e != nil {
+		return share, e
+	}
+	if share.HasPassword() {
+		key := c.Query(common.SignatureQueryKey)
+		if key == "" {
+			return share, err.NewUnauthorizedError(i18n.T("api.share.password_required"))
+		}
+		if !sr.signer.Validate(shareSignatureValue(share, SignatureIP(c, sr.config)), key) {
+			return share, err.NewUnauthorizedError(i18n.T("api.share.invalid_key"))
+		}
+	}
+	return share, nil
+}
+
+// getShareDrive returns the drive jailed in the shared path and the shared entry
+func (sr *shareRoute) getShareDrive(c *gin.Context, share types.Share) (types.IDrive, types.IEntry, error) {
+	owner, e := sr.userDAO.GetUser(share.Username)
+	if e != nil {
+		if err.IsNotFoundError(e) {
+			return nil, nil, err.NewNotFoundMessageError(i18n.T("storage.shares.share_not_exists", share.ID))
+		}
+		return nil, nil, e
+	}
+	ownerSession := types.Session{User: owner}
+	// the shared entry must be still readable by the owner
+	entry, e := sr.access.GetShareDrive(ownerSession, share.Path, true).Get(c.Request.Context(), "")
+	if e != nil {
+		return nil, nil, e
+	}
+	if entry.Type() == types.TypeDir {
+		return sr.access.GetShareDrive(ownerSession, share.Path, true), entry, nil
+	}
+	d := sr.access.GetShareDrive(ownerSession, share.Path, false)
+	entry, e = d.Get(c.Request.Context(), utils.PathBase(share.Path))
+	if e != nil {
+		return nil, nil, e
+	}
+	return d, entry, nil
+}
+
+func shareSignatureValue(share types.Share, ip string) string {
+	return shareSignaturePrefix + share.ID + "\n" + ip
+}
+
+// countDownload counts the download of the file once per client IP in shareDownloadSessionTTL,
+// so the range requests of a download are counted only once, no matter what ranges are requested.
+// It returns false if the download limit has been reached.
+func (sr *shareRoute) countDownload(c *gin.Context, share types.Share, path string) (bool, error) {
+	// the client IP is used no matter whether the signatures are bound to IP
+	key := share.ID + "\n" + path + "\n" + c.ClientIP()
+	if _, ok := sr.counted.Get(key); ok {
+		return true, nil
+	}
+	ok, e := sr.shareDAO.IncreaseDownloads(share.ID)
+	if e != nil || !ok {
+		return ok, e
+	}
+	sr.counted.Set(key, true, shareDownloadSessionTTL)
+	return true, nil
+}
+
+type shareRequest struct {
+	Password     string `json:"password"`
+	AllowBrowse  bool   `json:"allowBrowse"`
+	MaxDownloads uint   `json:"maxDownloads"`
+	ExpiresAt    uint64 `json:"expiresAt"`
+}
+
+type shareInfo struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Type        types.EntryType `json:"type"`
+	Size        int64           `json:"size"`
+	ModTime     int64           `json:"modTime"`
+	AllowBrowse bool            `json:"allowBrowse"`
+	ExpiresAt   uint64          `json:"expiresAt"`
+	// Key is used to access the password protected share
+	Key string `json:"key,omitempty"`
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/storage"
+	"net/http"
+	"testing"
+)
+
+func TestCountShareDownload(t *testing.T) {
+	config, db, ch := newTestDB(t)
+	shareDAO := storage.NewShareDAO(db, ch)
+	sr := shareRoute{
+		config:   config,
+		shareDAO: shareDAO,
+		counted:  utils.NewKVCache[bool](shareDownloadSessionTTL),
+	}
+	ch.Add("shareDownloads", sr.counted)
+
+	share, e := shareDAO.AddShare(types.Share{Path: "a/b.txt", Username: "admin", MaxDownloads: 2})
+	if e != nil {
+		t.Fatal(e)
+	}
+	cases := []struct {
+		ip     string
+		expect bool
+	}{
+		{"10.0.0.1", true},
+		// the range requests of the same download
+		{"10.0.0.1", true},
+		{"10.0.0.2", true},
+		{"10.0.0.2", true},
+		// the limit is reached
+		{"10.0.0.3", false},
+		{"10.0.0.3", false},
+		{"10.0.0.4", false},
+	}
+	for i, cs := range cases {
+		c, _ := newTestContext(http.MethodGet, "/s/"+share.ID+"/content/", cs.ip)
+		ok, e := sr.countDownload(c, share, "")
+		if e != nil {
+			t.Fatal(e)
+		}
+		if ok != cs.expect {
+			t.Errorf("#%d %s: expect %v, but is %v", i, cs.ip, cs.expect, ok)
+		}
+	}
+	saved, e := shareDAO.GetShare(share.ID)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if saved.Downloads != 2 {
+		t.Errorf("expect 2 downloads, but is %d", saved.Downloads)
+	}
+}
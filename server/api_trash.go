@@ -0,0 +1,166 @@
+package server
+
+import (
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/drive"
+	"go-drive/storage"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func InitTrashRoutes(
+	router gin.IRouter,
+	access *drive.Access,
+	trash *drive.Trash,
+	runner task.Runner,
+	tokenStore types.TokenStore,
+	trashDAO *storage.TrashDAO) error {
+
+	tr := trashRoute{
+		access:   access,
+		trash:    trash,
+		runner:   runner,
+		trashDAO: trashDAO,
+	}
+
+	r := router.Group("/", TokenAuth(tokenStore), UnrestrictedRequired())
+	// list entries deleted by current user
+	r.GET("/trash", tr.list)
+	// restore entry
+	r.POST("/trash/:id/restore", tr.restore)
+	// purge entry
+	r.DELETE("/trash/:id", tr.purge)
+	// purge all entries deleted by current user
+	r.DELETE("/trash", tr.purgeAll)
+
+	// list all deleted entries
+	router.GET("/admin/trash", TokenAuth(tokenStore), AdminGroupRequired(), func(c *gin.Context) {
+		items, e := trashDAO.ListItems("")
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		SetResult(c, items)
+	})
+
+	return nil
+}
+
+type trashRoute struct {
+	access   *drive.Access
+	trash    *drive.Trash
+	runner   task.Runner
+	trashDAO *storage.TrashDAO
+}
+
+func (tr *trashRoute) list(c *gin.Context) {
+	session := GetSession(c)
+	if session.IsAnonymous() {
+		SetResult(c, []types.TrashItem{})
+		return
+	}
+	items, e := tr.trashDAO.ListItems(session.User.Username)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	chroot, e := tr.access.GetChroot(session)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if chroot != nil {
+		for i := range items {
+			items[i].Path = chroot.UnwrapPath(items[i].Path)
+		}
+	}
+	SetResult(c, items)
+}
+
+func (tr *trashRoute) restore(c *gin.Context) {
+	session := GetSession(c)
+	item, e := tr.getItem(c)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	perm := tr.access.GetPerms().Filter(session).ResolvePath(utils.PathParent(item.Path))
+	if !perm.Has(types.PermissionCreate) {
+		_ = c.Error(err.NewNotFoundMessageError(i18n.T("error.permission_denied")))
+		return
+	}
+	t, e := tr.runner.ExecuteAndWait(func(ctx types.TaskCtx) (interface{}, error) {
+		_, e := tr.trash.Restore(ctx, item, session)
+		return nil, e
+	}, 2*time.Second, task.WithNameGroup(item.Path, "drive/trash-restore"), TaskUser(c))
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	SetResult(c, t)
+}
+
+func (tr *trashRoute) purge(c *gin.Context) {
+	item, e := tr.getItem(c)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	t, e := tr.runner.ExecuteAndWait(func(ctx types.TaskCtx) (interface{}, error) {
+		return nil, tr.trash.Purge(ctx, item)
+	}, 2*time.Second, task.WithNameGroup(item.Path, "drive/trash-purge"), TaskUser(c))
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	SetResult(c, t)
+}
+
+func (tr *trashRoute) purgeAll(c *gin.Context) {
+	session := GetSession(c)
+	if session.IsAnonymous() {
+		return
+	}
+	items, e := tr.trashDAO.ListItems(session.User.Username)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	t, e := tr.runner.ExecuteAndWait(func(ctx types.TaskCtx) (interface{}, error) {
+		ctx.Total(int64(len(items)), true)
+		for _, item := range items {
+			if e := ctx.Err(); e != nil {
+				return nil, e
+			}
+			if e := tr.trash.Purge(ctx, item); e != nil {
+				return nil, e
+			}
+			ctx.Progress(1, false)
+		}
+		return nil, nil
+	}, 2*time.Second, task.WithNameGroup(session.User.Username, "drive/trash-purge"), TaskUser(c))
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	SetResult(c, t)
+}
+
+// getItem gets the trash item which can be accessed by current user
+func (tr *trashRoute) getItem(c *gin.Context) (types.TrashItem, error) {
+	session := GetSession(c)
+	item, e := tr.trashDAO.GetItem(c.Param("id"))
+	if e != nil {
+		return item, e
+	}
+	if session.IsAnonymous() ||
+		(item.DeletedBy != session.User.Username && !session.IsEffectiveAdmin()) {
+		return item, err.NewNotFoundMessageError(i18n.T("storage.trash.item_not_exists", item.ID))
+	}
+	return item, nil
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"encoding/base64"
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/drive"
+	"net/http"
+	path2 "path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	tusVersion     = "1.0.0"
+	tusExtensions  = "creation,creation-with-upload,termination,checksum,expiration"
+	tusContentType = "application/offset+octet-stream"
+)
+
+// InitTusRoutes initializes the tus 1.0 resumable upload endpoint.
+// The destination is set by the 'path' metadata, or the 'filename' metadata in the 'dir' metadata,
+// and the existing file will be overwritten if the 'override' metadata is 'true'.
+func InitTusRoutes(
+	router gin.IRouter,
+	access *drive.Access,
+	tokenStore types.TokenStore,
+	tusUploader *TusUploader) error {
+
+	tr := tusRoute{access: access, uploader: tusUploader}
+
+	// OPTIONS requests are not authenticated, they are used to discover the capabilities of the server
+	router.OPTIONS("/tus", tr.options)
+	router.OPTIONS("/tus/:id", tr.options)
+
+	r := router.Group("/tus", tusResumable, TokenAuth(tokenStore))
+	// creation
+	r.POST("", tr.create)
+	// get the offset of upload
+	r.HEAD("/:id", tr.head)
+	// upload data
+	r.PATCH("/:id", tr.patch)
+	// termination
+	r.DELETE("/:id", tr.terminate)
+
+	return nil
+}
+
+type tusRoute struct {
+	access   *drive.Access
+	uploader *TusUploader
+}
+
+func tusResumable(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		_ = c.Error(tusError{http.StatusPreconditionFailed, i18n.T("api.tus.unsupported_version")})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+func (tr *tusRoute) options(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	algorithms := make([]string, 0, len(tusChecksumAlgorithms))
+	for alg := range tusChecksumAlgorithms {
+		algorithms = append(algorithms, alg)
+	}
+	sort.Strings(algorithms)
+	c.Header("Tus-Checksum-Algorithm", strings.Join(algorithms, ","))
+	c.Status(http.StatusNoContent)
+}
+
+func (tr *tusRoute) create(c *gin.Context) {
+	if c.GetHeader("Upload-Defer-Length") != "" {
+		_ = c.Error(err.NewBadRequestError(i18n.T("api.tus.defer_length_not_supported")))
+		return
+	}
+	length := utils.ToInt64(c.GetHeader("Upload-Length"), -1)
+	if length < 0 {
+		_ = c.Error(err.NewBadRequestError(i18n.T("api.chunk_uploader.invalid_file_size")))
+		return
+	}
+	metadata, e := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	path := metadata["path"]
+	if path == "" && metadata["filename"] != "" {
+		path = path2.Join(metadata["dir"], metadata["filename"])
+	}
+	path = utils.CleanPath(path)
+	if path == "" {
+		_ = c.Error(err.NewBadRequestError(i18n.T("api.tus.path_required")))
+		return
+	}
+	override := utils.ToBool(metadata["override"])
+
+	d, e := tr.access.GetDrive(GetSession(c))
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	// fail fast before receiving the data
+	if !override {
+		if _, e := d.Get(c.Request.Context(), path); e == nil {
+			_ = c.Error(err.NewNotAllowedMessageError(i18n.T("drive.file_exists")))
+			return
+		} else if !err.IsNotFoundError(e) {
+			_ = c.Error(e)
+			return
+		}
+	}
+
+	upload, e := tr.uploader.CreateUpload(GetSession(c).User.Username, path, override,
+		length, c.GetHeader("Upload-Metadata"))
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	c.Header("Location", path2.Join(c.Request.URL.Path, upload.Id))
+
+	// creation-with-upload
+	if c.ContentType() == tusContentType && c.Request.ContentLength != 0 {
+		if e := tr.write(c, d, upload, 0); e != nil {
+			_ = c.Error(e)
+			return
+		}
+	} else if upload.Length == 0 {
+		if e := tr.complete(c, d, upload); e != nil {
+			_ = c.Error(e)
+			return
+		}
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusCreated)
+}
+
+func (tr *tusRoute) head(c *gin.Context) {
+	upload, e := tr.uploader.GetUpload(c.Param("id"), GetSession(c).User.Username)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		c.Header("Upload-Metadata", upload.Metadata)
+	}
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusOK)
+}
+
+func (tr *tusRoute) patch(c *gin.Context) {
+	if c.ContentType() != tusContentType {
+		_ = c.Error(tusError{http.StatusUnsupportedMediaType, i18n.T("api.tus.invalid_content_type")})
+		return
+	}
+	offset := utils.ToInt64(c.GetHeader("Upload-Offset"), -1)
+	if offset < 0 {
+		_ = c.Error(err.NewBadRequestError(i18n.T("api.tus.invalid_offset")))
+		return
+	}
+	upload, e := tr.uploader.GetUpload(c.Param("id"), GetSession(c).User.Username)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	d, e := tr.access.GetDrive(GetSession(c))
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if e := tr.write(c, d, upload, offset); e != nil {
+		_ = c.Error(e)
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusNoContent)
+}
+
+func (tr *tusRoute) terminate(c *gin.Context) {
+	upload, e := tr.uploader.GetUpload(c.Param("id"), GetSession(c).User.Username)
+	if e != nil {
+		_ = c.Error(e)
+		return
+	}
+	if e := tr.uploader.DeleteUpload(upload); e != nil {
+		_ = c.Error(e)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// write writes the request body to the upload, and saves the file to the drive when all the data received
+func (tr *tusRoute) write(c *gin.Context, d types.IDrive, upload *TusUpload, offset int64) error {
+	if e := tr.uploader.WriteChunk(upload, offset, c.GetHeader("Upload-Checksum"), c.Request.Body); e != nil {
+		return e
+	}
+	if upload.Offset < upload.Length {
+		return nil
+	}
+	return tr.complete(c, d, upload)
+}
+
+// complete saves the uploaded file to the drive and removes the upload.
+// The upload is kept if failed, so it can be completed by the next PATCH request.
+func (tr *tusRoute) complete(c *gin.Context, d types.IDrive, upload *TusUpload) error {
+	file, e := tr.uploader.OpenData(upload)
+	if e != nil {
+		return e
+	}
+	tempFile := utils.NewTempFile(file)
+	_, e = d.Save(task.NewTaskContext(c.Request.Context()), upload.Path, upload.Length, upload.Override, tempFile)
+	_ = tempFile.Close()
+	if e != nil {
+		return e
+	}
+	return tr.uploader.DeleteUpload(upload)
+}
+
+// parseTusMetadata parses the Upload-Metadata header, which is comma separated key and base64 encoded value pairs
+func parseTusMetadata(header string) (types.SM, error) {
+	metadata := make(types.SM)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, " ")
+		v, e := base64.StdEncoding.DecodeString(value)
+		if e != nil {
+			return nil, err.NewBadRequestError(i18n.T("api.tus.invalid_metadata", key))
+		}
+		metadata[key] = string(v)
+	}
+	return metadata, nil
+}
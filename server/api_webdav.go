@@ -2,8 +2,12 @@ package server
 
 import (
 	"context"
+	"errors"
 	"go-drive/common"
 	"go-drive/common/drive_util"
+	"go-drive/common/event"
+	"go-drive/common/types"
+	"go-drive/common/utils"
 	"go-drive/drive"
 	"go-drive/server/webdav"
 	"log"
@@ -19,7 +23,7 @@ var webdavHTTPMethods = []string{
 }
 
 func InitWebdavAccess(router gin.IRouter, config common.Config,
-	access *drive.Access, userAuth *UserAuth) error {
+	access *drive.Access, userAuth *UserAuth, bus event.Bus) error {
 
 	cfp, e := drive_util.NewCacheFillPool(config.WebDav.MaxCacheItems, config.TempDir)
 	if e != nil {
@@ -31,6 +35,7 @@ func InitWebdavAccess(router gin.IRouter, config common.Config,
 		cfp:     cfp,
 		config:  config,
 		lockSys: webdav.NewMemLS(),
+		bus:     bus,
 	}
 
 	withAuth := router.Group(config.WebDav.Prefix, BasicAuth(userAuth, "webdav", config.WebDav.AllowAnonymous))
@@ -51,6 +56,7 @@ type webdavAccess struct {
 	cfp     *drive_util.CacheFilePool
 	lockSys webdav.LockSystem
 	config  common.Config
+	bus     event.Bus
 }
 
 func (w *webdavAccess) ServeHTTP(c *gin.Context) {
@@ -75,6 +81,28 @@ func (w *webdavAccess) ServeHTTP(c *gin.Context) {
 		LockSystem: w.lockSys,
 	}
 	handler.ServeHTTP(c.Writer, c.Request)
+	w.downloaded(c, session)
+}
+
+// downloaded publishes the download operation if the request is downloading a file
+func (w *webdavAccess) downloaded(c *gin.Context, session types.Session) {
+	if !isDownloadRequest(c.Request) {
+		return
+	}
+	rootPath := utils.CleanPath(c.Param("path"))
+	chroot, e := w.access.GetChroot(session)
+	if e != nil {
+		return
+	}
+	if chroot != nil {
+		if rootPath, e = chroot.WrapPath(rootPath); e != nil {
+			return
+		}
+	}
+	if status := c.Writer.Status(); status >= http.StatusBadRequest {
+		e = errors.New(http.StatusText(status))
+	}
+	publishDownload(w.bus, session, rootPath, e)
 }
 
 type webDavFS struct {
@@ -0,0 +1,159 @@
+package server
+
+import (
+	err "go-drive/common/errors"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/server/audit"
+	"go-drive/server/webhook"
+	"go-drive/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+func InitWebhookRoutes(
+	r gin.IRouter,
+	tokenStore types.TokenStore,
+	webhookService *webhook.Service,
+	webhookDAO *storage.WebhookDAO,
+	auditLogger *audit.Logger) error {
+
+	r = r.Group("/admin/webhooks", TokenAuth(tokenStore), AdminGroupRequired(), AuditAdmin(auditLogger))
+
+	// get all subscribable events
+	r.GET("/events", func(c *gin.Context) {
+		SetResult(c, webhook.Events)
+	})
+
+	// get all webhooks
+	r.GET("", func(c *gin.Context) {
+		hooks, e := webhookDAO.GetWebhooks(true)
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		for i := range hooks {
+			hooks[i] = escapeWebhookSecret(hooks[i])
+		}
+		SetResult(c, hooks)
+	})
+
+	// create webhook
+	r.POST("", func(c *gin.Context) {
+		hook := types.Webhook{}
+		if e := c.Bind(&hook); e != nil {
+			_ = c.Error(e)
+			return
+		}
+		if e := webhook.Validate(hook); e != nil {
+			_ = c.Error(e)
+			return
+		}
+		addHook, e := webhookDAO.AddWebhook(hook)
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		if e := webhookService.Reload(); e != nil {
+			_ = c.Error(e)
+			return
+		}
+		SetResult(c, escapeWebhookSecret(addHook))
+	})
+
+	// update webhook
+	r.PUT("/:id", func(c *gin.Context) {
+		hook := types.Webhook{}
+		if e := c.Bind(&hook); e != nil {
+			_ = c.Error(e)
+			return
+		}
+		id := utils.ToUInt(c.Param("id"), 0)
+		if id == 0 {
+			_ = c.Error(err.NewBadRequestError(""))
+			return
+		}
+		if e := webhook.Validate(hook); e != nil {
+			_ = c.Error(e)
+			return
+		}
+		savedHook, e := webhookDAO.GetWebhook(id)
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		if hook.Secret == escapedPassword {
+			hook.Secret = savedHook.Secret
+		}
+		if e := webhookDAO.UpdateWebhook(id, hook); e != nil {
+			_ = c.Error(e)
+			return
+		}
+		if e := webhookService.Reload(); e != nil {
+			_ = c.Error(e)
+			return
+		}
+	})
+
+	// delete webhook and its deliveries
+	r.DELETE("/:id", func(c *gin.Context) {
+		id := utils.ToUInt(c.Param("id"), 0)
+		if id == 0 {
+			_ = c.Error(err.NewBadRequestError(""))
+			return
+		}
+		if e := webhookDAO.DeleteWebhook(id); e != nil {
+			_ = c.Error(e)
+			return
+		}
+		if e := webhookService.Reload(); e != nil {
+			_ = c.Error(e)
+			return
+		}
+	})
+
+	// get recent deliveries of webhook
+	r.GET("/:id/deliveries", func(c *gin.Context) {
+		id := utils.ToUInt(c.Param("id"), 0)
+		if id == 0 {
+			_ = c.Error(err.NewBadRequestError(""))
+			return
+		}
+		deliveries, e := webhookDAO.GetDeliveries(id)
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		SetResult(c, deliveries)
+	})
+
+	// send a ping delivery to webhook
+	r.POST("/:id/test", func(c *gin.Context) {
+		id := utils.ToUInt(c.Param("id"), 0)
+		if id == 0 {
+			_ = c.Error(err.NewBadRequestError(""))
+			return
+		}
+		hook, e := webhookDAO.GetWebhook(id)
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		t, e := webhookService.Test(hook)
+		if e != nil {
+			_ = c.Error(e)
+			return
+		}
+		SetResult(c, t)
+	})
+
+	return nil
+}
+
+// escapeWebhookSecret hides the secret of the webhook, the escaped secret is kept unchanged when updating
+func escapeWebhookSecret(hook types.Webhook) types.Webhook {
+	if hook.Secret != "" {
+		hook.Secret = escapedPassword
+	}
+	return hook
+}
@@ -0,0 +1,134 @@
+package audit
+
+import (
+	"go-drive/common/event"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/storage"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// maxAgeKey is the option of how long the audit logs are kept, empty uses the default, 0 keeps them forever
+	maxAgeKey = "audit.maxAge"
+
+	defaultMaxAge = 90 * 24 * time.Hour
+	cleanInterval = 12 * time.Hour
+
+	queueSize = 1024
+	// batchSize is the max count of the logs written in one insert
+	batchSize = 100
+)
+
+// Logger records the entry operations and the admin operations
+type Logger struct {
+	dao     *storage.AuditLogDAO
+	options *storage.OptionsDAO
+
+	// queue holds the logs waiting to be written by the worker
+	queue  chan types.AuditLog
+	done   chan struct{}
+	mux    sync.RWMutex
+	closed bool
+
+	stopCleaner func()
+}
+
+func NewLogger(ch *registry.ComponentsHolder, dao *storage.AuditLogDAO,
+	optionsDAO *storage.OptionsDAO, bus event.Bus) *Logger {
+	l := &Logger{
+		dao:     dao,
+		options: optionsDAO,
+		queue:   make(chan types.AuditLog, queueSize),
+		done:    make(chan struct{}),
+	}
+	go l.writeLogs()
+	bus.Subscribe(event.EntryOperated, l.onEntryOperated)
+	l.stopCleaner = utils.TimeTick(l.clean, cleanInterval)
+	ch.Add("auditLogger", l)
+	return l
+}
+
+// Log queues the audit log to be written, CreatedAt and Result will be filled if they are empty.
+// It blocks only when the queue is full.
+func (l *Logger) Log(item types.AuditLog) {
+	if item.CreatedAt == 0 {
+		item.CreatedAt = time.Now().UnixMilli()
+	}
+	if item.Result == "" {
+		item.Result = types.AuditResultSuccess
+	}
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+	if l.closed {
+		return
+	}
+	l.queue <- item
+}
+
+// writeLogs writes the queued logs in batches until the queue is closed
+func (l *Logger) writeLogs() {
+	defer close(l.done)
+	for item := range l.queue {
+		items := []types.AuditLog{item}
+	batch:
+		for len(items) < batchSize {
+			select {
+			case item, ok := <-l.queue:
+				if !ok {
+					break batch
+				}
+				items = append(items, item)
+			default:
+				break batch
+			}
+		}
+		if e := l.dao.AddLogs(items); e != nil {
+			for _, item := range items {
+				log.Printf("[Audit] failed to write log %s %s: %v", item.Action, utils.LogSanitize(item.Path), e)
+			}
+		}
+	}
+}
+
+func (l *Logger) onEntryOperated(dc types.DriveListenerContext, op types.EntryOperation) {
+	item := types.AuditLog{Action: op.Action, Path: op.Path, To: op.To}
+	if dc.Session != nil {
+		item.Username = dc.Session.User.Username
+		item.IP = dc.Session.ClientIP
+	}
+	if op.Error != nil {
+		item.Result = types.AuditResultFailed
+		item.Message = op.Error.Error()
+	}
+	l.Log(item)
+}
+
+func (l *Logger) clean() {
+	maxAge := defaultMaxAge
+	if v := l.options.GetValue(maxAgeKey); v != "" {
+		maxAge = v.Duration(defaultMaxAge)
+	}
+	if maxAge <= 0 {
+		return
+	}
+	if e := l.dao.DeleteLogsBefore(time.Now().Add(-maxAge).UnixMilli()); e != nil {
+		log.Printf("[Audit] failed to clean logs: %v", e)
+	}
+}
+
+func (l *Logger) Dispose() error {
+	l.stopCleaner()
+	l.mux.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.queue)
+	}
+	l.mux.Unlock()
+	// wait for the queued logs to be written
+	<-l.done
+	return nil
+}
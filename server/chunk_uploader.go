@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"go-drive/common"
+	"go-drive/common/drive_util"
 	err "go-drive/common/errors"
 	"go-drive/common/i18n"
 	"go-drive/common/types"
@@ -102,23 +103,24 @@ func (c *ChunkUploader) ChunkUpload(id string, seq int, reader io.Reader) error
 	return nil
 }
 
-func (c *ChunkUploader) CompleteUpload(id string, ctx types.TaskCtx) (*os.File, error) {
+// CompleteUpload merges the chunks, returns the merged file and its hashes for verifying the saved file
+func (c *ChunkUploader) CompleteUpload(id string, ctx types.TaskCtx) (*os.File, *drive_util.HashWriter, error) {
 	upload, e := c.getUpload(id)
 	if e != nil {
-		return nil, e
+		return nil, nil, e
 	}
 	for seq := 0; seq < upload.Chunks; seq++ {
 		exists, e := utils.FileExists(c.getChunk(upload, seq))
 		if e != nil {
-			return nil, e
+			return nil, nil, e
 		}
 		if !exists {
-			return nil, err.NewNotAllowedMessageError(i18n.T("missing_chunks"))
+			return nil, nil, err.NewNotAllowedMessageError(i18n.T("missing_chunks"))
 		}
 	}
 	file, e := os.OpenFile(c.getFile(upload), os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
 	if e != nil {
-		return nil, e
+		return nil, nil, e
 	}
 	allSuccess := false
 	defer func() {
@@ -130,31 +132,36 @@ func (c *ChunkUploader) CompleteUpload(id string, ctx types.TaskCtx) (*os.File,
 			_ = c.DeleteUpload(upload.Id)
 		}
 	}()
+	hashes := drive_util.NewHashWriter()
 	ctx.Total(upload.Size, true)
 	for seq := 0; seq < upload.Chunks; seq++ {
 		if e := ctx.Err(); e != nil {
-			return nil, e
+			return nil, nil, e
 		}
 		chunk, e := os.Open(c.getChunk(upload, seq))
 		if e != nil {
-			return nil, e
+			return nil, nil, e
 		}
-		w, e := io.Copy(file, chunk)
+		w, e := io.Copy(io.MultiWriter(file, hashes), chunk)
 		_ = chunk.Close()
 		if c.isMarkedDelete(upload) {
-			return nil, context.Canceled
+			return nil, nil, context.Canceled
 		}
 		if e != nil {
-			return nil, e
+			return nil, nil, e
 		}
 		ctx.Progress(w, false)
 	}
 	allSuccess = true
 	_ = file.Close()
 	if !allSuccess {
-		return nil, context.Canceled
+		return nil, nil, context.Canceled
+	}
+	merged, e := os.Open(c.getFile(upload))
+	if e != nil {
+		return nil, nil, e
 	}
-	return os.Open(c.getFile(upload))
+	return merged, hashes, nil
 }
 
 func (c *ChunkUploader) DeleteUpload(id string) error {
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"go-drive/common/drive_util"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/storage"
+	"log"
+	"sync"
+	"time"
+)
+
+// copyCheckpointRetention is how long an unfinished copy or move task can be resumed
+var copyCheckpointRetention = 7 * 24 * time.Hour
+
+// copyCheckpointPurgeInterval is how often the expired checkpoints are purged
+var copyCheckpointPurgeInterval = time.Hour
+
+func purgeCopyCheckpoints(dao *storage.CopyCheckpointDAO) error {
+	return dao.DeleteCheckpointsBefore(time.Now().Add(-copyCheckpointRetention).UnixMilli())
+}
+
+// copyCheckpointPurger deletes the expired checkpoints periodically
+type copyCheckpointPurger struct {
+	stop func()
+}
+
+func newCopyCheckpointPurger(dao *storage.CopyCheckpointDAO) *copyCheckpointPurger {
+	return &copyCheckpointPurger{stop: utils.TimeTick(func() {
+		if e := purgeCopyCheckpoints(dao); e != nil {
+			log.Printf("failed to purge copy checkpoints: %v", e)
+		}
+	}, copyCheckpointPurgeInterval)}
+}
+
+func (p *copyCheckpointPurger) Dispose() error {
+	p.stop()
+	return nil
+}
+
+// copyCheckpoint implements drive_util.CopyCheckpoint, the states are saved to database
+type copyCheckpoint struct {
+	dao      *storage.CopyCheckpointDAO
+	id       string
+	resuming bool
+	entries  map[string]*types.CopyCheckpointEntry
+	mux      *sync.Mutex
+}
+
+func newCopyCheckpoint(dao *storage.CopyCheckpointDAO, id string, resuming bool) (*copyCheckpoint, error) {
+	cp := &copyCheckpoint{
+		dao:      dao,
+		id:       id,
+		resuming: resuming,
+		entries:  make(map[string]*types.CopyCheckpointEntry),
+		mux:      &sync.Mutex{},
+	}
+	if resuming {
+		entries, e := dao.ListEntries(id)
+		if e != nil {
+			return nil, e
+		}
+		for i := range entries {
+			cp.entries[entries[i].Path] = &entries[i]
+		}
+	}
+	return cp, nil
+}
+
+func (c *copyCheckpoint) State(to string) (string, string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if entry, ok := c.entries[to]; ok {
+		return entry.State, entry.Dest
+	}
+	return "", ""
+}
+
+func (c *copyCheckpoint) Start(to, dest string) error {
+	return c.save(to, drive_util.CopyStarted, dest)
+}
+
+func (c *copyCheckpoint) Done(to string) error {
+	return c.save(to, drive_util.CopyDone, "")
+}
+
+func (c *copyCheckpoint) Resuming() bool {
+	return c.resuming
+}
+
+func (c *copyCheckpoint) save(to, state, dest string) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	entry, ok := c.entries[to]
+	if !ok {
+		entry = &types.CopyCheckpointEntry{CheckpointID: c.id, Path: to}
+	}
+	entry.State = state
+	if dest != "" {
+		entry.Dest = dest
+	}
+	if e := c.dao.SaveEntry(entry); e != nil {
+		return e
+	}
+	c.entries[to] = entry
+	return nil
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"go-drive/common/drive_util"
+	err "go-drive/common/errors"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"time"
+)
+
+const hashCacheTTL = 24 * time.Hour
+
+// Hasher gets the hashes of the entries.
+// The hashes are cached by the real path, modification time and size of the entry.
+type Hasher struct {
+	cache *utils.KVCache[string]
+}
+
+type EntryHash struct {
+	Algorithm string `json:"algorithm"`
+	Hash      string `json:"hash"`
+}
+
+func NewHasher(ch *registry.ComponentsHolder) *Hasher {
+	h := &Hasher{cache: utils.NewKVCache[string](time.Hour)}
+	ch.Add("hasher", h)
+	return h
+}
+
+// Hash returns the hash of the entry.
+// The hash is calculated by reading the content if the entry does not provide it.
+// If algorithm is empty, the first hash provided by the entry is returned, or the computed sha256.
+func (h *Hasher) Hash(ctx context.Context, entry types.IEntry, algorithm string) (EntryHash, error) {
+	if !entry.Type().IsFile() {
+		return EntryHash{}, err.NewNotAllowedError()
+	}
+	if algorithm == "" {
+		if hasher := drive_util.GetEntryHasher(entry); hasher != nil {
+			if algorithms, computed := hasher.HashAlgorithms(); !computed {
+				for _, a := range algorithms {
+					r, e := h.hash(ctx, entry, hasher, a, false)
+					if err.IsUnsupportedError(e) {
+						continue
+					}
+					return r, e
+				}
+			}
+		}
+		algorithm = types.HashSHA256
+	}
+	return h.hash(ctx, entry, drive_util.GetEntryHasher(entry), algorithm, true)
+}
+
+// hash gets the hash from the hasher, or calculates it if compute is true
+func (h *Hasher) hash(ctx context.Context, entry types.IEntry,
+	hasher types.IEntryHasher, algorithm string, compute bool) (EntryHash, error) {
+	key := h.cacheKey(entry, algorithm)
+	if v, ok := h.cache.Get(key); ok {
+		return EntryHash{algorithm, v}, nil
+	}
+	v, e := "", error(err.NewUnsupportedError())
+	if hasher != nil {
+		v, e = hasher.Hash(ctx, algorithm)
+	}
+	if compute && err.IsUnsupportedError(e) {
+		v, e = drive_util.ComputeHash(ctx, entry, algorithm)
+	}
+	if e != nil {
+		return EntryHash{}, e
+	}
+	h.cache.Set(key, v, hashCacheTTL)
+	return EntryHash{algorithm, v}, nil
+}
+
+func (h *Hasher) cacheKey(entry types.IEntry, algorithm string) string {
+	path := entry.Path()
+	if de := drive_util.GetIEntry(entry, func(e types.IEntry) bool {
+		_, ok := e.(types.IDispatcherEntry)
+		return ok
+	}); de != nil {
+		path = de.(types.IDispatcherEntry).GetRealPath()
+	}
+	return fmt.Sprintf("%s:%d:%d:%s", path, entry.ModTime(), entry.Size(), algorithm)
+}
+
+func (h *Hasher) Dispose() error {
+	return h.cache.Dispose()
+}
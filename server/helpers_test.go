@@ -0,0 +1,39 @@
+package server
+
+import (
+	"go-drive/common"
+	"go-drive/common/registry"
+	"go-drive/storage"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestDB creates a sqlite database in a temporary directory, the components are disposed after the test
+func newTestDB(t *testing.T) (common.Config, *storage.DB, *registry.ComponentsHolder) {
+	ch := registry.NewComponentHolder()
+	t.Cleanup(func() { _ = ch.Dispose() })
+	config := common.Config{
+		DataDir: t.TempDir(),
+		Db:      common.DbConfig{Type: "sqlite", Name: "data.db"},
+	}
+	db, e := storage.NewDB(config, ch)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return config, db, ch
+}
+
+// newTestContext creates the context of a request from the client IP
+func newTestContext(method, target, ip string) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, target, nil)
+	c.Request.RemoteAddr = ip + ":12345"
+	return c, w
+}
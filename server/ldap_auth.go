@@ -0,0 +1,231 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"go-drive/common"
+	err "go-drive/common/errors"
+	"go-drive/common/ldap"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/storage"
+	"log"
+	"strings"
+)
+
+var errLDAPUserNotFound = errors.New("user not found")
+
+// LDAPAuthenticator authenticates the users by binding to the LDAP server with their DNs and passwords
+type LDAPAuthenticator struct {
+	config      common.LDAPConfig
+	userDAO     *storage.UserDAO
+	provisioner *userProvisioner
+
+	stopSync func()
+}
+
+func NewLDAPAuthenticator(config common.LDAPConfig, userDAO *storage.UserDAO, groupDAO *storage.GroupDAO,
+	ch *registry.ComponentsHolder) *LDAPAuthenticator {
+	a := &LDAPAuthenticator{
+		config:  config,
+		userDAO: userDAO,
+		provisioner: &userProvisioner{
+			userDAO:      userDAO,
+			groupDAO:     groupDAO,
+			source:       types.UserSourceLDAP,
+			autoCreate:   config.AutoCreateUser,
+			linkExisting: config.LinkExistingUsers,
+			groupMapping: config.GroupMapping,
+		},
+	}
+	if config.SyncInterval > 0 && a.syncsGroups() {
+		a.stopSync = utils.TimeTick(a.syncGroups, config.SyncInterval)
+	}
+	ch.Add("ldapAuthenticator", a)
+	return a
+}
+
+func (a *LDAPAuthenticator) Authenticate(username, password string) (*types.User, error) {
+	if username == "" || password == "" {
+		return nil, nil
+	}
+	conn, e := a.connect()
+	if e != nil {
+		return nil, e
+	}
+	defer func() { _ = conn.Close() }()
+
+	entry, e := a.findUser(conn, username)
+	if e != nil {
+		if e == errLDAPUserNotFound {
+			return nil, nil
+		}
+		return nil, e
+	}
+	// the username in LDAP may differ in case
+	if name := entry.GetAttributeValue(a.config.UsernameAttribute); name != "" {
+		username = name
+	}
+	if len(username) > 32 {
+		return nil, nil
+	}
+	// the user of the same name from other sources is not authenticated by LDAP
+	if ok, e := a.provisioner.canProvision(username); !ok || e != nil {
+		return nil, e
+	}
+
+	if e := conn.Bind(entry.DN, password); e != nil {
+		if ldap.IsResultCode(e, ldap.ResultInvalidCredentials) {
+			return nil, nil
+		}
+		return nil, e
+	}
+	var groups []string
+	if a.syncsGroups() {
+		// the groups are searched by the service account if it's configured, or the user itself
+		if e := a.bindService(conn); e != nil {
+			return nil, e
+		}
+		groups, e = a.findGroups(conn, entry.DN, username)
+		if e != nil {
+			return nil, e
+		}
+	}
+	user, e := a.provisioner.provision(username, groups)
+	if e != nil {
+		return nil, e
+	}
+	return &user, nil
+}
+
+// syncsGroups returns true if the groups of the users are synchronized from LDAP,
+// the groups are granted by the explicit mapping only
+func (a *LDAPAuthenticator) syncsGroups() bool {
+	return a.config.GroupFilter != "" && len(a.config.GroupMapping) > 0
+}
+
+func (a *LDAPAuthenticator) connect() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: a.config.InsecureSkipVerify}
+	conn, e := ldap.Dial(a.config.URL, tlsConfig, a.config.Timeout)
+	if e != nil {
+		return nil, e
+	}
+	if a.config.StartTLS && strings.HasPrefix(a.config.URL, "ldap://") {
+		if e := conn.StartTLS(tlsConfig); e != nil {
+			_ = conn.Close()
+			return nil, e
+		}
+	}
+	if e := a.bindService(conn); e != nil {
+		_ = conn.Close()
+		return nil, e
+	}
+	return conn, nil
+}
+
+func (a *LDAPAuthenticator) bindService(conn *ldap.Conn) error {
+	if a.config.BindDN == "" {
+		return nil
+	}
+	return conn.Bind(a.config.BindDN, a.config.BindPassword)
+}
+
+// findUser finds the entry of the user, the user must be unique
+func (a *LDAPAuthenticator) findUser(conn *ldap.Conn, username string) (*ldap.Entry, error) {
+	entries, e := conn.Search(ldap.SearchRequest{
+		BaseDN:     a.config.UserBaseDN,
+		Scope:      ldap.ScopeWholeSubtree,
+		Filter:     strings.ReplaceAll(a.config.UserFilter, "{username}", ldap.EscapeFilter(username)),
+		Attributes: []string{a.config.UsernameAttribute},
+		SizeLimit:  2,
+	})
+	if e != nil && !ldap.IsResultCode(e, ldap.ResultSizeLimitExceeded) {
+		return nil, e
+	}
+	if len(entries) != 1 {
+		if len(entries) > 1 {
+			log.Printf("[LDAP] multiple entries found for user '%s'", username)
+		}
+		return nil, errLDAPUserNotFound
+	}
+	return entries[0], nil
+}
+
+func (a *LDAPAuthenticator) findGroups(conn *ldap.Conn, dn, username string) ([]string, error) {
+	filter := strings.ReplaceAll(a.config.GroupFilter, "{dn}", ldap.EscapeFilter(dn))
+	filter = strings.ReplaceAll(filter, "{username}", ldap.EscapeFilter(username))
+	entries, e := conn.Search(ldap.SearchRequest{
+		BaseDN:     a.config.GroupBaseDN,
+		Scope:      ldap.ScopeWholeSubtree,
+		Filter:     filter,
+		Attributes: []string{a.config.GroupNameAttribute},
+	})
+	if e != nil {
+		return nil, e
+	}
+	groups := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if name := entry.GetAttributeValue(a.config.GroupNameAttribute); name != "" {
+			groups = append(groups, name)
+		}
+	}
+	return groups, nil
+}
+
+// syncGroups synchronizes the groups of the users owned by LDAP
+func (a *LDAPAuthenticator) syncGroups() {
+	users, e := a.userDAO.ListUser()
+	if e != nil {
+		log.Printf("[LDAP] failed to list users: %v", e)
+		return
+	}
+	conn, e := a.connect()
+	if e != nil {
+		log.Printf("[LDAP] failed to connect: %v", e)
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	synced := 0
+	for _, u := range users {
+		if !a.provisioner.owns(u) {
+			continue
+		}
+		entry, e := a.findUser(conn, u.Username)
+		if e == errLDAPUserNotFound {
+			continue
+		}
+		if e == nil {
+			var groups []string
+			groups, e = a.findGroups(conn, entry.DN, u.Username)
+			if e == nil {
+				e = a.syncUserGroups(u.Username, groups)
+			}
+		}
+		if e != nil {
+			log.Printf("[LDAP] failed to synchronize groups of user '%s': %v", u.Username, e)
+			continue
+		}
+		synced++
+	}
+	log.Printf("[LDAP] groups of %d users synchronized", synced)
+}
+
+func (a *LDAPAuthenticator) syncUserGroups(username string, groups []string) error {
+	user, e := a.userDAO.GetUser(username)
+	if e != nil {
+		if err.IsNotFoundError(e) {
+			return nil
+		}
+		return e
+	}
+	return a.provisioner.syncGroups(user, groups)
+}
+
+func (a *LDAPAuthenticator) Dispose() error {
+	if a.stopSync != nil {
+		a.stopSync()
+	}
+	return nil
+}
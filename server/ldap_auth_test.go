@@ -0,0 +1,425 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"go-drive/common"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"go-drive/storage"
+	"io"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ldapEntry is the entry in the directory stand-in, password is empty if the entry can not bind
+type ldapEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// ldapDirectory is a directory stand-in serving the LDAPv3 bind and search operations.
+// The messages are encoded by encoding/asn1 instead of the client's BER encoder.
+type ldapDirectory struct {
+	t       *testing.T
+	entries []ldapEntry
+}
+
+func newLDAPDirectory(t *testing.T) *ldapDirectory {
+	person := func(uid, password string) ldapEntry {
+		return ldapEntry{
+			dn: "uid=" + uid + ",ou=people,dc=example,dc=com", password: password,
+			attrs: map[string][]string{"objectclass": {"inetOrgPerson"}, "uid": {uid}},
+		}
+	}
+	group := func(cn string, members ...string) ldapEntry {
+		dns := make([]string, 0, len(members))
+		for _, m := range members {
+			dns = append(dns, "uid="+m+",ou=people,dc=example,dc=com")
+		}
+		return ldapEntry{
+			dn:    "cn=" + cn + ",ou=groups,dc=example,dc=com",
+			attrs: map[string][]string{"objectclass": {"groupOfNames"}, "cn": {cn}, "member": dns},
+		}
+	}
+	return &ldapDirectory{t: t, entries: []ldapEntry{
+		{dn: "cn=svc,dc=example,dc=com", password: "svc"},
+		person("alice", "alice-pass"),
+		person("Bob", "bob-pass"),
+		person("carol", "carol-pass"),
+		group("admin", "alice"),
+		group("developers", "alice", "Bob"),
+		group("drive-admins", "Bob"),
+	}}
+}
+
+// listen serves the directory on a local port, the connection is TLS if tlsConfig is not nil
+func (d *ldapDirectory) listen(tlsConfig *tls.Config) string {
+	l, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		d.t.Fatal(e)
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+	d.t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, e := l.Accept()
+			if e != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func (d *ldapDirectory) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	r := bufio.NewReader(conn)
+	for {
+		msg, e := readASN1(r)
+		if e != nil {
+			return
+		}
+		parts := asn1Children(msg.Bytes)
+		var id int
+		if len(parts) < 2 {
+			d.t.Errorf("malformed message %x", msg.FullBytes)
+			return
+		}
+		if _, e := asn1.Unmarshal(parts[0].FullBytes, &id); e != nil {
+			d.t.Errorf("malformed message id: %v", e)
+			return
+		}
+		op := parts[1]
+		if op.Class != asn1.ClassApplication {
+			d.t.Errorf("unexpected operation %x", op.FullBytes)
+			return
+		}
+		var responses [][]byte
+		switch op.Tag {
+		case 0:
+			responses = [][]byte{d.bind(op)}
+		case 2:
+			// unbind
+			return
+		case 3:
+			responses = d.search(op)
+		default:
+			d.t.Errorf("unexpected operation %d", op.Tag)
+			return
+		}
+		for _, resp := range responses {
+			if _, e := conn.Write(asn1Constructed(asn1.ClassUniversal, asn1.TagSequence, asn1Marshal(id), resp)); e != nil {
+				return
+			}
+		}
+	}
+}
+
+func (d *ldapDirectory) bind(op asn1.RawValue) []byte {
+	parts := asn1Children(op.Bytes)
+	dn, auth := string(parts[1].Bytes), parts[2]
+	if auth.Class != asn1.ClassContextSpecific || auth.Tag != 0 {
+		d.t.Errorf("expect the simple authentication, but is %x", auth.FullBytes)
+	}
+	for _, entry := range d.entries {
+		if entry.dn == dn && entry.password != "" && entry.password == string(auth.Bytes) {
+			return ldapResult(1, 0)
+		}
+	}
+	return ldapResult(1, 49)
+}
+
+func (d *ldapDirectory) search(op asn1.RawValue) [][]byte {
+	parts := asn1Children(op.Bytes)
+	base, filter := strings.ToLower(string(parts[0].Bytes)), parts[6]
+	attributes := make([]string, 0)
+	for _, a := range asn1Children(parts[7].Bytes) {
+		attributes = append(attributes, strings.ToLower(string(a.Bytes)))
+	}
+	responses := make([][]byte, 0)
+	for _, entry := range d.entries {
+		if !strings.HasSuffix(strings.ToLower(entry.dn), base) || !d.match(filter, entry) {
+			continue
+		}
+		attrs := make([]byte, 0)
+		for _, name := range attributes {
+			values, ok := entry.attrs[name]
+			if !ok {
+				continue
+			}
+			set := make([]byte, 0)
+			for _, v := range values {
+				set = append(set, asn1Marshal([]byte(v))...)
+			}
+			attrs = append(attrs, asn1Constructed(asn1.ClassUniversal, asn1.TagSequence,
+				asn1Marshal([]byte(name)), asn1Constructed(asn1.ClassUniversal, asn1.TagSet, set))...)
+		}
+		responses = append(responses, asn1Constructed(asn1.ClassApplication, 4,
+			asn1Marshal([]byte(entry.dn)), asn1Constructed(asn1.ClassUniversal, asn1.TagSequence, attrs)))
+	}
+	return append(responses, ldapResult(5, 0))
+}
+
+// match evaluates the and, or, not, equality and present filters
+func (d *ldapDirectory) match(filter asn1.RawValue, entry ldapEntry) bool {
+	if filter.Class != asn1.ClassContextSpecific {
+		d.t.Errorf("unexpected filter %x", filter.FullBytes)
+		return false
+	}
+	switch filter.Tag {
+	case 0, 1:
+		for _, f := range asn1Children(filter.Bytes) {
+			if d.match(f, entry) != (filter.Tag == 0) {
+				return filter.Tag != 0
+			}
+		}
+		return filter.Tag == 0
+	case 2:
+		return !d.match(asn1Children(filter.Bytes)[0], entry)
+	case 3:
+		parts := asn1Children(filter.Bytes)
+		for _, v := range entry.attrs[strings.ToLower(string(parts[0].Bytes))] {
+			if strings.EqualFold(v, string(parts[1].Bytes)) {
+				return true
+			}
+		}
+		return false
+	case 7:
+		_, ok := entry.attrs[strings.ToLower(string(filter.Bytes))]
+		return ok
+	}
+	d.t.Errorf("unsupported filter %x", filter.FullBytes)
+	return false
+}
+
+func ldapResult(tag int, code int) []byte {
+	return asn1Constructed(asn1.ClassApplication, tag,
+		asn1Marshal(asn1.Enumerated(code)), asn1Marshal([]byte("")), asn1Marshal([]byte("")))
+}
+
+func readASN1(r *bufio.Reader) (asn1.RawValue, error) {
+	header := make([]byte, 2)
+	if _, e := io.ReadFull(r, header); e != nil {
+		return asn1.RawValue{}, e
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		lb := make([]byte, length&0x7f)
+		if _, e := io.ReadFull(r, lb); e != nil {
+			return asn1.RawValue{}, e
+		}
+		header = append(header, lb...)
+		length = 0
+		for _, b := range lb {
+			length = length<<8 | int(b)
+		}
+	}
+	content := make([]byte, length)
+	if _, e := io.ReadFull(r, content); e != nil {
+		return asn1.RawValue{}, e
+	}
+	var v asn1.RawValue
+	_, e := asn1.Unmarshal(append(header, content...), &v)
+	return v, e
+}
+
+func asn1Children(b []byte) []asn1.RawValue {
+	children := make([]asn1.RawValue, 0)
+	for len(b) > 0 {
+		var v asn1.RawValue
+		rest, e := asn1.Unmarshal(b, &v)
+		if e != nil {
+			break
+		}
+		children = append(children, v)
+		b = rest
+	}
+	return children
+}
+
+func asn1Marshal(v interface{}) []byte {
+	b, e := asn1.Marshal(v)
+	if e != nil {
+		panic(e)
+	}
+	return b
+}
+
+func asn1Constructed(class, tag int, children ...[]byte) []byte {
+	return asn1Marshal(asn1.RawValue{Class: class, Tag: tag, IsCompound: true, Bytes: bytes.Join(children, nil)})
+}
+
+// newSelfSignedTLSConfig creates the TLS config of the server with a self-signed certificate
+func newSelfSignedTLSConfig(t *testing.T) *tls.Config {
+	key, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if e != nil {
+		t.Fatal(e)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, e := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func newTestLDAPAuthenticator(t *testing.T, url string,
+	mapping map[string]string) (*LDAPAuthenticator, *storage.UserDAO) {
+	_, db, ch := newTestDB(t)
+	userDAO := storage.NewUserDAO(db, ch)
+	groupDAO := storage.NewGroupDAO(db, ch)
+	if _, e := groupDAO.AddGroup(storage.GroupWithUsers{Group: types.Group{Name: "dev"}}); e != nil {
+		t.Fatal(e)
+	}
+	// the local user of the same name as the LDAP user
+	if _, e := userDAO.AddUser(types.User{Username: "carol", Password: "local"}); e != nil {
+		t.Fatal(e)
+	}
+	config := common.LDAPConfig{
+		URL:                url,
+		InsecureSkipVerify: true,
+		Timeout:            5 * time.Second,
+		BindDN:             "cn=svc,dc=example,dc=com",
+		BindPassword:       "svc",
+		UserBaseDN:         "ou=people,dc=example,dc=com",
+		UserFilter:         "(&(objectClass=inetOrgPerson)(uid={username}))",
+		UsernameAttribute:  "uid",
+		GroupBaseDN:        "ou=groups,dc=example,dc=com",
+		GroupFilter:        "(&(objectClass=groupOfNames)(member={dn}))",
+		GroupNameAttribute: "cn",
+		GroupMapping:       mapping,
+		AutoCreateUser:     true,
+	}
+	return NewLDAPAuthenticator(config, userDAO, groupDAO, registry.NewComponentHolder()), userDAO
+}
+
+func userGroupNames(user types.User) []string {
+	names := make([]string, 0, len(user.Groups))
+	for _, g := range user.Groups {
+		names = append(names, g.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	dir := newLDAPDirectory(t)
+	urls := map[string]string{
+		"ldap":  "ldap://" + dir.listen(nil),
+		"ldaps": "ldaps://" + dir.listen(newSelfSignedTLSConfig(t)),
+	}
+	for name, url := range urls {
+		a, _ := newTestLDAPAuthenticator(t, url, map[string]string{"developers": "dev", "drive-admins": "admin"})
+
+		user, e := a.Authenticate("alice", "alice-pass")
+		if e != nil || user == nil {
+			t.Fatalf("%s: expect alice to be authenticated, but is %v", name, e)
+		}
+		if user.Source != types.UserSourceLDAP {
+			t.Errorf("%s: expect the user to be created by LDAP, but is '%s'", name, user.Source)
+		}
+		// the LDAP group 'admin' is not mapped
+		if g := userGroupNames(*user); strings.Join(g, ",") != "dev" {
+			t.Errorf("%s: expect alice in groups [dev], but is %v", name, g)
+		}
+
+		// the username in LDAP is used
+		user, e = a.Authenticate("bob", "bob-pass")
+		if e != nil || user == nil {
+			t.Fatalf("%s: expect bob to be authenticated, but is %v", name, e)
+		}
+		if user.Username != "Bob" {
+			t.Errorf("%s: expect the username '%s', but is '%s'", name, "Bob", user.Username)
+		}
+		if g := userGroupNames(*user); strings.Join(g, ",") != "admin,dev" {
+			t.Errorf("%s: expect Bob in groups [admin dev], but is %v", name, g)
+		}
+
+		rejected := []struct{ username, password string }{
+			{"alice", "wrong"},
+			{"alice", ""},
+			{"nobody", "alice-pass"},
+			// the local user is not owned by LDAP
+			{"carol", "carol-pass"},
+			{"alice)(uid=*", "alice-pass"},
+		}
+		for _, r := range rejected {
+			user, e := a.Authenticate(r.username, r.password)
+			if e != nil || user != nil {
+				t.Errorf("%s: expect '%s' to be rejected, but is %v, %v", name, r.username, user, e)
+			}
+		}
+	}
+}
+
+func TestLDAPGroupsWithoutMapping(t *testing.T) {
+	dir := newLDAPDirectory(t)
+	a, userDAO := newTestLDAPAuthenticator(t, "ldap://"+dir.listen(nil), nil)
+
+	user, e := a.Authenticate("alice", "alice-pass")
+	if e != nil || user == nil {
+		t.Fatalf("expect alice to be authenticated, but is %v", e)
+	}
+	// the LDAP group 'admin' has the same name as the admin group, but it's never granted implicitly
+	if len(user.Groups) != 0 {
+		t.Errorf("expect no groups, but is %v", userGroupNames(*user))
+	}
+
+	// the groups granted locally are kept
+	if e := userDAO.UpdateUser("alice", types.User{Groups: []types.Group{{Name: "dev"}}}); e != nil {
+		t.Fatal(e)
+	}
+	if user, e = a.Authenticate("alice", "alice-pass"); e != nil || user == nil {
+		t.Fatalf("expect alice to be authenticated, but is %v", e)
+	}
+	if g := userGroupNames(*user); strings.Join(g, ",") != "dev" {
+		t.Errorf("expect the local groups [dev] to be kept, but is %v", g)
+	}
+}
+
+func TestLDAPSyncGroups(t *testing.T) {
+	dir := newLDAPDirectory(t)
+	a, userDAO := newTestLDAPAuthenticator(t, "ldap://"+dir.listen(nil), map[string]string{"developers": "dev"})
+	if _, e := userDAO.AddUser(types.User{Username: "alice", Password: "x", Source: types.UserSourceLDAP}); e != nil {
+		t.Fatal(e)
+	}
+	a.syncGroups()
+
+	alice, e := userDAO.GetUser("alice")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if g := userGroupNames(alice); strings.Join(g, ",") != "dev" {
+		t.Errorf("expect alice in groups [dev], but is %v", g)
+	}
+	// the local user is not synchronized
+	carol, e := userDAO.GetUser("carol")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(carol.Groups) != 0 {
+		t.Errorf("expect no groups of the local user, but is %v", userGroupNames(carol))
+	}
+}
@@ -0,0 +1,644 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-drive/common"
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/storage"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcHTTPTimeout = 30 * time.Second
+	// oidcStateValidity is how long the user can take to log in at the provider
+	oidcStateValidity = 10 * time.Minute
+	// oidcClockSkew is the tolerance of the expiry time of the ID token
+	oidcClockSkew = time.Minute
+	// oidcLoginCodeValidity is how long the browser can take to exchange the login code for a session token,
+	// including entering the two-factor authentication code
+	oidcLoginCodeValidity = 5 * time.Minute
+
+	// oidcBindingCookie is the cookie that binds the pending login to the browser
+	oidcBindingCookie = "oidc_binding"
+	// oidcLoginCodeParam is the query parameter of the login code in the redirect url
+	oidcLoginCodeParam = "oidc_code"
+)
+
+// OIDCAuth implements the OpenID Connect authorization code flow with PKCE
+type OIDCAuth struct {
+	config        common.OIDCConfig
+	passwordLogin bool
+
+	provisioner *userProvisioner
+	client      *http.Client
+
+	provider *oidcProvider
+	keys     map[string]crypto.PublicKey
+	// providerMu guards provider and keys
+	providerMu sync.Mutex
+
+	// states are the pending logins, keyed by the state parameter
+	states map[string]oidcState
+	// logins are the completed logins waiting for the session token exchange, keyed by the login code
+	logins map[string]oidcLogin
+	// stateMu guards states and logins
+	stateMu sync.Mutex
+}
+
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type oidcState struct {
+	// binding is the secret in the cookie of the browser which started the login
+	binding      string
+	nonce        string
+	codeVerifier string
+	redirect     string
+	expiresAt    time.Time
+}
+
+type oidcLogin struct {
+	user      types.User
+	binding   string
+	expiresAt time.Time
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func NewOIDCAuth(config common.Config, userDAO *storage.UserDAO, groupDAO *storage.GroupDAO,
+	ch *registry.ComponentsHolder) *OIDCAuth {
+	o := &OIDCAuth{
+		config:        config.Auth.OIDC,
+		passwordLogin: !config.Auth.DisablePasswordLogin,
+		provisioner: &userProvisioner{
+			userDAO:      userDAO,
+			groupDAO:     groupDAO,
+			source:       types.UserSourceOIDC,
+			autoCreate:   config.Auth.OIDC.AutoCreateUser,
+			linkExisting: config.Auth.OIDC.LinkExistingUsers,
+			groupMapping: config.Auth.OIDC.GroupMapping,
+		},
+		client: &http.Client{Timeout: oidcHTTPTimeout},
+		states: make(map[string]oidcState),
+		logins: make(map[string]oidcLogin),
+	}
+	ch.Add("oidcAuth", o)
+	return o
+}
+
+func (o *OIDCAuth) Enabled() bool {
+	return o.config.Enabled
+}
+
+// AuthURL creates a pending login and returns the authorization url of the provider
+// and the binding which must be kept by the browser until the login is completed.
+// redirect is where to go after logging in.
+func (o *OIDCAuth) AuthURL(ctx context.Context, redirect string) (string, string, error) {
+	if !o.config.Enabled {
+		return "", "", err.NewNotAllowedMessageError(i18n.T("api.auth.oidc_not_enabled"))
+	}
+	provider, e := o.getProvider(ctx)
+	if e != nil {
+		return "", "", e
+	}
+	state, e := randomString()
+	if e != nil {
+		return "", "", e
+	}
+	binding, e := randomString()
+	if e != nil {
+		return "", "", e
+	}
+	nonce, e := randomString()
+	if e != nil {
+		return "", "", e
+	}
+	verifier, e := randomString()
+	if e != nil {
+		return "", "", e
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	o.stateMu.Lock()
+	now := time.Now()
+	for k, s := range o.states {
+		if now.After(s.expiresAt) {
+			delete(o.states, k)
+		}
+	}
+	for k, l := range o.logins {
+		if now.After(l.expiresAt) {
+			delete(o.logins, k)
+		}
+	}
+	o.states[state] = oidcState{
+		binding:      binding,
+		nonce:        nonce,
+		codeVerifier: verifier,
+		redirect:     redirect,
+		expiresAt:    now.Add(oidcStateValidity),
+	}
+	o.stateMu.Unlock()
+
+	return o.oauth2Config(provider).AuthCodeURL(state,
+		oauth2.SetAuthURLParam("nonce", nonce),
+		oauth2.SetAuthURLParam("code_challenge", utils.Base64URLEncode(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	), binding, nil
+}
+
+// Callback completes the login of the state in the browser holding the binding,
+// it returns the login code to exchange for a session token and where to redirect.
+func (o *OIDCAuth) Callback(ctx context.Context, state, code, binding string) (string, string, error) {
+	if !o.config.Enabled {
+		return "", "", err.NewNotAllowedMessageError(i18n.T("api.auth.oidc_not_enabled"))
+	}
+	o.stateMu.Lock()
+	s, ok := o.states[state]
+	delete(o.states, state)
+	o.stateMu.Unlock()
+	if !ok || time.Now().After(s.expiresAt) || !bindingMatches(s.binding, binding) {
+		return "", "", err.NewBadRequestError(i18n.T("api.auth.invalid_oidc_state"))
+	}
+
+	provider, e := o.getProvider(ctx)
+	if e != nil {
+		return "", "", e
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, o.client)
+	t, e := o.oauth2Config(provider).Exchange(ctx, code,
+		oauth2.SetAuthURLParam("code_verifier", s.codeVerifier))
+	if e != nil {
+		return "", "", o.loginFailed(e)
+	}
+	rawIDToken, _ := t.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return "", "", o.loginFailed(errors.New("no id_token in the token response"))
+	}
+	claims, e := o.verifyIDToken(ctx, provider, rawIDToken, s.nonce)
+	if e != nil {
+		return "", "", o.loginFailed(e)
+	}
+	if provider.UserinfoEndpoint != "" {
+		if e := o.mergeUserinfo(ctx, provider, t, claims); e != nil {
+			return "", "", o.loginFailed(e)
+		}
+	}
+
+	user, e := o.provisionUser(claims)
+	if e != nil {
+		return "", "", e
+	}
+	loginCode, e := randomString()
+	if e != nil {
+		return "", "", e
+	}
+	o.stateMu.Lock()
+	o.logins[loginCode] = oidcLogin{
+		user:      user,
+		binding:   binding,
+		expiresAt: time.Now().Add(oidcLoginCodeValidity),
+	}
+	o.stateMu.Unlock()
+	return loginCode, s.redirect, nil
+}
+
+// Login consumes the login code in the browser holding the binding, it returns the logged-in user.
+// The login code is kept if verify fails, e.g. the two-factor authentication code is wrong.
+func (o *OIDCAuth) Login(loginCode, binding string, verify func(types.User) error) (types.User, error) {
+	o.stateMu.Lock()
+	l, ok := o.logins[loginCode]
+	if ok && time.Now().After(l.expiresAt) {
+		delete(o.logins, loginCode)
+		ok = false
+	}
+	o.stateMu.Unlock()
+	if !ok || !bindingMatches(l.binding, binding) {
+		return types.User{}, err.NewBadRequestError(i18n.T("api.auth.invalid_oidc_state"))
+	}
+	if e := verify(l.user); e != nil {
+		return types.User{}, e
+	}
+
+	o.stateMu.Lock()
+	_, ok = o.logins[loginCode]
+	delete(o.logins, loginCode)
+	o.stateMu.Unlock()
+	// the login code has been used by another request
+	if !ok {
+		return types.User{}, err.NewBadRequestError(i18n.T("api.auth.invalid_oidc_state"))
+	}
+	return l.user, nil
+}
+
+func bindingMatches(expected, actual string) bool {
+	return actual != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) == 1
+}
+
+func (o *OIDCAuth) loginFailed(e error) error {
+	return err.NewUnauthorizedError(i18n.T("api.auth.oidc_login_failed", e.Error()))
+}
+
+func (o *OIDCAuth) oauth2Config(provider *oidcProvider) *oauth2.Config {
+	scopes := []string{"openid"}
+	for _, s := range o.config.Scopes {
+		if s != "openid" {
+			scopes = append(scopes, s)
+		}
+	}
+	return &oauth2.Config{
+		ClientID:     o.config.ClientID,
+		ClientSecret: o.config.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  provider.AuthorizationEndpoint,
+			TokenURL: provider.TokenEndpoint,
+		},
+		RedirectURL: o.config.RedirectURI,
+		Scopes:      scopes,
+	}
+}
+
+// provisionUser gets the user linked to the subject of the claims, or provisions the user of the username claim
+// and links it to the subject. The groups of the user are synchronized if the groups are mapped.
+func (o *OIDCAuth) provisionUser(claims map[string]interface{}) (types.User, error) {
+	issuer, _ := claims["iss"].(string)
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return types.User{}, o.loginFailed(errors.New("no subject in the claims"))
+	}
+	groups := o.claimGroups(claims)
+
+	user, e := o.provisioner.userDAO.GetUserByIdentity(issuer, subject)
+	if e == nil {
+		if groups != nil {
+			if e := o.provisioner.syncGroups(user, groups); e != nil {
+				return types.User{}, e
+			}
+			return o.provisioner.userDAO.GetUser(user.Username)
+		}
+		return user, nil
+	}
+	if !err.IsNotFoundError(e) {
+		return types.User{}, e
+	}
+
+	username, _ := claims[o.config.UsernameClaim].(string)
+	if username == "" || len(username) > 32 {
+		return types.User{}, err.NewNotAllowedMessageError(
+			i18n.T("api.auth.oidc_invalid_username", o.config.UsernameClaim))
+	}
+	// the user is linked to another subject
+	linked, e := o.provisioner.userDAO.HasIdentity(username)
+	if e != nil {
+		return types.User{}, e
+	}
+	if linked {
+		return types.User{}, err.NewNotAllowedMessageError(i18n.T("api.auth.user_not_linkable", username))
+	}
+	user, e = o.provisioner.provision(username, groups)
+	if e != nil {
+		return types.User{}, e
+	}
+	if e := o.provisioner.userDAO.AddIdentity(types.UserIdentity{
+		Issuer: issuer, Subject: subject, Username: user.Username,
+	}); e != nil {
+		return types.User{}, e
+	}
+	return user, nil
+}
+
+// claimGroups gets the groups in the claims, it returns nil if the groups are not synchronized
+func (o *OIDCAuth) claimGroups(claims map[string]interface{}) []string {
+	// the groups are granted by the explicit mapping only
+	if o.config.GroupsClaim == "" || len(o.config.GroupMapping) == 0 {
+		return nil
+	}
+	groups := make([]string, 0)
+	switch v := claimByPath(claims, o.config.GroupsClaim).(type) {
+	case string:
+		groups = append(groups, v)
+	case []interface{}:
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	}
+	return groups
+}
+
+// claimByPath gets the claim by the dot separated path
+func claimByPath(claims map[string]interface{}, path string) interface{} {
+	var v interface{} = claims
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+func (o *OIDCAuth) verifyIDToken(ctx context.Context, provider *oidcProvider,
+	rawToken, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id_token")
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if e := decodeJWTPart(parts[0], &header); e != nil {
+		return nil, e
+	}
+	signature, e := base64.RawURLEncoding.DecodeString(parts[2])
+	if e != nil {
+		return nil, e
+	}
+	key, e := o.getKey(ctx, provider, header.Kid)
+	if e != nil {
+		return nil, e
+	}
+	if e := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); e != nil {
+		return nil, e
+	}
+
+	claims := make(map[string]interface{})
+	if e := decodeJWTPart(parts[1], &claims); e != nil {
+		return nil, e
+	}
+	if iss, _ := claims["iss"].(string); iss != provider.Issuer {
+		return nil, fmt.Errorf("unexpected issuer '%s'", iss)
+	}
+	audOk := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audOk = aud == o.config.ClientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == o.config.ClientID {
+				audOk = true
+			}
+		}
+	}
+	if !audOk {
+		return nil, errors.New("unexpected audience")
+	}
+	exp, _ := claims["exp"].(float64)
+	if time.Unix(int64(exp), 0).Add(oidcClockSkew).Before(time.Now()) {
+		return nil, errors.New("id_token expired")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("unexpected nonce")
+	}
+	return claims, nil
+}
+
+// mergeUserinfo adds the claims from the userinfo endpoint that are not in the ID token
+func (o *OIDCAuth) mergeUserinfo(ctx context.Context, provider *oidcProvider,
+	t *oauth2.Token, claims map[string]interface{}) error {
+	r, e := http.NewRequestWithContext(ctx, http.MethodGet, provider.UserinfoEndpoint, nil)
+	if e != nil {
+		return e
+	}
+	t.SetAuthHeader(r)
+	userinfo := make(map[string]interface{})
+	if e := o.getJSON(r, &userinfo); e != nil {
+		return e
+	}
+	if userinfo["sub"] != claims["sub"] {
+		return errors.New("unexpected subject of userinfo")
+	}
+	for k, v := range userinfo {
+		if _, ok := claims[k]; !ok {
+			claims[k] = v
+		}
+	}
+	return nil
+}
+
+func (o *OIDCAuth) getProvider(ctx context.Context) (*oidcProvider, error) {
+	o.providerMu.Lock()
+	defer o.providerMu.Unlock()
+	if o.provider != nil {
+		return o.provider, nil
+	}
+	r, e := http.NewRequestWithContext(ctx, http.MethodGet,
+		strings.TrimSuffix(o.config.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if e != nil {
+		return nil, e
+	}
+	p := &oidcProvider{}
+	if e := o.getJSON(r, p); e != nil {
+		return nil, o.loginFailed(e)
+	}
+	if p.Issuer != o.config.Issuer {
+		return nil, o.loginFailed(fmt.Errorf("unexpected issuer '%s' of the provider", p.Issuer))
+	}
+	o.provider = p
+	return p, nil
+}
+
+// getKey gets the signing key by kid, the keys are fetched again if the key is not found
+func (o *OIDCAuth) getKey(ctx context.Context, provider *oidcProvider, kid string) (crypto.PublicKey, error) {
+	o.providerMu.Lock()
+	defer o.providerMu.Unlock()
+	if key, ok := o.findKey(kid); ok {
+		return key, nil
+	}
+	r, e := http.NewRequestWithContext(ctx, http.MethodGet, provider.JwksURI, nil)
+	if e != nil {
+		return nil, e
+	}
+	jwks := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if e := o.getJSON(r, &jwks); e != nil {
+		return nil, e
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, e := k.publicKey(); e == nil {
+			keys[k.Kid] = key
+		}
+	}
+	o.keys = keys
+	if key, ok := o.findKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("signing key '%s' not found", kid)
+}
+
+func (o *OIDCAuth) findKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(o.keys) == 1 {
+		for _, key := range o.keys {
+			return key, true
+		}
+	}
+	key, ok := o.keys[kid]
+	return key, ok
+}
+
+func (o *OIDCAuth) getJSON(r *http.Request, v interface{}) error {
+	r.Header.Set("Accept", "application/json")
+	resp, e := o.client.Do(r)
+	if e != nil {
+		return e
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, r.URL.String())
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(v)
+}
+
+func (o *OIDCAuth) SysConfig() (string, types.M, error) {
+	return "auth", types.M{
+		"passwordLogin": o.passwordLogin,
+		"oidc":          o.config.Enabled,
+	}, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, e := base64.RawURLEncoding.DecodeString(k.N)
+		if e != nil {
+			return nil, e
+		}
+		exp, e := base64.RawURLEncoding.DecodeString(k.E)
+		if e != nil {
+			return nil, e
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(exp).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+		x, e := base64.RawURLEncoding.DecodeString(k.X)
+		if e != nil {
+			return nil, e
+		}
+		y, e := base64.RawURLEncoding.DecodeString(k.Y)
+		if e != nil {
+			return nil, e
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type '%s'", k.Kty)
+}
+
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm '%s'", alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+	if hash == 0 {
+		return fmt.Errorf("unsupported algorithm '%s'", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("invalid key type")
+		}
+		if alg[0] == 'P' {
+			return rsa.VerifyPSS(k, hash, digest, signature, nil)
+		}
+		return rsa.VerifyPKCS1v15(k, hash, digest, signature)
+	case "ES":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("invalid key type")
+		}
+		// the signature is the fixed-size r and s of the curve
+		if len(signature) != 2*((k.Curve.Params().BitSize+7)/8) {
+			return errors.New("invalid signature")
+		}
+		r := new(big.Int).SetBytes(signature[:len(signature)/2])
+		s := new(big.Int).SetBytes(signature[len(signature)/2:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm '%s'", alg)
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, e := base64.RawURLEncoding.DecodeString(part)
+	if e != nil {
+		return e
+	}
+	return json.Unmarshal(b, v)
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, e := rand.Read(b); e != nil {
+		return "", e
+	}
+	return utils.Base64URLEncode(b), nil
+}
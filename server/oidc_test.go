@@ -0,0 +1,336 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"go-drive/common"
+	err "go-drive/common/errors"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"go-drive/storage"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testOIDCIssuer is an OpenID provider stand-in with the discovery, JWKS, token and userinfo endpoints
+type testOIDCIssuer struct {
+	server *httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+
+	mu sync.Mutex
+	// idToken is returned by the token endpoint
+	idToken string
+	// codeVerifier is the PKCE verifier received by the token endpoint
+	codeVerifier string
+}
+
+func newTestOIDCIssuer(t *testing.T) *testOIDCIssuer {
+	rsaKey, e := rsa.GenerateKey(rand.Reader, 2048)
+	if e != nil {
+		t.Fatal(e)
+	}
+	ecKey, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if e != nil {
+		t.Fatal(e)
+	}
+	i := &testOIDCIssuer{rsaKey: rsaKey, ecKey: ecKey}
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, map[string]string{
+			"issuer":                 i.server.URL,
+			"authorization_endpoint": i.server.URL + "/auth",
+			"token_endpoint":         i.server.URL + "/token",
+			"userinfo_endpoint":      i.server.URL + "/userinfo",
+			"jwks_uri":               i.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, map[string]interface{}{"keys": []map[string]string{
+			{"kid": "rsa1", "kty": "RSA", "use": "sig",
+				"n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kid": "ec1", "kty": "EC", "crv": "P-256",
+				"x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+			// the encryption key is ignored
+			{"kid": "enc1", "kty": "RSA", "use": "enc",
+				"n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		i.codeVerifier = r.PostFormValue("code_verifier")
+		if r.PostFormValue("code") != "code" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		writeTestJSON(w, map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": i.idToken})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer at" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writeTestJSON(w, map[string]string{"sub": "s1", "preferred_username": "alice"})
+	})
+	i.server = httptest.NewServer(mux)
+	t.Cleanup(i.server.Close)
+	return i
+}
+
+func (i *testOIDCIssuer) setIDToken(token string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.idToken = token
+}
+
+func writeTestJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// sign makes the JWT of the header alg and kid, which is signed by signAlg
+func (i *testOIDCIssuer) sign(t *testing.T, alg, signAlg, kid string, claims map[string]interface{}) string {
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	var e error
+	switch signAlg {
+	case "RS256":
+		signature, e = rsa.SignPKCS1v15(rand.Reader, i.rsaKey, crypto.SHA256, digest[:])
+	case "PS256":
+		signature, e = rsa.SignPSS(rand.Reader, i.rsaKey, crypto.SHA256, digest[:], nil)
+	case "ES256":
+		var r, s *big.Int
+		r, s, e = ecdsa.Sign(rand.Reader, i.ecKey, digest[:])
+		if e == nil {
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	case "HS256":
+		// the public key is used as the HMAC secret
+		m := hmac.New(sha256.New, i.rsaKey.N.Bytes())
+		m.Write([]byte(signed))
+		signature = m.Sum(nil)
+	case "none":
+	}
+	if e != nil {
+		t.Fatal(e)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func newTestOIDCAuth(t *testing.T, issuer string) *OIDCAuth {
+	config, db, ch := newTestDB(t)
+	config.Auth.OIDC = common.OIDCConfig{
+		Enabled:        true,
+		Issuer:         issuer,
+		ClientID:       "client",
+		ClientSecret:   "secret",
+		RedirectURI:    "http://drive/api/auth/oidc/callback",
+		UsernameClaim:  "preferred_username",
+		AutoCreateUser: true,
+	}
+	return NewOIDCAuth(config, storage.NewUserDAO(db, ch), storage.NewGroupDAO(db, ch), registry.NewComponentHolder())
+}
+
+// startOIDCLogin starts the login, returns the state, the nonce, the code challenge and the binding
+func startOIDCLogin(t *testing.T, o *OIDCAuth) (string, string, string, string) {
+	authURL, binding, e := o.AuthURL(context.Background(), "/files")
+	if e != nil {
+		t.Fatal(e)
+	}
+	u, e := url.Parse(authURL)
+	if e != nil {
+		t.Fatal(e)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != "client" {
+		t.Errorf("unexpected authorization url '%s'", authURL)
+	}
+	return q.Get("state"), q.Get("nonce"), q.Get("code_challenge"), binding
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer := newTestOIDCIssuer(t)
+	o := newTestOIDCAuth(t, issuer.server.URL)
+
+	state, nonce, challenge, binding := startOIDCLogin(t, o)
+	issuer.setIDToken(issuer.sign(t, "RS256", "RS256", "rsa1", map[string]interface{}{
+		"iss": issuer.server.URL, "aud": "client", "sub": "s1", "nonce": nonce,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}))
+	code, redirect, e := o.Callback(context.Background(), state, "code", binding)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if redirect != "/files" {
+		t.Errorf("expect redirect '%s', but is '%s'", "/files", redirect)
+	}
+	issuer.mu.Lock()
+	verifier := sha256.Sum256([]byte(issuer.codeVerifier))
+	issuer.mu.Unlock()
+	if base64.RawURLEncoding.EncodeToString(verifier[:]) != challenge {
+		t.Error("expect the code verifier of the code challenge")
+	}
+	// the state is used only once
+	if _, _, e := o.Callback(context.Background(), state, "code", binding); !isBadRequestError(e) {
+		t.Errorf("expect the used state to be rejected, but is %v", e)
+	}
+
+	// the login code is consumed by the browser holding the binding only
+	if _, e := o.Login(code, "other", func(types.User) error { return nil }); !isBadRequestError(e) {
+		t.Errorf("expect the mismatched binding to be rejected, but is %v", e)
+	}
+	user, e := o.Login(code, binding, func(types.User) error { return nil })
+	if e != nil {
+		t.Fatal(e)
+	}
+	// the username is from the userinfo
+	if user.Username != "alice" || user.Source != types.UserSourceOIDC {
+		t.Errorf("unexpected user '%s' of source '%s'", user.Username, user.Source)
+	}
+	if _, e := o.Login(code, binding, func(types.User) error { return nil }); !isBadRequestError(e) {
+		t.Errorf("expect the used login code to be rejected, but is %v", e)
+	}
+}
+
+func TestOIDCBinding(t *testing.T) {
+	issuer := newTestOIDCIssuer(t)
+	o := newTestOIDCAuth(t, issuer.server.URL)
+
+	for _, binding := range []string{"", "other"} {
+		state, nonce, _, _ := startOIDCLogin(t, o)
+		issuer.setIDToken(issuer.sign(t, "RS256", "RS256", "rsa1", map[string]interface{}{
+			"iss": issuer.server.URL, "aud": "client", "sub": "s1", "nonce": nonce,
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}))
+		if _, _, e := o.Callback(context.Background(), state, "code", binding); !isBadRequestError(e) {
+			t.Errorf("binding '%s': expect to be rejected, but is %v", binding, e)
+		}
+	}
+}
+
+func TestOIDCVerifyIDToken(t *testing.T) {
+	issuer := newTestOIDCIssuer(t)
+	o := newTestOIDCAuth(t, issuer.server.URL)
+
+	claimsOf := func(nonce string, modify func(map[string]interface{})) map[string]interface{} {
+		claims := map[string]interface{}{
+			"iss": issuer.server.URL, "aud": "client", "sub": "s1", "nonce": nonce,
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		if modify != nil {
+			modify(claims)
+		}
+		return claims
+	}
+	cases := []struct {
+		name  string
+		valid bool
+		token func(nonce string) string
+	}{
+		{"RS256", true, func(n string) string {
+			return issuer.sign(t, "RS256", "RS256", "rsa1", claimsOf(n, nil))
+		}},
+		{"PS256", true, func(n string) string {
+			return issuer.sign(t, "PS256", "PS256", "rsa1", claimsOf(n, nil))
+		}},
+		{"ES256", true, func(n string) string {
+			return issuer.sign(t, "ES256", "ES256", "ec1", claimsOf(n, nil))
+		}},
+		{"audience in array", true, func(n string) string {
+			return issuer.sign(t, "RS256", "RS256", "rsa1", claimsOf(n, func(c map[string]interface{}) {
+				c["aud"] = []string{"other", "client"}
+			}))
+		}},
+		{"wrong alg", false, func(n string) string {
+			return issuer.sign(t, "RS512", "RS256", "rsa1", claimsOf(n, nil))
+		}},
+		{"alg of other key type", false, func(n string) string {
+			return issuer.sign(t, "ES256", "ES256", "rsa1", claimsOf(n, nil))
+		}},
+		{"HMAC by the public key", false, func(n string) string {
+			return issuer.sign(t, "HS256", "HS256", "rsa1", claimsOf(n, nil))
+		}},
+		{"alg none", false, func(n string) string {
+			return issuer.sign(t, "none", "none", "rsa1", claimsOf(n, nil))
+		}},
+		{"unknown kid", false, func(n string) string {
+			return issuer.sign(t, "RS256", "RS256", "rsa2", claimsOf(n, nil))
+		}},
+		{"encryption key", false, func(n string) string {
+			return issuer.sign(t, "RS256", "RS256", "enc1", claimsOf(n, nil))
+		}},
+		{"tampered claims", false, func(n string) string {
+			token := issuer.sign(t, "RS256", "RS256", "rsa1", claimsOf(n, nil))
+			parts := strings.Split(token, ".")
+			c, _ := json.Marshal(claimsOf(n, func(c map[string]interface{}) { c["sub"] = "s2" }))
+			return parts[0] + "." + base64.RawURLEncoding.EncodeToString(c) + "." + parts[2]
+		}},
+		{"bad audience", false, func(n string) string {
+			return issuer.sign(t, "RS256", "RS256", "rsa1", claimsOf(n, func(c map[string]interface{}) {
+				c["aud"] = "other"
+			}))
+		}},
+		{"bad issuer", false, func(n string) string {
+			return issuer.sign(t, "RS256", "RS256", "rsa1", claimsOf(n, func(c map[string]interface{}) {
+				c["iss"] = "https://other"
+			}))
+		}},
+		{"expired", false, func(n string) string {
+			return issuer.sign(t, "RS256", "RS256", "rsa1", claimsOf(n, func(c map[string]interface{}) {
+				c["exp"] = time.Now().Add(-2 * oidcClockSkew).Unix()
+			}))
+		}},
+		{"no expiry", false, func(n string) string {
+			return issuer.sign(t, "RS256", "RS256", "rsa1", claimsOf(n, func(c map[string]interface{}) {
+				delete(c, "exp")
+			}))
+		}},
+		{"nonce mismatch", false, func(n string) string {
+			return issuer.sign(t, "RS256", "RS256", "rsa1", claimsOf(n+"x", nil))
+		}},
+		{"malformed", false, func(n string) string {
+			return "a.b"
+		}},
+	}
+	for _, c := range cases {
+		state, nonce, _, binding := startOIDCLogin(t, o)
+		issuer.setIDToken(c.token(nonce))
+		_, _, e := o.Callback(context.Background(), state, "code", binding)
+		if c.valid && e != nil {
+			t.Errorf("%s: unexpected error %v", c.name, e)
+		}
+		if !c.valid && !err.IsUnauthorizedError(e) {
+			t.Errorf("%s: expect to be rejected, but is %v", c.name, e)
+		}
+	}
+}
+
+func isBadRequestError(e error) bool {
+	_, ok := e.(err.BadRequestError)
+	return ok
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"go-drive/storage"
+)
+
+// userProvisioner creates the local users of the external identity providers, and synchronizes their groups
+type userProvisioner struct {
+	userDAO  *storage.UserDAO
+	groupDAO *storage.GroupDAO
+	// source is the types.User.Source of the users created by this provisioner
+	source string
+	// autoCreate creates the user if the user does not exist
+	autoCreate bool
+	// linkExisting allows the users from other sources to be provisioned
+	linkExisting bool
+	// groupMapping maps the external group names to the group names, the unmapped groups are ignored.
+	// The external group names are never used as they are, so no group can be granted without the mapping.
+	groupMapping map[string]string
+}
+
+// provision gets or creates the user, the groups of the user are replaced by groups if it's not nil
+func (p *userProvisioner) provision(username string, groups []string) (types.User, error) {
+	user, e := p.userDAO.GetUser(username)
+	if e != nil {
+		if !err.IsNotFoundError(e) {
+			return types.User{}, e
+		}
+		if !p.autoCreate {
+			return types.User{}, err.NewNotAllowedMessageError(i18n.T("api.auth.user_not_provisioned", username))
+		}
+		// the user can not log in by the local password unless it's reset by the administrator
+		password, e := randomString()
+		if e != nil {
+			return types.User{}, e
+		}
+		user, e = p.userDAO.AddUser(types.User{Username: username, Password: password, Source: p.source})
+		if e != nil {
+			return types.User{}, e
+		}
+	} else if !p.owns(user) {
+		return types.User{}, err.NewNotAllowedMessageError(i18n.T("api.auth.user_not_linkable", username))
+	}
+	if groups != nil {
+		if e := p.syncGroups(user, groups); e != nil {
+			return types.User{}, e
+		}
+	}
+	return p.userDAO.GetUser(username)
+}
+
+// canProvision returns true if the user exists and is owned by this provisioner,
+// or the user does not exist and can be created
+func (p *userProvisioner) canProvision(username string) (bool, error) {
+	user, e := p.userDAO.GetUser(username)
+	if e != nil {
+		if err.IsNotFoundError(e) {
+			return p.autoCreate, nil
+		}
+		return false, e
+	}
+	return p.owns(user), nil
+}
+
+// owns returns true if the existing user can be provisioned
+func (p *userProvisioner) owns(user types.User) bool {
+	return p.linkExisting || user.Source == p.source
+}
+
+// syncGroups replaces the groups of the existing user by the mapped groups
+func (p *userProvisioner) syncGroups(user types.User, groups []string) error {
+	mapped, e := p.mapGroups(groups)
+	if e != nil {
+		return e
+	}
+	return p.userDAO.UpdateUser(user.Username, types.User{RootPath: user.RootPath, Groups: mapped})
+}
+
+// mapGroups maps the external groups to the existing groups by the explicit mapping
+func (p *userProvisioner) mapGroups(names []string) ([]types.Group, error) {
+	existing, e := p.groupDAO.ListGroup()
+	if e != nil {
+		return nil, e
+	}
+	exists := make(map[string]bool, len(existing))
+	for _, g := range existing {
+		exists[g.Name] = true
+	}
+
+	groups := make([]types.Group, 0)
+	added := make(map[string]bool)
+	for _, external := range names {
+		name, ok := p.groupMapping[external]
+		if !ok {
+			continue
+		}
+		if exists[name] && !added[name] {
+			added[name] = true
+			groups = append(groups, types.Group{Name: name})
+		}
+	}
+	return groups, nil
+}
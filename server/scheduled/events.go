@@ -0,0 +1,155 @@
+package scheduled
+
+import (
+	"context"
+	"go-drive/common/types"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+const (
+	EventEntryUpdated = "entry.updated"
+	EventEntryDeleted = "entry.deleted"
+
+	defaultDebounce = time.Second
+	// maxDebounceTimes limits the delay of a batch when the events keep coming,
+	// the batch is executed no later than maxDebounceTimes * debounce after its first event
+	maxDebounceTimes = 10
+)
+
+// Events are the event types can trigger jobs
+var Events = []string{EventEntryUpdated, EventEntryDeleted}
+
+// JobEvent is the drive event that triggers the job
+type JobEvent struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+	// User is the username of the user who triggered the event
+	User string `json:"user"`
+	// Timestamp is unix timestamp in milliseconds
+	Timestamp int64 `json:"timestamp"`
+}
+
+type jobEventsKey struct{}
+
+// GetJobEvents returns the events that triggered the job execution, or nil if it's executed by cron
+func GetJobEvents(ctx context.Context) []JobEvent {
+	events, _ := ctx.Value(jobEventsKey{}).([]JobEvent)
+	return events
+}
+
+func withJobEvents(ctx context.Context, events []JobEvent) context.Context {
+	return context.WithValue(ctx, jobEventsKey{}, events)
+}
+
+// eventTrigger coalesces the events of a job, and executes the job when no more events come in debounce
+type eventTrigger struct {
+	job      types.Job
+	debounce time.Duration
+	// sem limits the concurrent executions
+	sem     chan struct{}
+	execute func(types.Job, []JobEvent)
+
+	events  []JobEvent
+	first   time.Time
+	timer   *time.Timer
+	stopped bool
+	mu      sync.Mutex
+}
+
+func newEventTrigger(job types.Job, execute func(types.Job, []JobEvent)) *eventTrigger {
+	t := &eventTrigger{execute: execute}
+	t.update(job)
+	return t
+}
+
+func (t *eventTrigger) update(job types.Job) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.job = job
+	t.debounce = defaultDebounce
+	if job.Debounce != "" {
+		t.debounce = types.SV(job.Debounce).Duration(defaultDebounce)
+	}
+	concurrency := job.MaxConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	if t.sem == nil || cap(t.sem) != concurrency {
+		t.sem = make(chan struct{}, concurrency)
+	}
+}
+
+func (t *eventTrigger) matches(eventType, path string) bool {
+	subscribed := false
+	for _, ev := range strings.Split(t.job.Events, ",") {
+		if strings.TrimSpace(ev) == eventType {
+			subscribed = true
+			break
+		}
+	}
+	if !subscribed {
+		return false
+	}
+	if t.job.PathPattern == "" {
+		return true
+	}
+	ok, _ := doublestar.Match(t.job.PathPattern, path)
+	return ok
+}
+
+// add adds the event to the pending batch, the same event of the same path is coalesced
+func (t *eventTrigger) add(ev JobEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped || !t.matches(ev.Type, ev.Path) {
+		return
+	}
+	for i, e := range t.events {
+		if e.Type == ev.Type && e.Path == ev.Path {
+			t.events = append(t.events[:i], t.events[i+1:]...)
+			break
+		}
+	}
+	t.events = append(t.events, ev)
+
+	if t.timer == nil {
+		t.first = time.Now()
+		t.timer = time.AfterFunc(t.debounce, t.flush)
+		return
+	}
+	delay := t.debounce
+	if remaining := time.Until(t.first.Add(maxDebounceTimes * t.debounce)); remaining < delay {
+		delay = remaining
+	}
+	t.timer.Reset(delay)
+}
+
+// flush executes the job with the pending events, it waits if the executions reach the max concurrency
+func (t *eventTrigger) flush() {
+	t.mu.Lock()
+	events, job, sem := t.events, t.job, t.sem
+	t.events, t.timer = nil, nil
+	stopped := t.stopped
+	t.mu.Unlock()
+	if stopped || len(events) == 0 {
+		return
+	}
+	sem <- struct{}{}
+	defer func() { <-sem }()
+	t.execute(job, events)
+}
+
+// stop discards the pending events
+func (t *eventTrigger) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.events, t.timer = nil, nil
+}
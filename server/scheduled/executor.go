@@ -5,40 +5,68 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"go-drive/common"
 	err "go-drive/common/errors"
+	"go-drive/common/event"
+	"go-drive/common/i18n"
 	"go-drive/common/registry"
 	"go-drive/common/types"
+	"go-drive/common/utils"
 	"go-drive/storage"
 	"log"
+	"net/mail"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/bmatcuk/doublestar/v4"
 	"github.com/go-co-op/gocron"
 	"github.com/robfig/cron/v3"
 )
 
+const (
+	defaultRetryBackoff = 10 * time.Second
+	maxRetryBackoff     = time.Hour
+	maxRetries          = 100
+	// stopGracePeriod is how long to wait for the job to return after it's canceled or timed out
+	stopGracePeriod = 30 * time.Second
+)
+
 type JobExecutor struct {
 	ch           *registry.ComponentsHolder
 	s            *gocron.Scheduler
 	scheduledDAO *storage.ScheduledDAO
 
 	executions map[uint]*jobExecutionItem
+	// triggers are the event triggers of the jobs, keyed by job id
+	triggers map[uint]*eventTrigger
+	notifier *notifier
+	// disposed is closed when the executor is disposed, to stop the pending retries
+	disposed chan struct{}
 
 	mu sync.Mutex
 }
 
-func NewJobExecutor(scheduledDAO *storage.ScheduledDAO, ch *registry.ComponentsHolder) (*JobExecutor, error) {
+func NewJobExecutor(config common.Config, scheduledDAO *storage.ScheduledDAO, bus event.Bus,
+	ms i18n.MessageSource, ch *registry.ComponentsHolder) (*JobExecutor, error) {
+	n, e := newNotifier(config, ms)
+	if e != nil {
+		return nil, e
+	}
 	executor := &JobExecutor{
 		ch:           ch,
 		s:            gocron.NewScheduler(time.Local),
 		scheduledDAO: scheduledDAO,
 		executions:   make(map[uint]*jobExecutionItem),
+		triggers:     make(map[uint]*eventTrigger),
+		notifier:     n,
+		disposed:     make(chan struct{}),
 	}
 	executor.s.TagsUnique()
 
-	e := executor.ReloadJobs()
+	e = executor.ReloadJobs()
 	if e != nil {
 		return nil, e
 	}
@@ -47,6 +75,9 @@ func NewJobExecutor(scheduledDAO *storage.ScheduledDAO, ch *registry.ComponentsH
 
 	_ = scheduledDAO.UpdateAllRunningJobExecutionsToFailed()
 
+	bus.Subscribe(event.EntryUpdated, executor.onUpdated)
+	bus.Subscribe(event.EntryDeleted, executor.onDeleted)
+
 	ch.Add("jobExecutor", executor)
 	return executor, nil
 }
@@ -62,7 +93,29 @@ func (je *JobExecutor) ReloadJobs() error {
 
 	je.s.Clear()
 
+	triggers := make(map[uint]*eventTrigger)
+	for _, job := range jobs {
+		if job.Events == "" {
+			continue
+		}
+		if t, ok := je.triggers[job.ID]; ok {
+			t.update(job)
+			triggers[job.ID] = t
+		} else {
+			triggers[job.ID] = newEventTrigger(job, je.execute)
+		}
+	}
+	for id, t := range je.triggers {
+		if _, ok := triggers[id]; !ok {
+			t.stop()
+		}
+	}
+	je.triggers = triggers
+
 	for _, job := range jobs {
+		if job.Schedule == "" {
+			continue
+		}
 		job, e := je.s.
 			Cron(job.Schedule).
 			Tag(strconv.FormatUint(uint64(job.ID), 10)).
@@ -78,19 +131,105 @@ func (je *JobExecutor) ReloadJobs() error {
 }
 
 func (je *JobExecutor) jobExecutor(job types.Job) {
+	je.execute(job, nil)
+}
+
+func (je *JobExecutor) onUpdated(dc types.DriveListenerContext, path string, _ bool) {
+	je.onEvent(EventEntryUpdated, dc, path)
+}
+
+func (je *JobExecutor) onDeleted(dc types.DriveListenerContext, path string) {
+	je.onEvent(EventEntryDeleted, dc, path)
+}
+
+func (je *JobExecutor) onEvent(eventType string, dc types.DriveListenerContext, path string) {
+	// the events without session are caused by the jobs or the system,
+	// they do not trigger jobs to avoid loops
+	if dc.Session == nil {
+		return
+	}
+	ev := JobEvent{Type: eventType, Path: path, User: dc.Session.User.Username, Timestamp: time.Now().UnixMilli()}
+	je.mu.Lock()
+	defer je.mu.Unlock()
+	for _, t := range je.triggers {
+		t.add(ev)
+	}
+}
+
+// execute executes the job and retries it if failed,
+// events are the events that triggered this execution, or nil if it's executed by cron
+func (je *JobExecutor) execute(job types.Job, events []JobEvent) {
+	backoff := defaultRetryBackoff
+	if job.RetryBackoff != "" {
+		backoff = types.SV(job.RetryBackoff).Duration(defaultRetryBackoff)
+	}
+	var item *jobExecutionItem
+	for attempt := 1; ; attempt++ {
+		item = je.executeAttempt(job, events, attempt)
+		if item == nil {
+			return
+		}
+		if item.Status == types.JobExecutionSuccess || item.aborted.Load() || item.unstopped ||
+			attempt > job.MaxRetries {
+			break
+		}
+		log.Printf("[JobExecutor] job %d failed, retry in %s", job.ID, backoff)
+		select {
+		case <-je.disposed:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+
+	je.notifier.notify(job, *item.JobExecution, func(e error) {
+		item.logger.Log(fmt.Sprintf("failed to send notification: %v", e))
+		item.JobExecution.Logs = item.logger.String()
+		if e := je.scheduledDAO.UpdateJobExecution(item.JobExecution); e != nil {
+			log.Printf("failed to update job execution: %v", e)
+		}
+	})
+}
+
+// executeAttempt executes the job once, each attempt is recorded as a JobExecution
+func (je *JobExecutor) executeAttempt(job types.Job, events []JobEvent, attempt int) *jobExecutionItem {
 	jobExecution := &types.JobExecution{
 		JobId:     job.ID,
 		StartedAt: uint64(time.Now().UnixMilli()),
 		Status:    types.JobExecutionRunning,
+		Attempt:   attempt,
 	}
 	e := je.scheduledDAO.AddJobExecution(jobExecution)
 	if e != nil {
 		log.Printf("failed to save job execution: %v", e)
-		return
+		return nil
 	}
 
-	executionCtx, cancel := context.WithCancel(context.Background())
+	timeout := types.SV(job.Timeout).Duration(0)
+	parentCtx := context.Background()
+	if events != nil {
+		parentCtx = withJobEvents(parentCtx, events)
+	}
+	var executionCtx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		executionCtx, cancel = context.WithTimeout(parentCtx, timeout)
+	} else {
+		executionCtx, cancel = context.WithCancel(parentCtx)
+	}
 	logger := newJobExecutionLogger((jobExecution.ID))
+	if attempt > 1 {
+		logger.Log(fmt.Sprintf("attempt %d of %d", attempt, job.MaxRetries+1))
+	}
+	if events != nil {
+		logger.Log(fmt.Sprintf("triggered by %d events", len(events)))
+		for _, ev := range events {
+			logger.Log(fmt.Sprintf("  %s '%s' by '%s'", ev.Type, ev.Path, ev.User))
+		}
+	}
 	item := &jobExecutionItem{JobExecution: jobExecution, cancel: cancel, logger: logger}
 	je.addJobExecution(item)
 
@@ -101,17 +240,50 @@ func (je *JobExecutor) jobExecutor(job types.Job) {
 	jobDefinition := GetJob(job.Job)
 	if jobDefinition == nil {
 		e = errors.New("job not found")
-		return
+		return item
 	}
 
 	params := make(types.SM, 0)
 	e = json.Unmarshal([]byte(job.Params), &params)
 	if e != nil {
 		e = fmt.Errorf("failed to parse params: %s", e.Error())
-		return
+		return item
 	}
 
-	e = jobDefinition.Do(executionCtx, params, je.ch, item.logger.Log)
+	var stopped bool
+	stopped, e = je.run(executionCtx, jobDefinition, params, item.logger.Log)
+	if !stopped {
+		// the job may still be writing, retrying it could run two instances at the same time
+		item.logger.Log(fmt.Sprintf("the job did not stop within %s after it was canceled", stopGracePeriod))
+		item.unstopped = true
+	}
+	if errors.Is(executionCtx.Err(), context.DeadlineExceeded) {
+		e = fmt.Errorf("timed out after %s", timeout)
+	}
+	return item
+}
+
+// run runs the job until it returns.
+// The job should return when the ctx is done, it's waited for stopGracePeriod at most,
+// stopped is false if the job is still running.
+func (je *JobExecutor) run(ctx context.Context, jd *JobDefinition,
+	params types.SM, onLog func(string)) (stopped bool, e error) {
+	done := make(chan error, 1)
+	go func() { done <- jd.Do(ctx, params, je.ch, onLog) }()
+	select {
+	case e := <-done:
+		return true, e
+	case <-ctx.Done():
+	}
+	select {
+	case e := <-done:
+		if e == nil {
+			e = ctx.Err()
+		}
+		return true, e
+	case <-time.After(stopGracePeriod):
+		return false, ctx.Err()
+	}
 }
 
 func (je *JobExecutor) updateJobExecutionResult(item *jobExecutionItem, e error) {
@@ -138,6 +310,78 @@ func (je *JobExecutor) ValidateSchedule(s string) error {
 	return nil
 }
 
+// ValidateJob checks the cron schedule and the event trigger of the job
+func (je *JobExecutor) ValidateJob(job types.Job) error {
+	if job.Schedule == "" && job.Events == "" {
+		return err.NewNotAllowedMessageError(i18n.T("api.jobs.trigger_required"))
+	}
+	if job.Schedule != "" {
+		if e := je.ValidateSchedule(job.Schedule); e != nil {
+			return e
+		}
+	}
+	if job.Events != "" {
+		for _, ev := range strings.Split(job.Events, ",") {
+			if !isValidEvent(strings.TrimSpace(ev)) {
+				return err.NewNotAllowedMessageError(i18n.T("api.jobs.invalid_event", ev))
+			}
+		}
+	}
+	if job.PathPattern != "" && !doublestar.ValidatePattern(job.PathPattern) {
+		return err.NewNotAllowedMessageError(i18n.T("api.jobs.invalid_path_pattern", job.PathPattern))
+	}
+	if job.Debounce != "" && types.SV(job.Debounce).Duration(-1) < 0 {
+		return err.NewNotAllowedMessageError(i18n.T("api.jobs.invalid_debounce", job.Debounce))
+	}
+	if job.MaxConcurrency < 0 {
+		return err.NewNotAllowedMessageError(i18n.T("api.jobs.invalid_max_concurrency"))
+	}
+	if job.MaxRetries < 0 || job.MaxRetries > maxRetries {
+		return err.NewNotAllowedMessageError(i18n.T("api.jobs.invalid_max_retries", strconv.Itoa(maxRetries)))
+	}
+	if job.RetryBackoff != "" && types.SV(job.RetryBackoff).Duration(-1) < 0 {
+		return err.NewNotAllowedMessageError(i18n.T("api.jobs.invalid_retry_backoff", job.RetryBackoff))
+	}
+	if job.Timeout != "" && types.SV(job.Timeout).Duration(-1) < 0 {
+		return err.NewNotAllowedMessageError(i18n.T("api.jobs.invalid_timeout", job.Timeout))
+	}
+	return je.validateNotification(job)
+}
+
+func (je *JobExecutor) validateNotification(job types.Job) error {
+	if job.NotifyOn == "" {
+		return nil
+	}
+	if _, ok := utils.ArrayFind(notifyOnValues, func(v string, _ int) bool { return v == job.NotifyOn }); !ok {
+		return err.NewNotAllowedMessageError(i18n.T("api.jobs.invalid_notify_on", job.NotifyOn))
+	}
+	if job.NotifyWebhook == "" && job.NotifyEmail == "" {
+		return err.NewNotAllowedMessageError(i18n.T("api.jobs.notify_target_required"))
+	}
+	if job.NotifyWebhook != "" &&
+		!strings.HasPrefix(job.NotifyWebhook, "http://") && !strings.HasPrefix(job.NotifyWebhook, "https://") {
+		return err.NewNotAllowedMessageError(i18n.T("api.jobs.invalid_notify_webhook", job.NotifyWebhook))
+	}
+	if job.NotifyEmail != "" {
+		if _, e := mail.ParseAddressList(job.NotifyEmail); e != nil {
+			return err.NewNotAllowedMessageError(i18n.T("api.jobs.invalid_notify_email", job.NotifyEmail))
+		}
+		if je.notifier.smtp.Host == "" {
+			return err.NewNotAllowedMessageError(i18n.T("api.jobs.smtp_not_configured"))
+		}
+	}
+	return nil
+}
+
+func isValidEvent(eventType string) bool {
+	for _, ev := range Events {
+		if ev == eventType {
+			return true
+		}
+	}
+	return false
+}
+
 func (je *JobExecutor) GetJob(id uint) *gocron.Job {
 	jobs, e := je.s.FindJobsByTag(strconv.FormatUint(uint64(id), 10))
 	if e != nil {
@@ -149,6 +393,7 @@ func (je *JobExecutor) GetJob(id uint) *gocron.Job {
 func (je *JobExecutor) CancelJobExecution(id uint) error {
 	item := je.executions[id]
 	if item != nil {
+		item.aborted.Store(true)
 		item.cancel()
 	}
 	return nil
@@ -179,7 +424,12 @@ func (je *JobExecutor) Dispose() error {
 	defer je.mu.Unlock()
 	je.s.Stop()
 	je.s.Clear()
+	close(je.disposed)
+	for _, t := range je.triggers {
+		t.stop()
+	}
 	for _, exec := range je.executions {
+		exec.aborted.Store(true)
 		exec.cancel()
 		je.updateJobExecutionResult(exec, errors.New("aborted"))
 	}
@@ -190,6 +440,10 @@ type jobExecutionItem struct {
 	*types.JobExecution
 	cancel func()
 	logger *jobExecutionLogger
+	// aborted is true if the execution is canceled by user, it will not be retried
+	aborted atomic.Bool
+	// unstopped is true if the job did not return after it was canceled, it will not be retried
+	unstopped bool
 }
 
 func newJobExecutionLogger(jid uint) *jobExecutionLogger {
@@ -0,0 +1,384 @@
+package scheduled
+
+import (
+	"context"
+	"fmt"
+	"go-drive/common/drive_util"
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/registry"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/drive"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupSnapshotLayout is the time layout of the snapshot folder names
+const backupSnapshotLayout = "20060102-150405"
+
+const (
+	// backupUnchangedCopy copies all the files from the sources
+	backupUnchangedCopy = "copy"
+	// backupUnchangedReuse hard-links the unchanged files to the previous snapshot if supported,
+	// otherwise copies them from the previous snapshot on the destination
+	backupUnchangedReuse = "reuse"
+)
+
+func init() {
+	t := i18n.TPrefix("jobs.backup.")
+	RegisterJob(JobDefinition{
+		Name:        "backup",
+		DisplayName: t("name"),
+		Description: t("desc"),
+		ParamsForm: []types.FormItem{
+			{Field: "src", Label: t("src"), Description: t("src_desc"), Type: "textarea", Required: true},
+			{Field: "dest", Label: t("dest"), Description: t("dest_desc"), Type: "text", Required: true},
+			{
+				Field: "unchanged", Label: t("unchanged"), Description: t("unchanged_desc"), Type: "select",
+				Options: &[]types.FormItemOption{
+					{Name: t("unchanged_reuse"), Value: backupUnchangedReuse, Title: t("unchanged_reuse_desc")},
+					{Name: t("unchanged_copy"), Value: backupUnchangedCopy, Title: t("unchanged_copy_desc")},
+				},
+				DefaultValue: backupUnchangedReuse, Required: true,
+			},
+			{Field: "keep_last", Label: t("keep_last"), Description: t("keep_last_desc"), Type: "text"},
+			{Field: "keep_daily", Label: t("keep_daily"), Description: t("keep_daily_desc"), Type: "text"},
+			{Field: "keep_weekly", Label: t("keep_weekly"), Description: t("keep_weekly_desc"), Type: "text"},
+			{Field: "keep_monthly", Label: t("keep_monthly"), Description: t("keep_monthly_desc"), Type: "text"},
+		},
+		Do: func(ctx context.Context, params types.SM, ch *registry.ComponentsHolder, log func(string)) error {
+			b, e := newBackup(params, ch, log)
+			if e != nil {
+				return e
+			}
+			return b.run(ctx)
+		},
+	})
+}
+
+// retentionPolicy decides which snapshots are kept.
+// A snapshot is kept if any of the rules keeps it, and the latest snapshot is always kept.
+type retentionPolicy struct {
+	// last is the number of the latest snapshots to keep
+	last int
+	// daily, weekly and monthly are the number of the latest days, weeks and months
+	// to keep the last snapshot of each of them
+	daily, weekly, monthly int
+}
+
+func (p retentionPolicy) isEmpty() bool {
+	return p.last <= 0 && p.daily <= 0 && p.weekly <= 0 && p.monthly <= 0
+}
+
+// keep returns the snapshots to keep, snapshots are sorted in ascending order
+func (p retentionPolicy) keep(snapshots []string) map[string]bool {
+	keep := make(map[string]bool)
+	if len(snapshots) == 0 {
+		return keep
+	}
+	keep[snapshots[len(snapshots)-1]] = true
+	for i := len(snapshots) - 1; i >= 0 && len(snapshots)-i <= p.last; i-- {
+		keep[snapshots[i]] = true
+	}
+	keepPeriods(snapshots, keep, p.daily, func(t time.Time) string { return t.Format("2006-01-02") })
+	keepPeriods(snapshots, keep, p.weekly, func(t time.Time) string {
+		y, w := t.ISOWeek()
+		return fmt.Sprintf("%d-%d", y, w)
+	})
+	keepPeriods(snapshots, keep, p.monthly, func(t time.Time) string { return t.Format("2006-01") })
+	return keep
+}
+
+// keepPeriods keeps the last snapshot of each of the latest n periods
+func keepPeriods(snapshots []string, keep map[string]bool, n int, period func(time.Time) string) {
+	periods := make(map[string]bool)
+	for i := len(snapshots) - 1; i >= 0 && len(periods) < n; i-- {
+		t, e := time.ParseInLocation(backupSnapshotLayout, snapshots[i], time.Local)
+		if e != nil {
+			continue
+		}
+		if p := period(t); !periods[p] {
+			periods[p] = true
+			keep[snapshots[i]] = true
+		}
+	}
+}
+
+type backup struct {
+	root   types.IDrive
+	src    []string
+	dest   string
+	reuse  bool
+	policy retentionPolicy
+
+	// linker is the drive of dest if it supports hard links
+	linker types.IHardLinkDrive
+	// destDrivePath is the path of dest in the linker
+	destDrivePath string
+
+	copied, linked, reused int
+
+	log func(string)
+}
+
+func newBackup(params types.SM, ch *registry.ComponentsHolder, log func(string)) (*backup, error) {
+	t := i18n.TPrefix("jobs.backup.")
+	b := &backup{
+		root:  ch.Get("driveAccess").(*drive.Access).GetUnwrappedRootDrive(),
+		src:   splitLines(params["src"]),
+		dest:  utils.CleanPath(params["dest"]),
+		reuse: params["unchanged"] != backupUnchangedCopy,
+		policy: retentionPolicy{
+			last:    params.GetInt("keep_last", 0),
+			daily:   params.GetInt("keep_daily", 0),
+			weekly:  params.GetInt("keep_weekly", 0),
+			monthly: params.GetInt("keep_monthly", 0),
+		},
+		log: log,
+	}
+	if len(b.src) == 0 || utils.IsRootPath(b.dest) {
+		return nil, err.NewBadRequestError(t("invalid_path"))
+	}
+	for _, src := range b.src {
+		if containsPath(utils.CleanPath(src), b.dest) {
+			return nil, err.NewBadRequestError(t("src_contains_dest", src))
+		}
+	}
+	if b.policy.last < 0 || b.policy.daily < 0 || b.policy.weekly < 0 || b.policy.monthly < 0 {
+		return nil, err.NewBadRequestError(t("invalid_retention"))
+	}
+	return b, nil
+}
+
+func (b *backup) run(ctx context.Context) error {
+	destEntry, e := b.root.Get(ctx, b.dest)
+	if err.IsNotFoundError(e) {
+		if _, e = b.root.MakeDir(ctx, b.dest); e == nil {
+			destEntry, e = b.root.Get(ctx, b.dest)
+		}
+	}
+	if e != nil {
+		return e
+	}
+	if !destEntry.Type().IsDir() {
+		return err.NewNotAllowedMessageError(i18n.T("jobs.backup.dest_not_dir", b.dest))
+	}
+	snapshots, e := b.snapshots(ctx)
+	if e != nil {
+		return e
+	}
+	name := time.Now().Format(backupSnapshotLayout)
+	if len(snapshots) > 0 && snapshots[len(snapshots)-1] >= name {
+		return err.NewNotAllowedMessageError(i18n.T("jobs.backup.snapshot_exists", name))
+	}
+
+	snapshot := path.Join(b.dest, name)
+	b.log(fmt.Sprintf("snapshot '%s'", snapshot))
+	var prev types.IEntry
+	if b.reuse && len(snapshots) > 0 {
+		prev, e = b.root.Get(ctx, path.Join(b.dest, snapshots[len(snapshots)-1]))
+		if e != nil {
+			return e
+		}
+		b.log(fmt.Sprintf("previous snapshot '%s'", prev.Path()))
+		b.initLinker(destEntry)
+	}
+	if _, e := b.root.MakeDir(ctx, snapshot); e != nil {
+		return e
+	}
+	if e := b.backupSources(ctx, snapshot, prev); e != nil {
+		b.log(fmt.Sprintf("backup failed, delete the incomplete snapshot '%s'", snapshot))
+		if de := b.root.Delete(task.DummyContext(), snapshot); de != nil {
+			b.log(fmt.Sprintf("failed to delete '%s': %s", snapshot, de.Error()))
+		}
+		return e
+	}
+	if b.reuse {
+		b.log(fmt.Sprintf("%d files copied, %d files linked, %d files copied from the previous snapshot",
+			b.copied, b.linked, b.reused))
+	}
+	return b.prune(ctx, append(snapshots, name))
+}
+
+func (b *backup) backupSources(ctx context.Context, snapshot string, prev types.IEntry) error {
+	var prevChildren map[string]types.IEntry
+	if prev != nil {
+		var e error
+		if prevChildren, e = b.listChildren(ctx, prev); e != nil {
+			return e
+		}
+	}
+	for _, src := range b.src {
+		entries, e := drive_util.FindEntries(task.NewContextWrapper(ctx), b.root, src, false)
+		if e != nil {
+			return e
+		}
+		b.log(fmt.Sprintf("'%s' matched %d entries", src, len(entries)))
+		for _, entry := range entries {
+			// the pattern may match the ancestors of dest
+			if containsPath(entry.Path(), b.dest) {
+				return err.NewNotAllowedMessageError(i18n.T("jobs.backup.src_contains_dest", entry.Path()))
+			}
+		}
+		for _, entry := range entries {
+			to := path.Join(snapshot, entry.Name())
+			if _, e := b.root.Get(ctx, to); e == nil {
+				return err.NewNotAllowedMessageError(i18n.T("jobs.backup.name_conflict", entry.Path()))
+			} else if !err.IsNotFoundError(e) {
+				return e
+			}
+			b.log(fmt.Sprintf("  copy '%s'", entry.Path()))
+			if !b.reuse {
+				if _, e := b.root.Copy(task.NewContextWrapper(ctx), entry, to, false); e != nil {
+					return e
+				}
+				continue
+			}
+			tree, e := drive_util.BuildEntriesTree(task.NewContextWrapper(ctx), entry, false)
+			if e != nil {
+				return e
+			}
+			if e := b.backupTree(ctx, tree, to, prevChildren[entry.Name()]); e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// backupTree copies the entries in the tree to `to`, prev is the same entry in the previous snapshot or nil
+func (b *backup) backupTree(ctx context.Context, node drive_util.EntryTreeNode, to string, prev types.IEntry) error {
+	if e := ctx.Err(); e != nil {
+		return e
+	}
+	if node.Entry.Type().IsDir() {
+		if _, e := b.root.MakeDir(ctx, to); e != nil {
+			return e
+		}
+		var prevChildren map[string]types.IEntry
+		if prev != nil && prev.Type().IsDir() {
+			var e error
+			if prevChildren, e = b.listChildren(ctx, prev); e != nil {
+				return e
+			}
+		}
+		for _, child := range node.Children {
+			name := child.Entry.Name()
+			if e := b.backupTree(ctx, child, path.Join(to, name), prevChildren[name]); e != nil {
+				return e
+			}
+		}
+		return nil
+	}
+	// the file is unchanged if it has the same size and is not newer than the one in the previous snapshot
+	if prev != nil && prev.Type().IsFile() &&
+		prev.Size() == node.Entry.Size() && node.Entry.ModTime() <= prev.ModTime() {
+		return b.reuseFile(ctx, prev, to)
+	}
+	if _, e := b.root.Copy(task.NewContextWrapper(ctx), node.Entry, to, true); e != nil {
+		return e
+	}
+	b.copied++
+	return nil
+}
+
+// reuseFile hard-links or copies the file in the previous snapshot to `to`
+func (b *backup) reuseFile(ctx context.Context, prev types.IEntry, to string) error {
+	if b.linker != nil {
+		// the link is made on the drive directly, it does not take extra space
+		_, e := b.linker.HardLink(ctx, prev, path.Join(b.destDrivePath, strings.TrimPrefix(to, b.dest+"/")))
+		if e == nil {
+			b.linked++
+			return nil
+		}
+		if !err.IsUnsupportedError(e) {
+			return e
+		}
+		b.log("hard links are not supported by the destination")
+		b.linker = nil
+	}
+	if _, e := b.root.Copy(task.NewContextWrapper(ctx), prev, to, true); e != nil {
+		return e
+	}
+	b.reused++
+	return nil
+}
+
+// initLinker sets the linker if the drive of dest supports hard links
+func (b *backup) initLinker(destEntry types.IEntry) {
+	de := drive_util.GetIEntry(destEntry, func(e types.IEntry) bool {
+		_, ok := e.(types.IDispatcherEntry)
+		return ok
+	})
+	if de == nil {
+		return
+	}
+	driveName, d := de.(types.IDispatcherEntry).GetDispatchedDrive()
+	linker, ok := d.(types.IHardLinkDrive)
+	if !ok {
+		return
+	}
+	b.linker = linker
+	b.destDrivePath = utils.CleanPath(strings.TrimPrefix(de.(types.IDispatcherEntry).GetRealPath(), driveName))
+}
+
+// snapshots returns the names of the snapshots in dest in ascending order
+func (b *backup) snapshots(ctx context.Context) ([]string, error) {
+	entries, e := b.root.List(ctx, b.dest)
+	if e != nil {
+		return nil, e
+	}
+	snapshots := make([]string, 0)
+	for _, entry := range entries {
+		if !entry.Type().IsDir() {
+			continue
+		}
+		if _, e := time.ParseInLocation(backupSnapshotLayout, entry.Name(), time.Local); e == nil {
+			snapshots = append(snapshots, entry.Name())
+		}
+	}
+	sort.Strings(snapshots)
+	return snapshots, nil
+}
+
+func (b *backup) prune(ctx context.Context, snapshots []string) error {
+	if b.policy.isEmpty() {
+		return nil
+	}
+	keep := b.policy.keep(snapshots)
+	pruned := 0
+	for _, s := range snapshots {
+		if keep[s] {
+			continue
+		}
+		p := path.Join(b.dest, s)
+		b.log(fmt.Sprintf("  prune '%s'", p))
+		if e := b.root.Delete(task.NewContextWrapper(ctx), p); e != nil && !err.IsNotFoundError(e) {
+			return e
+		}
+		pruned++
+	}
+	b.log(fmt.Sprintf("%d snapshots kept, %d snapshots pruned", len(snapshots)-pruned, pruned))
+	return nil
+}
+
+func (b *backup) listChildren(ctx context.Context, dir types.IEntry) (map[string]types.IEntry, error) {
+	entries, e := b.root.List(ctx, dir.Path())
+	if e != nil {
+		return nil, e
+	}
+	children := make(map[string]types.IEntry, len(entries))
+	for _, entry := range entries {
+		children[entry.Name()] = entry
+	}
+	return children, nil
+}
+
+// containsPath returns true if the path is the same as sub or the ancestor of it
+func containsPath(path, sub string) bool {
+	return path == sub || utils.IsPathParent(sub, path)
+}
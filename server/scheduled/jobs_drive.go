@@ -13,6 +13,7 @@ import (
 	"go-drive/drive"
 	"path"
 	"strings"
+	"time"
 )
 
 func init() {
@@ -103,4 +104,23 @@ func init() {
 		},
 	})
 
+	t = i18n.TPrefix("jobs.trash_clean.")
+	RegisterJob(JobDefinition{
+		Name:        "trash-clean",
+		DisplayName: t("name"),
+		Description: t("desc"),
+		ParamsForm: []types.FormItem{
+			{Field: "retention", Label: t("retention"), Description: t("retention_desc"), Type: "text", Required: true, DefaultValue: "30d"},
+		},
+		Do: func(ctx context.Context, params types.SM, ch *registry.ComponentsHolder, log func(string)) error {
+			retention := types.SV(params["retention"]).Duration(-1)
+			if retention < 0 {
+				return err.NewBadRequestError(t("invalid_retention"))
+			}
+			trash := ch.Get("trash").(*drive.Trash)
+			n, e := trash.Clean(task.NewContextWrapper(ctx), time.Now().Add(-retention))
+			log(fmt.Sprintf("%d entries purged", n))
+			return e
+		},
+	})
 }
@@ -56,11 +56,23 @@ func ExecuteJobCode(ctx context.Context, code interface{}, ch *registry.Componen
 
 	vm.Set("drive", s.NewDrive(vm, ch.Get("driveAccess").(*drive.Access).GetRootDrive()))
 	vm.Set("log", onLog)
+	vm.Set("events", scriptJobEvents(GetJobEvents(ctx)))
 
 	_, e := vm.Run(ctx, code)
 	return e
 }
 
+// scriptJobEvents converts the events to script values, the field names are the same as the JSON
+func scriptJobEvents(events []JobEvent) []interface{} {
+	r := make([]interface{}, 0, len(events))
+	for _, ev := range events {
+		r = append(r, map[string]interface{}{
+			"type": ev.Type, "path": ev.Path, "user": ev.User, "timestamp": ev.Timestamp,
+		})
+	}
+	return r
+}
+
 var defaultCodeValue = strings.TrimLeft(`
 // Available functions:
 // - cp: copy files/directories
@@ -70,6 +82,9 @@ var defaultCodeValue = strings.TrimLeft(`
 // - mkdir: create a directory
 //
 // Or you can use 'drive' to do anything.
+//
+// If the job is triggered by drive events, 'events' contains them,
+// e.g. events[0].path is the path of the first uploaded file.
 
 // See https://github.com/devld/go-drive/blob/master/docs/scripts/global.d.ts
 // See https://github.com/devld/go-drive/blob/master/docs/scripts/env/jobs.d.ts
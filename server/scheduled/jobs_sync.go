@@ -0,0 +1,698 @@
+package scheduled
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-drive/common"
+	"go-drive/common/drive_util"
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/registry"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/drive"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+const (
+	// syncModeMirror makes the destination the same as the source
+	syncModeMirror = "mirror"
+	// syncModeTwoWay propagates the changes of both sides to each other
+	syncModeTwoWay = "two-way"
+
+	syncCompareSize    = "size"
+	syncCompareModTime = "modtime"
+	syncCompareHash    = "hash"
+)
+
+func init() {
+	t := i18n.TPrefix("jobs.sync.")
+	RegisterJob(JobDefinition{
+		Name:        "sync",
+		DisplayName: t("name"),
+		Description: t("desc"),
+		ParamsForm: []types.FormItem{
+			{Field: "src", Label: t("src"), Description: t("src_desc"), Type: "text", Required: true},
+			{Field: "dest", Label: t("dest"), Description: t("dest_desc"), Type: "text", Required: true},
+			{
+				Field: "mode", Label: t("mode"), Description: t("mode_desc"), Type: "select",
+				Options: &[]types.FormItemOption{
+					{Name: t("mode_mirror"), Value: syncModeMirror, Title: t("mode_mirror_desc")},
+					{Name: t("mode_two_way"), Value: syncModeTwoWay, Title: t("mode_two_way_desc")},
+				},
+				DefaultValue: syncModeMirror, Required: true,
+			},
+			{
+				Field: "compare", Label: t("compare"), Description: t("compare_desc"), Type: "select",
+				Options: &[]types.FormItemOption{
+					{Name: t("compare_size"), Value: syncCompareSize, Title: t("compare_size_desc")},
+					{Name: t("compare_modtime"), Value: syncCompareModTime, Title: t("compare_modtime_desc")},
+					{Name: t("compare_hash"), Value: syncCompareHash, Title: t("compare_hash_desc")},
+				},
+				DefaultValue: syncCompareModTime, Required: true,
+			},
+			{Field: "delete", Label: t("delete"), Description: t("delete_desc"), Type: "checkbox"},
+			{Field: "include", Label: t("include"), Description: t("include_desc"), Type: "textarea"},
+			{Field: "exclude", Label: t("exclude"), Description: t("exclude_desc"), Type: "textarea"},
+			{Field: "dry_run", Label: t("dry_run"), Description: t("dry_run_desc"), Type: "checkbox"},
+			{Field: "bandwidth", Label: t("bandwidth"), Description: t("bandwidth_desc"), Type: "text"},
+			{Field: "concurrency", Label: t("concurrency"), Description: t("concurrency_desc"), Type: "text", DefaultValue: "1"},
+		},
+		Do: func(ctx context.Context, params types.SM, ch *registry.ComponentsHolder, log func(string)) error {
+			s, e := newSyncer(params, ch, log)
+			if e != nil {
+				return e
+			}
+			return s.sync(ctx)
+		},
+	})
+}
+
+// syncStamp identifies the version of a file
+type syncStamp struct {
+	Size    int64 `json:"size"`
+	ModTime int64 `json:"modTime"`
+}
+
+func stampOf(entry types.IEntry) syncStamp {
+	if entry.Type().IsDir() {
+		return syncStamp{}
+	}
+	return syncStamp{entry.Size(), entry.ModTime()}
+}
+
+// syncState is the stamps of both sides after the last two-way sync
+type syncState struct {
+	Src  syncStamp `json:"src"`
+	Dest syncStamp `json:"dest"`
+}
+
+func (s syncState) stamp(srcSide bool) syncStamp {
+	if srcSide {
+		return s.Src
+	}
+	return s.Dest
+}
+
+type syncAction struct {
+	rel string
+	// from is the source of copying
+	from types.IEntry
+	// to is the path to be created, written or deleted
+	to string
+	// srcSide is true if the action is applied to the src side
+	srcSide bool
+}
+
+type syncPlan struct {
+	// removes are the entries conflicted with the source that must be deleted before mkdirs and copies
+	removes []syncAction
+	mkdirs  []syncAction
+	copies  []syncAction
+	deletes []syncAction
+	// synced are the entries that have already been synced
+	synced map[string]syncState
+}
+
+type syncer struct {
+	root      types.IDrive
+	src, dest string
+
+	mode, compare    string
+	delete, dryRun   bool
+	include, exclude []string
+	limiter          *utils.RateLimiter
+	concurrency      int
+	stateFile        string
+
+	log func(string)
+}
+
+func newSyncer(params types.SM, ch *registry.ComponentsHolder, log func(string)) (*syncer, error) {
+	t := i18n.TPrefix("jobs.sync.")
+	s := &syncer{
+		root:        ch.Get("driveAccess").(*drive.Access).GetUnwrappedRootDrive(),
+		src:         utils.CleanPath(params["src"]),
+		dest:        utils.CleanPath(params["dest"]),
+		mode:        params["mode"],
+		compare:     params["compare"],
+		delete:      params.GetBool("delete"),
+		dryRun:      params.GetBool("dry_run"),
+		include:     splitLines(params["include"]),
+		exclude:     splitLines(params["exclude"]),
+		concurrency: params.GetInt("concurrency", 1),
+		log:         log,
+	}
+	if s.mode == "" {
+		s.mode = syncModeMirror
+	}
+	if s.mode != syncModeMirror && s.mode != syncModeTwoWay {
+		return nil, err.NewBadRequestError(t("invalid_mode", s.mode))
+	}
+	if s.compare == "" {
+		s.compare = syncCompareModTime
+	}
+	if s.compare != syncCompareSize && s.compare != syncCompareModTime && s.compare != syncCompareHash {
+		return nil, err.NewBadRequestError(t("invalid_compare", s.compare))
+	}
+	if s.src == s.dest || utils.IsRootPath(s.src) || utils.IsRootPath(s.dest) ||
+		strings.HasPrefix(s.dest, s.src+"/") || strings.HasPrefix(s.src, s.dest+"/") {
+		return nil, err.NewBadRequestError(t("overlapped"))
+	}
+	for _, p := range append(append([]string{}, s.include...), s.exclude...) {
+		if !doublestar.ValidatePattern(p) {
+			return nil, err.NewBadRequestError(t("invalid_pattern", p))
+		}
+	}
+	if s.concurrency < 1 {
+		return nil, err.NewBadRequestError(t("invalid_concurrency"))
+	}
+	if bw := strings.TrimSpace(params["bandwidth"]); bw != "" {
+		rate := types.SV(bw).DataSize(-1)
+		if rate <= 0 {
+			return nil, err.NewBadRequestError(t("invalid_bandwidth"))
+		}
+		s.limiter = utils.NewRateLimiter(rate)
+	}
+	if s.mode == syncModeTwoWay {
+		dir, e := ch.Get("config").(common.Config).GetDir("sync", true)
+		if e != nil {
+			return nil, e
+		}
+		// the state is bound to the pair of folders
+		key := sha1.Sum([]byte(s.src + "\n" + s.dest))
+		s.stateFile = filepath.Join(dir, hex.EncodeToString(key[:])+".json")
+	}
+	return s, nil
+}
+
+func (s *syncer) sync(ctx context.Context) error {
+	srcTree, e := s.listTree(ctx, s.src)
+	if e != nil {
+		return e
+	}
+	destTree, e := s.listTree(ctx, s.dest)
+	destCreated := false
+	if err.IsNotFoundError(e) {
+		destTree, destCreated, e = make(map[string]types.IEntry), true, nil
+	}
+	if e != nil {
+		return e
+	}
+	s.log(fmt.Sprintf("'%s' has %d entries, '%s' has %d entries", s.src, len(srcTree), s.dest, len(destTree)))
+
+	var p *syncPlan
+	if s.mode == syncModeTwoWay {
+		state, e := s.loadState()
+		if e != nil {
+			return e
+		}
+		p, e = s.planTwoWay(ctx, srcTree, destTree, state)
+		if e != nil {
+			return e
+		}
+	} else {
+		p, e = s.planMirror(ctx, srcTree, destTree)
+		if e != nil {
+			return e
+		}
+	}
+	if destCreated {
+		p.mkdirs = append([]syncAction{{to: s.dest}}, p.mkdirs...)
+	}
+	s.log(fmt.Sprintf("%d to make, %d to copy, %d to delete",
+		len(p.mkdirs), len(p.copies), len(p.removes)+len(p.deletes)))
+
+	if s.dryRun {
+		s.logPlan(p)
+		return nil
+	}
+	failed, e := s.execute(ctx, p)
+	if s.mode == syncModeTwoWay {
+		if ee := s.saveState(p.synced); ee != nil && e == nil {
+			e = ee
+		}
+	}
+	if e != nil {
+		return e
+	}
+	if failed > 0 {
+		return errors.New(i18n.T("jobs.sync.actions_failed", strconv.Itoa(failed)))
+	}
+	return nil
+}
+
+// region plan
+
+func (s *syncer) planMirror(ctx context.Context, srcTree, destTree map[string]types.IEntry) (*syncPlan, error) {
+	p := &syncPlan{}
+	skipped := make(map[string]bool)
+	for _, rel := range sortedKeys(srcTree) {
+		if hasAncestor(skipped, rel) {
+			continue
+		}
+		se := srcTree[rel]
+		de, exists := destTree[rel]
+		to := path.Join(s.dest, rel)
+		if exists && se.Type() != de.Type() {
+			if !s.delete {
+				s.log(fmt.Sprintf("  skip '%s': type mismatch", rel))
+				skipped[rel] = true
+				continue
+			}
+			p.removes = append(p.removes, syncAction{rel: rel, to: de.Path()})
+			exists = false
+		}
+		if se.Type().IsDir() {
+			if !exists {
+				p.mkdirs = append(p.mkdirs, syncAction{rel: rel, to: to})
+			}
+			continue
+		}
+		if exists {
+			need, e := s.needCopy(ctx, se, de)
+			if e != nil {
+				return nil, e
+			}
+			if !need {
+				continue
+			}
+		}
+		p.copies = append(p.copies, syncAction{rel: rel, from: se, to: to})
+	}
+	if s.delete {
+		deleted := make(map[string]bool)
+		for _, a := range p.removes {
+			deleted[a.rel] = true
+		}
+		for _, rel := range sortedKeys(destTree) {
+			if _, ok := srcTree[rel]; ok || hasAncestor(deleted, rel) {
+				continue
+			}
+			deleted[rel] = true
+			p.deletes = append(p.deletes, syncAction{rel: rel, to: destTree[rel].Path()})
+		}
+	}
+	return p, nil
+}
+
+func (s *syncer) planTwoWay(ctx context.Context, srcTree, destTree map[string]types.IEntry,
+	state map[string]syncState) (*syncPlan, error) {
+	p := &syncPlan{synced: make(map[string]syncState)}
+	rels := sortedKeys(srcTree)
+	for rel := range destTree {
+		if _, ok := srcTree[rel]; !ok {
+			rels = append(rels, rel)
+		}
+	}
+	sort.Strings(rels)
+
+	// handled are the directories whose descendants must not be handled again
+	handled := make(map[string]bool)
+	for _, rel := range rels {
+		if hasAncestor(handled, rel) {
+			continue
+		}
+		se, srcExists := srcTree[rel]
+		de, destExists := destTree[rel]
+		prev, synced := state[rel]
+
+		if !srcExists || !destExists {
+			entry, tree, srcSide, to := se, srcTree, true, path.Join(s.dest, rel)
+			if !srcExists {
+				entry, tree, srcSide, to = de, destTree, false, path.Join(s.src, rel)
+			}
+			if s.delete && synced && s.unchanged(tree, rel, state, srcSide) {
+				// it's deleted from the other side since the last sync
+				handled[rel] = true
+				p.deletes = append(p.deletes, syncAction{rel: rel, to: entry.Path(), srcSide: srcSide})
+				continue
+			}
+			if entry.Type().IsDir() {
+				p.mkdirs = append(p.mkdirs, syncAction{rel: rel, to: to, srcSide: !srcSide})
+			} else {
+				p.copies = append(p.copies, syncAction{rel: rel, from: entry, to: to, srcSide: !srcSide})
+			}
+			continue
+		}
+
+		if se.Type() != de.Type() {
+			s.log(fmt.Sprintf("  skip '%s': type mismatch", rel))
+			handled[rel] = true
+			continue
+		}
+		if se.Type().IsDir() {
+			p.synced[rel] = syncState{}
+			continue
+		}
+		srcChanged := !synced || stampOf(se) != prev.Src
+		destChanged := !synced || stampOf(de) != prev.Dest
+		if srcChanged && destChanged {
+			// changed on both sides or never synced, the newer one wins
+			from, to, toSrc := se, de, false
+			if de.ModTime() > se.ModTime() {
+				from, to, toSrc = de, se, true
+			}
+			need, e := s.needCopy(ctx, from, to)
+			if e != nil {
+				return nil, e
+			}
+			if !need {
+				p.synced[rel] = syncState{stampOf(se), stampOf(de)}
+				continue
+			}
+			if synced {
+				s.log(fmt.Sprintf("  conflict '%s': keep the newer one", rel))
+			}
+			p.copies = append(p.copies, syncAction{rel: rel, from: from, to: to.Path(), srcSide: toSrc})
+		} else if srcChanged {
+			p.copies = append(p.copies, syncAction{rel: rel, from: se, to: de.Path()})
+		} else if destChanged {
+			p.copies = append(p.copies, syncAction{rel: rel, from: de, to: se.Path(), srcSide: true})
+		} else {
+			p.synced[rel] = prev
+		}
+	}
+	return p, nil
+}
+
+// unchanged returns true if the entry and its descendants at one side are not changed since the last sync
+func (s *syncer) unchanged(tree map[string]types.IEntry, rel string,
+	state map[string]syncState, srcSide bool) bool {
+	for r, entry := range tree {
+		if r != rel && !strings.HasPrefix(r, rel+"/") {
+			continue
+		}
+		prev, ok := state[r]
+		if !ok || stampOf(entry) != prev.stamp(srcSide) {
+			return false
+		}
+	}
+	return true
+}
+
+// needCopy returns true if the content of 'from' should be copied to 'to'.
+// When comparing the modification time, only the newer one is copied,
+// because the drives do not keep the modification time of the saved files.
+func (s *syncer) needCopy(ctx context.Context, from, to types.IEntry) (bool, error) {
+	if from.Size() != to.Size() {
+		return true, nil
+	}
+	switch s.compare {
+	case syncCompareSize:
+		return false, nil
+	case syncCompareHash:
+		same, e := sameHash(ctx, from, to)
+		return !same, e
+	default:
+		return from.ModTime() > to.ModTime(), nil
+	}
+}
+
+// sameHash compares the hashes of the two entries.
+// The hash provided by both sides is preferred, so that the content will not be read.
+func sameHash(ctx context.Context, a, b types.IEntry) (bool, error) {
+	algorithm := types.HashSHA256
+	ha, hb := drive_util.GetEntryHasher(a), drive_util.GetEntryHasher(b)
+	if ha != nil && hb != nil {
+		algorithmsA, _ := ha.HashAlgorithms()
+		algorithmsB, _ := hb.HashAlgorithms()
+		for _, alg := range algorithmsA {
+			if _, ok := utils.ArrayFind(algorithmsB, func(x string, _ int) bool { return x == alg }); ok {
+				algorithm = alg
+				break
+			}
+		}
+	}
+	x, e := entryHash(ctx, a, algorithm)
+	var y string
+	if e == nil {
+		y, e = entryHash(ctx, b, algorithm)
+	}
+	if err.IsUnsupportedError(e) && algorithm != types.HashSHA256 {
+		algorithm = types.HashSHA256
+		if x, e = entryHash(ctx, a, algorithm); e == nil {
+			y, e = entryHash(ctx, b, algorithm)
+		}
+	}
+	if e != nil {
+		return false, e
+	}
+	return strings.EqualFold(x, y), nil
+}
+
+func entryHash(ctx context.Context, entry types.IEntry, algorithm string) (string, error) {
+	if hasher := drive_util.GetEntryHasher(entry); hasher != nil {
+		v, e := hasher.Hash(ctx, algorithm)
+		if !err.IsUnsupportedError(e) {
+			return v, e
+		}
+	}
+	return drive_util.ComputeHash(ctx, entry, algorithm)
+}
+
+// endregion
+
+// region execute
+
+func (s *syncer) logPlan(p *syncPlan) {
+	for _, a := range p.removes {
+		s.log(fmt.Sprintf("  [dry-run] delete '%s'", a.to))
+	}
+	for _, a := range p.mkdirs {
+		s.log(fmt.Sprintf("  [dry-run] mkdir '%s'", a.to))
+	}
+	for _, a := range p.copies {
+		s.log(fmt.Sprintf("  [dry-run] copy '%s' -> '%s'", a.from.Path(), a.to))
+	}
+	for _, a := range p.deletes {
+		s.log(fmt.Sprintf("  [dry-run] delete '%s'", a.to))
+	}
+}
+
+// execute executes the plan, returns the count of failed actions.
+// The failed actions are logged and the others are continued.
+func (s *syncer) execute(ctx context.Context, p *syncPlan) (int, error) {
+	failed := 0
+	for _, a := range p.removes {
+		if e := s.deleteEntry(ctx, a); e != nil {
+			return failed, e
+		}
+	}
+	for _, a := range p.mkdirs {
+		if e := ctx.Err(); e != nil {
+			return failed, e
+		}
+		s.log(fmt.Sprintf("  mkdir '%s'", a.to))
+		if _, e := s.root.MakeDir(ctx, a.to); e != nil {
+			s.log(fmt.Sprintf("  failed to mkdir '%s': %s", a.to, e.Error()))
+			failed++
+			continue
+		}
+		if p.synced != nil && a.rel != "" {
+			p.synced[a.rel] = syncState{}
+		}
+	}
+
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	sem := make(chan struct{}, s.concurrency)
+	for _, a := range p.copies {
+		if ctx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(a syncAction) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			s.log(fmt.Sprintf("  copy '%s' -> '%s'", a.from.Path(), a.to))
+			copied, e := s.transfer(ctx, a.from, a.to)
+			mu.Lock()
+			defer mu.Unlock()
+			if e != nil {
+				if ctx.Err() == nil {
+					s.log(fmt.Sprintf("  failed to copy '%s': %s", a.from.Path(), e.Error()))
+					failed++
+				}
+				return
+			}
+			if p.synced != nil {
+				if a.srcSide {
+					p.synced[a.rel] = syncState{stampOf(copied), stampOf(a.from)}
+				} else {
+					p.synced[a.rel] = syncState{stampOf(a.from), stampOf(copied)}
+				}
+			}
+		}(a)
+	}
+	wg.Wait()
+	if e := ctx.Err(); e != nil {
+		return failed, e
+	}
+
+	for _, a := range p.deletes {
+		if e := s.deleteEntry(ctx, a); e != nil {
+			s.log(fmt.Sprintf("  failed to delete '%s': %s", a.to, e.Error()))
+			failed++
+		}
+	}
+	return failed, ctx.Err()
+}
+
+// transfer copies the file, the content is read and saved if the bandwidth is limited
+func (s *syncer) transfer(ctx context.Context, from types.IEntry, to string) (types.IEntry, error) {
+	if s.limiter == nil {
+		return s.root.Copy(task.NewContextWrapper(ctx), from, to, true)
+	}
+	reader, e := drive_util.GetIContentReader(ctx, from, -1, -1)
+	if e != nil {
+		return nil, e
+	}
+	defer func() { _ = reader.Close() }()
+	written := drive_util.NewHashWriter()
+	saved, e := s.root.Save(task.NewContextWrapper(ctx), to, from.Size(), true,
+		s.limiter.Reader(ctx, io.TeeReader(reader, written)))
+	if e != nil {
+		return nil, e
+	}
+	if e := drive_util.VerifySavedHash(task.NewContextWrapper(ctx), s.root, to, from, written, saved); e != nil {
+		return nil, e
+	}
+	return saved, nil
+}
+
+func (s *syncer) deleteEntry(ctx context.Context, a syncAction) error {
+	if e := ctx.Err(); e != nil {
+		return e
+	}
+	s.log(fmt.Sprintf("  delete '%s'", a.to))
+	e := s.root.Delete(task.NewContextWrapper(ctx), a.to)
+	if e != nil && !err.IsNotFoundError(e) {
+		return e
+	}
+	return nil
+}
+
+// endregion
+
+// listTree lists the descendants of root that are not filtered, the keys are the paths relative to root
+func (s *syncer) listTree(ctx context.Context, root string) (map[string]types.IEntry, error) {
+	entry, e := s.root.Get(ctx, root)
+	if e != nil {
+		return nil, e
+	}
+	if !entry.Type().IsDir() {
+		return nil, err.NewNotAllowedMessageError(i18n.T("jobs.sync.not_dir", root))
+	}
+	tree := make(map[string]types.IEntry)
+	return tree, s.walk(ctx, root, "", tree)
+}
+
+func (s *syncer) walk(ctx context.Context, dir, rel string, tree map[string]types.IEntry) error {
+	if e := ctx.Err(); e != nil {
+		return e
+	}
+	entries, e := s.root.List(ctx, dir)
+	if e != nil {
+		return e
+	}
+	for _, entry := range entries {
+		r := path.Join(rel, entry.Name())
+		if !s.filter(r, entry.Type().IsDir()) {
+			continue
+		}
+		tree[r] = entry
+		if entry.Type().IsDir() {
+			if e := s.walk(ctx, entry.Path(), r, tree); e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// filter returns true if the entry should be synced.
+// The excluded directories are not synced with all their descendants,
+// and the include patterns only apply to files.
+func (s *syncer) filter(rel string, isDir bool) bool {
+	for _, p := range s.exclude {
+		if ok, _ := doublestar.Match(p, rel); ok {
+			return false
+		}
+	}
+	if isDir || len(s.include) == 0 {
+		return true
+	}
+	for _, p := range s.include {
+		if ok, _ := doublestar.Match(p, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *syncer) loadState() (map[string]syncState, error) {
+	state := make(map[string]syncState)
+	data, e := os.ReadFile(s.stateFile)
+	if os.IsNotExist(e) {
+		return state, nil
+	}
+	if e != nil {
+		return nil, e
+	}
+	return state, json.Unmarshal(data, &state)
+}
+
+func (s *syncer) saveState(state map[string]syncState) error {
+	data, e := json.Marshal(state)
+	if e != nil {
+		return e
+	}
+	return os.WriteFile(s.stateFile, data, 0644)
+}
+
+func sortedKeys(tree map[string]types.IEntry) []string {
+	keys := make([]string, 0, len(tree))
+	for k := range tree {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// hasAncestor returns true if any ancestor of the path is in the set
+func hasAncestor(set map[string]bool, rel string) bool {
+	for p := utils.PathParent(rel); p != ""; p = utils.PathParent(p) {
+		if set[p] {
+			return true
+		}
+	}
+	return false
+}
+
+func splitLines(s string) []string {
+	lines := make([]string, 0)
+	for _, l := range strings.Split(s, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,113 @@
+package scheduled
+
+import (
+	"context"
+	"go-drive/common"
+	"go-drive/common/drive_util"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/drive/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestSyncer creates the syncer of the folders in a local drive,
+// the content is saved by the rate limiter because the local drive does not copy files.
+func newTestSyncer(t *testing.T, mode string, copies *int) (*syncer, string) {
+	root := t.TempDir()
+	for _, p := range []string{"src/d", "dest"} {
+		if e := os.MkdirAll(filepath.Join(root, p), 0755); e != nil {
+			t.Fatal(e)
+		}
+	}
+	d, e := fs.NewDrive(context.Background(), types.SM{"path": root},
+		drive_util.DriveUtils{Config: common.Config{FreeFs: true}})
+	if e != nil {
+		t.Fatal(e)
+	}
+	return &syncer{
+		root: d, src: "src", dest: "dest",
+		mode: mode, compare: syncCompareModTime,
+		limiter:     utils.NewRateLimiter(1 << 30),
+		concurrency: 1,
+		stateFile:   filepath.Join(t.TempDir(), "state.json"),
+		log: func(s string) {
+			if strings.HasPrefix(s, "  copy ") {
+				*copies++
+			}
+		},
+	}, root
+}
+
+func writeTestFile(t *testing.T, name, content string, modTime time.Time) {
+	if e := os.WriteFile(name, []byte(content), 0644); e != nil {
+		t.Fatal(e)
+	}
+	if e := os.Chtimes(name, modTime, modTime); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestSyncMirrorTwice(t *testing.T) {
+	copies := 0
+	s, root := newTestSyncer(t, syncModeMirror, &copies)
+	// the source files are older than the copied files
+	old := time.Now().Add(-time.Hour)
+	writeTestFile(t, filepath.Join(root, "src/a.txt"), "a", old)
+	writeTestFile(t, filepath.Join(root, "src/d/b.txt"), "bb", old)
+
+	if e := s.sync(context.Background()); e != nil {
+		t.Fatal(e)
+	}
+	if copies != 2 {
+		t.Errorf("expect 2 files to be copied, but is %d", copies)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "dest/d/b.txt")); string(data) != "bb" {
+		t.Errorf("expect '%s', but is '%s'", "bb", data)
+	}
+
+	copies = 0
+	if e := s.sync(context.Background()); e != nil {
+		t.Fatal(e)
+	}
+	if copies != 0 {
+		t.Errorf("expect nothing to be copied by the second run, but is %d", copies)
+	}
+
+	// the updated source is copied again
+	writeTestFile(t, filepath.Join(root, "src/a.txt"), "c", time.Now().Add(time.Hour))
+	if e := s.sync(context.Background()); e != nil {
+		t.Fatal(e)
+	}
+	if copies != 1 {
+		t.Errorf("expect the updated file to be copied, but is %d", copies)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "dest/a.txt")); string(data) != "c" {
+		t.Errorf("expect '%s', but is '%s'", "c", data)
+	}
+}
+
+func TestSyncTwoWayTwice(t *testing.T) {
+	copies := 0
+	s, root := newTestSyncer(t, syncModeTwoWay, &copies)
+	old := time.Now().Add(-time.Hour)
+	writeTestFile(t, filepath.Join(root, "src/a.txt"), "a", old)
+	writeTestFile(t, filepath.Join(root, "dest/b.txt"), "b", old)
+
+	if e := s.sync(context.Background()); e != nil {
+		t.Fatal(e)
+	}
+	if copies != 2 {
+		t.Errorf("expect 2 files to be copied, but is %d", copies)
+	}
+	copies = 0
+	if e := s.sync(context.Background()); e != nil {
+		t.Fatal(e)
+	}
+	if copies != 0 {
+		t.Errorf("expect nothing to be copied by the second run, but is %d", copies)
+	}
+}
@@ -0,0 +1,209 @@
+package scheduled
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-drive/common"
+	"go-drive/common/i18n"
+	"go-drive/common/req"
+	"go-drive/common/types"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const notifyTimeout = 30 * time.Second
+
+var notifyOnValues = []string{types.JobNotifyOnFailure, types.JobNotifyOnSuccess, types.JobNotifyAlways}
+
+// JobNotification is the execution result sent to the notification webhook
+type JobNotification struct {
+	JobID       uint   `json:"jobId"`
+	Description string `json:"description"`
+	Job         string `json:"job"`
+	ExecutionID uint   `json:"executionId"`
+	Attempt     int    `json:"attempt"`
+	Status      string `json:"status"`
+	ErrorMsg    string `json:"errorMsg,omitempty"`
+	// StartedAt and CompletedAt are unix timestamps in milliseconds
+	StartedAt   uint64 `json:"startedAt"`
+	CompletedAt uint64 `json:"completedAt"`
+}
+
+// notifier sends the execution result of jobs by webhook and email
+type notifier struct {
+	smtp   common.SMTPConfig
+	client *req.Client
+	// timeout is the max duration of sending each notification, it's not retried if failed
+	timeout time.Duration
+	// ms and lang are used to translate the emails
+	ms   i18n.MessageSource
+	lang string
+}
+
+func newNotifier(config common.Config, ms i18n.MessageSource) (*notifier, error) {
+	client, e := req.NewClient("", nil, nil, &http.Client{Timeout: notifyTimeout})
+	if e != nil {
+		return nil, e
+	}
+	return &notifier{smtp: config.SMTP, client: client, timeout: notifyTimeout, ms: ms, lang: config.DefaultLang}, nil
+}
+
+func (n *notifier) t(key string, args ...string) string {
+	return i18n.TranslateT(n.lang, n.ms, i18n.T(key, args...))
+}
+
+func shouldNotify(job types.Job, status string) bool {
+	switch job.NotifyOn {
+	case types.JobNotifyAlways:
+		return true
+	case types.JobNotifyOnFailure:
+		return status == types.JobExecutionFailed
+	case types.JobNotifyOnSuccess:
+		return status == types.JobExecutionSuccess
+	}
+	return false
+}
+
+// notify sends the notifications of the execution if needed, the errors are reported to onError
+func (n *notifier) notify(job types.Job, exec types.JobExecution, onError func(error)) {
+	if !shouldNotify(job, exec.Status) {
+		return
+	}
+	notification := JobNotification{
+		JobID:       job.ID,
+		Description: job.Description,
+		Job:         job.Job,
+		ExecutionID: exec.ID,
+		Attempt:     exec.Attempt,
+		Status:      exec.Status,
+		ErrorMsg:    exec.ErrorMsg,
+		StartedAt:   exec.StartedAt,
+		CompletedAt: exec.CompletedAt,
+	}
+	if job.NotifyWebhook != "" {
+		if e := n.postWebhook(job.NotifyWebhook, notification); e != nil {
+			onError(fmt.Errorf("webhook: %w", e))
+		}
+	}
+	if job.NotifyEmail != "" {
+		if e := n.sendEmail(job.NotifyEmail, notification); e != nil {
+			onError(fmt.Errorf("email: %w", e))
+		}
+	}
+}
+
+func (n *notifier) postWebhook(url string, notification JobNotification) error {
+	body, e := json.Marshal(notification)
+	if e != nil {
+		return e
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+	resp, e := n.client.Post(ctx, url, nil, req.NewBytesBody(body, "application/json"))
+	if e != nil {
+		return e
+	}
+	defer func() { _ = resp.Dispose() }()
+	if resp.Status() < 200 || resp.Status() >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.Status())
+	}
+	return nil
+}
+
+func (n *notifier) sendEmail(to string, notification JobNotification) error {
+	if n.smtp.Host == "" {
+		return errors.New(n.t("jobs.notify.smtp_not_configured"))
+	}
+	addresses, e := mail.ParseAddressList(to)
+	if e != nil {
+		return e
+	}
+	status := n.t("jobs.notify." + notification.Status)
+	subject := n.t("jobs.notify.subject", notification.Description, status)
+	body := n.t("jobs.notify.body",
+		notification.Description, notification.Job, status,
+		strconv.Itoa(notification.Attempt),
+		time.UnixMilli(int64(notification.StartedAt)).Format(time.RFC3339),
+		time.UnixMilli(int64(notification.CompletedAt)).Format(time.RFC3339),
+		i18n.TranslateT(n.lang, n.ms, notification.ErrorMsg),
+	)
+	return n.sendMail(addresses, subject, body)
+}
+
+// sendMail sends a plain text email through the configured SMTP server
+func (n *notifier) sendMail(to []*mail.Address, subject, body string) error {
+	c := n.smtp
+	addr := net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	conn, e := net.DialTimeout("tcp", addr, n.timeout)
+	if e != nil {
+		return e
+	}
+	_ = conn.SetDeadline(time.Now().Add(n.timeout))
+	tlsConfig := &tls.Config{ServerName: c.Host}
+	if c.TLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	client, e := smtp.NewClient(conn, c.Host)
+	if e != nil {
+		_ = conn.Close()
+		return e
+	}
+	defer func() { _ = client.Close() }()
+
+	if !c.TLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if e := client.StartTLS(tlsConfig); e != nil {
+				return e
+			}
+		}
+	}
+	if c.Username != "" {
+		if e := client.Auth(smtp.PlainAuth("", c.Username, c.Password, c.Host)); e != nil {
+			return e
+		}
+	}
+
+	from := c.From
+	if from == "" {
+		from = c.Username
+	}
+	if e := client.Mail(from); e != nil {
+		return e
+	}
+	recipients := make([]string, 0, len(to))
+	for _, a := range to {
+		if e := client.Rcpt(a.Address); e != nil {
+			return e
+		}
+		recipients = append(recipients, a.String())
+	}
+	w, e := client.Data()
+	if e != nil {
+		return e
+	}
+	msg := strings.Builder{}
+	msg.WriteString("From: " + from + "\r\n")
+	msg.WriteString("To: " + strings.Join(recipients, ", ") + "\r\n")
+	msg.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	if _, e := w.Write([]byte(msg.String())); e != nil {
+		return e
+	}
+	if e := w.Close(); e != nil {
+		return e
+	}
+	return client.Quit()
+}
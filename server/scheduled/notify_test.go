@@ -0,0 +1,371 @@
+package scheduled
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"go-drive/common"
+	"go-drive/common/i18n"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"go-drive/storage"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSMTPMail is the mail received by the SMTP stand-in
+type testSMTPMail struct {
+	from string
+	to   []string
+	data string
+}
+
+// testSMTP is a SMTP stand-in accepting the PLAIN authentication,
+// the recipients in the domain 'rejected.test' are rejected
+type testSMTP struct {
+	host     string
+	port     int
+	username string
+	password string
+
+	mu          sync.Mutex
+	connections int
+	mails       []testSMTPMail
+}
+
+func newTestSMTP(t *testing.T) *testSMTP {
+	l, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	addr := l.Addr().(*net.TCPAddr)
+	s := &testSMTP{host: addr.IP.String(), port: addr.Port, username: "user", password: "pass"}
+	go func() {
+		for {
+			conn, e := l.Accept()
+			if e != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testSMTP) config() common.SMTPConfig {
+	return common.SMTPConfig{Host: s.host, Port: s.port, Username: s.username, Password: s.password, From: "go-drive@example.test"}
+}
+
+func (s *testSMTP) received() (int, []testSMTPMail) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connections, append([]testSMTPMail(nil), s.mails...)
+}
+
+func (s *testSMTP) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	s.mu.Lock()
+	s.connections++
+	s.mu.Unlock()
+
+	r := bufio.NewReader(conn)
+	reply := func(lines ...string) {
+		_, _ = conn.Write([]byte(strings.Join(lines, "\r\n") + "\r\n"))
+	}
+	reply("220 test ESMTP")
+	authenticated := false
+	mail := testSMTPMail{}
+	for {
+		line, e := r.ReadString('\n')
+		if e != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			reply("250-test", "250 AUTH PLAIN")
+		case "AUTH":
+			credentials, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "AUTH PLAIN "))
+			if string(credentials) != "\x00"+s.username+"\x00"+s.password {
+				reply("535 authentication failed")
+				continue
+			}
+			authenticated = true
+			reply("235 authenticated")
+		case "MAIL":
+			if !authenticated {
+				reply("530 authentication required")
+				continue
+			}
+			mail.from = strings.Trim(strings.TrimPrefix(line, "MAIL FROM:"), "<>")
+			reply("250 ok")
+		case "RCPT":
+			to := strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>")
+			if strings.HasSuffix(to, "@rejected.test") {
+				reply("550 mailbox unavailable")
+				continue
+			}
+			mail.to = append(mail.to, to)
+			reply("250 ok")
+		case "DATA":
+			reply("354 end with .")
+			data := strings.Builder{}
+			for {
+				l, e := r.ReadString('\n')
+				if e != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			mail.data = data.String()
+			s.mu.Lock()
+			s.mails = append(s.mails, mail)
+			s.mu.Unlock()
+			mail = testSMTPMail{}
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+// testWebhook is a webhook stand-in responding with the status, the requests are recorded
+type testWebhook struct {
+	*httptest.Server
+	mu            sync.Mutex
+	status        int
+	notifications []JobNotification
+}
+
+func newTestWebhook(t *testing.T, status int, hang chan struct{}) *testWebhook {
+	w := &testWebhook{status: status}
+	w.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var n JobNotification
+		_ = json.NewDecoder(r.Body).Decode(&n)
+		w.mu.Lock()
+		w.notifications = append(w.notifications, n)
+		w.mu.Unlock()
+		if hang != nil {
+			<-hang
+		}
+		rw.WriteHeader(w.status)
+	}))
+	t.Cleanup(w.Close)
+	if hang != nil {
+		// the hanging handler must return before the server is closed
+		t.Cleanup(func() { close(hang) })
+	}
+	return w
+}
+
+func (w *testWebhook) received() []JobNotification {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]JobNotification(nil), w.notifications...)
+}
+
+func newTestNotifier(t *testing.T, smtp common.SMTPConfig) *notifier {
+	ms, e := i18n.NewFileMessageSource(common.Config{LangDir: "../../docs/lang", DefaultLang: "en-US"})
+	if e != nil {
+		t.Fatal(e)
+	}
+	n, e := newNotifier(common.Config{SMTP: smtp, DefaultLang: "en-US"}, ms)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return n
+}
+
+// notifyErrors sends the notifications and returns the reported errors
+func notifyErrors(n *notifier, job types.Job, exec types.JobExecution) []string {
+	errs := make([]string, 0)
+	n.notify(job, exec, func(e error) { errs = append(errs, e.Error()) })
+	return errs
+}
+
+var testFailedExecution = types.JobExecution{
+	ID: 2, JobId: 1, Attempt: 3, Status: types.JobExecutionFailed, ErrorMsg: "disk full",
+	StartedAt: 1000, CompletedAt: 2000,
+}
+
+func TestNotifyWebhook(t *testing.T) {
+	n := newTestNotifier(t, common.SMTPConfig{})
+	webhook := newTestWebhook(t, http.StatusOK, nil)
+	job := types.Job{ID: 1, Description: "backup", Job: "sync", NotifyOn: types.JobNotifyOnFailure, NotifyWebhook: webhook.URL}
+
+	if errs := notifyErrors(n, job, testFailedExecution); len(errs) != 0 {
+		t.Errorf("unexpected errors %v", errs)
+	}
+	received := webhook.received()
+	expected := JobNotification{
+		JobID: 1, Description: "backup", Job: "sync", ExecutionID: 2, Attempt: 3,
+		Status: types.JobExecutionFailed, ErrorMsg: "disk full", StartedAt: 1000, CompletedAt: 2000,
+	}
+	if len(received) != 1 || received[0] != expected {
+		t.Errorf("expect %v to be received, but is %v", expected, received)
+	}
+
+	// the successful execution is not notified
+	success := testFailedExecution
+	success.Status = types.JobExecutionSuccess
+	notifyErrors(n, job, success)
+	if len(webhook.received()) != 1 {
+		t.Errorf("expect the successful execution not to be notified")
+	}
+}
+
+func TestNotifyWebhookFailure(t *testing.T) {
+	n := newTestNotifier(t, common.SMTPConfig{})
+	webhook := newTestWebhook(t, http.StatusInternalServerError, nil)
+	job := types.Job{ID: 1, NotifyOn: types.JobNotifyAlways, NotifyWebhook: webhook.URL}
+
+	errs := notifyErrors(n, job, testFailedExecution)
+	if len(errs) != 1 || errs[0] != "webhook: unexpected status code 500" {
+		t.Errorf("expect the failure to be reported once, but is %v", errs)
+	}
+	if len(webhook.received()) != 1 {
+		t.Errorf("expect the webhook not to be retried, but is requested %d times", len(webhook.received()))
+	}
+
+	// the webhook not responding is given up after the timeout
+	hanging := newTestWebhook(t, http.StatusOK, make(chan struct{}))
+	job.NotifyWebhook = hanging.URL
+	n.timeout = 100 * time.Millisecond
+	start := time.Now()
+	errs = notifyErrors(n, job, testFailedExecution)
+	if len(errs) != 1 || !strings.HasPrefix(errs[0], "webhook: ") {
+		t.Errorf("expect the timeout to be reported, but is %v", errs)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("expect to give up after the timeout, but is %s", d)
+	}
+	if len(hanging.received()) != 1 {
+		t.Errorf("expect the webhook not to be retried, but is requested %d times", len(hanging.received()))
+	}
+}
+
+func TestNotifyEmail(t *testing.T) {
+	smtp := newTestSMTP(t)
+	n := newTestNotifier(t, smtp.config())
+	job := types.Job{ID: 1, Description: "backup", Job: "sync", NotifyOn: types.JobNotifyOnFailure,
+		NotifyEmail: "Admin <admin@example.test>, ops@example.test"}
+
+	if errs := notifyErrors(n, job, testFailedExecution); len(errs) != 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	_, mails := smtp.received()
+	if len(mails) != 1 {
+		t.Fatalf("expect 1 mail, but is %d", len(mails))
+	}
+	m := mails[0]
+	if m.from != "go-drive@example.test" || strings.Join(m.to, ",") != "admin@example.test,ops@example.test" {
+		t.Errorf("unexpected sender '%s' or recipients %v", m.from, m.to)
+	}
+	for _, s := range []string{
+		"Subject: [go-drive] Job 'backup' failed\r\n",
+		"Status: failed\r\n",
+		"Attempt: 3\r\n",
+		"Error: disk full",
+	} {
+		if !strings.Contains(m.data, s) {
+			t.Errorf("expect the mail to contain '%s', but is\n%s", s, m.data)
+		}
+	}
+}
+
+func TestNotifyEmailFailure(t *testing.T) {
+	smtp := newTestSMTP(t)
+	job := types.Job{ID: 1, NotifyOn: types.JobNotifyAlways, NotifyEmail: "admin@rejected.test"}
+
+	errs := notifyErrors(newTestNotifier(t, smtp.config()), job, testFailedExecution)
+	if len(errs) != 1 || !strings.HasPrefix(errs[0], "email: 550 ") {
+		t.Errorf("expect the rejection to be reported once, but is %v", errs)
+	}
+	config := smtp.config()
+	config.Password = "wrong"
+	job.NotifyEmail = "admin@example.test"
+	errs = notifyErrors(newTestNotifier(t, config), job, testFailedExecution)
+	if len(errs) != 1 || !strings.HasPrefix(errs[0], "email: 535 ") {
+		t.Errorf("expect the authentication failure to be reported once, but is %v", errs)
+	}
+	if connections, mails := smtp.received(); connections != 2 || len(mails) != 0 {
+		t.Errorf("expect 2 connections without mails, but is %d connections and %d mails", connections, len(mails))
+	}
+
+	errs = notifyErrors(newTestNotifier(t, common.SMTPConfig{}), job, testFailedExecution)
+	if len(errs) != 1 || errs[0] != "email: The SMTP server is not configured" {
+		t.Errorf("expect the SMTP server not configured, but is %v", errs)
+	}
+}
+
+func TestExecuteNotifyFailure(t *testing.T) {
+	ch := registry.NewComponentHolder()
+	t.Cleanup(func() { _ = ch.Dispose() })
+	db, e := storage.NewDB(common.Config{
+		DataDir: t.TempDir(),
+		Db:      common.DbConfig{Type: "sqlite", Name: "data.db"},
+	}, ch)
+	if e != nil {
+		t.Fatal(e)
+	}
+	dao := storage.NewScheduledDAO(db, ch)
+	webhook := newTestWebhook(t, http.StatusBadGateway, nil)
+	job, e := dao.AddJob(types.Job{
+		Job: "not-exists", Params: "{}", MaxRetries: 1, RetryBackoff: "10ms",
+		NotifyOn: types.JobNotifyOnFailure, NotifyWebhook: webhook.URL,
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	je := &JobExecutor{
+		scheduledDAO: dao,
+		executions:   make(map[uint]*jobExecutionItem),
+		triggers:     make(map[uint]*eventTrigger),
+		notifier:     newTestNotifier(t, common.SMTPConfig{}),
+		disposed:     make(chan struct{}),
+	}
+
+	done := make(chan struct{})
+	go func() {
+		je.execute(job, nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expect the execution to be finished")
+	}
+
+	// the notification is sent once after the last attempt
+	if received := webhook.received(); len(received) != 1 || received[0].Attempt != 2 {
+		t.Errorf("expect the last attempt to be notified once, but is %v", received)
+	}
+	executions, e := dao.GetJobExecutions(job.ID)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(executions) != 2 {
+		t.Fatalf("expect 2 attempts, but is %d", len(executions))
+	}
+	for _, exec := range executions {
+		logged := strings.Contains(exec.Logs, "failed to send notification: webhook: unexpected status code "+
+			strconv.Itoa(http.StatusBadGateway))
+		if logged != (exec.Attempt == 2) {
+			t.Errorf("attempt %d: unexpected logs\n%s", exec.Attempt, exec.Logs)
+		}
+	}
+}
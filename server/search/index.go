@@ -3,6 +3,7 @@ package search
 import (
 	"errors"
 	"go-drive/common"
+	"go-drive/common/drive_util"
 	err "go-drive/common/errors"
 	"go-drive/common/event"
 	"go-drive/common/registry"
@@ -287,7 +288,8 @@ func (s *Service) walk(ctx types.TaskCtx, d types.IDrive, rootPath string,
 	if e == nil {
 		ctx.Progress(1, false)
 	}
-	if entry.Type() == types.TypeDir {
+	// the members of the archives are indexed too
+	if entry.Type() == types.TypeDir || drive_util.IsMountableArchive(entry) {
 		entries, e := d.List(ctx, rootPath)
 		if e != nil {
 			if ignoreError {
@@ -318,6 +320,9 @@ func (s *Service) onUpdated(dc types.DriveListenerContext, path string, includeD
 			if e != nil {
 				return nil, e
 			}
+			if drive_util.IsMountableArchive(entry) {
+				return nil, s.indexAll(ctx, path, true)
+			}
 			e = s.Index(ctx, entry)
 			if e != nil {
 				log.Printf("Error indexing %s: %s", path, e)
@@ -11,9 +11,11 @@ import (
 	"go-drive/common/types"
 	"go-drive/common/utils"
 	"go-drive/drive"
+	"go-drive/server/audit"
 	"go-drive/server/scheduled"
 	"go-drive/server/search"
 	"go-drive/server/thumbnail"
+	"go-drive/server/webhook"
 	"go-drive/storage"
 	"net/http"
 	"os"
@@ -29,11 +31,15 @@ func InitServer(config common.Config,
 	bus event.Bus,
 	rootDrive *drive.RootDrive,
 	driveAccess *drive.Access,
+	trash *drive.Trash,
+	versions *drive.Versions,
 	searcher *search.Service,
 	tokenStore types.TokenStore,
 	thumbnail *thumbnail.Maker,
 	signer *utils.Signer,
 	chunkUploader *ChunkUploader,
+	tusUploader *TusUploader,
+	hasher *Hasher,
 	runner task.Runner,
 	optionsDAO *storage.OptionsDAO,
 	userDAO *storage.UserDAO,
@@ -43,7 +49,18 @@ func InitServer(config common.Config,
 	permissionDAO *storage.PathPermissionDAO,
 	pathMountDAO *storage.PathMountDAO,
 	scheduledDAO *storage.ScheduledDAO,
+	shareDAO *storage.ShareDAO,
+	trashDAO *storage.TrashDAO,
+	checkpointDAO *storage.CopyCheckpointDAO,
 	jobExecutor *scheduled.JobExecutor,
+	webhookService *webhook.Service,
+	webhookDAO *storage.WebhookDAO,
+	auditLogger *audit.Logger,
+	auditLogDAO *storage.AuditLogDAO,
+	quotas *drive.Quotas,
+	quotaDAO *storage.QuotaDAO,
+	apiTokenDAO *storage.APITokenDAO,
+	twoFactorDAO *storage.TwoFactorDAO,
 	messageSource i18n.MessageSource) (*gin.Engine, error) {
 
 	if utils.IsDebugOn {
@@ -62,7 +79,10 @@ func InitServer(config common.Config,
 
 	engine.Use(apiResultHandler(messageSource))
 
-	userAuth := NewUserAuth(userDAO)
+	twoFactor := NewTwoFactorAuth(config, twoFactorDAO)
+	userAuth := NewUserAuth(config, userDAO, groupDAO, apiTokenDAO, twoFactor, ch)
+	tokenStore = NewAPITokenStore(tokenStore, userAuth)
+	oidcAuth := NewOIDCAuth(config, userDAO, groupDAO, ch)
 
 	router := engine.Group(config.APIPath)
 
@@ -72,25 +92,54 @@ func InitServer(config common.Config,
 	if e := InitCommonRoutes(ch, router, optionsDAO, tokenStore, runner); e != nil {
 		return nil, e
 	}
-	if e := InitAuthRoutes(router, userAuth, tokenStore, failBanGroup); e != nil {
+	if e := InitAuthRoutes(router, config, userAuth, oidcAuth, twoFactor, tokenStore, apiTokenDAO, failBanGroup); e != nil {
 		return nil, e
 	}
-	if e := InitAdminRoutes(router, ch, config, bus, driveAccess, rootDrive, searcher, tokenStore, optionsDAO,
-		userDAO, groupDAO, driveDAO, driveDataDAO, permissionDAO, pathMountDAO); e != nil {
+	if e := InitAdminRoutes(router, ch, config, bus, driveAccess, rootDrive, searcher, tokenStore, signer, optionsDAO,
+		userDAO, groupDAO, driveDAO, driveDataDAO, permissionDAO, pathMountDAO, twoFactorDAO, auditLogger); e != nil {
 		return nil, e
 	}
 
-	if e := InitJobsRoutes(router, ch, tokenStore, jobExecutor, scheduledDAO); e != nil {
+	if e := InitJobsRoutes(router, ch, tokenStore, jobExecutor, scheduledDAO, auditLogger); e != nil {
 		return nil, e
 	}
 
-	if e := InitDriveRoutes(router, driveAccess, searcher, config, thumbnail,
-		signer, chunkUploader, runner, tokenStore, userDAO, optionsDAO); e != nil {
+	if e := InitDriveRoutes(router, ch, driveAccess, versions, searcher, config, thumbnail,
+		signer, chunkUploader, hasher, runner, tokenStore, userDAO, optionsDAO, checkpointDAO, bus); e != nil {
+		return nil, e
+	}
+
+	if e := InitTusRoutes(router, driveAccess, tokenStore, tusUploader); e != nil {
+		return nil, e
+	}
+
+	if e := InitShareRoutes(router, ch, config, driveAccess, signer, tokenStore,
+		shareDAO, userDAO, failBanGroup, bus); e != nil {
+		return nil, e
+	}
+
+	if e := InitTrashRoutes(router, driveAccess, trash, runner, tokenStore, trashDAO); e != nil {
+		return nil, e
+	}
+
+	if e := InitEventRoutes(router, driveAccess, tokenStore, bus); e != nil {
+		return nil, e
+	}
+
+	if e := InitWebhookRoutes(router, tokenStore, webhookService, webhookDAO, auditLogger); e != nil {
+		return nil, e
+	}
+
+	if e := InitAuditRoutes(router, tokenStore, auditLogDAO); e != nil {
+		return nil, e
+	}
+
+	if e := InitQuotaRoutes(router, tokenStore, quotas, runner, quotaDAO, auditLogger); e != nil {
 		return nil, e
 	}
 
 	if config.WebDav.Enabled {
-		if e := InitWebdavAccess(engine, config, driveAccess, userAuth); e != nil {
+		if e := InitWebdavAccess(engine, config, driveAccess, userAuth, bus); e != nil {
 			return nil, e
 		}
 	}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"go-drive/common"
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/registry"
+	"go-drive/common/utils"
+	"go-drive/storage"
+	"log"
+	"time"
+)
+
+const (
+	signerSecretsKey = "signer.secrets"
+	signerSecretSize = 32
+	// signerReloadInterval is the interval to reload the secrets, which may be rotated by other instances
+	signerReloadInterval = time.Minute
+	// signerMinReloadInterval limits the reloading triggered by the invalid signatures
+	signerMinReloadInterval = 5 * time.Second
+)
+
+type signerSecrets struct {
+	Secret   string   `json:"secret"`
+	Previous []string `json:"previous"`
+	// Expires is the unix time when the previous secret is removed,
+	// all signatures signed by it have expired then
+	Expires map[string]int64 `json:"expires"`
+}
+
+// NewSigner creates the signer for signed urls.
+// The secrets in config take precedence over the secrets persisted in database,
+// so all instances sharing the same config or database can validate each other's signatures.
+func NewSigner(config common.Config, optionsDAO *storage.OptionsDAO, ch *registry.ComponentsHolder) (*utils.Signer, error) {
+	if config.Signature.Secret != "" {
+		previous := make([][]byte, 0, len(config.Signature.PreviousSecrets))
+		for _, s := range config.Signature.PreviousSecrets {
+			previous = append(previous, []byte(s))
+		}
+		return utils.NewSignerWithSecrets([]byte(config.Signature.Secret), previous...), nil
+	}
+
+	secrets, e := loadSignerSecrets(optionsDAO)
+	if e != nil {
+		return nil, e
+	}
+	if secrets.Secret == "" {
+		log.Println("[Signer] no secret found, generating new one")
+		secrets.Secret, e = newSignerSecret()
+		if e != nil {
+			return nil, e
+		}
+		if e := saveSignerSecrets(optionsDAO, secrets); e != nil {
+			return nil, e
+		}
+	}
+	signer := utils.NewSignerWithSecrets(nil)
+	if e := applySignerSecrets(signer, secrets); e != nil {
+		return nil, e
+	}
+	signer.SetReloader(func() ([]byte, [][]byte, error) {
+		v, e := optionsDAO.Reload(signerSecretsKey)
+		if e != nil {
+			return nil, nil, e
+		}
+		secrets := signerSecrets{}
+		if e := json.Unmarshal([]byte(v), &secrets); e != nil {
+			return nil, nil, e
+		}
+		return decodeSignerSecrets(secrets)
+	}, signerMinReloadInterval)
+	ch.Add("signerReloader", &signerReloader{stop: utils.TimeTick(func() {
+		if e := signer.Reload(); e != nil {
+			log.Printf("[Signer] failed to reload secrets: %v", e)
+		}
+	}, signerReloadInterval)})
+	return signer, nil
+}
+
+type signerReloader struct {
+	stop func()
+}
+
+func (s *signerReloader) Dispose() error {
+	s.stop()
+	return nil
+}
+
+// RotateSignerSecret generates a new persisted secret.
+// The rotated secrets are kept to validate the signatures signed before until the signatures expire.
+func RotateSignerSecret(config common.Config, signer *utils.Signer, optionsDAO *storage.OptionsDAO) error {
+	if config.Signature.Secret != "" {
+		return err.NewNotAllowedMessageError(i18n.T("api.admin.signer_secret_configured"))
+	}
+	secrets, e := loadSignerSecrets(optionsDAO)
+	if e != nil {
+		return e
+	}
+	newSecret, e := newSignerSecret()
+	if e != nil {
+		return e
+	}
+	now := time.Now().Unix()
+	previous := make([]string, 0, len(secrets.Previous)+1)
+	expires := make(map[string]int64, len(secrets.Previous)+1)
+	if secrets.Secret != "" {
+		previous = append(previous, secrets.Secret)
+		expires[secrets.Secret] = time.Now().Add(config.SignatureTTL).Unix()
+	}
+	for _, p := range secrets.Previous {
+		exp, ok := secrets.Expires[p]
+		if !ok {
+			// rotated before the expiration is recorded
+			exp = time.Now().Add(config.SignatureTTL).Unix()
+		}
+		if exp > now {
+			previous = append(previous, p)
+			expires[p] = exp
+		}
+	}
+	secrets.Secret = newSecret
+	secrets.Previous = previous
+	secrets.Expires = expires
+	if e := saveSignerSecrets(optionsDAO, secrets); e != nil {
+		return e
+	}
+	return applySignerSecrets(signer, secrets)
+}
+
+func applySignerSecrets(signer *utils.Signer, secrets signerSecrets) error {
+	secret, previous, e := decodeSignerSecrets(secrets)
+	if e != nil {
+		return e
+	}
+	signer.SetSecrets(secret, previous...)
+	return nil
+}
+
+// decodeSignerSecrets decodes the secrets, the expired previous secrets are skipped
+func decodeSignerSecrets(secrets signerSecrets) ([]byte, [][]byte, error) {
+	secret, e := utils.Base64URLDecode(secrets.Secret)
+	if e != nil {
+		return nil, nil, e
+	}
+	now := time.Now().Unix()
+	previous := make([][]byte, 0, len(secrets.Previous))
+	for _, s := range secrets.Previous {
+		if exp, ok := secrets.Expires[s]; ok && exp <= now {
+			continue
+		}
+		p, e := utils.Base64URLDecode(s)
+		if e != nil {
+			return nil, nil, e
+		}
+		previous = append(previous, p)
+	}
+	return secret, previous, nil
+}
+
+func loadSignerSecrets(optionsDAO *storage.OptionsDAO) (signerSecrets, error) {
+	secrets := signerSecrets{}
+	v, e := optionsDAO.Get(signerSecretsKey)
+	if e != nil {
+		return secrets, e
+	}
+	if v == "" {
+		return secrets, nil
+	}
+	return secrets, json.Unmarshal([]byte(v), &secrets)
+}
+
+func saveSignerSecrets(optionsDAO *storage.OptionsDAO, secrets signerSecrets) error {
+	v, e := json.Marshal(secrets)
+	if e != nil {
+		return e
+	}
+	return optionsDAO.Set(signerSecretsKey, string(v))
+}
+
+func newSignerSecret() (string, error) {
+	b := make([]byte, signerSecretSize)
+	if _, e := rand.Read(b); e != nil {
+		return "", e
+	}
+	return utils.Base64URLEncode(b), nil
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"go-drive/common"
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/registry"
+	"go-drive/common/utils"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	path2 "path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	tusUploadExpiration = 24 * time.Hour
+	// statusChecksumMismatch is defined by the checksum extension of tus
+	statusChecksumMismatch = 460
+)
+
+var (
+	tusUploadIdPattern = regexp.MustCompile("^[a-f0-9]{32}$")
+	// tusChecksumAlgorithms are the supported algorithms of the checksum extension
+	tusChecksumAlgorithms = map[string]func() hash.Hash{
+		"md5":    md5.New,
+		"sha1":   sha1.New,
+		"sha256": sha256.New,
+	}
+)
+
+// TusUploader stores the uploads of the tus protocol in the temp upload directory.
+// Each upload is a directory containing the info file and the data file.
+type TusUploader struct {
+	dir string
+
+	locks map[string]bool
+	mux   *sync.Mutex
+
+	stopCleaner func()
+}
+
+type TusUpload struct {
+	Id       string `json:"id"`
+	Username string `json:"username"`
+	// Path is the destination path in the user's drive
+	Path     string `json:"path"`
+	Override bool   `json:"override"`
+	Length   int64  `json:"length"`
+	// Metadata is the raw Upload-Metadata header
+	Metadata string `json:"metadata"`
+	// Offset is the size of the received data, it's not saved in the info file
+	Offset    int64     `json:"-"`
+	ExpiresAt time.Time `json:"-"`
+}
+
+func NewTusUploader(config common.Config, ch *registry.ComponentsHolder) (*TusUploader, error) {
+	dir, e := config.GetTempDir("upload", true)
+	if e != nil {
+		return nil, e
+	}
+	dir = filepath.Join(dir, "tus")
+	if e := os.MkdirAll(dir, 0755); e != nil {
+		return nil, e
+	}
+	t := &TusUploader{dir: dir, locks: make(map[string]bool), mux: &sync.Mutex{}}
+	t.stopCleaner = utils.TimeTick(t.clean, time.Hour)
+	ch.Add("tusUploader", t)
+	return t, nil
+}
+
+func (t *TusUploader) CreateUpload(username, path string, override bool, length int64, metadata string) (*TusUpload, error) {
+	if length < 0 {
+		return nil, err.NewBadRequestError(i18n.T("api.chunk_uploader.invalid_file_size"))
+	}
+	upload := &TusUpload{
+		Id:       strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Username: username,
+		Path:     path,
+		Override: override,
+		Length:   length,
+		Metadata: metadata,
+	}
+	if e := os.Mkdir(t.getDir(upload.Id), 0755); e != nil {
+		return nil, e
+	}
+	info, e := json.Marshal(upload)
+	if e != nil {
+		return nil, e
+	}
+	if e := os.WriteFile(t.getInfoFile(upload.Id), info, 0644); e != nil {
+		_ = os.RemoveAll(t.getDir(upload.Id))
+		return nil, e
+	}
+	if e := os.WriteFile(t.getDataFile(upload.Id), nil, 0644); e != nil {
+		_ = os.RemoveAll(t.getDir(upload.Id))
+		return nil, e
+	}
+	upload.ExpiresAt = time.Now().Add(tusUploadExpiration)
+	return upload, nil
+}
+
+// GetUpload returns the upload created by the user, returns NotFoundError if it does not exist or expired
+func (t *TusUploader) GetUpload(id, username string) (*TusUpload, error) {
+	if !tusUploadIdPattern.MatchString(id) {
+		return nil, err.NewNotFoundError()
+	}
+	info, e := os.ReadFile(t.getInfoFile(id))
+	if os.IsNotExist(e) {
+		return nil, err.NewNotFoundError()
+	}
+	if e != nil {
+		return nil, e
+	}
+	upload := &TusUpload{}
+	if e := json.Unmarshal(info, upload); e != nil {
+		return nil, e
+	}
+	if upload.Username != username {
+		return nil, err.NewNotFoundError()
+	}
+	stat, e := os.Stat(t.getDataFile(id))
+	if e != nil {
+		return nil, e
+	}
+	upload.Offset = stat.Size()
+	upload.ExpiresAt = stat.ModTime().Add(tusUploadExpiration)
+	if time.Now().After(upload.ExpiresAt) {
+		return nil, err.NewNotFoundError()
+	}
+	return upload, nil
+}
+
+// WriteChunk appends the data at the offset of the upload.
+// checksum is the value of Upload-Checksum header, the data is discarded if the checksum mismatches.
+func (t *TusUploader) WriteChunk(upload *TusUpload, offset int64, checksum string, reader io.Reader) error {
+	if !t.lock(upload.Id) {
+		return tusError{http.StatusLocked, i18n.T("api.tus.upload_locked")}
+	}
+	defer t.unlock(upload.Id)
+
+	if offset != upload.Offset {
+		return tusError{http.StatusConflict, i18n.T("api.tus.offset_mismatch")}
+	}
+	var h hash.Hash
+	var expected []byte
+	if checksum != "" {
+		alg, value, _ := strings.Cut(checksum, " ")
+		newHash, ok := tusChecksumAlgorithms[alg]
+		if !ok {
+			return err.NewBadRequestError(i18n.T("api.tus.unsupported_checksum_algorithm", alg))
+		}
+		v, e := base64.StdEncoding.DecodeString(value)
+		if e != nil {
+			return err.NewBadRequestError(i18n.T("api.tus.invalid_checksum"))
+		}
+		h, expected = newHash(), v
+	}
+
+	file, e := os.OpenFile(t.getDataFile(upload.Id), os.O_WRONLY|os.O_APPEND, 0644)
+	if e != nil {
+		return e
+	}
+	defer func() { _ = file.Close() }()
+
+	var w io.Writer = file
+	if h != nil {
+		w = io.MultiWriter(file, h)
+	}
+	// the data exceeds the length is not accepted
+	written, e := io.Copy(w, io.LimitReader(reader, upload.Length-offset))
+	if h != nil && e == nil && !bytes.Equal(h.Sum(nil), expected) {
+		e = tusError{statusChecksumMismatch, i18n.T("api.tus.checksum_mismatch")}
+	}
+	if h != nil && e != nil {
+		// the chunk must be discarded entirely if it's not verified
+		if te := file.Truncate(offset); te != nil {
+			return te
+		}
+		written = 0
+	}
+	// the received data is kept for resuming, even if the connection is broken
+	upload.Offset = offset + written
+	upload.ExpiresAt = time.Now().Add(tusUploadExpiration)
+	return e
+}
+
+// OpenData opens the data file of the completed upload
+func (t *TusUploader) OpenData(upload *TusUpload) (*os.File, error) {
+	if upload.Offset != upload.Length {
+		return nil, err.NewNotAllowedMessageError(i18n.T("api.chunk_uploader.missing_chunks"))
+	}
+	return os.Open(t.getDataFile(upload.Id))
+}
+
+func (t *TusUploader) DeleteUpload(upload *TusUpload) error {
+	if !t.lock(upload.Id) {
+		return tusError{http.StatusLocked, i18n.T("api.tus.upload_locked")}
+	}
+	defer t.unlock(upload.Id)
+	return os.RemoveAll(t.getDir(upload.Id))
+}
+
+func (t *TusUploader) lock(id string) bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.locks[id] {
+		return false
+	}
+	t.locks[id] = true
+	return true
+}
+
+func (t *TusUploader) unlock(id string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	delete(t.locks, id)
+}
+
+// clean removes the expired uploads
+func (t *TusUploader) clean() {
+	entries, e := os.ReadDir(t.dir)
+	if e != nil {
+		log.Printf("[TusUploader] failed to list uploads: %v", e)
+		return
+	}
+	for _, entry := range entries {
+		stat, e := os.Stat(t.getDataFile(entry.Name()))
+		if e == nil && time.Now().Before(stat.ModTime().Add(tusUploadExpiration)) {
+			continue
+		}
+		if !t.lock(entry.Name()) {
+			continue
+		}
+		if e := os.RemoveAll(t.getDir(entry.Name())); e != nil {
+			log.Printf("[TusUploader] failed to remove expired upload %s: %v", entry.Name(), e)
+		}
+		t.unlock(entry.Name())
+	}
+}
+
+func (t *TusUploader) getDir(id string) string {
+	return path2.Join(t.dir, filepath.Clean(id))
+}
+
+func (t *TusUploader) getInfoFile(id string) string {
+	return path2.Join(t.getDir(id), "info")
+}
+
+func (t *TusUploader) getDataFile(id string) string {
+	return path2.Join(t.getDir(id), "data")
+}
+
+func (t *TusUploader) Dispose() error {
+	t.stopCleaner()
+	return nil
+}
+
+// tusError is the error with the status code defined by tus protocol
+type tusError struct {
+	code int
+	msg  string
+}
+
+func (t tusError) Error() string {
+	return t.msg
+}
+
+func (t tusError) Code() int {
+	return t.code
+}
+
+func (t tusError) Name() string {
+	return "TUS"
+}
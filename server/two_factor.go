@@ -0,0 +1,233 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"go-drive/common"
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/types"
+	"go-drive/common/utils"
+	"go-drive/storage"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	recoveryCodesCount = 10
+	// totpSkew accepts the codes of the adjacent time steps for the clock drift
+	totpSkew = 1
+)
+
+// TwoFactorAuth manages the TOTP two-factor authentication of the users
+type TwoFactorAuth struct {
+	config common.TwoFactorConfig
+	dao    *storage.TwoFactorDAO
+	// mu serializes the verifications, so a code can only be used once
+	mu sync.Mutex
+}
+
+func NewTwoFactorAuth(config common.Config, dao *storage.TwoFactorDAO) *TwoFactorAuth {
+	return &TwoFactorAuth{config: config.Auth.TwoFactor, dao: dao}
+}
+
+// IsEnabled returns true if the user has enabled the two-factor authentication
+func (t *TwoFactorAuth) IsEnabled(username string) (bool, error) {
+	tf, e := t.dao.GetTwoFactor(username)
+	if e != nil {
+		if err.IsNotFoundError(e) {
+			return false, nil
+		}
+		return false, e
+	}
+	return tf.Enabled, nil
+}
+
+// IsRequired returns true if the user must enable the two-factor authentication
+func (t *TwoFactorAuth) IsRequired(user types.User) bool {
+	if !t.config.RequireForAdmin {
+		return false
+	}
+	for _, g := range user.Groups {
+		if g.Name == types.AdminUserGroup {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify verifies the TOTP code or the recovery code of the user, the recovery code is consumed
+func (t *TwoFactorAuth) Verify(username, code string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tf, e := t.dao.GetTwoFactor(username)
+	if e != nil {
+		return e
+	}
+	if !tf.Enabled {
+		return err.NewNotAllowedMessageError(i18n.T("storage.two_factor.not_enrolled", username))
+	}
+	return t.verify(tf, code)
+}
+
+func (t *TwoFactorAuth) verify(tf types.TwoFactor, code string) error {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if step, ok := utils.ValidateTOTP(tf.Secret, code, time.Now(), totpSkew); ok {
+		if step <= tf.LastStep {
+			return err.NewNotAllowedMessageError(i18n.T("api.auth.invalid_two_factor_code"))
+		}
+		tf.LastStep = step
+		return t.dao.SaveTwoFactor(tf)
+	}
+	if tf.Enabled && code != "" {
+		hash := hashRecoveryCode(code)
+		codes := splitRecoveryCodes(tf.RecoveryCodes)
+		for i, c := range codes {
+			if subtle.ConstantTimeCompare([]byte(c), []byte(hash)) == 1 {
+				tf.RecoveryCodes = strings.Join(append(codes[:i], codes[i+1:]...), ",")
+				return t.dao.SaveTwoFactor(tf)
+			}
+		}
+	}
+	return err.NewNotAllowedMessageError(i18n.T("api.auth.invalid_two_factor_code"))
+}
+
+// Enroll generates a new secret for the user, it takes effect after it's confirmed by Enable.
+// It returns the secret and the otpauth URI.
+func (t *TwoFactorAuth) Enroll(username string) (string, string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tf, e := t.dao.GetTwoFactor(username)
+	if e != nil && !err.IsNotFoundError(e) {
+		return "", "", e
+	}
+	if e == nil && tf.Enabled {
+		return "", "", err.NewNotAllowedMessageError(i18n.T("api.auth.two_factor_already_enabled"))
+	}
+	secret, e := utils.NewTOTPSecret()
+	if e != nil {
+		return "", "", e
+	}
+	e = t.dao.SaveTwoFactor(types.TwoFactor{
+		Username:  username,
+		Secret:    secret,
+		CreatedAt: uint64(time.Now().UnixMilli()),
+	})
+	if e != nil {
+		return "", "", e
+	}
+	return secret, utils.TOTPURI(t.config.Issuer, username, secret), nil
+}
+
+// Enable confirms the enrollment by the TOTP code, and returns the recovery codes
+func (t *TwoFactorAuth) Enable(username, code string) ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tf, e := t.dao.GetTwoFactor(username)
+	if e != nil {
+		return nil, e
+	}
+	if tf.Enabled {
+		return nil, err.NewNotAllowedMessageError(i18n.T("api.auth.two_factor_already_enabled"))
+	}
+	if e := t.verify(tf, code); e != nil {
+		return nil, e
+	}
+	tf, e = t.dao.GetTwoFactor(username)
+	if e != nil {
+		return nil, e
+	}
+	tf.Enabled = true
+	return t.resetRecoveryCodes(tf)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes, the code is required to confirm it
+func (t *TwoFactorAuth) RegenerateRecoveryCodes(username, code string) ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tf, e := t.getEnabled(username)
+	if e != nil {
+		return nil, e
+	}
+	if e := t.verify(tf, code); e != nil {
+		return nil, e
+	}
+	tf, e = t.dao.GetTwoFactor(username)
+	if e != nil {
+		return nil, e
+	}
+	return t.resetRecoveryCodes(tf)
+}
+
+// Disable disables the two-factor authentication, the code is required to confirm it
+func (t *TwoFactorAuth) Disable(username, code string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tf, e := t.getEnabled(username)
+	if e != nil {
+		return e
+	}
+	if e := t.verify(tf, code); e != nil {
+		return e
+	}
+	return t.dao.DeleteTwoFactor(username)
+}
+
+// Status returns whether the two-factor authentication is enabled and the count of the unused recovery codes
+func (t *TwoFactorAuth) Status(user types.User) (types.M, error) {
+	tf, e := t.dao.GetTwoFactor(user.Username)
+	if e != nil && !err.IsNotFoundError(e) {
+		return nil, e
+	}
+	return types.M{
+		"enabled":       tf.Enabled,
+		"required":      t.IsRequired(user),
+		"recoveryCodes": len(splitRecoveryCodes(tf.RecoveryCodes)),
+	}, nil
+}
+
+func (t *TwoFactorAuth) getEnabled(username string) (types.TwoFactor, error) {
+	tf, e := t.dao.GetTwoFactor(username)
+	if e != nil {
+		return tf, e
+	}
+	if !tf.Enabled {
+		return tf, err.NewNotAllowedMessageError(i18n.T("storage.two_factor.not_enrolled", username))
+	}
+	return tf, nil
+}
+
+func (t *TwoFactorAuth) resetRecoveryCodes(tf types.TwoFactor) ([]string, error) {
+	codes := make([]string, recoveryCodesCount)
+	hashes := make([]string, recoveryCodesCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, e := rand.Read(b); e != nil {
+			return nil, e
+		}
+		s := hex.EncodeToString(b)
+		codes[i] = s[:5] + "-" + s[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	tf.RecoveryCodes = strings.Join(hashes, ",")
+	if e := t.dao.SaveTwoFactor(tf); e != nil {
+		return nil, e
+	}
+	return codes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(code, "-", ""))
+	h := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(h[:])
+}
+
+func splitRecoveryCodes(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
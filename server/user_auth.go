@@ -1,35 +1,227 @@
 package server
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"go-drive/common"
 	err "go-drive/common/errors"
 	"go-drive/common/i18n"
+	"go-drive/common/registry"
 	"go-drive/common/types"
+	"go-drive/common/utils"
 	"go-drive/storage"
+	"log"
+	"strings"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	// apiTokenPrefix makes the API tokens distinguishable from the session tokens
+	apiTokenPrefix = "gdp_"
+	// apiTokenTouchInterval limits how often the last used time of a token is updated
+	apiTokenTouchInterval = time.Minute
+)
+
+// Authenticator verifies the username and password of the user
+type Authenticator interface {
+	// Authenticate returns the user if the username and password are accepted,
+	// or nil if the user is unknown or the password is wrong
+	Authenticate(username, password string) (*types.User, error)
+}
+
 type UserAuth struct {
-	userDAO *storage.UserDAO
+	userDAO     *storage.UserDAO
+	apiTokenDAO *storage.APITokenDAO
+	// authenticators are tried in order until one of them accepts the user
+	authenticators []Authenticator
+	twoFactor      *TwoFactorAuth
+
+	passwordLogin bool
 }
 
-func NewUserAuth(userDao *storage.UserDAO) *UserAuth {
-	return &UserAuth{userDAO: userDao}
+func NewUserAuth(config common.Config, userDao *storage.UserDAO, groupDAO *storage.GroupDAO,
+	apiTokenDAO *storage.APITokenDAO, twoFactor *TwoFactorAuth, ch *registry.ComponentsHolder) *UserAuth {
+	authenticators := []Authenticator{&localAuthenticator{userDAO: userDao}}
+	if config.Auth.LDAP.Enabled {
+		authenticators = append(authenticators, NewLDAPAuthenticator(config.Auth.LDAP, userDao, groupDAO, ch))
+	}
+	return &UserAuth{
+		userDAO:        userDao,
+		apiTokenDAO:    apiTokenDAO,
+		authenticators: authenticators,
+		twoFactor:      twoFactor,
+		passwordLogin:  !config.Auth.DisablePasswordLogin,
+	}
+}
+
+// PasswordLoginEnabled returns false if logging in with the username and password is disabled
+func (ua *UserAuth) PasswordLoginEnabled() bool {
+	return ua.passwordLogin
 }
 
 func (ua *UserAuth) AuthByUsernamePassword(username, password string) (types.User, error) {
-	getUser, e := ua.userDAO.GetUser(username)
+	if !ua.passwordLogin {
+		return types.User{}, err.NewNotAllowedMessageError(i18n.T("api.auth.password_login_disabled"))
+	}
+	// the error is returned only if no authenticator accepts the user
+	var firstErr error
+	for _, a := range ua.authenticators {
+		user, e := a.Authenticate(username, password)
+		if e != nil {
+			log.Printf("[%T] failed to authenticate user '%s': %v", a, username, e)
+			if firstErr == nil {
+				firstErr = e
+			}
+			continue
+		}
+		if user != nil {
+			return *user, nil
+		}
+	}
+	if firstErr != nil {
+		return types.User{}, firstErr
+	}
+	return types.User{}, err.NewNotAllowedMessageError(i18n.T("api.auth.invalid_username_or_password"))
+}
+
+// AuthByPasswordOnly authenticates the user without the second factor, it's used where the code can not be asked,
+// e.g. WebDAV. The users with two-factor authentication enabled are rejected, they should use the API tokens instead.
+func (ua *UserAuth) AuthByPasswordOnly(username, password string) (types.Session, error) {
+	user, e := ua.AuthByUsernamePassword(username, password)
+	if e != nil {
+		return types.Session{}, e
+	}
+	enabled, e := ua.twoFactor.IsEnabled(user.Username)
+	if e != nil {
+		return types.Session{}, e
+	}
+	if enabled {
+		return types.Session{}, err.NewNotAllowedMessageError(i18n.T("api.auth.two_factor_use_api_token"))
+	}
+	return types.Session{User: user, TwoFactorPending: ua.twoFactor.IsRequired(user)}, nil
+}
+
+// AuthByAPIToken validates the API token and returns the session restricted by the token scope.
+// ip is recorded as the last used IP of the token if it's not empty.
+func (ua *UserAuth) AuthByAPIToken(token, ip string) (types.Session, error) {
+	t, e := ua.apiTokenDAO.GetTokenByHash(hashAPIToken(token))
 	if e != nil {
 		if err.IsNotFoundError(e) {
-			return types.User{},
-				err.NewNotAllowedMessageError(i18n.T("api.auth.invalid_username_or_password"))
-		} else {
-			return types.User{}, e
+			return types.Session{}, err.NewUnauthorizedError(i18n.T("api.auth.invalid_api_token"))
+		}
+		return types.Session{}, e
+	}
+	if t.IsExpired() {
+		return types.Session{}, err.NewUnauthorizedError(i18n.T("api.auth.invalid_api_token"))
+	}
+	user, e := ua.userDAO.GetUser(t.Username)
+	if e != nil {
+		if err.IsNotFoundError(e) {
+			return types.Session{}, err.NewUnauthorizedError(i18n.T("api.auth.invalid_api_token"))
+		}
+		return types.Session{}, e
+	}
+	if ip != "" && (ip != t.LastUsedIP ||
+		time.Since(time.UnixMilli(int64(t.LastUsedAt))) > apiTokenTouchInterval) {
+		if e := ua.apiTokenDAO.TouchToken(t.ID, ip); e != nil {
+			log.Printf("failed to update the last used time of API token %d: %v", t.ID, e)
+		}
+	}
+	session := types.Session{
+		User:  user,
+		Scope: &types.TokenScope{TokenID: t.ID, Path: t.Path, ReadOnly: t.ReadOnly},
+	}
+	if ua.twoFactor.IsRequired(user) {
+		// the API tokens do not bypass the mandatory two-factor authentication
+		enabled, e := ua.twoFactor.IsEnabled(user.Username)
+		if e != nil {
+			return types.Session{}, e
 		}
+		session.TwoFactorPending = !enabled
 	}
-	if e := bcrypt.CompareHashAndPassword([]byte(getUser.Password), []byte(password)); e != nil {
-		return types.User{},
-			err.NewNotAllowedMessageError(i18n.T("api.auth.invalid_username_or_password"))
+	return session, nil
+}
+
+// localAuthenticator authenticates the users by the password hashes in the database
+type localAuthenticator struct {
+	userDAO *storage.UserDAO
+}
+
+func (la *localAuthenticator) Authenticate(username, password string) (*types.User, error) {
+	user, e := la.userDAO.GetUser(username)
+	if e != nil {
+		if err.IsNotFoundError(e) {
+			return nil, nil
+		}
+		return nil, e
+	}
+	if e := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); e != nil {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+// IsAPIToken returns true if the token looks like an API token
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, apiTokenPrefix)
+}
+
+// newAPIToken generates a new API token and returns the token and its hash
+func newAPIToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, e := rand.Read(b); e != nil {
+		return "", "", e
+	}
+	token := apiTokenPrefix + utils.Base64URLEncode(b)
+	return token, hashAPIToken(token), nil
+}
+
+func hashAPIToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// apiTokenStore accepts the API tokens in addition to the tokens of the wrapped TokenStore
+type apiTokenStore struct {
+	types.TokenStore
+	ua *UserAuth
+}
+
+// NewAPITokenStore wraps the TokenStore to accept the API tokens
+func NewAPITokenStore(tokenStore types.TokenStore, ua *UserAuth) types.TokenStore {
+	return &apiTokenStore{TokenStore: tokenStore, ua: ua}
+}
+
+func (s *apiTokenStore) Update(token string, value types.Session) (types.Token, error) {
+	if IsAPIToken(token) {
+		return types.Token{}, err.NewNotAllowedMessageError(i18n.T("api.auth.api_token_not_allowed"))
+	}
+	return s.TokenStore.Update(token, value)
+}
+
+func (s *apiTokenStore) Validate(token string) (types.Token, error) {
+	return s.ValidateClient(token, "")
+}
+
+// ValidateClient validates the token, the ip is recorded if the token is an API token
+func (s *apiTokenStore) ValidateClient(token, ip string) (types.Token, error) {
+	if !IsAPIToken(token) {
+		return s.TokenStore.Validate(token)
+	}
+	session, e := s.ua.AuthByAPIToken(token, ip)
+	if e != nil {
+		return types.Token{}, e
+	}
+	return types.Token{Token: token, Value: session, ExpiredAt: -1}, nil
+}
+
+func (s *apiTokenStore) Revoke(token string) error {
+	if IsAPIToken(token) {
+		// the API tokens can only be revoked by deleting them
+		return nil
 	}
-	return getUser, nil
+	return s.TokenStore.Revoke(token)
 }
@@ -5,6 +5,7 @@ import (
 	"go-drive/common"
 	err "go-drive/common/errors"
 	"go-drive/common/i18n"
+	"go-drive/common/task"
 	"go-drive/common/types"
 	"go-drive/common/utils"
 	"go-drive/storage"
@@ -35,7 +36,10 @@ const (
 	keyResult  = "apiResult"
 )
 
-func SignatureAuth(signer *utils.Signer, userDAO *storage.UserDAO, skipOnEmptySignature bool) gin.HandlerFunc {
+// SignatureAuth authenticates the request by the signature in the query.
+// The API token in the header is accepted if there is no signature.
+func SignatureAuth(signer *utils.Signer, userDAO *storage.UserDAO,
+	tokenStore types.TokenStore, skipOnEmptySignature bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		signature := c.Query(common.SignatureQueryKey)
 		if signature == "" && skipOnEmptySignature {
@@ -43,10 +47,22 @@ func SignatureAuth(signer *utils.Signer, userDAO *storage.UserDAO, skipOnEmptySi
 			return
 		}
 
+		if apiToken := c.GetHeader(common.HeaderAuth); signature == "" && IsAPIToken(apiToken) {
+			token, e := validateToken(c, tokenStore, apiToken)
+			if e != nil {
+				_ = c.Error(e)
+				c.Abort()
+				return
+			}
+			SetSession(c, token.Value)
+			c.Next()
+			return
+		}
+
 		session := types.Session{}
 		var username string
 
-		path := utils.CleanPath(c.Param("path"))
+		path := versionSignaturePath(utils.CleanPath(c.Param("path")), c.Query("version"))
 
 		if signature != "" {
 			parts := strings.Split(signature, ".")
@@ -59,8 +75,12 @@ func SignatureAuth(signer *utils.Signer, userDAO *storage.UserDAO, skipOnEmptySi
 				}
 				username = string(temp)
 			}
+			ip := ""
+			if len(parts) > 2 && parts[2] == signatureIPBound {
+				ip = c.ClientIP()
+			}
 
-			if !signer.Validate(path+username, signature) {
+			if !signer.Validate(signatureValue(c.Request.Method, path, username, ip), signature) {
 				_ = c.Error(err.NewBadRequestError("bad signature"))
 				c.Abort()
 				return
@@ -82,9 +102,43 @@ func SignatureAuth(signer *utils.Signer, userDAO *storage.UserDAO, skipOnEmptySi
 	}
 }
 
-func MakeSignature(signer *utils.Signer, path, username string, ttl time.Duration) string {
-	signature := signer.Sign(path+username, time.Now().Add(ttl))
-	return signature + "." + utils.Base64URLEncode([]byte(username))
+// signatureIPBound is the flag appended to the signature if the signature is bound to the client IP
+const signatureIPBound = "i"
+
+// MakeSignature signs the path for the request method.
+// If ip is not empty, the signature is only valid for requests from this ip.
+func MakeSignature(signer *utils.Signer, method, path, username, ip string, ttl time.Duration) string {
+	signature := signer.Sign(signatureValue(method, path, username, ip), time.Now().Add(ttl))
+	signature += "." + utils.Base64URLEncode([]byte(username))
+	if ip != "" {
+		signature += "." + signatureIPBound
+	}
+	return signature
+}
+
+func signatureValue(method, path, username, ip string) string {
+	// HEAD requests are allowed by the signature of GET
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	return method + "\n" + path + "\n" + username + "\n" + ip
+}
+
+// versionSignaturePath returns the signed path of the version of the file,
+// so the signature of the current content cannot be used to read other versions
+func versionSignaturePath(path, version string) string {
+	if version == "" {
+		return path
+	}
+	return path + "?version=" + version
+}
+
+// SignatureIP returns the client IP if signatures should be bound to it
+func SignatureIP(c *gin.Context, config common.Config) string {
+	if !config.Signature.BindIP {
+		return ""
+	}
+	return c.ClientIP()
 }
 
 // TokenAuthWithPostParams get token from Header or FormData
@@ -112,7 +166,7 @@ func tokenAuth(tokenStore types.TokenStore, getToken func(*gin.Context) string)
 		}
 
 		tokenKey := getToken(c)
-		token, e := tokenStore.Validate(tokenKey)
+		token, e := validateToken(c, tokenStore, tokenKey)
 		if e != nil {
 			_ = c.Error(e)
 			c.Abort()
@@ -127,6 +181,22 @@ func tokenAuth(tokenStore types.TokenStore, getToken func(*gin.Context) string)
 	}
 }
 
+// clientTokenValidator is implemented by the TokenStore that records the client IP of the tokens
+type clientTokenValidator interface {
+	ValidateClient(token, ip string) (types.Token, error)
+}
+
+func validateToken(c *gin.Context, tokenStore types.TokenStore, token string) (types.Token, error) {
+	if v, ok := tokenStore.(clientTokenValidator); ok {
+		return v.ValidateClient(token, c.ClientIP())
+	}
+	return tokenStore.Validate(token)
+}
+
+// BasicAuth authenticates the request by the username and password,
+// the API token can be used as the password with any username.
+// Only the API tokens are accepted if the password login is disabled,
+// or the user has enabled the two-factor authentication.
 func BasicAuth(userAuth *UserAuth, realm string, allowAnonymous bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if IsAuthenticated(c) {
@@ -136,8 +206,16 @@ func BasicAuth(userAuth *UserAuth, realm string, allowAnonymous bool) gin.Handle
 
 		username, password, ok := c.Request.BasicAuth()
 		session := types.Session{}
-		if ok {
-			user, e := userAuth.AuthByUsernamePassword(username, password)
+		if ok && IsAPIToken(password) {
+			s, e := userAuth.AuthByAPIToken(password, c.ClientIP())
+			if e != nil && !err.IsUnauthorizedError(e) {
+				_ = c.Error(e)
+				c.Abort()
+				return
+			}
+			session = s
+		} else if ok && userAuth.PasswordLoginEnabled() {
+			s, e := userAuth.AuthByPasswordOnly(username, password)
 			if e != nil {
 				if !err.IsUnauthorizedError(e) {
 					_ = c.Error(e)
@@ -145,7 +223,7 @@ func BasicAuth(userAuth *UserAuth, realm string, allowAnonymous bool) gin.Handle
 					return
 				}
 			}
-			session.User = user
+			session = s
 		}
 
 		if session.IsAnonymous() && !allowAnonymous {
@@ -163,7 +241,12 @@ func BasicAuth(userAuth *UserAuth, realm string, allowAnonymous bool) gin.Handle
 func UserGroupRequired(group string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		session := GetSession(c)
-		if session.HasUserGroup(group) {
+		if group == types.AdminUserGroup && session.TwoFactorPending {
+			_ = c.Error(err.NewPermissionDeniedError(i18n.T("api.auth.two_factor_enrollment_required")))
+			c.Abort()
+			return
+		}
+		if session.HasUserGroup(group) && !session.IsRestricted() {
 			c.Next()
 			return
 		}
@@ -176,6 +259,19 @@ func AdminGroupRequired() gin.HandlerFunc {
 	return UserGroupRequired(types.AdminUserGroup)
 }
 
+// UnrestrictedRequired rejects the sessions authenticated by the API tokens with a restricted scope
+func UnrestrictedRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session := GetSession(c)
+		if !session.IsRestricted() {
+			c.Next()
+			return
+		}
+		_ = c.Error(err.NewPermissionDeniedError(i18n.T("api.auth.token_scope_not_allowed")))
+		c.Abort()
+	}
+}
+
 func SetResult(c *gin.Context, result interface{}) {
 	c.Set(keyResult, result)
 }
@@ -204,15 +300,14 @@ func GetSession(c *gin.Context) types.Session {
 	return types.Session{}
 }
 
-func SetSession(c *gin.Context, session types.Session) {
-	c.Set(keySession, session)
+// TaskUser returns the task option to record the current user as the one who started the task
+func TaskUser(c *gin.Context) task.Option {
+	return task.WithUser(GetSession(c).User.Username)
 }
 
-func UpdateSessionUser(c *gin.Context, tokenStore types.TokenStore, user types.User) error {
-	session := GetSession(c)
-	session.User = user
-	_, e := tokenStore.Update(GetToken(c), session)
-	return e
+func SetSession(c *gin.Context, session types.Session) {
+	session.ClientIP = c.ClientIP()
+	c.Set(keySession, session)
 }
 
 func TranslateV(c *gin.Context, ms i18n.MessageSource, v interface{}) interface{} {
@@ -210,6 +210,9 @@ func (h *Handler) handleGetHeadPost(w http.ResponseWriter, r *http.Request) (sta
 		if e == os.ErrInvalid {
 			return http.StatusMethodNotAllowed, nil
 		}
+		if e == os.ErrPermission {
+			return http.StatusForbidden, e
+		}
 		if e != nil {
 			return http.StatusInternalServerError, e
 		}
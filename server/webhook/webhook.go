@@ -0,0 +1,275 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	err "go-drive/common/errors"
+	"go-drive/common/event"
+	"go-drive/common/i18n"
+	"go-drive/common/registry"
+	"go-drive/common/req"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"go-drive/storage"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+const (
+	EventEntryUpdated  = "entry.updated"
+	EventEntryDeleted  = "entry.deleted"
+	EventEntryAccessed = "entry.accessed"
+	// EventPing is sent by the test delivery
+	EventPing = "ping"
+
+	HeaderEvent     = "X-Drive-Event"
+	HeaderDelivery  = "X-Drive-Delivery"
+	HeaderSignature = "X-Drive-Signature"
+
+	maxAttempts    = 5
+	keepDeliveries = 100
+)
+
+var (
+	// Events are the event types can be subscribed
+	Events = []string{EventEntryUpdated, EventEntryDeleted, EventEntryAccessed}
+
+	retryBaseDelay = time.Second
+	requestTimeout = 30 * time.Second
+)
+
+type Payload struct {
+	Event string `json:"event"`
+	Path  string `json:"path,omitempty"`
+	// User is the username of the user who triggered the event
+	User string `json:"user,omitempty"`
+	// Timestamp is unix timestamp in milliseconds
+	Timestamp int64 `json:"timestamp"`
+}
+
+// Service posts the drive events to the subscribed webhooks
+type Service struct {
+	dao    *storage.WebhookDAO
+	runner task.Runner
+	client *req.Client
+
+	hooks []types.Webhook
+	mux   *sync.RWMutex
+}
+
+func NewService(ch *registry.ComponentsHolder, dao *storage.WebhookDAO,
+	runner task.Runner, bus event.Bus) (*Service, error) {
+	client, e := req.NewClient("", nil, nil, &http.Client{Timeout: requestTimeout})
+	if e != nil {
+		return nil, e
+	}
+	s := &Service{
+		dao:    dao,
+		runner: runner,
+		client: client,
+		mux:    &sync.RWMutex{},
+	}
+	if e := s.Reload(); e != nil {
+		return nil, e
+	}
+	bus.Subscribe(event.EntryUpdated, s.onUpdated)
+	bus.Subscribe(event.EntryDeleted, s.onDeleted)
+	bus.Subscribe(event.EntryAccessed, s.onAccessed)
+	ch.Add("webhookService", s)
+	return s, nil
+}
+
+// Reload reloads the enabled webhooks from database
+func (s *Service) Reload() error {
+	hooks, e := s.dao.GetWebhooks(false)
+	if e != nil {
+		return e
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.hooks = hooks
+	return nil
+}
+
+// Validate checks the url, events and the path pattern of the webhook
+func Validate(hook types.Webhook) error {
+	if !strings.HasPrefix(hook.URL, "http://") && !strings.HasPrefix(hook.URL, "https://") {
+		return err.NewNotAllowedMessageError(i18n.T("api.webhooks.invalid_url", hook.URL))
+	}
+	for _, ev := range strings.Split(hook.Events, ",") {
+		if !isValidEvent(strings.TrimSpace(ev)) {
+			return err.NewNotAllowedMessageError(i18n.T("api.webhooks.invalid_event", ev))
+		}
+	}
+	if hook.PathPattern != "" && !doublestar.ValidatePattern(hook.PathPattern) {
+		return err.NewNotAllowedMessageError(i18n.T("api.webhooks.invalid_path_pattern", hook.PathPattern))
+	}
+	return nil
+}
+
+// Test sends a ping to the webhook and waits for the delivery
+func (s *Service) Test(hook types.Webhook) (task.Task, error) {
+	payload := Payload{Event: EventPing, Timestamp: time.Now().UnixMilli()}
+	return s.runner.ExecuteAndWait(func(ctx types.TaskCtx) (interface{}, error) {
+		d, e := s.deliver(ctx, hook, payload)
+		if e != nil {
+			return nil, e
+		}
+		return d, nil
+	}, 10*time.Second, task.WithNameGroup(hook.Name, "webhook/test"))
+}
+
+func (s *Service) onUpdated(dc types.DriveListenerContext, path string, _ bool) {
+	s.trigger(EventEntryUpdated, dc, path)
+}
+
+func (s *Service) onDeleted(dc types.DriveListenerContext, path string) {
+	s.trigger(EventEntryDeleted, dc, path)
+}
+
+func (s *Service) onAccessed(dc types.DriveListenerContext, path string) {
+	s.trigger(EventEntryAccessed, dc, path)
+}
+
+func (s *Service) trigger(eventType string, dc types.DriveListenerContext, path string) {
+	s.mux.RLock()
+	hooks := s.hooks
+	s.mux.RUnlock()
+
+	var payload *Payload
+	for _, hook := range hooks {
+		if !matches(hook, eventType, path) {
+			continue
+		}
+		if payload == nil {
+			payload = &Payload{Event: eventType, Path: path, Timestamp: time.Now().UnixMilli()}
+			if dc.Session != nil {
+				payload.User = dc.Session.User.Username
+			}
+		}
+		hook, payload := hook, *payload
+		_, _ = s.runner.Execute(func(ctx types.TaskCtx) (interface{}, error) {
+			_, e := s.deliver(ctx, hook, payload)
+			return nil, e
+		}, task.WithNameGroup(hook.Name+": "+eventType+" "+path, "webhook/deliver"))
+	}
+}
+
+func isValidEvent(eventType string) bool {
+	for _, ev := range Events {
+		if ev == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func matches(hook types.Webhook, eventType, path string) bool {
+	subscribed := false
+	for _, ev := range strings.Split(hook.Events, ",") {
+		if strings.TrimSpace(ev) == eventType {
+			subscribed = true
+			break
+		}
+	}
+	if !subscribed {
+		return false
+	}
+	if hook.PathPattern == "" {
+		return true
+	}
+	ok, _ := doublestar.Match(hook.PathPattern, path)
+	return ok
+}
+
+// deliver posts the payload to the webhook, retries with exponential backoff if failed
+func (s *Service) deliver(ctx types.TaskCtx, hook types.Webhook, payload Payload) (types.WebhookDelivery, error) {
+	body, e := json.Marshal(payload)
+	if e != nil {
+		return types.WebhookDelivery{}, e
+	}
+	d := types.WebhookDelivery{
+		WebhookID: hook.ID,
+		Event:     payload.Event,
+		Payload:   string(body),
+		Status:    types.WebhookDeliveryPending,
+		CreatedAt: uint64(time.Now().UnixMilli()),
+	}
+	if e := s.dao.AddDelivery(&d); e != nil {
+		return d, e
+	}
+
+	headers := types.SM{
+		HeaderEvent:    payload.Event,
+		HeaderDelivery: fmt.Sprintf("%d", d.ID),
+	}
+	if hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		mac.Write(body)
+		headers[HeaderSignature] = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	ctx.Total(maxAttempts, true)
+	delay := retryBaseDelay
+	for d.Attempts < maxAttempts {
+		if d.Attempts > 0 {
+			if we := waitRetry(ctx, delay); we != nil {
+				e = we
+				break
+			}
+			delay *= 2
+		}
+		d.Attempts++
+		ctx.Progress(int64(d.Attempts), true)
+		d.StatusCode, e = s.post(ctx, hook.URL, headers, body)
+		if e == nil {
+			break
+		}
+	}
+
+	d.CompletedAt = uint64(time.Now().UnixMilli())
+	if e == nil {
+		d.Status = types.WebhookDeliverySuccess
+		d.ErrorMsg = ""
+	} else {
+		d.Status = types.WebhookDeliveryFailed
+		d.ErrorMsg = e.Error()
+	}
+	if ue := s.dao.UpdateDelivery(&d); ue != nil {
+		log.Printf("[Webhook] failed to update delivery %d: %v", d.ID, ue)
+	}
+	if te := s.dao.TrimDeliveries(hook.ID, keepDeliveries); te != nil {
+		log.Printf("[Webhook] failed to clean deliveries of webhook %d: %v", hook.ID, te)
+	}
+	return d, e
+}
+
+func waitRetry(ctx context.Context, delay time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+func (s *Service) post(ctx context.Context, url string, headers types.SM, body []byte) (int, error) {
+	resp, e := s.client.Post(ctx, url, headers, req.NewBytesBody(body, "application/json"))
+	if e != nil {
+		return 0, e
+	}
+	defer func() { _ = resp.Dispose() }()
+	if resp.Status() < 200 || resp.Status() >= 300 {
+		return resp.Status(), fmt.Errorf("unexpected status code %d", resp.Status())
+	}
+	return resp.Status(), nil
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"errors"
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type APITokenDAO struct {
+	db *DB
+}
+
+func NewAPITokenDAO(db *DB, ch *registry.ComponentsHolder) *APITokenDAO {
+	dao := &APITokenDAO{db}
+	ch.Add("apiTokenDAO", dao)
+	return dao
+}
+
+// GetTokenByHash gets the token by the SHA-256 hash of the token
+func (a *APITokenDAO) GetTokenByHash(hash string) (types.APIToken, error) {
+	token := types.APIToken{}
+	e := a.db.C().Take(&token, "`token` = ?", hash).Error
+	if errors.Is(e, gorm.ErrRecordNotFound) {
+		return token, err.NewNotFoundMessageError(i18n.T("storage.api_tokens.token_not_exists", ""))
+	}
+	return token, e
+}
+
+// ListTokens lists the tokens of the user
+func (a *APITokenDAO) ListTokens(username string) ([]types.APIToken, error) {
+	tokens := make([]types.APIToken, 0)
+	return tokens, a.db.C().Where("`username` = ?", username).Order("`created_at` DESC").Find(&tokens).Error
+}
+
+// AddToken creates a new token, token.Token is the hash of the token
+func (a *APITokenDAO) AddToken(token types.APIToken) (types.APIToken, error) {
+	token.ID = 0
+	token.CreatedAt = uint64(time.Now().UnixMilli())
+	token.LastUsedAt = 0
+	token.LastUsedIP = ""
+	return token, a.db.C().Create(&token).Error
+}
+
+// TouchToken records the last used time and ip of the token
+func (a *APITokenDAO) TouchToken(id uint, ip string) error {
+	return a.db.C().Model(&types.APIToken{}).Where("`id` = ?", id).Updates(map[string]interface{}{
+		"last_used_at": uint64(time.Now().UnixMilli()),
+		"last_used_ip": ip,
+	}).Error
+}
+
+// DeleteToken revokes the token of the user
+func (a *APITokenDAO) DeleteToken(id uint, username string) error {
+	r := a.db.C().Delete(&types.APIToken{}, "`id` = ? AND `username` = ?", id, username)
+	if r.Error != nil {
+		return r.Error
+	}
+	if r.RowsAffected != 1 {
+		return err.NewNotFoundMessageError(i18n.T("storage.api_tokens.token_not_exists", strconv.FormatUint(uint64(id), 10)))
+	}
+	return nil
+}
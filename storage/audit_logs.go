@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"strings"
+)
+
+type AuditLogDAO struct {
+	db *DB
+}
+
+// AuditLogQuery filters the audit logs, the zero value fields are ignored
+type AuditLogQuery struct {
+	Username string
+	Action   string
+	// Path matches the path or the destination path, and their descendants
+	Path   string
+	Result string
+	// Since and Before are unix timestamps in milliseconds
+	Since  int64
+	Before int64
+
+	Offset int
+	Limit  int
+}
+
+func NewAuditLogDAO(db *DB, ch *registry.ComponentsHolder) *AuditLogDAO {
+	dao := &AuditLogDAO{db}
+	ch.Add("auditLogDAO", dao)
+	return dao
+}
+
+// GetLogs returns the logs matched the query, and the total count of the matched logs
+func (a *AuditLogDAO) GetLogs(q AuditLogQuery) ([]types.AuditLog, int64, error) {
+	tx := a.db.C().Model(&types.AuditLog{})
+	if q.Username != "" {
+		tx = tx.Where("`username` = ?", q.Username)
+	}
+	if q.Action != "" {
+		tx = tx.Where("`action` = ?", q.Action)
+	}
+	if q.Path != "" {
+		like := escapeLike(q.Path) + "/%"
+		tx = tx.Where("`path` = ? OR `path` LIKE ? ESCAPE '\\' OR `to_path` = ? OR `to_path` LIKE ? ESCAPE '\\'",
+			q.Path, like, q.Path, like)
+	}
+	if q.Result != "" {
+		tx = tx.Where("`result` = ?", q.Result)
+	}
+	if q.Since > 0 {
+		tx = tx.Where("`created_at` >= ?", q.Since)
+	}
+	if q.Before > 0 {
+		tx = tx.Where("`created_at` < ?", q.Before)
+	}
+	var total int64
+	if e := tx.Count(&total).Error; e != nil {
+		return nil, 0, e
+	}
+	logs := make([]types.AuditLog, 0)
+	if e := tx.Order("`id` DESC").Offset(q.Offset).Limit(q.Limit).Find(&logs).Error; e != nil {
+		return nil, 0, e
+	}
+	return logs, total, nil
+}
+
+func (a *AuditLogDAO) AddLogs(logs []types.AuditLog) error {
+	return a.db.C().Create(&logs).Error
+}
+
+// DeleteLogsBefore deletes the logs created before the timestamp in milliseconds
+func (a *AuditLogDAO) DeleteLogsBefore(createdAt int64) error {
+	return a.db.C().Delete(&types.AuditLog{}, "`created_at` < ?", createdAt).Error
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(s)
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"errors"
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+
+	"gorm.io/gorm"
+)
+
+type CopyCheckpointDAO struct {
+	db *DB
+}
+
+func NewCopyCheckpointDAO(db *DB, ch *registry.ComponentsHolder) *CopyCheckpointDAO {
+	dao := &CopyCheckpointDAO{db}
+	ch.Add("copyCheckpointDAO", dao)
+	return dao
+}
+
+func (c *CopyCheckpointDAO) GetCheckpointByTask(taskId string) (types.CopyCheckpoint, error) {
+	cp := types.CopyCheckpoint{}
+	e := c.db.C().Take(&cp, "`task_id` = ?", taskId).Error
+	if errors.Is(e, gorm.ErrRecordNotFound) {
+		return cp, err.NewNotFoundMessageError(i18n.T("storage.copy_checkpoints.checkpoint_not_exists", taskId))
+	}
+	return cp, e
+}
+
+func (c *CopyCheckpointDAO) AddCheckpoint(cp types.CopyCheckpoint) error {
+	return c.db.C().Create(&cp).Error
+}
+
+// SetCheckpointTask sets the task running with the checkpoint
+func (c *CopyCheckpointDAO) SetCheckpointTask(id, taskId string) error {
+	return c.db.C().Model(&types.CopyCheckpoint{}).
+		Where("`id` = ?", id).Update("task_id", taskId).Error
+}
+
+// DeleteCheckpoint deletes the checkpoint and its entries
+func (c *CopyCheckpointDAO) DeleteCheckpoint(id string) error {
+	return c.db.C().Transaction(func(tx *gorm.DB) error {
+		if e := tx.Delete(&types.CopyCheckpointEntry{}, "`checkpoint_id` = ?", id).Error; e != nil {
+			return e
+		}
+		return tx.Delete(&types.CopyCheckpoint{}, "`id` = ?", id).Error
+	})
+}
+
+// DeleteCheckpointsBefore deletes the checkpoints created before the timestamp in milliseconds
+func (c *CopyCheckpointDAO) DeleteCheckpointsBefore(createdAt int64) error {
+	ids := make([]string, 0)
+	if e := c.db.C().Model(&types.CopyCheckpoint{}).
+		Where("`created_at` < ?", createdAt).Pluck("id", &ids).Error; e != nil {
+		return e
+	}
+	for _, id := range ids {
+		if e := c.DeleteCheckpoint(id); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (c *CopyCheckpointDAO) ListEntries(checkpointId string) ([]types.CopyCheckpointEntry, error) {
+	entries := make([]types.CopyCheckpointEntry, 0)
+	return entries, c.db.C().Where("`checkpoint_id` = ?", checkpointId).Find(&entries).Error
+}
+
+// SaveEntry creates the entry if its ID is 0, otherwise updates it
+func (c *CopyCheckpointDAO) SaveEntry(entry *types.CopyCheckpointEntry) error {
+	return c.db.C().Save(entry).Error
+}
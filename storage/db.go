@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"errors"
 	"go-drive/common"
 	"go-drive/common/registry"
 	"go-drive/common/types"
@@ -17,7 +18,7 @@ var initSQL = []string{
 	"INSERT INTO `users`(`username`, `password`) VALUES ('admin', '$2y$10$Xqn8qV2D2KY2ceI5esM/JOiKTPKJFbkSzzuhce89BxygvCqnhyk3m')", // 123456
 	"INSERT INTO `groups`(`name`) VALUES ('admin')",
 	"INSERT INTO `user_groups`(`username`, `group_name`) VALUES ('admin', 'admin')",
-	"INSERT INTO `path_permissions`(`path`, `subject`, `permission`, `policy`) VALUES ('', 'ANY', 1, 1)",
+	"INSERT INTO `path_permissions`(`path`, `subject`, `permission`, `policy`) VALUES ('', 'ANY', 67, 1)", // list, download, share
 }
 
 func NewDB(config common.Config, ch *registry.ComponentsHolder) (*DB, error) {
@@ -45,6 +46,7 @@ func NewDB(config common.Config, ch *registry.ComponentsHolder) (*DB, error) {
 		&types.User{},
 		&types.Group{},
 		&types.UserGroup{},
+		&types.UserIdentity{},
 		&types.Drive{},
 		&types.PathPermission{},
 		&types.PathMount{},
@@ -53,11 +55,28 @@ func NewDB(config common.Config, ch *registry.ComponentsHolder) (*DB, error) {
 		&types.Option{},
 		&types.Job{},
 		&types.JobExecution{},
+		&types.Share{},
+		&types.TrashItem{},
+		&types.FileVersion{},
+		&types.TaskRecord{},
+		&types.CopyCheckpoint{},
+		&types.CopyCheckpointEntry{},
+		&types.Webhook{},
+		&types.WebhookDelivery{},
+		&types.AuditLog{},
+		&types.Quota{},
+		&types.APIToken{},
+		&types.TwoFactor{},
 	); e != nil {
 		closeDb(db)
 		return nil, e
 	}
 
+	if e := migratePermissionBits(db); e != nil {
+		closeDb(db)
+		return nil, e
+	}
+
 	if e := tryInitDbData(db); e != nil {
 		closeDb(db)
 		return nil, e
@@ -86,6 +105,63 @@ func tryInitDbData(db *gorm.DB) error {
 	})
 }
 
+const (
+	permissionBitsVersionKey = "db.permission_bits_version"
+	// permissionBitsVersion 2 is the operation-level bits, 1 is the legacy read/write bits
+	permissionBitsVersion = "2"
+
+	legacyPermissionRead  types.Permission = 1 << 0
+	legacyPermissionWrite types.Permission = 1 << 1
+)
+
+// migratePermissionBits maps the legacy read/write bits of the path permissions to the operation-level bits
+func migratePermissionBits(db *gorm.DB) error {
+	var option types.Option
+	e := db.Where("`key` = ?", permissionBitsVersionKey).Take(&option).Error
+	if e != nil && !errors.Is(e, gorm.ErrRecordNotFound) {
+		return e
+	}
+	if option.Value == permissionBitsVersion {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		pps := make([]types.PathPermission, 0)
+		if e := tx.Find(&pps).Error; e != nil {
+			return e
+		}
+		for _, p := range pps {
+			if e := tx.Model(&types.PathPermission{}).Where("`id` = ?", p.ID).
+				Update("permission", mapLegacyPermission(p.Permission, p.Policy)).Error; e != nil {
+				return e
+			}
+		}
+		if len(pps) > 0 {
+			log.Printf("%d path permissions migrated", len(pps))
+		}
+		if option.Key == "" {
+			return tx.Create(&types.Option{Key: permissionBitsVersionKey, Value: permissionBitsVersion}).Error
+		}
+		return tx.Model(&types.Option{}).Where("`key` = ?", permissionBitsVersionKey).
+			Update("value", permissionBitsVersion).Error
+	})
+}
+
+func mapLegacyPermission(p types.Permission, policy uint8) types.Permission {
+	result := types.PermissionEmpty
+	if p&legacyPermissionRead != 0 {
+		if policy == types.PolicyReject {
+			// nothing could be done without the read permission
+			return types.PermissionAll
+		}
+		// sharing required the read permission only
+		result |= types.PermissionRead | types.PermissionShare
+	}
+	if p&legacyPermissionWrite != 0 {
+		result |= types.PermissionWrite
+	}
+	return result
+}
+
 type DB struct {
 	db *gorm.DB
 }
@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"go-drive/common/types"
+	"testing"
+)
+
+func TestMapLegacyPermission(t *testing.T) {
+	cases := []struct {
+		permission types.Permission
+		policy     uint8
+		expect     types.Permission
+	}{
+		{1, types.PolicyAccept, types.PermissionRead | types.PermissionShare},
+		{2, types.PolicyAccept, types.PermissionWrite},
+		{3, types.PolicyAccept, types.PermissionAll},
+		{1, types.PolicyReject, types.PermissionAll},
+		{2, types.PolicyReject, types.PermissionWrite},
+		{3, types.PolicyReject, types.PermissionAll},
+		{0, types.PolicyAccept, types.PermissionEmpty},
+		{0, types.PolicyReject, types.PermissionEmpty},
+	}
+	for _, c := range cases {
+		if r := mapLegacyPermission(c.permission, c.policy); r != c.expect {
+			t.Errorf("%d, policy %d: expect %d, but is %d", c.permission, c.policy, c.expect, r)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"errors"
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+
+	"gorm.io/gorm"
+)
+
+type FileVersionDAO struct {
+	db *DB
+}
+
+func NewFileVersionDAO(db *DB, ch *registry.ComponentsHolder) *FileVersionDAO {
+	dao := &FileVersionDAO{db}
+	ch.Add("fileVersionDAO", dao)
+	return dao
+}
+
+func (f *FileVersionDAO) GetVersion(id string) (types.FileVersion, error) {
+	v := types.FileVersion{}
+	e := f.db.C().Take(&v, "`id` = ?", id).Error
+	if errors.Is(e, gorm.ErrRecordNotFound) {
+		return v, err.NewNotFoundMessageError(i18n.T("storage.file_versions.version_not_exists", id))
+	}
+	return v, e
+}
+
+// ListVersions lists the versions of the file, the latest version comes first
+func (f *FileVersionDAO) ListVersions(path string) ([]types.FileVersion, error) {
+	versions := make([]types.FileVersion, 0)
+	return versions, f.db.C().
+		Where("`path` = ?", path).
+		Order("`created_at` DESC").
+		Find(&versions).Error
+}
+
+func (f *FileVersionDAO) AddVersion(v types.FileVersion) error {
+	return f.db.C().Create(&v).Error
+}
+
+func (f *FileVersionDAO) DeleteVersion(id string) error {
+	return f.db.C().Delete(&types.FileVersion{}, "`id` = ?", id).Error
+}
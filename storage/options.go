@@ -67,6 +67,16 @@ func (d *OptionsDAO) Get(key string) (string, error) {
 	return o.Value, nil
 }
 
+// Reload gets the value from database rather than the cache,
+// it's used for the options may be changed by other instances
+func (d *OptionsDAO) Reload(key string) (string, error) {
+	o, e := d.get(key, false)
+	if e != nil {
+		return "", e
+	}
+	return o.Value, nil
+}
+
 func (d *OptionsDAO) Gets(keys ...string) (map[string]string, error) {
 	options := make(map[string]string)
 	for _, key := range keys {
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"errors"
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+type QuotaDAO struct {
+	db *DB
+}
+
+func NewQuotaDAO(db *DB, ch *registry.ComponentsHolder) *QuotaDAO {
+	dao := &QuotaDAO{db}
+	ch.Add("quotaDAO", dao)
+	return dao
+}
+
+func (q *QuotaDAO) GetQuota(id uint) (types.Quota, error) {
+	quota := types.Quota{}
+	e := q.db.C().Take(&quota, "`id` = ?", id).Error
+	if errors.Is(e, gorm.ErrRecordNotFound) {
+		return quota, err.NewNotFoundMessageError(i18n.T("storage.quotas.quota_not_exists", strconv.FormatUint(uint64(id), 10)))
+	}
+	return quota, e
+}
+
+func (q *QuotaDAO) GetQuotas() ([]types.Quota, error) {
+	quotas := make([]types.Quota, 0)
+	return quotas, q.db.C().Order("`id`").Find(&quotas).Error
+}
+
+func (q *QuotaDAO) AddQuota(quota types.Quota) (types.Quota, error) {
+	quota.ID = 0
+	quota.Used = 0
+	return quota, q.db.C().Create(&quota).Error
+}
+
+// UpdateQuota updates the subject, path and limit of the quota, the usage is kept
+func (q *QuotaDAO) UpdateQuota(id uint, quota types.Quota) error {
+	return q.db.C().Model(&types.Quota{}).Where("`id` = ?", id).
+		Select("subject", "path", "limit_bytes").Updates(quota).Error
+}
+
+func (q *QuotaDAO) DeleteQuota(id uint) error {
+	return q.db.C().Delete(&types.Quota{}, "`id` = ?", id).Error
+}
+
+// AddUsage adds the delta to the used bytes of the quota
+func (q *QuotaDAO) AddUsage(id uint, delta int64) error {
+	return q.db.C().Model(&types.Quota{}).Where("`id` = ?", id).
+		UpdateColumn("used_bytes", gorm.Expr("`used_bytes` + ?", delta)).Error
+}
+
+// SetUsage sets the used bytes of the quota after recomputed
+func (q *QuotaDAO) SetUsage(id uint, used int64) error {
+	return q.db.C().Model(&types.Quota{}).Where("`id` = ?", id).
+		Update("used_bytes", used).Error
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"errors"
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+type ShareDAO struct {
+	db *DB
+}
+
+func NewShareDAO(db *DB, ch *registry.ComponentsHolder) *ShareDAO {
+	dao := &ShareDAO{db}
+	ch.Add("shareDAO", dao)
+	return dao
+}
+
+func (s *ShareDAO) GetShare(id string) (types.Share, error) {
+	share := types.Share{}
+	e := s.db.C().Take(&share, "`id` = ?", id).Error
+	if errors.Is(e, gorm.ErrRecordNotFound) {
+		return share, err.NewNotFoundMessageError(i18n.T("storage.shares.share_not_exists", id))
+	}
+	return share, e
+}
+
+// ListShares lists shares created by the user, or all shares if username is empty
+func (s *ShareDAO) ListShares(username string) ([]types.Share, error) {
+	shares := make([]types.Share, 0)
+	tx := s.db.C().Order("`created_at` DESC")
+	if username != "" {
+		tx = tx.Where("`username` = ?", username)
+	}
+	return shares, tx.Find(&shares).Error
+}
+
+// AddShare creates a new share, share.Password is the plain text password
+func (s *ShareDAO) AddShare(share types.Share) (types.Share, error) {
+	share.ID = newShareId()
+	share.Downloads = 0
+	share.CreatedAt = uint64(time.Now().UnixMilli())
+	if share.Password != "" {
+		encoded, e := bcrypt.GenerateFromPassword([]byte(share.Password), bcrypt.DefaultCost)
+		if e != nil {
+			return types.Share{}, e
+		}
+		share.Password = string(encoded)
+	}
+	return share, s.db.C().Create(&share).Error
+}
+
+func (s *ShareDAO) CheckPassword(share types.Share, password string) bool {
+	if !share.HasPassword() {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(share.Password), []byte(password)) == nil
+}
+
+// IncreaseDownloads increases the download count of the share,
+// returns false if the download limit has been reached.
+func (s *ShareDAO) IncreaseDownloads(id string) (bool, error) {
+	r := s.db.C().Model(&types.Share{}).
+		Where("`id` = ? AND ( `max_downloads` = 0 OR `downloads` < `max_downloads` )", id).
+		Update("downloads", gorm.Expr("`downloads` + 1"))
+	if r.Error != nil {
+		return false, r.Error
+	}
+	return r.RowsAffected == 1, nil
+}
+
+func (s *ShareDAO) DeleteShare(id string) error {
+	r := s.db.C().Delete(&types.Share{}, "`id` = ?", id)
+	if r.Error != nil {
+		return r.Error
+	}
+	if r.RowsAffected != 1 {
+		return err.NewNotFoundMessageError(i18n.T("storage.shares.share_not_exists", id))
+	}
+	return nil
+}
+
+func newShareId() string {
+	return strings.ReplaceAll(uuid.New().String(), "-", "")
+}
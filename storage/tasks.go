@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"go-drive/common/registry"
+	"go-drive/common/task"
+	"go-drive/common/types"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TaskDAO implements task.Store
+type TaskDAO struct {
+	db *DB
+}
+
+func NewTaskDAO(db *DB, ch *registry.ComponentsHolder) *TaskDAO {
+	dao := &TaskDAO{db}
+	ch.Add("taskDAO", dao)
+	return dao
+}
+
+func (t *TaskDAO) SaveTask(tk task.Task) error {
+	r, e := toTaskRecord(tk)
+	if e != nil {
+		return e
+	}
+	return t.db.C().Save(&r).Error
+}
+
+func (t *TaskDAO) GetTask(id string) (task.Task, error) {
+	r := types.TaskRecord{}
+	e := t.db.C().Take(&r, "`id` = ?", id).Error
+	if errors.Is(e, gorm.ErrRecordNotFound) {
+		return task.Task{}, task.ErrorNotFound
+	}
+	if e != nil {
+		return task.Task{}, e
+	}
+	return fromTaskRecord(r), nil
+}
+
+func (t *TaskDAO) GetTasks(group, user string) ([]task.Task, error) {
+	records := make([]types.TaskRecord, 0)
+	tx := t.db.C().Order("`created_at` DESC")
+	if group != "" {
+		tx = tx.Where("`task_group` = ? OR `task_group` LIKE ?", group, group+"/%")
+	}
+	if user != "" {
+		tx = tx.Where("`username` = ?", user)
+	}
+	if e := tx.Find(&records).Error; e != nil {
+		return nil, e
+	}
+	tasks := make([]task.Task, 0, len(records))
+	for _, r := range records {
+		tasks = append(tasks, fromTaskRecord(r))
+	}
+	return tasks, nil
+}
+
+func (t *TaskDAO) DeleteTask(id string) error {
+	return t.db.C().Delete(&types.TaskRecord{}, "`id` = ?", id).Error
+}
+
+func (t *TaskDAO) DeleteTasksBefore(before time.Time) error {
+	return t.db.C().Delete(&types.TaskRecord{},
+		"`updated_at` < ? AND `status` NOT IN ?",
+		before.UnixMilli(), []string{task.Pending, task.Running},
+	).Error
+}
+
+func (t *TaskDAO) MarkInterrupted() error {
+	return t.db.C().Model(&types.TaskRecord{}).
+		Where("`status` IN ?", []string{task.Pending, task.Running}).
+		Updates(map[string]interface{}{
+			"status":     task.Interrupted,
+			"updated_at": time.Now().UnixMilli(),
+		}).Error
+}
+
+func toTaskRecord(t task.Task) (types.TaskRecord, error) {
+	r := types.TaskRecord{
+		ID:        t.Id,
+		Name:      t.Name,
+		Group:     t.Group,
+		User:      t.User,
+		Status:    t.Status,
+		Loaded:    t.Progress.Loaded,
+		Total:     t.Progress.Total,
+		CreatedAt: t.CreatedAt.UnixMilli(),
+		UpdatedAt: t.UpdatedAt.UnixMilli(),
+	}
+	if t.Result != nil {
+		v, e := json.Marshal(t.Result)
+		if e != nil {
+			return r, e
+		}
+		r.Result = string(v)
+	}
+	if t.Error != nil {
+		v, e := json.Marshal(t.Error)
+		if e != nil {
+			return r, e
+		}
+		r.Error = string(v)
+	}
+	return r, nil
+}
+
+func fromTaskRecord(r types.TaskRecord) task.Task {
+	t := task.Task{
+		Id:        r.ID,
+		Status:    r.Status,
+		Progress:  task.Progress{Loaded: r.Loaded, Total: r.Total},
+		CreatedAt: time.UnixMilli(r.CreatedAt),
+		UpdatedAt: time.UnixMilli(r.UpdatedAt),
+		Name:      r.Name,
+		Group:     r.Group,
+		User:      r.User,
+	}
+	if r.Result != "" {
+		t.Result = json.RawMessage(r.Result)
+	}
+	if r.Error != "" {
+		t.Error = json.RawMessage(r.Error)
+	}
+	return t
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"errors"
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+
+	"gorm.io/gorm"
+)
+
+type TrashDAO struct {
+	db *DB
+}
+
+func NewTrashDAO(db *DB, ch *registry.ComponentsHolder) *TrashDAO {
+	dao := &TrashDAO{db}
+	ch.Add("trashDAO", dao)
+	return dao
+}
+
+func (t *TrashDAO) GetItem(id string) (types.TrashItem, error) {
+	item := types.TrashItem{}
+	e := t.db.C().Take(&item, "`id` = ?", id).Error
+	if errors.Is(e, gorm.ErrRecordNotFound) {
+		return item, err.NewNotFoundMessageError(i18n.T("storage.trash.item_not_exists", id))
+	}
+	return item, e
+}
+
+// ListItems lists the items deleted by the user, or all items if username is empty
+func (t *TrashDAO) ListItems(username string) ([]types.TrashItem, error) {
+	items := make([]types.TrashItem, 0)
+	tx := t.db.C().Order("`deleted_at` DESC")
+	if username != "" {
+		tx = tx.Where("`deleted_by` = ?", username)
+	}
+	return items, tx.Find(&items).Error
+}
+
+// ListItemsBefore lists the items deleted before the timestamp in milliseconds
+func (t *TrashDAO) ListItemsBefore(deletedAt uint64) ([]types.TrashItem, error) {
+	items := make([]types.TrashItem, 0)
+	return items, t.db.C().Where("`deleted_at` < ?", deletedAt).Find(&items).Error
+}
+
+func (t *TrashDAO) AddItem(item types.TrashItem) error {
+	return t.db.C().Create(&item).Error
+}
+
+func (t *TrashDAO) DeleteItem(id string) error {
+	return t.db.C().Delete(&types.TrashItem{}, "`id` = ?", id).Error
+}
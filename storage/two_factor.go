@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"errors"
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+
+	"gorm.io/gorm"
+)
+
+type TwoFactorDAO struct {
+	db *DB
+}
+
+func NewTwoFactorDAO(db *DB, ch *registry.ComponentsHolder) *TwoFactorDAO {
+	dao := &TwoFactorDAO{db}
+	ch.Add("twoFactorDAO", dao)
+	return dao
+}
+
+func (t *TwoFactorDAO) GetTwoFactor(username string) (types.TwoFactor, error) {
+	tf := types.TwoFactor{}
+	e := t.db.C().Take(&tf, "`username` = ?", username).Error
+	if errors.Is(e, gorm.ErrRecordNotFound) {
+		return tf, err.NewNotFoundMessageError(i18n.T("storage.two_factor.not_enrolled", username))
+	}
+	return tf, e
+}
+
+// SaveTwoFactor creates or updates the two-factor authentication of the user
+func (t *TwoFactorDAO) SaveTwoFactor(tf types.TwoFactor) error {
+	return t.db.C().Save(&tf).Error
+}
+
+func (t *TwoFactorDAO) DeleteTwoFactor(username string) error {
+	r := t.db.C().Delete(&types.TwoFactor{}, "`username` = ?", username)
+	if r.Error != nil {
+		return r.Error
+	}
+	if r.RowsAffected != 1 {
+		return err.NewNotFoundMessageError(i18n.T("storage.two_factor.not_enrolled", username))
+	}
+	return nil
+}
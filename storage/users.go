@@ -111,10 +111,46 @@ func (u *UserDAO) DeleteUser(username string) error {
 		if e := tx.Where("`username` = ?", username).Delete(&types.UserGroup{}).Error; e != nil {
 			return e
 		}
-		return tx.Where("`subject` = ?", types.UserSubject(username)).Delete(&types.PathPermission{}).Error
+		if e := tx.Where("`username` = ?", username).Delete(&types.UserIdentity{}).Error; e != nil {
+			return e
+		}
+		if e := tx.Where("`subject` = ?", types.UserSubject(username)).Delete(&types.PathPermission{}).Error; e != nil {
+			return e
+		}
+		if e := tx.Where("`username` = ?", username).Delete(&types.APIToken{}).Error; e != nil {
+			return e
+		}
+		if e := tx.Where("`username` = ?", username).Delete(&types.TwoFactor{}).Error; e != nil {
+			return e
+		}
+		return tx.Where("`username` = ?", username).Delete(&types.Share{}).Error
 	})
 }
 
+// GetUserByIdentity gets the user linked to the subject of the issuer
+func (u *UserDAO) GetUserByIdentity(issuer, subject string) (types.User, error) {
+	identity := types.UserIdentity{}
+	e := u.db.C().Take(&identity, "`issuer` = ? AND `subject` = ?", issuer, subject).Error
+	if errors.Is(e, gorm.ErrRecordNotFound) {
+		return types.User{}, err.NewNotFoundError()
+	}
+	if e != nil {
+		return types.User{}, e
+	}
+	return u.GetUser(identity.Username)
+}
+
+// HasIdentity returns true if the user is linked to any subject
+func (u *UserDAO) HasIdentity(username string) (bool, error) {
+	var count int64
+	e := u.db.C().Model(&types.UserIdentity{}).Where("`username` = ?", username).Count(&count).Error
+	return count > 0, e
+}
+
+func (u *UserDAO) AddIdentity(identity types.UserIdentity) error {
+	return u.db.C().Create(&identity).Error
+}
+
 func (u *UserDAO) ListUser() ([]types.User, error) {
 	users := make([]types.User, 0)
 	e := u.db.C().Find(&users).Error
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"errors"
+	err "go-drive/common/errors"
+	"go-drive/common/i18n"
+	"go-drive/common/registry"
+	"go-drive/common/types"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+type WebhookDAO struct {
+	db *DB
+}
+
+func NewWebhookDAO(db *DB, ch *registry.ComponentsHolder) *WebhookDAO {
+	dao := &WebhookDAO{db}
+	ch.Add("webhookDAO", dao)
+	return dao
+}
+
+func (w *WebhookDAO) GetWebhook(id uint) (types.Webhook, error) {
+	hook := types.Webhook{}
+	e := w.db.C().Take(&hook, "`id` = ?", id).Error
+	if errors.Is(e, gorm.ErrRecordNotFound) {
+		return hook, err.NewNotFoundMessageError(i18n.T("storage.webhooks.webhook_not_exists", strconv.FormatUint(uint64(id), 10)))
+	}
+	return hook, e
+}
+
+func (w *WebhookDAO) GetWebhooks(includeDisabled bool) ([]types.Webhook, error) {
+	hooks := make([]types.Webhook, 0)
+	tx := w.db.C()
+	if !includeDisabled {
+		tx = tx.Where("`enabled` = ?", true)
+	}
+	return hooks, tx.Find(&hooks).Error
+}
+
+func (w *WebhookDAO) AddWebhook(hook types.Webhook) (types.Webhook, error) {
+	hook.ID = 0
+	return hook, w.db.C().Create(&hook).Error
+}
+
+func (w *WebhookDAO) UpdateWebhook(id uint, hook types.Webhook) error {
+	hook.ID = id
+	return w.db.C().Save(hook).Error
+}
+
+func (w *WebhookDAO) DeleteWebhook(id uint) error {
+	return w.db.C().Transaction(func(tx *gorm.DB) error {
+		if e := tx.Delete(&types.Webhook{}, "`id` = ?", id).Error; e != nil {
+			return e
+		}
+		return tx.Delete(&types.WebhookDelivery{}, "`webhook_id` = ?", id).Error
+	})
+}
+
+func (w *WebhookDAO) GetDeliveries(webhookId uint) ([]types.WebhookDelivery, error) {
+	deliveries := make([]types.WebhookDelivery, 0)
+	return deliveries, w.db.C().Where("`webhook_id` = ?", webhookId).
+		Order("`id` DESC").Find(&deliveries).Error
+}
+
+func (w *WebhookDAO) AddDelivery(d *types.WebhookDelivery) error {
+	return w.db.C().Create(d).Error
+}
+
+func (w *WebhookDAO) UpdateDelivery(d *types.WebhookDelivery) error {
+	if d.ID == 0 {
+		return errors.New("webhook delivery to update has no id")
+	}
+	return w.db.C().Save(d).Error
+}
+
+// TrimDeliveries deletes the old deliveries of the webhook, only the latest `keep` deliveries are kept
+func (w *WebhookDAO) TrimDeliveries(webhookId uint, keep int) error {
+	ids := make([]uint, 0)
+	if e := w.db.C().Model(&types.WebhookDelivery{}).Where("`webhook_id` = ?", webhookId).
+		Order("`id` DESC").Offset(keep).Limit(1).Pluck("id", &ids).Error; e != nil {
+		return e
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return w.db.C().Delete(&types.WebhookDelivery{},
+		"`webhook_id` = ? AND `id` <= ?", webhookId, ids[0]).Error
+}
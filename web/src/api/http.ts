@@ -35,6 +35,21 @@ const doAuth = waitPromise(async () => {
   return token
 })
 
+/**
+ * exchange the login code of the single sign-on callback for a new token,
+ * otp is required if the user has enabled two-factor authentication
+ */
+export async function oidcToken(code: string, otp?: string) {
+  let data
+  try {
+    data = (await Axios.post('/auth/oidc/token', { code, otp }, BASE_CONFIG))
+      .data
+  } catch (e: any) {
+    throw ApiError.from(e)
+  }
+  setToken(data.token)
+}
+
 const axios = Axios.create(BASE_CONFIG)
 
 async function processConfig(config: AxiosRequestConfig) {
@@ -129,13 +129,18 @@ export function deleteTask(id: string) {
 
 /// auth
 
-export function login(username: string, password: string) {
+export function login(username: string, password: string, otp?: string) {
   return http.post<void>('/auth/login', {
     username,
     password,
+    otp,
   })
 }
 
+export function oidcLogin(redirect: string) {
+  return http.post<{ url: string }>('/auth/oidc/login', { redirect })
+}
+
 export function logout() {
   return http.post<void>('/auth/logout')
 }
@@ -129,6 +129,36 @@ export default {
       enabled: 'Enabled',
       schedule: 'Schedule',
       schedule_desc: 'Cron Expression. see https://crontab.cronhub.io/',
+      events: 'Events',
+      events_desc:
+        "Trigger the job on drive events, separated by ','. Available events: entry.updated, entry.deleted",
+      path_pattern: 'Path Pattern',
+      path_pattern_desc:
+        "Only the events of the paths matching this pattern trigger the job, e.g. 'inbox/**'",
+      debounce: 'Debounce',
+      debounce_desc:
+        "The events in this duration are coalesced into one execution, e.g. '5s'. Default is 1s",
+      max_concurrency: 'Max Concurrency',
+      max_concurrency_desc:
+        'The max concurrent executions triggered by events. Default is 1',
+      max_retries: 'Max Retries',
+      max_retries_desc: 'Retry the job when it fails. Default is 0',
+      retry_backoff: 'Retry Backoff',
+      retry_backoff_desc:
+        "The delay before the first retry, doubled for each retry, e.g. '30s'. Default is 10s",
+      timeout: 'Timeout',
+      timeout_desc: "Abort the execution after this duration, e.g. '1h'",
+      notify_on: 'Notify',
+      notify_never: 'Never',
+      notify_on_failure: 'On failure',
+      notify_on_success: 'On success',
+      notify_always: 'Always',
+      notify_webhook: 'Notification Webhook',
+      notify_webhook_desc: 'The execution result will be posted to this URL',
+      notify_email: 'Notification Email',
+      notify_email_desc:
+        "The execution result will be sent to these addresses separated by ','. The SMTP server is required",
+      attempt: 'Attempt',
       next_run: 'Next RunTime',
       desc: 'Description',
       add_job: 'Add job',
@@ -183,7 +213,13 @@ export default {
     },
     p_edit: {
       subject: 'Subject',
-      rw: '(R/W)',
+      list: 'List',
+      download: 'Download',
+      create: 'Create',
+      overwrite: 'Overwrite',
+      delete: 'Delete',
+      move: 'Rename/Move',
+      share: 'Share',
       policy: 'Policy',
       any: 'ANY',
       reject: 'Reject',
@@ -228,5 +264,7 @@ export default {
     username: 'Username',
     password: 'Password',
     login: 'Login',
+    sso: 'Login with SSO',
+    otp: 'Authentication code or recovery code',
   },
 }
@@ -126,6 +126,31 @@ export default {
       enabled: '启用',
       schedule: '执行计划',
       schedule_desc: 'Cron 表达式。请参考 https://crontab.cronhub.io/',
+      events: '触发事件',
+      events_desc:
+        "在文件发生变化时执行任务，多个事件以 ',' 分隔。可用的事件：entry.updated, entry.deleted",
+      path_pattern: '路径匹配',
+      path_pattern_desc: "仅匹配该规则的路径上的事件会触发任务，如 'inbox/**'",
+      debounce: '防抖时间',
+      debounce_desc: "该时间内的事件会合并为一次执行，如 '5s'。默认为 1s",
+      max_concurrency: '最大并发数',
+      max_concurrency_desc: '由事件触发的最大同时执行数。默认为 1',
+      max_retries: '最大重试次数',
+      max_retries_desc: '任务失败时重试。默认为 0',
+      retry_backoff: '重试间隔',
+      retry_backoff_desc: "第一次重试前的等待时间，每次重试翻倍，如 '30s'。默认为 10s",
+      timeout: '超时时间',
+      timeout_desc: "执行超过该时间后中止，如 '1h'",
+      notify_on: '通知',
+      notify_never: '不通知',
+      notify_on_failure: '失败时',
+      notify_on_success: '成功时',
+      notify_always: '总是',
+      notify_webhook: '通知 Webhook',
+      notify_webhook_desc: '执行结果会被 POST 到该地址',
+      notify_email: '通知邮箱',
+      notify_email_desc: "执行结果会被发送到这些邮箱，多个以 ',' 分隔。需要配置 SMTP 服务器",
+      attempt: '尝试次数',
       next_run: '下次运行时间',
       desc: '描述',
       add_job: '新建任务',
@@ -180,7 +205,13 @@ export default {
     },
     p_edit: {
       subject: '主体',
-      rw: '(读/写)',
+      list: '列出',
+      download: '下载',
+      create: '创建',
+      overwrite: '覆盖',
+      delete: '删除',
+      move: '重命名/移动',
+      share: '分享',
       policy: '策略',
       any: '任何',
       reject: '拒绝',
@@ -225,5 +256,7 @@ export default {
     username: '用户名',
     password: '密码',
     login: '登录',
+    sso: '单点登录',
+    otp: '验证码或恢复码',
   },
 }
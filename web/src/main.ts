@@ -2,8 +2,9 @@ import { createApp } from 'vue'
 import App from './App.vue'
 
 import router from './router'
-import store from './store'
+import store, { useAppStore } from './store'
 import i18n, { setLang } from './i18n'
+import { oidcToken } from '@/api/http'
 
 import Components from '@/components'
 import Utils from '@/utils'
@@ -12,6 +13,25 @@ import '@/styles/index.scss'
 ;(async () => {
   await setLang(navigator.language)
 
+  // the single sign-on callback redirects to here with the login code
+  const url = new URL(location.href)
+  const oidcCode = url.searchParams.get('oidc_code')
+  let pendingOIDCCode: string | undefined
+  if (oidcCode) {
+    url.searchParams.delete('oidc_code')
+    history.replaceState(history.state, '', url.toString())
+    try {
+      await oidcToken(oidcCode)
+    } catch (e: any) {
+      if (e.data?.twoFactorRequired) {
+        // the code is asked by the login view
+        pendingOIDCCode = oidcCode
+      } else {
+        console.error('failed to complete the single sign-on', e)
+      }
+    }
+  }
+
   createApp(App)
     .use(Utils)
     .use(Components)
@@ -19,4 +39,10 @@ import '@/styles/index.scss'
     .use(store)
     .use(i18n)
     .mount('#app')
+
+  if (pendingOIDCCode) {
+    const appStore = useAppStore(store)
+    appStore.setOIDCCode(pendingOIDCCode)
+    appStore.toggleLogin(true)
+  }
 })()
@@ -15,6 +15,10 @@ interface AppState {
   config?: Readonly<TypedConfig>
 
   showLogin: boolean
+  /**
+   * the login code of single sign-on waiting for the two-factor authentication code
+   */
+  oidcCode?: string
 
   progressBar: number | boolean
 }
@@ -37,6 +41,9 @@ export const useAppStore = defineStore('app', {
     toggleLogin(show: boolean) {
       this.showLogin = show
     },
+    setOIDCCode(code?: string) {
+      this.oidcCode = code
+    },
     setProgressBar(val?: number | boolean) {
       if (typeof val === 'boolean' || typeof val === 'number') {
         this.progressBar = val
@@ -37,9 +37,13 @@ export enum PathPermissionPolicy {
 
 export enum PathPermissionPerm {
   Empty = 0,
-  Read = 1 << 0,
-  Write = 1 << 1,
-  ReadWrite = PathPermissionPerm.Read | PathPermissionPerm.Write,
+  List = 1 << 0,
+  Download = 1 << 1,
+  Create = 1 << 2,
+  Overwrite = 1 << 3,
+  Delete = 1 << 4,
+  Move = 1 << 5,
+  Share = 1 << 6,
 }
 
 export interface PathPermission {
@@ -72,6 +76,16 @@ export interface Job {
   job: string
   params: string
   schedule: string
+  events: string
+  pathPattern: string
+  debounce: string
+  maxConcurrency: number
+  maxRetries: number
+  retryBackoff: string
+  timeout: string
+  notifyOn: string
+  notifyWebhook: string
+  notifyEmail: string
   enabled: boolean
 
   nextRun?: string
@@ -88,6 +102,7 @@ export interface JobExecution {
   jobId: number
   startedAt: number
   completedAt?: number
+  attempt: number
   status: JobExecutionStatus
   logs?: string
   errorMsg?: string
@@ -13,9 +13,15 @@ export interface VersionConfig {
   version: string
 }
 
+export interface AuthConfig {
+  passwordLogin: boolean
+  oidc: boolean
+}
+
 export interface Config {
   version: VersionConfig
   thumbnail: ThumbnailConfig
+  auth: AuthConfig
   options: O
 
   search?: SearchConfig
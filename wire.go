@@ -13,12 +13,13 @@ import (
 	"go-drive/common/registry"
 	"go-drive/common/task"
 	"go-drive/common/types"
-	"go-drive/common/utils"
 	"go-drive/drive"
 	"go-drive/server"
+	"go-drive/server/audit"
 	"go-drive/server/scheduled"
 	"go-drive/server/search"
 	"go-drive/server/thumbnail"
+	"go-drive/server/webhook"
 	"go-drive/storage"
 )
 
@@ -36,19 +37,37 @@ func Initialize(ctx context.Context, ch *registry.ComponentsHolder) (*gin.Engine
 		storage.NewDriveDataDAO,
 		storage.NewOptionsDAO,
 		storage.NewScheduledDAO,
-		wire.Bind(new(task.Runner), new(*task.TunnyRunner)),
-		task.NewTunnyRunner,
-		utils.NewSigner,
+		storage.NewShareDAO,
+		storage.NewTrashDAO,
+		storage.NewFileVersionDAO,
+		storage.NewCopyCheckpointDAO,
+		storage.NewWebhookDAO,
+		storage.NewAuditLogDAO,
+		storage.NewQuotaDAO,
+		storage.NewAPITokenDAO,
+		storage.NewTwoFactorDAO,
+		wire.Bind(new(task.Store), new(*storage.TaskDAO)),
+		storage.NewTaskDAO,
+		wire.Bind(new(task.Runner), new(*task.PersistentRunner)),
+		task.NewPersistentRunner,
+		server.NewSigner,
 		wire.Bind(new(types.TokenStore), new(*server.FileTokenStore)),
 		server.NewFileTokenStore,
 		server.NewChunkUploader,
+		server.NewTusUploader,
+		server.NewHasher,
 		thumbnail.NewMaker,
 		drive.NewRootDrive,
+		drive.NewTrash,
+		drive.NewVersions,
+		drive.NewQuotas,
 		drive.NewAccess,
 		search.NewService,
 		wire.Bind(new(i18n.MessageSource), new(*i18n.FileMessageSource)),
 		i18n.NewFileMessageSource,
 		scheduled.NewJobExecutor,
+		webhook.NewService,
+		audit.NewLogger,
 		server.InitServer,
 	)
 	return &gin.Engine{}, nil
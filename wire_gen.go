@@ -14,12 +14,13 @@ import (
 	"go-drive/common/i18n"
 	"go-drive/common/registry"
 	"go-drive/common/task"
-	"go-drive/common/utils"
 	"go-drive/drive"
 	"go-drive/server"
+	"go-drive/server/audit"
 	"go-drive/server/scheduled"
 	"go-drive/server/search"
 	"go-drive/server/thumbnail"
+	"go-drive/server/webhook"
 	"go-drive/storage"
 )
 
@@ -43,14 +44,27 @@ func Initialize(ctx context.Context, ch *registry.ComponentsHolder) (*gin.Engine
 	if err != nil {
 		return nil, err
 	}
-	pathPermissionDAO := storage.NewPathPermissionDAO(db, ch)
+	trashDAO := storage.NewTrashDAO(db, ch)
 	optionsDAO := storage.NewOptionsDAO(db, ch)
-	access, err := drive.NewAccess(ch, rootDrive, pathPermissionDAO, optionsDAO, bus)
+	trash := drive.NewTrash(ch, rootDrive, trashDAO, optionsDAO, bus)
+	fileVersionDAO := storage.NewFileVersionDAO(db, ch)
+	versions := drive.NewVersions(ch, rootDrive, fileVersionDAO, optionsDAO)
+	pathPermissionDAO := storage.NewPathPermissionDAO(db, ch)
+	quotaDAO := storage.NewQuotaDAO(db, ch)
+	quotas, err := drive.NewQuotas(ch, rootDrive, quotaDAO, pathPermissionDAO, bus)
+	if err != nil {
+		return nil, err
+	}
+	access, err := drive.NewAccess(ch, rootDrive, trash, versions, quotas, pathPermissionDAO, optionsDAO, bus)
 	if err != nil {
 		return nil, err
 	}
-	tunnyRunner := task.NewTunnyRunner(config, ch)
-	service, err := search.NewService(ch, config, optionsDAO, rootDrive, tunnyRunner, bus)
+	taskDAO := storage.NewTaskDAO(db, ch)
+	persistentRunner, err := task.NewPersistentRunner(config, taskDAO, bus, ch)
+	if err != nil {
+		return nil, err
+	}
+	service, err := search.NewService(ch, config, optionsDAO, rootDrive, persistentRunner, bus)
 	if err != nil {
 		return nil, err
 	}
@@ -62,23 +76,42 @@ func Initialize(ctx context.Context, ch *registry.ComponentsHolder) (*gin.Engine
 	if err != nil {
 		return nil, err
 	}
-	signer := utils.NewSigner()
+	signer, err := server.NewSigner(config, optionsDAO, ch)
+	if err != nil {
+		return nil, err
+	}
 	chunkUploader, err := server.NewChunkUploader(config)
 	if err != nil {
 		return nil, err
 	}
+	tusUploader, err := server.NewTusUploader(config, ch)
+	if err != nil {
+		return nil, err
+	}
+	hasher := server.NewHasher(ch)
 	userDAO := storage.NewUserDAO(db, ch)
 	groupDAO := storage.NewGroupDAO(db, ch)
 	scheduledDAO := storage.NewScheduledDAO(db, ch)
-	jobExecutor, err := scheduled.NewJobExecutor(scheduledDAO, ch)
+	shareDAO := storage.NewShareDAO(db, ch)
+	copyCheckpointDAO := storage.NewCopyCheckpointDAO(db, ch)
+	fileMessageSource, err := i18n.NewFileMessageSource(config)
 	if err != nil {
 		return nil, err
 	}
-	fileMessageSource, err := i18n.NewFileMessageSource(config)
+	jobExecutor, err := scheduled.NewJobExecutor(config, scheduledDAO, bus, fileMessageSource, ch)
+	if err != nil {
+		return nil, err
+	}
+	webhookDAO := storage.NewWebhookDAO(db, ch)
+	webhookService, err := webhook.NewService(ch, webhookDAO, persistentRunner, bus)
 	if err != nil {
 		return nil, err
 	}
-	engine, err := server.InitServer(config, ch, bus, rootDrive, access, service, fileTokenStore, maker, signer, chunkUploader, tunnyRunner, optionsDAO, userDAO, groupDAO, driveDAO, driveDataDAO, pathPermissionDAO, pathMountDAO, scheduledDAO, jobExecutor, fileMessageSource)
+	auditLogDAO := storage.NewAuditLogDAO(db, ch)
+	logger := audit.NewLogger(ch, auditLogDAO, optionsDAO, bus)
+	apiTokenDAO := storage.NewAPITokenDAO(db, ch)
+	twoFactorDAO := storage.NewTwoFactorDAO(db, ch)
+	engine, err := server.InitServer(config, ch, bus, rootDrive, access, trash, versions, service, fileTokenStore, maker, signer, chunkUploader, tusUploader, hasher, persistentRunner, optionsDAO, userDAO, groupDAO, driveDAO, driveDataDAO, pathPermissionDAO, pathMountDAO, scheduledDAO, shareDAO, trashDAO, copyCheckpointDAO, jobExecutor, webhookService, webhookDAO, logger, auditLogDAO, quotas, quotaDAO, apiTokenDAO, twoFactorDAO, fileMessageSource)
 	if err != nil {
 		return nil, err
 	}